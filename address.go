@@ -0,0 +1,258 @@
+/*
+ * This file is part of the libvirt-go-xml project
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ *
+ * Copyright (C) 2020 Red Hat, Inc.
+ *
+ */
+
+package libvirtxml
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PCIAddress is a complete PCI address, as used by the various
+// address structs of the documents
+type PCIAddress struct {
+	Domain   uint
+	Bus      uint
+	Slot     uint
+	Function uint
+}
+
+func (a PCIAddress) check() error {
+	if a.Domain > 0xffff || a.Bus > 0xff || a.Slot > 0x1f || a.Function > 0x7 {
+		return fmt.Errorf("PCI address %s is out of range", a)
+	}
+	return nil
+}
+
+// ParsePCIAddress parses the canonical "0000:03:00.1" form, with
+// the domain being optional
+func ParsePCIAddress(s string) (PCIAddress, error) {
+	addr := PCIAddress{}
+	fields := strings.Split(strings.TrimSpace(s), ":")
+	if len(fields) == 2 {
+		fields = append([]string{"0"}, fields...)
+	}
+	if len(fields) != 3 {
+		return addr, fmt.Errorf("invalid PCI address '%s'", s)
+	}
+	slotFunction := strings.Split(fields[2], ".")
+	if len(slotFunction) != 2 {
+		return addr, fmt.Errorf("invalid PCI address '%s'", s)
+	}
+
+	vals := []*uint{&addr.Domain, &addr.Bus, &addr.Slot, &addr.Function}
+	for i, field := range []string{fields[0], fields[1], slotFunction[0], slotFunction[1]} {
+		val, err := strconv.ParseUint(field, 16, 32)
+		if err != nil {
+			return addr, fmt.Errorf("invalid PCI address '%s'", s)
+		}
+		*vals[i] = uint(val)
+	}
+	return addr, addr.check()
+}
+
+func (a PCIAddress) String() string {
+	return fmt.Sprintf("%04x:%02x:%02x.%x", a.Domain, a.Bus, a.Slot, a.Function)
+}
+
+// The bus and slot have to be present, while the domain and
+// function default to zero as they do in libvirt
+func newPCIAddress(domain, bus, slot, function *uint) (PCIAddress, error) {
+	addr := PCIAddress{}
+	if bus == nil || slot == nil {
+		return addr, fmt.Errorf("incomplete PCI address")
+	}
+	addr.Bus, addr.Slot = *bus, *slot
+	if domain != nil {
+		addr.Domain = *domain
+	}
+	if function != nil {
+		addr.Function = *function
+	}
+	return addr, addr.check()
+}
+
+func (a PCIAddress) fields() (*uint, *uint, *uint, *uint) {
+	domain, bus, slot, function := a.Domain, a.Bus, a.Slot, a.Function
+	return &domain, &bus, &slot, &function
+}
+
+func (a PCIAddress) DomainAddressPCI() *DomainAddressPCI {
+	addr := &DomainAddressPCI{}
+	addr.Domain, addr.Bus, addr.Slot, addr.Function = a.fields()
+	return addr
+}
+
+func (a PCIAddress) NodeDevicePCIAddress() *NodeDevicePCIAddress {
+	addr := &NodeDevicePCIAddress{}
+	addr.Domain, addr.Bus, addr.Slot, addr.Function = a.fields()
+	return addr
+}
+
+func (a PCIAddress) StoragePoolPCIAddress() *StoragePoolPCIAddress {
+	addr := &StoragePoolPCIAddress{}
+	addr.Domain, addr.Bus, addr.Slot, addr.Function = a.fields()
+	return addr
+}
+
+func (a PCIAddress) NetworkForwardAddressPCI() *NetworkForwardAddressPCI {
+	addr := &NetworkForwardAddressPCI{}
+	addr.Domain, addr.Bus, addr.Slot, addr.Function = a.fields()
+	return addr
+}
+
+func (a PCIAddress) NetworkPortPlugHostDevPCIAddress() *NetworkPortPlugHostDevPCIAddress {
+	addr := &NetworkPortPlugHostDevPCIAddress{}
+	addr.Domain, addr.Bus, addr.Slot, addr.Function = a.fields()
+	return addr
+}
+
+func (a *DomainAddressPCI) PCIAddress() (PCIAddress, error) {
+	return newPCIAddress(a.Domain, a.Bus, a.Slot, a.Function)
+}
+
+func (a *NodeDevicePCIAddress) PCIAddress() (PCIAddress, error) {
+	return newPCIAddress(a.Domain, a.Bus, a.Slot, a.Function)
+}
+
+func (a *StoragePoolPCIAddress) PCIAddress() (PCIAddress, error) {
+	return newPCIAddress(a.Domain, a.Bus, a.Slot, a.Function)
+}
+
+func (a *NetworkForwardAddressPCI) PCIAddress() (PCIAddress, error) {
+	return newPCIAddress(a.Domain, a.Bus, a.Slot, a.Function)
+}
+
+func (a *NetworkPortPlugHostDevPCIAddress) PCIAddress() (PCIAddress, error) {
+	return newPCIAddress(a.Domain, a.Bus, a.Slot, a.Function)
+}
+
+func (c *NodeDevicePCICapability) PCIAddress() (PCIAddress, error) {
+	return newPCIAddress(c.Domain, c.Bus, c.Slot, c.Function)
+}
+
+// USBAddress is the location of a USB device on the host
+type USBAddress struct {
+	Bus    uint
+	Device uint
+}
+
+// ParseUSBAddress parses the "001:002" form used by lsusb
+func ParseUSBAddress(s string) (USBAddress, error) {
+	addr := USBAddress{}
+	fields := strings.Split(strings.TrimSpace(s), ":")
+	if len(fields) != 2 {
+		return addr, fmt.Errorf("invalid USB address '%s'", s)
+	}
+	bus, err := strconv.ParseUint(fields[0], 10, 32)
+	if err != nil {
+		return addr, fmt.Errorf("invalid USB address '%s'", s)
+	}
+	device, err := strconv.ParseUint(fields[1], 10, 32)
+	if err != nil {
+		return addr, fmt.Errorf("invalid USB address '%s'", s)
+	}
+	addr.Bus, addr.Device = uint(bus), uint(device)
+	return addr, nil
+}
+
+func (a USBAddress) String() string {
+	return fmt.Sprintf("%03d:%03d", a.Bus, a.Device)
+}
+
+func (a USBAddress) DomainAddressUSB() *DomainAddressUSB {
+	bus, device := a.Bus, a.Device
+	return &DomainAddressUSB{
+		Bus:    &bus,
+		Device: &device,
+	}
+}
+
+func (a *DomainAddressUSB) USBAddress() (USBAddress, error) {
+	if a.Bus == nil || a.Device == nil {
+		return USBAddress{}, fmt.Errorf("incomplete USB address")
+	}
+	return USBAddress{Bus: *a.Bus, Device: *a.Device}, nil
+}
+
+func (c *NodeDeviceUSBDeviceCapability) USBAddress() (USBAddress, error) {
+	if c.Bus < 0 || c.Device < 0 {
+		return USBAddress{}, fmt.Errorf("invalid USB address %d:%d", c.Bus, c.Device)
+	}
+	return USBAddress{Bus: uint(c.Bus), Device: uint(c.Device)}, nil
+}
+
+// DriveAddress is the location of a disk on a disk controller
+type DriveAddress struct {
+	Controller uint
+	Bus        uint
+	Target     uint
+	Unit       uint
+}
+
+// ParseDriveAddress parses the "controller:bus:target:unit" form
+func ParseDriveAddress(s string) (DriveAddress, error) {
+	addr := DriveAddress{}
+	fields := strings.Split(strings.TrimSpace(s), ":")
+	if len(fields) != 4 {
+		return addr, fmt.Errorf("invalid drive address '%s'", s)
+	}
+	vals := []*uint{&addr.Controller, &addr.Bus, &addr.Target, &addr.Unit}
+	for i, field := range fields {
+		val, err := strconv.ParseUint(field, 10, 32)
+		if err != nil {
+			return addr, fmt.Errorf("invalid drive address '%s'", s)
+		}
+		*vals[i] = uint(val)
+	}
+	return addr, nil
+}
+
+func (a DriveAddress) String() string {
+	return fmt.Sprintf("%d:%d:%d:%d", a.Controller, a.Bus, a.Target, a.Unit)
+}
+
+func (a DriveAddress) DomainAddressDrive() *DomainAddressDrive {
+	controller, bus, target, unit := a.Controller, a.Bus, a.Target, a.Unit
+	return &DomainAddressDrive{
+		Controller: &controller,
+		Bus:        &bus,
+		Target:     &target,
+		Unit:       &unit,
+	}
+}
+
+// Missing fields default to zero, as they do in libvirt
+func (a *DomainAddressDrive) DriveAddress() DriveAddress {
+	addr := DriveAddress{}
+	vals := []*uint{&addr.Controller, &addr.Bus, &addr.Target, &addr.Unit}
+	for i, val := range []*uint{a.Controller, a.Bus, a.Target, a.Unit} {
+		if val != nil {
+			*vals[i] = *val
+		}
+	}
+	return addr
+}
@@ -0,0 +1,155 @@
+/*
+ * This file is part of the libvirt-go-xml project
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ *
+ * Copyright (C) 2020 Red Hat, Inc.
+ *
+ */
+
+package libvirtxml
+
+import (
+	"reflect"
+	"testing"
+)
+
+var pciAddressParseTestData = []struct {
+	Input    string
+	Expected string
+}{
+	{"0000:03:00.1", "0000:03:00.1"},
+	{"03:1f.7", "0000:03:1f.7"},
+	{"ffff:ff:1F.0", "ffff:ff:1f.0"},
+	{"0000:03:20.0", ""},
+	{"0000:03:00.8", ""},
+	{"10000:03:00.0", ""},
+	{"0000:03:00", ""},
+	{"0000:03.00.1", ""},
+	{"0000:0g:00.1", ""},
+}
+
+func TestParsePCIAddress(t *testing.T) {
+	for _, test := range pciAddressParseTestData {
+		addr, err := ParsePCIAddress(test.Input)
+		if test.Expected == "" {
+			if err == nil {
+				t.Fatalf("Expected error parsing %s, got %s", test.Input, addr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if addr.String() != test.Expected {
+			t.Fatalf("Expected %s for %s, got %s", test.Expected, test.Input, addr)
+		}
+	}
+}
+
+func TestPCIAddressConversion(t *testing.T) {
+	nodedev := &NodeDevice{}
+	err := nodedev.Unmarshal(`<device>
+  <name>pci_0000_03_00_1</name>
+  <capability type="pci">
+    <domain>0</domain>
+    <bus>3</bus>
+    <slot>0</slot>
+    <function>1</function>
+    <product id="0x1528"></product>
+    <vendor id="0x8086"></vendor>
+  </capability>
+</device>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr, err := nodedev.Capability.PCI.PCIAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr.String() != "0000:03:00.1" {
+		t.Fatalf("Unexpected address %s", addr)
+	}
+
+	hostdev := addr.DomainAddressPCI()
+	back, err := hostdev.PCIAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	forward, err := addr.NetworkForwardAddressPCI().PCIAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := addr.NetworkPortPlugHostDevPCIAddress().PCIAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool, err := addr.StoragePoolPCIAddress().PCIAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, other := range []PCIAddress{back, forward, port, pool} {
+		if other != addr {
+			t.Fatalf("Expected %s, got %s", addr, other)
+		}
+	}
+
+	_, err = (&DomainAddressPCI{}).PCIAddress()
+	if err == nil {
+		t.Fatal("Expected an error for an incomplete address")
+	}
+}
+
+func TestUSBAndDriveAddress(t *testing.T) {
+	usb, err := ParseUSBAddress("001:012")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(usb, USBAddress{Bus: 1, Device: 12}) || usb.String() != "001:012" {
+		t.Fatalf("Unexpected USB address %s", usb)
+	}
+	back, err := usb.DomainAddressUSB().USBAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if back != usb {
+		t.Fatalf("Expected %s, got %s", usb, back)
+	}
+
+	drive, err := ParseDriveAddress("0:0:1:3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if drive != (DriveAddress{Target: 1, Unit: 3}) || drive.String() != "0:0:1:3" {
+		t.Fatalf("Unexpected drive address %s", drive)
+	}
+	if drive.DomainAddressDrive().DriveAddress() != drive {
+		t.Fatalf("Drive address %s did not round trip", drive)
+	}
+
+	for _, bad := range []string{"1", "1:2:3", "a:b"} {
+		if _, err := ParseUSBAddress(bad); err == nil {
+			t.Fatalf("Expected error parsing USB address %s", bad)
+		}
+		if _, err := ParseDriveAddress(bad); err == nil {
+			t.Fatalf("Expected error parsing drive address %s", bad)
+		}
+	}
+}
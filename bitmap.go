@@ -0,0 +1,320 @@
+/*
+ * This file is part of the libvirt-go-xml project
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ *
+ * Copyright (C) 2020 Red Hat, Inc.
+ *
+ */
+
+package libvirtxml
+
+import (
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// The largest bit number accepted when parsing, which keeps a
+// malformed range from allocating unbounded memory
+const bitmapMaxBit = 1<<20 - 1
+
+// Bitmap is a set of CPU or NUMA node numbers, as used in the
+// cpuset and nodeset attributes. The zero value is an empty set.
+// Bitmaps are values: changing a copy with Set or Clear leaves
+// the original unchanged.
+type Bitmap struct {
+	words []uint64
+}
+
+// Sets and clears bits in place, growing the words as needed
+func bitmapSetWord(words []uint64, n uint) []uint64 {
+	word := int(n / 64)
+	if word >= len(words) {
+		grown := make([]uint64, word+1)
+		copy(grown, words)
+		words = grown
+	}
+	words[word] |= 1 << (n % 64)
+	return words
+}
+
+func bitmapClearWord(words []uint64, n uint) {
+	word := int(n / 64)
+	if word < len(words) {
+		words[word] &^= 1 << (n % 64)
+	}
+}
+
+// NewBitmap returns a bitmap with the given members
+func NewBitmap(members ...uint) Bitmap {
+	var words []uint64
+	for _, n := range members {
+		words = bitmapSetWord(words, n)
+	}
+	return Bitmap{words: words}
+}
+
+// ParseBitmap parses the list syntax libvirt uses for CPU and
+// node sets, a comma separated list of numbers, ranges such as
+// "0-3" and exclusions such as "^2", applied in order
+func ParseBitmap(s string) (Bitmap, error) {
+	if strings.TrimSpace(s) == "" {
+		return Bitmap{}, fmt.Errorf("empty bitmap")
+	}
+	parseBit := func(s string) (uint, error) {
+		n, err := strconv.ParseUint(strings.TrimSpace(s), 10, 32)
+		if err != nil || n > bitmapMaxBit {
+			return 0, fmt.Errorf("invalid bitmap element '%s'", s)
+		}
+		return uint(n), nil
+	}
+
+	var words []uint64
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if strings.HasPrefix(part, "^") {
+			n, err := parseBit(part[1:])
+			if err != nil {
+				return Bitmap{}, err
+			}
+			bitmapClearWord(words, n)
+			continue
+		}
+
+		bounds := strings.SplitN(part, "-", 2)
+		start, err := parseBit(bounds[0])
+		if err != nil {
+			return Bitmap{}, err
+		}
+		end := start
+		if len(bounds) == 2 {
+			end, err = parseBit(bounds[1])
+			if err != nil {
+				return Bitmap{}, err
+			}
+			if end < start {
+				return Bitmap{}, fmt.Errorf("invalid bitmap range '%s'", part)
+			}
+		}
+		for n := start; n <= end; n++ {
+			words = bitmapSetWord(words, n)
+		}
+	}
+	return Bitmap{words: words}, nil
+}
+
+// String formats the bitmap in libvirt's list syntax, collapsing
+// consecutive members into ranges, as in "0-3,8"
+func (b Bitmap) String() string {
+	parts := []string{}
+	members := b.Members()
+	for i := 0; i < len(members); {
+		j := i
+		for j+1 < len(members) && members[j+1] == members[j]+1 {
+			j++
+		}
+		if i == j {
+			parts = append(parts, fmt.Sprintf("%d", members[i]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", members[i], members[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
+
+// Set adds n to the bitmap. Copies of the bitmap share their
+// words, so they are copied before being changed.
+func (b *Bitmap) Set(n uint) {
+	b.words = bitmapSetWord(append([]uint64{}, b.words...), n)
+}
+
+// Clear removes n from the bitmap
+func (b *Bitmap) Clear(n uint) {
+	words := append([]uint64{}, b.words...)
+	bitmapClearWord(words, n)
+	b.words = words
+}
+
+// IsSet reports whether n is in the bitmap
+func (b Bitmap) IsSet(n uint) bool {
+	word := int(n / 64)
+	return word < len(b.words) && b.words[word]&(1<<(n%64)) != 0
+}
+
+// Count returns the number of members
+func (b Bitmap) Count() int {
+	count := 0
+	for _, word := range b.words {
+		count += bits.OnesCount64(word)
+	}
+	return count
+}
+
+// IsEmpty reports whether the bitmap has no members
+func (b Bitmap) IsEmpty() bool {
+	return b.Count() == 0
+}
+
+// Members returns the members in ascending order
+func (b Bitmap) Members() []uint {
+	members := []uint{}
+	for i, word := range b.words {
+		for word != 0 {
+			bit := bits.TrailingZeros64(word)
+			members = append(members, uint(i*64+bit))
+			word &^= 1 << uint(bit)
+		}
+	}
+	return members
+}
+
+func (b Bitmap) combine(other Bitmap, op func(a, b uint64) uint64) Bitmap {
+	size := len(b.words)
+	if len(other.words) > size {
+		size = len(other.words)
+	}
+	res := Bitmap{words: make([]uint64, size)}
+	for i := range res.words {
+		var x, y uint64
+		if i < len(b.words) {
+			x = b.words[i]
+		}
+		if i < len(other.words) {
+			y = other.words[i]
+		}
+		res.words[i] = op(x, y)
+	}
+	return res
+}
+
+// Union returns the members of either bitmap
+func (b Bitmap) Union(other Bitmap) Bitmap {
+	return b.combine(other, func(x, y uint64) uint64 { return x | y })
+}
+
+// Intersection returns the members of both bitmaps
+func (b Bitmap) Intersection(other Bitmap) Bitmap {
+	return b.combine(other, func(x, y uint64) uint64 { return x & y })
+}
+
+// Difference returns the members of b which are not in other
+func (b Bitmap) Difference(other Bitmap) Bitmap {
+	return b.combine(other, func(x, y uint64) uint64 { return x &^ y })
+}
+
+// Equal reports whether both bitmaps have the same members
+func (b Bitmap) Equal(other Bitmap) bool {
+	return b.Difference(other).IsEmpty() && other.Difference(b).IsEmpty()
+}
+
+// IsSubset reports whether every member of b is in other
+func (b Bitmap) IsSubset(other Bitmap) bool {
+	return b.Difference(other).IsEmpty()
+}
+
+// An empty attribute is an empty bitmap rather than an error
+func parseBitmapAttr(s string) (Bitmap, error) {
+	if s == "" {
+		return Bitmap{}, nil
+	}
+	return ParseBitmap(s)
+}
+
+// CPUSetBitmap returns the host CPUs the vCPUs may run on
+func (v *DomainVCPU) CPUSetBitmap() (Bitmap, error) {
+	return parseBitmapAttr(v.CPUSet)
+}
+
+// SetCPUSetBitmap sets the host CPUs the vCPUs may run on
+func (v *DomainVCPU) SetCPUSetBitmap(b Bitmap) {
+	v.CPUSet = b.String()
+}
+
+// CPUSetBitmap returns the host CPUs the vCPU is pinned to
+func (p *DomainCPUTuneVCPUPin) CPUSetBitmap() (Bitmap, error) {
+	return parseBitmapAttr(p.CPUSet)
+}
+
+// SetCPUSetBitmap sets the host CPUs the vCPU is pinned to
+func (p *DomainCPUTuneVCPUPin) SetCPUSetBitmap(b Bitmap) {
+	p.CPUSet = b.String()
+}
+
+// CPUSetBitmap returns the host CPUs the emulator is pinned to
+func (p *DomainCPUTuneEmulatorPin) CPUSetBitmap() (Bitmap, error) {
+	return parseBitmapAttr(p.CPUSet)
+}
+
+// SetCPUSetBitmap sets the host CPUs the emulator is pinned to
+func (p *DomainCPUTuneEmulatorPin) SetCPUSetBitmap(b Bitmap) {
+	p.CPUSet = b.String()
+}
+
+// CPUSetBitmap returns the host CPUs the I/O thread is pinned to
+func (p *DomainCPUTuneIOThreadPin) CPUSetBitmap() (Bitmap, error) {
+	return parseBitmapAttr(p.CPUSet)
+}
+
+// SetCPUSetBitmap sets the host CPUs the I/O thread is pinned to
+func (p *DomainCPUTuneIOThreadPin) SetCPUSetBitmap(b Bitmap) {
+	p.CPUSet = b.String()
+}
+
+// NodesetBitmap returns the host NUMA nodes memory is bound to
+func (m *DomainNUMATuneMemory) NodesetBitmap() (Bitmap, error) {
+	return parseBitmapAttr(m.Nodeset)
+}
+
+// SetNodesetBitmap sets the host NUMA nodes memory is bound to
+func (m *DomainNUMATuneMemory) SetNodesetBitmap(b Bitmap) {
+	m.Nodeset = b.String()
+}
+
+// NodesetBitmap returns the host NUMA nodes the guest node is bound to
+func (m *DomainNUMATuneMemNode) NodesetBitmap() (Bitmap, error) {
+	return parseBitmapAttr(m.Nodeset)
+}
+
+// SetNodesetBitmap sets the host NUMA nodes the guest node is bound to
+func (m *DomainNUMATuneMemNode) SetNodesetBitmap(b Bitmap) {
+	m.Nodeset = b.String()
+}
+
+// NodesetBitmap returns the guest NUMA nodes using the page size
+func (p *DomainMemoryHugepage) NodesetBitmap() (Bitmap, error) {
+	return parseBitmapAttr(p.Nodeset)
+}
+
+// SetNodesetBitmap sets the guest NUMA nodes using the page size
+func (p *DomainMemoryHugepage) SetNodesetBitmap(b Bitmap) {
+	p.Nodeset = b.String()
+}
+
+// CPUsBitmap returns the vCPUs in the guest NUMA node
+func (c *DomainCell) CPUsBitmap() (Bitmap, error) {
+	return parseBitmapAttr(c.CPUs)
+}
+
+// SetCPUsBitmap sets the vCPUs in the guest NUMA node
+func (c *DomainCell) SetCPUsBitmap(b Bitmap) {
+	c.CPUs = b.String()
+}
@@ -0,0 +1,147 @@
+/*
+ * This file is part of the libvirt-go-xml project
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ *
+ * Copyright (C) 2020 Red Hat, Inc.
+ *
+ */
+
+package libvirtxml
+
+import (
+	"reflect"
+	"testing"
+)
+
+var bitmapParseTestData = []struct {
+	Input    string
+	Members  []uint
+	Expected string
+}{
+	{"0", []uint{0}, "0"},
+	{"0-3", []uint{0, 1, 2, 3}, "0-3"},
+	{"0-3,^2,8", []uint{0, 1, 3, 8}, "0-1,3,8"},
+	{" 8, 1 ,2-3", []uint{1, 2, 3, 8}, "1-3,8"},
+	{"62-65,127", []uint{62, 63, 64, 65, 127}, "62-65,127"},
+	{"0-3,^0-1", nil, ""},
+	{"3-1", nil, ""},
+	{"a", nil, ""},
+	{"1,,2", nil, ""},
+	{"", nil, ""},
+	{"0-4294967296", nil, ""},
+}
+
+func TestParseBitmap(t *testing.T) {
+	for _, test := range bitmapParseTestData {
+		b, err := ParseBitmap(test.Input)
+		if test.Members == nil {
+			if err == nil {
+				t.Fatalf("Expected error parsing '%s', got %s", test.Input, b)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(b.Members(), test.Members) {
+			t.Fatalf("Expected members %v for '%s', got %v", test.Members, test.Input, b.Members())
+		}
+		if b.String() != test.Expected {
+			t.Fatalf("Expected '%s' for '%s', got '%s'", test.Expected, test.Input, b.String())
+		}
+	}
+}
+
+func TestBitmapOperations(t *testing.T) {
+	a := NewBitmap(0, 1, 2, 3, 70)
+	b := NewBitmap(2, 3, 4)
+
+	if s := a.Union(b).String(); s != "0-4,70" {
+		t.Fatalf("Unexpected union %s", s)
+	}
+	if s := a.Intersection(b).String(); s != "2-3" {
+		t.Fatalf("Unexpected intersection %s", s)
+	}
+	if s := a.Difference(b).String(); s != "0-1,70" {
+		t.Fatalf("Unexpected difference %s", s)
+	}
+	if a.Count() != 5 || !a.IsSet(70) || a.IsSet(4) {
+		t.Fatalf("Unexpected members %s", a)
+	}
+	if !NewBitmap(2, 3).IsSubset(a) || b.IsSubset(a) {
+		t.Fatal("Unexpected subset result")
+	}
+	if !a.Equal(NewBitmap(70, 3, 2, 1, 0)) || a.Equal(b) {
+		t.Fatal("Unexpected equality result")
+	}
+
+	a.Clear(70)
+	if a.String() != "0-3" || !a.Equal(NewBitmap(0, 1, 2, 3)) {
+		t.Fatalf("Unexpected members after clear %s", a)
+	}
+	if !(Bitmap{}).IsEmpty() {
+		t.Fatal("Expected zero bitmap to be empty")
+	}
+}
+
+func TestBitmapCopy(t *testing.T) {
+	x := NewBitmap(0, 1, 2, 3)
+	y := x
+	y.Clear(1)
+	y.Set(2)
+	y.Set(100)
+	if x.String() != "0-3" {
+		t.Fatalf("Original changed with its copy to %s", x)
+	}
+	if y.String() != "0,2-3,100" {
+		t.Fatalf("Unexpected copy members %s", y)
+	}
+
+	z := x
+	x.Set(4)
+	if z.String() != "0-3" || x.String() != "0-4" {
+		t.Fatalf("Unexpected members %s and %s", z, x)
+	}
+}
+
+func TestBitmapAccessors(t *testing.T) {
+	pin := DomainCPUTuneVCPUPin{
+		VCPU:   0,
+		CPUSet: "0-7,^4",
+	}
+	cpus, err := pin.CPUSetBitmap()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cpus = cpus.Intersection(NewBitmap(4, 5, 6))
+	pin.SetCPUSetBitmap(cpus)
+	if pin.CPUSet != "5-6" {
+		t.Fatalf("Unexpected cpuset %s", pin.CPUSet)
+	}
+
+	vcpu := DomainVCPU{}
+	cpus, err = vcpu.CPUSetBitmap()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cpus.IsEmpty() {
+		t.Fatalf("Expected an empty cpuset, got %s", cpus)
+	}
+}
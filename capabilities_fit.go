@@ -0,0 +1,290 @@
+/*
+ * This file is part of the libvirt-go-xml project
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ *
+ * Copyright (C) 2020 Red Hat, Inc.
+ *
+ */
+
+package libvirtxml
+
+import (
+	"fmt"
+	"sort"
+)
+
+// CapsDomainFit reports how a domain configuration fits the host
+// described by a Caps document. The guest, domain and machine
+// fields point into the Caps document and are nil if no match was
+// found.
+type CapsDomainFit struct {
+	Guest    *CapsGuest
+	Domain   *CapsGuestDomain
+	Machine  *CapsGuestMachine
+	Emulator string
+	// Host NUMA cells which the domain memory is bound to
+	NUMANodes []uint
+	// Hugepage sizes requested by the domain
+	HugepageSizes []Size
+	Problems      ValidationErrors
+}
+
+// Fits reports whether no problems were found
+func (f *CapsDomainFit) Fits() bool {
+	return len(f.Problems) == 0
+}
+
+// Err returns the problems found as an error, or nil if the
+// domain fits the host
+func (f *CapsDomainFit) Err() error {
+	if len(f.Problems) == 0 {
+		return nil
+	}
+	return f.Problems
+}
+
+func (c *Caps) findDomainGuest(arch, osType string) *CapsGuest {
+	for i := range c.Guests {
+		guest := &c.Guests[i]
+		if guest.OSType == osType && guest.Arch.Name == arch {
+			return guest
+		}
+	}
+	return nil
+}
+
+func findCapsGuestMachine(machines []CapsGuestMachine, name string) *CapsGuestMachine {
+	for i := range machines {
+		if machines[i].Name == name || machines[i].Canonical == name {
+			return &machines[i]
+		}
+	}
+	return nil
+}
+
+func (c *Caps) fitDomainGuest(fit *CapsDomainFit, v *validator, dom *Domain) {
+	path := "/domain[0]/os[0]/type[0]"
+	if dom.OS == nil || dom.OS.Type == nil || dom.OS.Type.Type == "" {
+		v.add(path, "missing OS type")
+		return
+	}
+	arch := dom.OS.Type.Arch
+	if arch == "" && c.Host.CPU != nil {
+		arch = c.Host.CPU.Arch
+	}
+
+	fit.Guest = c.findDomainGuest(arch, dom.OS.Type.Type)
+	if fit.Guest == nil {
+		v.add(path, "host has no '%s' guests for architecture '%s'", dom.OS.Type.Type, arch)
+		return
+	}
+
+	for i := range fit.Guest.Arch.Domains {
+		if fit.Guest.Arch.Domains[i].Type == dom.Type {
+			fit.Domain = &fit.Guest.Arch.Domains[i]
+			break
+		}
+	}
+	if fit.Domain == nil {
+		v.add("/domain[0]/@type", "host does not support '%s' domains for architecture '%s'", dom.Type, arch)
+		return
+	}
+
+	fit.Emulator = fit.Guest.Arch.Emulator
+	if fit.Domain.Emulator != "" {
+		fit.Emulator = fit.Domain.Emulator
+	}
+
+	machines := fit.Guest.Arch.Machines
+	if len(fit.Domain.Machines) > 0 {
+		machines = fit.Domain.Machines
+	}
+	if dom.OS.Type.Machine == "" {
+		if len(machines) > 0 {
+			fit.Machine = &machines[0]
+		}
+		return
+	}
+	fit.Machine = findCapsGuestMachine(machines, dom.OS.Type.Machine)
+	if fit.Machine == nil {
+		v.add(path+"/@machine", "machine type '%s' is not supported by the host", dom.OS.Type.Machine)
+	}
+}
+
+func (c *Caps) findNUMACell(id uint) *CapsHostNUMACell {
+	if c.Host.NUMA == nil || c.Host.NUMA.Cells == nil {
+		return nil
+	}
+	for i := range c.Host.NUMA.Cells.Cells {
+		if c.Host.NUMA.Cells.Cells[i].ID == int(id) {
+			return &c.Host.NUMA.Cells.Cells[i]
+		}
+	}
+	return nil
+}
+
+func (c *Caps) fitDomainNodeset(fit *CapsDomainFit, v *validator, path, nodeset string) {
+	if nodeset == "" {
+		return
+	}
+	nodes, err := ParseBitmap(nodeset)
+	if err != nil {
+		v.add(path, "%s", err)
+		return
+	}
+	for _, node := range nodes.Members() {
+		if c.findNUMACell(node) == nil {
+			v.add(path, "host NUMA node %d does not exist", node)
+			continue
+		}
+		found := false
+		for _, other := range fit.NUMANodes {
+			if other == node {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fit.NUMANodes = append(fit.NUMANodes, node)
+		}
+	}
+}
+
+func (c *Caps) fitDomainNUMATune(fit *CapsDomainFit, v *validator, tune *DomainNUMATune) {
+	path := "/domain[0]/numatune[0]"
+	if tune.Memory != nil {
+		c.fitDomainNodeset(fit, v, path+"/memory[0]/@nodeset", tune.Memory.Nodeset)
+	}
+	for i, memnode := range tune.MemNodes {
+		c.fitDomainNodeset(fit, v, fmt.Sprintf("%s/memnode[%d]/@nodeset", path, i), memnode.Nodeset)
+	}
+	sort.Slice(fit.NUMANodes, func(i, j int) bool { return fit.NUMANodes[i] < fit.NUMANodes[j] })
+}
+
+// Returns the host NUMA nodes backing the memory of a set of guest
+// NUMA nodes, which are those of the memnode for each guest node,
+// or else those the memory of the whole domain is bound to
+func fitDomainCellNodes(tune *DomainNUMATune, cellset string) ([]uint, error) {
+	cells, err := ParseBitmap(cellset)
+	if err != nil {
+		return nil, err
+	}
+	nodes := Bitmap{}
+	for _, cell := range cells.Members() {
+		nodeset := ""
+		if tune != nil {
+			if tune.Memory != nil {
+				nodeset = tune.Memory.Nodeset
+			}
+			for _, memnode := range tune.MemNodes {
+				if memnode.CellID == cell {
+					nodeset = memnode.Nodeset
+				}
+			}
+		}
+		if nodeset == "" {
+			continue
+		}
+		// Invalid nodesets are reported against the numatune
+		bound, err := ParseBitmap(nodeset)
+		if err != nil {
+			continue
+		}
+		nodes = nodes.Union(bound)
+	}
+	return nodes.Members(), nil
+}
+
+func (c *Caps) fitDomainHugepages(fit *CapsDomainFit, v *validator, hugepages *DomainMemoryHugepages, tune *DomainNUMATune) {
+	hostSizes := make(map[Size]bool)
+	if c.Host.CPU != nil {
+		for _, page := range c.Host.CPU.PageSizes {
+			size, err := page.AsSize()
+			if err == nil {
+				hostSizes[size] = true
+			}
+		}
+	}
+
+	for i, page := range hugepages.Hugepages {
+		path := fmt.Sprintf("/domain[0]/memoryBacking[0]/hugepages[0]/page[%d]", i)
+		size, err := page.AsSize()
+		if err != nil {
+			v.add(path, "%s", err)
+			continue
+		}
+		fit.HugepageSizes = append(fit.HugepageSizes, size)
+		if !hostSizes[size] {
+			v.add(path+"/@size", "host does not support %s pages", size)
+			continue
+		}
+
+		// Memory bound to host NUMA nodes has to come from
+		// the page pools of those nodes. Pages used only for
+		// some guest NUMA nodes need just the host nodes which
+		// those are bound to.
+		nodes := fit.NUMANodes
+		if page.Nodeset != "" {
+			nodes, err = fitDomainCellNodes(tune, page.Nodeset)
+			if err != nil {
+				v.add(path+"/@nodeset", "%s", err)
+				continue
+			}
+		}
+		for _, node := range nodes {
+			cell := c.findNUMACell(node)
+			if cell == nil {
+				continue
+			}
+			found := false
+			for _, info := range cell.PageInfo {
+				infoSize, err := info.AsSize()
+				if err == nil && infoSize == size {
+					found = true
+					break
+				}
+			}
+			if !found {
+				v.add(path+"/@size", "host NUMA node %d has no %s pages", node, size)
+			}
+		}
+	}
+}
+
+// DomainFit checks whether the domain configuration can be
+// placed on the host described by the capabilities. The arch,
+// OS type, virt type and machine type must be offered by one of
+// the guests, and any host NUMA nodes and hugepage sizes the
+// domain refers to must exist on the host.
+func (c *Caps) DomainFit(dom *Domain) *CapsDomainFit {
+	fit := &CapsDomainFit{}
+	v := &validator{}
+
+	c.fitDomainGuest(fit, v, dom)
+	if dom.NUMATune != nil {
+		c.fitDomainNUMATune(fit, v, dom.NUMATune)
+	}
+	if dom.MemoryBacking != nil && dom.MemoryBacking.MemoryHugePages != nil {
+		c.fitDomainHugepages(fit, v, dom.MemoryBacking.MemoryHugePages, dom.NUMATune)
+	}
+
+	fit.Problems = v.errs
+	return fit
+}
@@ -0,0 +1,274 @@
+/*
+ * This file is part of the libvirt-go-xml project
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ *
+ * Copyright (C) 2020 Red Hat, Inc.
+ *
+ */
+
+package libvirtxml
+
+import (
+	"reflect"
+	"testing"
+)
+
+const capsFitXML = `<capabilities>
+  <host>
+    <cpu>
+      <arch>x86_64</arch>
+      <pages unit="KiB" size="4"/>
+      <pages unit="KiB" size="2048"/>
+      <pages unit="KiB" size="1048576"/>
+    </cpu>
+    <topology>
+      <cells num="2">
+        <cell id="0">
+          <pages unit="KiB" size="4">1000</pages>
+          <pages unit="KiB" size="2048">512</pages>
+        </cell>
+        <cell id="1">
+          <pages unit="KiB" size="4">1000</pages>
+          <pages unit="KiB" size="2048">512</pages>
+          <pages unit="KiB" size="1048576">4</pages>
+        </cell>
+      </cells>
+    </topology>
+  </host>
+  <guest>
+    <os_type>hvm</os_type>
+    <arch name="x86_64">
+      <wordsize>64</wordsize>
+      <emulator>/usr/bin/qemu-system-x86_64</emulator>
+      <machine maxCpus="255">pc-i440fx-5.0</machine>
+      <machine canonical="pc-i440fx-5.0" maxCpus="255">pc</machine>
+      <machine maxCpus="288">pc-q35-5.0</machine>
+      <machine canonical="pc-q35-5.0" maxCpus="288">q35</machine>
+      <domain type="qemu"/>
+      <domain type="kvm"/>
+    </arch>
+  </guest>
+</capabilities>`
+
+var capsFitTestData = []struct {
+	Modify    func(dom *Domain)
+	Machine   string
+	NUMANodes []uint
+	Paths     []string
+}{
+	{
+		Modify:  func(dom *Domain) {},
+		Machine: "pc-i440fx-5.0",
+	},
+	{
+		Modify: func(dom *Domain) {
+			dom.OS.Type.Arch = "x86_64"
+			dom.OS.Type.Machine = "q35"
+			dom.NUMATune = &DomainNUMATune{
+				Memory: &DomainNUMATuneMemory{
+					Mode:    "strict",
+					Nodeset: "0-1,^0",
+				},
+			}
+			dom.MemoryBacking = &DomainMemoryBacking{
+				MemoryHugePages: &DomainMemoryHugepages{
+					Hugepages: []DomainMemoryHugepage{
+						DomainMemoryHugepage{
+							Size: 1,
+							Unit: "G",
+						},
+					},
+				},
+			}
+		},
+		Machine:   "q35",
+		NUMANodes: []uint{1},
+	},
+	{
+		Modify: func(dom *Domain) {
+			dom.OS.Type.Arch = "aarch64"
+		},
+		Paths: []string{
+			"/domain[0]/os[0]/type[0]",
+		},
+	},
+	{
+		Modify: func(dom *Domain) {
+			dom.Type = "xen"
+		},
+		Paths: []string{
+			"/domain[0]/@type",
+		},
+	},
+	{
+		Modify: func(dom *Domain) {
+			dom.OS.Type.Machine = "virt"
+		},
+		Paths: []string{
+			"/domain[0]/os[0]/type[0]/@machine",
+		},
+	},
+	{
+		Modify: func(dom *Domain) {
+			dom.NUMATune = &DomainNUMATune{
+				MemNodes: []DomainNUMATuneMemNode{
+					DomainNUMATuneMemNode{
+						CellID:  0,
+						Mode:    "strict",
+						Nodeset: "0,3",
+					},
+				},
+			}
+			dom.MemoryBacking = &DomainMemoryBacking{
+				MemoryHugePages: &DomainMemoryHugepages{
+					Hugepages: []DomainMemoryHugepage{
+						DomainMemoryHugepage{
+							Size: 1048576,
+						},
+						DomainMemoryHugepage{
+							Size: 16,
+							Unit: "M",
+						},
+					},
+				},
+			}
+		},
+		Machine:   "pc-i440fx-5.0",
+		NUMANodes: []uint{0},
+		Paths: []string{
+			"/domain[0]/numatune[0]/memnode[0]/@nodeset",
+			"/domain[0]/memoryBacking[0]/hugepages[0]/page[0]/@size",
+			"/domain[0]/memoryBacking[0]/hugepages[0]/page[1]/@size",
+		},
+	},
+	{
+		Modify: func(dom *Domain) {
+			dom.NUMATune = &DomainNUMATune{
+				MemNodes: []DomainNUMATuneMemNode{
+					DomainNUMATuneMemNode{
+						CellID:  0,
+						Mode:    "strict",
+						Nodeset: "0",
+					},
+					DomainNUMATuneMemNode{
+						CellID:  1,
+						Mode:    "strict",
+						Nodeset: "1",
+					},
+				},
+			}
+			dom.MemoryBacking = &DomainMemoryBacking{
+				MemoryHugePages: &DomainMemoryHugepages{
+					Hugepages: []DomainMemoryHugepage{
+						DomainMemoryHugepage{
+							Size:    1,
+							Unit:    "G",
+							Nodeset: "1",
+						},
+						DomainMemoryHugepage{
+							Size:    2,
+							Unit:    "M",
+							Nodeset: "0",
+						},
+					},
+				},
+			}
+		},
+		Machine:   "pc-i440fx-5.0",
+		NUMANodes: []uint{0, 1},
+	},
+	{
+		Modify: func(dom *Domain) {
+			dom.NUMATune = &DomainNUMATune{
+				Memory: &DomainNUMATuneMemory{
+					Mode:    "strict",
+					Nodeset: "0-1",
+				},
+				MemNodes: []DomainNUMATuneMemNode{
+					DomainNUMATuneMemNode{
+						CellID:  1,
+						Mode:    "strict",
+						Nodeset: "1",
+					},
+				},
+			}
+			dom.MemoryBacking = &DomainMemoryBacking{
+				MemoryHugePages: &DomainMemoryHugepages{
+					Hugepages: []DomainMemoryHugepage{
+						DomainMemoryHugepage{
+							Size:    1,
+							Unit:    "G",
+							Nodeset: "0",
+						},
+						DomainMemoryHugepage{
+							Size:    1,
+							Unit:    "G",
+							Nodeset: "1-",
+						},
+					},
+				},
+			}
+		},
+		Machine:   "pc-i440fx-5.0",
+		NUMANodes: []uint{0, 1},
+		Paths: []string{
+			"/domain[0]/memoryBacking[0]/hugepages[0]/page[0]/@size",
+			"/domain[0]/memoryBacking[0]/hugepages[0]/page[1]/@nodeset",
+		},
+	},
+}
+
+func TestCapsDomainFit(t *testing.T) {
+	caps := &Caps{}
+	err := caps.Unmarshal(capsFitXML)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range capsFitTestData {
+		dom := validDomain()
+		test.Modify(dom)
+
+		fit := caps.DomainFit(dom)
+		if fit.Fits() != (len(test.Paths) == 0) {
+			t.Fatalf("Unexpected fit result %v", fit.Err())
+		}
+
+		paths := []string{}
+		for _, err := range fit.Problems {
+			paths = append(paths, err.Path)
+		}
+		if !reflect.DeepEqual(paths, append([]string{}, test.Paths...)) {
+			t.Fatalf("Expected problems at %v, got %v", test.Paths, fit.Problems)
+		}
+
+		if test.Machine != "" {
+			if fit.Machine == nil || fit.Machine.Name != test.Machine {
+				t.Fatalf("Expected machine %s, got %v", test.Machine, fit.Machine)
+			}
+			if fit.Emulator != "/usr/bin/qemu-system-x86_64" {
+				t.Fatalf("Unexpected emulator %s", fit.Emulator)
+			}
+		}
+		if !reflect.DeepEqual(fit.NUMANodes, test.NUMANodes) {
+			t.Fatalf("Expected NUMA nodes %v, got %v", test.NUMANodes, fit.NUMANodes)
+		}
+	}
+}
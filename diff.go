@@ -0,0 +1,301 @@
+/*
+ * This file is part of the libvirt-go-xml project
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ *
+ * Copyright (C) 2020 Red Hat, Inc.
+ *
+ */
+
+package libvirtxml
+
+import (
+	"encoding/xml"
+	"fmt"
+)
+
+// DiffType is the kind of change a Difference describes
+type DiffType string
+
+const (
+	DiffAdded    DiffType = "added"
+	DiffRemoved  DiffType = "removed"
+	DiffModified DiffType = "modified"
+)
+
+// Difference is a single change between two documents. Paths are
+// in the form "/domain[0]/devices[0]/disk[1]/@foo", with "/text()"
+// for the text content of an element. As matching elements may be
+// at different positions in each document, the path is given for
+// both, with OldPath empty for additions and NewPath empty for
+// removals. The values are those of the attribute or the text, or
+// the XML of the whole element for added and removed elements.
+type Difference struct {
+	Type     DiffType
+	OldPath  string
+	NewPath  string
+	OldValue string
+	NewValue string
+}
+
+// Path returns the path in the new document, or in the old
+// document for removals
+func (d Difference) Path() string {
+	if d.NewPath != "" {
+		return d.NewPath
+	}
+	return d.OldPath
+}
+
+func (d Difference) String() string {
+	switch d.Type {
+	case DiffAdded:
+		return fmt.Sprintf("added %s", d.NewPath)
+	case DiffRemoved:
+		return fmt.Sprintf("removed %s", d.OldPath)
+	}
+	return fmt.Sprintf("modified %s: '%s' -> '%s'", d.NewPath, d.OldValue, d.NewValue)
+}
+
+// Pairs up the child elements of two matching elements, first by
+// identity and then by position among the remaining elements of
+// the same name which have no conflicting identity. The returned
+// slice is indexed by position in olds, holding the index into
+// news or -1.
+func diffMatchElements(olds, news []*xmlNode) []int {
+	matches := make([]int, len(olds))
+	used := make([]bool, len(news))
+	oldCount := make(map[string]int)
+	newCount := make(map[string]int)
+	for _, n := range olds {
+		oldCount[n.Space+" "+n.Local]++
+	}
+	for _, n := range news {
+		newCount[n.Space+" "+n.Local]++
+	}
+	repeated := func(n *xmlNode) bool {
+		name := n.Space + " " + n.Local
+		return oldCount[name] > 1 || newCount[name] > 1
+	}
+
+	oldKeys := make([][]string, len(olds))
+	newKeys := make([][]string, len(news))
+	for i := range olds {
+		matches[i] = -1
+		oldKeys[i] = xmlNodeIdentity(olds[i], repeated(olds[i]))
+	}
+	for j := range news {
+		newKeys[j] = xmlNodeIdentity(news[j], repeated(news[j]))
+	}
+	sameName := func(i, j int) bool {
+		return olds[i].Space == news[j].Space && olds[i].Local == news[j].Local
+	}
+
+	for probe := range xmlIdentityProbes {
+		for i := range olds {
+			if matches[i] != -1 || oldKeys[i][probe] == "" {
+				continue
+			}
+			for j := range news {
+				if !used[j] && sameName(i, j) && newKeys[j][probe] == oldKeys[i][probe] {
+					matches[i] = j
+					used[j] = true
+					break
+				}
+			}
+		}
+	}
+
+	for i := range olds {
+		if matches[i] != -1 {
+			continue
+		}
+		for j := range news {
+			if used[j] || !sameName(i, j) {
+				continue
+			}
+			if !xmlIdentityConflicts(oldKeys[i], newKeys[j]) {
+				matches[i] = j
+				used[j] = true
+				break
+			}
+		}
+	}
+	return matches
+}
+
+func diffElements(n *xmlNode) []*xmlNode {
+	elements := []*xmlNode{}
+	for _, child := range n.Children {
+		if child.isElement() {
+			elements = append(elements, child)
+		}
+	}
+	return elements
+}
+
+// Returns the path of each element, numbering elements
+// of the same name from zero
+func diffElementPaths(path string, elements []*xmlNode) []string {
+	paths := make([]string, len(elements))
+	indexes := make(map[string]uint)
+	for i, child := range elements {
+		name := xmlName(child.Space, xml.Name{Local: child.Local})
+		paths[i] = fmt.Sprintf("%s/%s[%d]", path, name, indexes[name])
+		indexes[name]++
+	}
+	return paths
+}
+
+func diffXMLNode(oldNode, newNode *xmlNode, oldPath, newPath string) ([]Difference, error) {
+	diffs := []Difference{}
+
+	for _, attr := range oldNode.Attrs {
+		if attr.Space == "xmlns" {
+			continue
+		}
+		name := "/@" + xmlName(attr.Space, xml.Name{Local: attr.Local})
+		val, ok := newNode.getAttr(attr.Space, attr.Local)
+		if !ok {
+			diffs = append(diffs, Difference{
+				Type:     DiffRemoved,
+				OldPath:  oldPath + name,
+				OldValue: attr.Value,
+			})
+		} else if val != attr.Value {
+			diffs = append(diffs, Difference{
+				Type:     DiffModified,
+				OldPath:  oldPath + name,
+				NewPath:  newPath + name,
+				OldValue: attr.Value,
+				NewValue: val,
+			})
+		}
+	}
+	for _, attr := range newNode.Attrs {
+		if attr.Space == "xmlns" {
+			continue
+		}
+		if _, ok := oldNode.getAttr(attr.Space, attr.Local); !ok {
+			diffs = append(diffs, Difference{
+				Type:     DiffAdded,
+				NewPath:  newPath + "/@" + xmlName(attr.Space, xml.Name{Local: attr.Local}),
+				NewValue: attr.Value,
+			})
+		}
+	}
+
+	oldText, newText := oldNode.content(), newNode.content()
+	if oldText != newText {
+		diff := Difference{
+			Type:     DiffModified,
+			OldPath:  oldPath + "/text()",
+			NewPath:  newPath + "/text()",
+			OldValue: oldText,
+			NewValue: newText,
+		}
+		if oldText == "" {
+			diff.Type, diff.OldPath = DiffAdded, ""
+		} else if newText == "" {
+			diff.Type, diff.NewPath = DiffRemoved, ""
+		}
+		diffs = append(diffs, diff)
+	}
+
+	olds, news := diffElements(oldNode), diffElements(newNode)
+	oldPaths, newPaths := diffElementPaths(oldPath, olds), diffElementPaths(newPath, news)
+	matches := diffMatchElements(olds, news)
+	matched := make([]int, len(news))
+	for j := range matched {
+		matched[j] = -1
+	}
+	for i, j := range matches {
+		if j != -1 {
+			matched[j] = i
+			continue
+		}
+		doc, err := formatXMLNode(olds[i])
+		if err != nil {
+			return nil, err
+		}
+		diffs = append(diffs, Difference{
+			Type:     DiffRemoved,
+			OldPath:  oldPaths[i],
+			OldValue: doc,
+		})
+	}
+	for j, i := range matched {
+		if i == -1 {
+			doc, err := formatXMLNode(news[j])
+			if err != nil {
+				return nil, err
+			}
+			diffs = append(diffs, Difference{
+				Type:     DiffAdded,
+				NewPath:  newPaths[j],
+				NewValue: doc,
+			})
+			continue
+		}
+		subDiffs, err := diffXMLNode(olds[i], news[j], oldPaths[i], newPaths[j])
+		if err != nil {
+			return nil, err
+		}
+		diffs = append(diffs, subDiffs...)
+	}
+
+	return diffs, nil
+}
+
+// DiffXML compares two XML documents, returning every change
+// needed to turn the old one into the new one
+func DiffXML(oldDoc, newDoc string) ([]Difference, error) {
+	oldRoot, err := parseXMLNode(oldDoc)
+	if err != nil {
+		return nil, err
+	}
+	newRoot, err := parseXMLNode(newDoc)
+	if err != nil {
+		return nil, err
+	}
+
+	oldName := xmlName(oldRoot.Space, xml.Name{Local: oldRoot.Local})
+	newName := xmlName(newRoot.Space, xml.Name{Local: newRoot.Local})
+	if oldName != newName {
+		return nil, fmt.Errorf("root element '%s' does not match '%s'", oldName, newName)
+	}
+	return diffXMLNode(oldRoot, newRoot, "/"+oldName+"[0]", "/"+newName+"[0]")
+}
+
+// Diff compares two documents of the same type, such as two
+// versions of a Domain or a Network, returning every change
+// needed to turn the old one into the new one. Repeated elements
+// such as devices are matched by identity rather than by
+// position, so reordering them is not reported as a change.
+func Diff(oldObj, newObj Document) ([]Difference, error) {
+	oldDoc, err := oldObj.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	newDoc, err := newObj.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	return DiffXML(oldDoc, newDoc)
+}
@@ -0,0 +1,257 @@
+/*
+ * This file is part of the libvirt-go-xml project
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ *
+ * Copyright (C) 2020 Red Hat, Inc.
+ *
+ */
+
+package libvirtxml
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func diffTestDomain(memory uint, image string, mac string, swap bool) *Domain {
+	dom := &Domain{
+		Type: "kvm",
+		Name: "demo",
+		Memory: &DomainMemory{
+			Value: memory,
+			Unit:  "KiB",
+		},
+		Devices: &DomainDeviceList{
+			Disks: []DomainDisk{
+				DomainDisk{
+					Device: "disk",
+					Source: &DomainDiskSource{
+						File: &DomainDiskSourceFile{
+							File: image,
+						},
+					},
+					Target: &DomainDiskTarget{
+						Dev: "vda",
+						Bus: "virtio",
+					},
+				},
+				DomainDisk{
+					Device: "cdrom",
+					Target: &DomainDiskTarget{
+						Dev: "sda",
+						Bus: "sata",
+					},
+				},
+			},
+			Interfaces: []DomainInterface{
+				DomainInterface{
+					MAC: &DomainInterfaceMAC{
+						Address: mac,
+					},
+					Source: &DomainInterfaceSource{
+						Network: &DomainInterfaceSourceNetwork{
+							Network: "default",
+						},
+					},
+				},
+			},
+		},
+	}
+	if swap {
+		disks := dom.Devices.Disks
+		disks[0], disks[1] = disks[1], disks[0]
+	}
+	return dom
+}
+
+func TestDiffDomain(t *testing.T) {
+	oldDom := diffTestDomain(1048576, "/var/lib/libvirt/images/a.img", "52:54:00:00:00:01", false)
+	newDom := diffTestDomain(2097152, "/var/lib/libvirt/images/b.img", "52:54:00:00:00:02", true)
+
+	diffs, err := Diff(oldDom, newDom)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Difference{
+		Difference{
+			Type:     DiffModified,
+			OldPath:  "/domain[0]/memory[0]/text()",
+			NewPath:  "/domain[0]/memory[0]/text()",
+			OldValue: "1048576",
+			NewValue: "2097152",
+		},
+		Difference{
+			Type:    DiffRemoved,
+			OldPath: "/domain[0]/devices[0]/interface[0]",
+			OldValue: strings.Join([]string{
+				`<interface type="network">`,
+				`  <mac address="52:54:00:00:00:01"></mac>`,
+				`  <source network="default"></source>`,
+				`</interface>`,
+			}, "\n"),
+		},
+		Difference{
+			Type:     DiffModified,
+			OldPath:  "/domain[0]/devices[0]/disk[0]/source[0]/@file",
+			NewPath:  "/domain[0]/devices[0]/disk[1]/source[0]/@file",
+			OldValue: "/var/lib/libvirt/images/a.img",
+			NewValue: "/var/lib/libvirt/images/b.img",
+		},
+		Difference{
+			Type:    DiffAdded,
+			NewPath: "/domain[0]/devices[0]/interface[0]",
+			NewValue: strings.Join([]string{
+				`<interface type="network">`,
+				`  <mac address="52:54:00:00:00:02"></mac>`,
+				`  <source network="default"></source>`,
+				`</interface>`,
+			}, "\n"),
+		},
+	}
+	if !reflect.DeepEqual(diffs, expected) {
+		t.Fatalf("Expected differences\n%v\ngot\n%v", expected, diffs)
+	}
+
+	diffs, err = Diff(oldDom, oldDom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 0 {
+		t.Fatalf("Expected no differences, got %v", diffs)
+	}
+}
+
+func TestDiffNetwork(t *testing.T) {
+	oldNet := &Network{
+		Name: "default",
+		Bridge: &NetworkBridge{
+			Name: "virbr0",
+		},
+		IPs: []NetworkIP{
+			NetworkIP{
+				Address: "192.168.122.1",
+				Netmask: "255.255.255.0",
+				DHCP: &NetworkDHCP{
+					Hosts: []NetworkDHCPHost{
+						NetworkDHCPHost{
+							MAC:  "52:54:00:00:00:01",
+							Name: "one",
+							IP:   "192.168.122.10",
+						},
+					},
+				},
+			},
+		},
+	}
+	newNet := &Network{
+		Name: "default",
+		Bridge: &NetworkBridge{
+			Name: "virbr1",
+		},
+		IPs: []NetworkIP{
+			NetworkIP{
+				Address: "192.168.122.1",
+				Netmask: "255.255.255.0",
+				DHCP: &NetworkDHCP{
+					Hosts: []NetworkDHCPHost{
+						NetworkDHCPHost{
+							MAC:  "52:54:00:00:00:02",
+							Name: "two",
+							IP:   "192.168.122.11",
+						},
+						NetworkDHCPHost{
+							MAC:  "52:54:00:00:00:01",
+							Name: "one",
+							IP:   "192.168.122.12",
+						},
+					},
+				},
+			},
+		},
+	}
+
+	diffs, err := Diff(oldNet, newNet)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paths := []string{}
+	for _, diff := range diffs {
+		paths = append(paths, diff.String())
+	}
+	expected := []string{
+		"modified /network[0]/bridge[0]/@name: 'virbr0' -> 'virbr1'",
+		"added /network[0]/ip[0]/dhcp[0]/host[0]",
+		"modified /network[0]/ip[0]/dhcp[0]/host[1]/@ip: '192.168.122.10' -> '192.168.122.12'",
+	}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Fatalf("Expected differences\n%v\ngot\n%v", expected, paths)
+	}
+
+	_, err = Diff(oldNet, diffTestDomain(1024, "", "", false))
+	if err == nil {
+		t.Fatal("Expected an error comparing different document types")
+	}
+}
+
+func TestDiffXMLMatching(t *testing.T) {
+	tests := []struct {
+		Old      string
+		New      string
+		Expected []string
+	}{
+		{
+			// A single device is still the same device after
+			// being moved to another address
+			Old: `<domain><devices><video><model type="virtio"/>` +
+				`<address type="pci" domain="0x0000" bus="0x00" slot="0x02" function="0x0"/></video></devices></domain>`,
+			New: `<domain><devices><video><model type="virtio"/>` +
+				`<address type="pci" domain="0x0000" bus="0x00" slot="0x03" function="0x0"/></video></devices></domain>`,
+			Expected: []string{
+				"modified /domain[0]/devices[0]/video[0]/address[0]/@slot: '0x02' -> '0x03'",
+			},
+		},
+		{
+			// A conflicting candidate is skipped in favour of a
+			// later compatible one
+			Old: `<root><item name="a" value="1"/></root>`,
+			New: `<root><item name="c" value="2"/><item value="1"/></root>`,
+			Expected: []string{
+				"added /root[0]/item[0]",
+				"removed /root[0]/item[0]/@name",
+			},
+		},
+	}
+	for i, test := range tests {
+		diffs, err := DiffXML(test.Old, test.New)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths := []string{}
+		for _, diff := range diffs {
+			paths = append(paths, diff.String())
+		}
+		if !reflect.DeepEqual(paths, test.Expected) {
+			t.Errorf("Test %d: expected differences\n%v\ngot\n%v", i, test.Expected, paths)
+		}
+	}
+}
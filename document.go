@@ -1,6 +1,90 @@
 package libvirtxml
 
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
 type Document interface {
 	Unmarshal(doc string) error
 	Marshal() (string, error)
 }
+
+// DocumentFactory returns a new, empty document for a given root element
+type DocumentFactory func() Document
+
+var documentFactoriesLock sync.RWMutex
+var documentFactories = map[string]DocumentFactory{
+	"capabilities":            func() Document { return &Caps{} },
+	"device":                  func() Document { return &NodeDevice{} },
+	"domain":                  func() Document { return &Domain{} },
+	"domainCapabilities":      func() Document { return &DomainCaps{} },
+	"domainbackup":            func() Document { return &DomainBackup{} },
+	"domaincheckpoint":        func() Document { return &DomainCheckpoint{} },
+	"domainsnapshot":          func() Document { return &DomainSnapshot{} },
+	"domstatus":               func() Document { return &DomainStatus{} },
+	"filter":                  func() Document { return &NWFilter{} },
+	"filterbinding":           func() Document { return &NWFilterBinding{} },
+	"interface":               func() Document { return &Interface{} },
+	"network":                 func() Document { return &Network{} },
+	"networkport":             func() Document { return &NetworkPort{} },
+	"pool":                    func() Document { return &StoragePool{} },
+	"secret":                  func() Document { return &Secret{} },
+	"sources":                 func() Document { return &StoragePoolSources{} },
+	"storagepoolCapabilities": func() Document { return &StoragePoolCaps{} },
+	"volume":                  func() Document { return &StorageVolume{} },
+}
+
+// RegisterDocument associates the XML root element name with a factory
+// used by ParseDocument, replacing any existing registration for it
+func RegisterDocument(root string, factory DocumentFactory) {
+	documentFactoriesLock.Lock()
+	defer documentFactoriesLock.Unlock()
+	if factory == nil {
+		delete(documentFactories, root)
+	} else {
+		documentFactories[root] = factory
+	}
+}
+
+// DocumentRoot returns the name of the root element of an XML document
+func DocumentRoot(doc string) (string, error) {
+	d := xml.NewDecoder(strings.NewReader(doc))
+	for {
+		t, err := d.Token()
+		if err == io.EOF {
+			return "", fmt.Errorf("Missing root element in XML document")
+		} else if err != nil {
+			return "", err
+		}
+		if start, ok := t.(xml.StartElement); ok {
+			return start.Name.Local, nil
+		}
+	}
+}
+
+// ParseDocument parses an XML document of unknown kind, choosing the
+// Document type from the name of its root element
+func ParseDocument(doc string) (Document, error) {
+	root, err := DocumentRoot(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	documentFactoriesLock.RLock()
+	factory, ok := documentFactories[root]
+	documentFactoriesLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("Unknown XML document root element '%s'", root)
+	}
+
+	ret := factory()
+	err = ret.Unmarshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
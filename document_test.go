@@ -0,0 +1,104 @@
+/*
+ * This file is part of the libvirt-go-xml project
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ *
+ * Copyright (C) 2020 Red Hat, Inc.
+ *
+ */
+
+package libvirtxml
+
+import (
+	"reflect"
+	"testing"
+)
+
+var parseDocumentTestData = []struct {
+	XML    string
+	Object Document
+}{
+	{
+		XML:    `<?xml version="1.0"?><domain type="kvm"><name>demo</name></domain>`,
+		Object: &Domain{Type: "kvm", Name: "demo"},
+	},
+	{
+		XML:    `<!-- comment --><network><name>default</name></network>`,
+		Object: &Network{Name: "default"},
+	},
+	{
+		XML:    `<pool type="dir"><name>images</name></pool>`,
+		Object: &StoragePool{Type: "dir", Name: "images"},
+	},
+	{
+		XML:    `<filter name="clean-traffic"></filter>`,
+		Object: &NWFilter{Name: "clean-traffic"},
+	},
+	{
+		XML:    `<domainsnapshot><name>snap1</name></domainsnapshot>`,
+		Object: &DomainSnapshot{Name: "snap1"},
+	},
+}
+
+func TestParseDocument(t *testing.T) {
+	for _, test := range parseDocumentTestData {
+		doc, err := ParseDocument(test.XML)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if reflect.TypeOf(doc) != reflect.TypeOf(test.Object) {
+			t.Fatalf("Expected %T for %s, got %T", test.Object, test.XML, doc)
+		}
+
+		expect, err := test.Object.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := doc.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual != expect {
+			t.Fatal("Bad xml:\n", actual, "\n does not match\n", expect, "\n")
+		}
+	}
+
+	_, err := ParseDocument(`<unknown></unknown>`)
+	if err == nil {
+		t.Fatal("Expected error for unknown root element")
+	}
+}
+
+func TestRegisterDocument(t *testing.T) {
+	RegisterDocument("disk", func() Document { return &DomainDisk{} })
+	defer RegisterDocument("disk", nil)
+
+	doc, err := ParseDocument(`<disk type="file" device="disk"><target dev="vda"></target></disk>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	disk, ok := doc.(*DomainDisk)
+	if !ok {
+		t.Fatalf("Expected *DomainDisk, got %T", doc)
+	}
+	if disk.Target == nil || disk.Target.Dev != "vda" {
+		t.Fatal("Disk target was not parsed")
+	}
+}
@@ -0,0 +1,200 @@
+/*
+ * This file is part of the libvirt-go-xml project
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ *
+ * Copyright (C) 2020 Red Hat, Inc.
+ *
+ */
+
+package libvirtxml
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var domainBackupTestData = []struct {
+	Object   *DomainBackup
+	Expected []string
+}{
+	{
+		Object: &DomainBackup{
+			Mode:        "push",
+			Incremental: "1525889631",
+			Disks: &DomainBackupDisks{
+				Disks: []DomainBackupDisk{
+					DomainBackupDisk{
+						Name:       "vda",
+						Backup:     "yes",
+						BackupMode: "incremental",
+						Driver: &DomainDiskDriver{
+							Type: "raw",
+						},
+						Target: &DomainDiskSource{
+							File: &DomainDiskSourceFile{
+								File: "/path/to/vda.backup",
+							},
+						},
+					},
+					DomainBackupDisk{
+						Name:   "vdb",
+						Backup: "yes",
+						Driver: &DomainDiskDriver{
+							Type: "qcow2",
+						},
+						Target: &DomainDiskSource{
+							Block: &DomainDiskSourceBlock{
+								Dev: "/dev/sdb1",
+							},
+							Encryption: &DomainDiskEncryption{
+								Format: "luks",
+								Secret: &DomainDiskSecret{
+									Type: "passphrase",
+									UUID: "0a81f5b2-8403-7b23-c8d6-21ccc2f80d6f",
+								},
+							},
+						},
+					},
+					DomainBackupDisk{
+						Name:   "vdc",
+						Backup: "no",
+					},
+				},
+			},
+		},
+		Expected: []string{
+			`<domainbackup mode="push">`,
+			`  <incremental>1525889631</incremental>`,
+			`  <disks>`,
+			`    <disk type="file" name="vda" backup="yes" backupmode="incremental">`,
+			`      <driver type="raw"></driver>`,
+			`      <target file="/path/to/vda.backup"></target>`,
+			`    </disk>`,
+			`    <disk type="block" name="vdb" backup="yes">`,
+			`      <driver type="qcow2"></driver>`,
+			`      <target dev="/dev/sdb1">`,
+			`        <encryption format="luks">`,
+			`          <secret type="passphrase" uuid="0a81f5b2-8403-7b23-c8d6-21ccc2f80d6f"></secret>`,
+			`        </encryption>`,
+			`      </target>`,
+			`    </disk>`,
+			`    <disk name="vdc" backup="no"></disk>`,
+			`  </disks>`,
+			`</domainbackup>`,
+		},
+	},
+	{
+		Object: &DomainBackup{
+			Mode: "pull",
+			Server: &DomainBackupServer{
+				DomainDiskSourceHost: DomainDiskSourceHost{
+					Transport: "tcp",
+					Name:      "localhost",
+					Port:      "10809",
+				},
+				TLS: "yes",
+			},
+			Disks: &DomainBackupDisks{
+				Disks: []DomainBackupDisk{
+					DomainBackupDisk{
+						Name:         "vda",
+						Backup:       "yes",
+						ExportName:   "vda",
+						ExportBitmap: "backup-vda",
+						Driver: &DomainDiskDriver{
+							Type: "qcow2",
+						},
+						Scratch: &DomainDiskSource{
+							File: &DomainDiskSourceFile{
+								File: "/path/to/scratch.qcow2",
+							},
+						},
+					},
+				},
+			},
+		},
+		Expected: []string{
+			`<domainbackup mode="pull">`,
+			`  <server transport="tcp" name="localhost" port="10809" tls="yes"></server>`,
+			`  <disks>`,
+			`    <disk type="file" name="vda" backup="yes" exportname="vda" exportbitmap="backup-vda">`,
+			`      <driver type="qcow2"></driver>`,
+			`      <scratch file="/path/to/scratch.qcow2"></scratch>`,
+			`    </disk>`,
+			`  </disks>`,
+			`</domainbackup>`,
+		},
+	},
+	{
+		Object: &DomainBackup{
+			Mode: "pull",
+			Server: &DomainBackupServer{
+				DomainDiskSourceHost: DomainDiskSourceHost{
+					Transport: "unix",
+					Socket:    "/path/to/sock",
+				},
+			},
+		},
+		Expected: []string{
+			`<domainbackup mode="pull">`,
+			`  <server transport="unix" socket="/path/to/sock"></server>`,
+			`</domainbackup>`,
+		},
+	},
+}
+
+func TestDomainBackup(t *testing.T) {
+	for _, test := range domainBackupTestData {
+		doc, err := test.Object.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expect := strings.Join(test.Expected, "\n")
+
+		if doc != expect {
+			t.Fatal("Bad initial xml:\n", string(doc), "\n does not match\n", expect, "\n")
+		}
+
+		typ := reflect.ValueOf(test.Object).Elem().Type()
+
+		newobj := reflect.New(typ)
+
+		newdocobj, ok := newobj.Interface().(Document)
+		if !ok {
+			t.Fatalf("Could not clone %s", newobj.Interface())
+		}
+
+		err = newdocobj.Unmarshal(expect)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		doc, err = newdocobj.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if doc != expect {
+			t.Fatal("Bad roundtrip xml:\n", string(doc), "\n does not match\n", expect, "\n")
+		}
+	}
+}
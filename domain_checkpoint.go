@@ -0,0 +1,65 @@
+/*
+ * This file is part of the libvirt-go-xml project
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ *
+ * Copyright (C) 2020 Red Hat, Inc.
+ *
+ */
+
+package libvirtxml
+
+import "encoding/xml"
+
+type DomainCheckpointParent struct {
+	Name string `xml:"name"`
+}
+
+type DomainCheckpointDisk struct {
+	Name       string `xml:"name,attr"`
+	Checkpoint string `xml:"checkpoint,attr,omitempty"`
+	Bitmap     string `xml:"bitmap,attr,omitempty"`
+	Size       uint64 `xml:"size,attr,omitempty"`
+}
+
+type DomainCheckpointDisks struct {
+	Disks []DomainCheckpointDisk `xml:"disk"`
+}
+
+type DomainCheckpoint struct {
+	XMLName      xml.Name                `xml:"domaincheckpoint"`
+	Name         string                  `xml:"name,omitempty"`
+	Description  string                  `xml:"description,omitempty"`
+	CreationTime string                  `xml:"creationTime,omitempty"`
+	Parent       *DomainCheckpointParent `xml:"parent"`
+	Disks        *DomainCheckpointDisks  `xml:"disks"`
+	Domain       *Domain                 `xml:"domain"`
+}
+
+func (s *DomainCheckpoint) Unmarshal(doc string) error {
+	return xml.Unmarshal([]byte(doc), s)
+}
+
+func (s *DomainCheckpoint) Marshal() (string, error) {
+	doc, err := xml.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(doc), nil
+}
@@ -0,0 +1,153 @@
+/*
+ * This file is part of the libvirt-go-xml project
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ *
+ * Copyright (C) 2020 Red Hat, Inc.
+ *
+ */
+
+package libvirtxml
+
+import (
+	"strings"
+	"testing"
+)
+
+var domainCheckpointTestData = []struct {
+	Object   *DomainCheckpoint
+	Expected []string
+}{
+	{
+		Object: &DomainCheckpoint{
+			Description: "Completion of updates after OS install",
+			Disks: &DomainCheckpointDisks{
+				[]DomainCheckpointDisk{
+					DomainCheckpointDisk{
+						Name:       "vda",
+						Checkpoint: "bitmap",
+					},
+					DomainCheckpointDisk{
+						Name:       "vdb",
+						Checkpoint: "no",
+					},
+				},
+			},
+		},
+		Expected: []string{
+			`<domaincheckpoint>`,
+			`  <description>Completion of updates after OS install</description>`,
+			`  <disks>`,
+			`    <disk name="vda" checkpoint="bitmap"></disk>`,
+			`    <disk name="vdb" checkpoint="no"></disk>`,
+			`  </disks>`,
+			`</domaincheckpoint>`,
+		},
+	},
+	{
+		Object: &DomainCheckpoint{
+			Name:         "1525889631",
+			Description:  "Completion of updates after OS install",
+			CreationTime: "1525889631",
+			Parent: &DomainCheckpointParent{
+				Name: "1525111885",
+			},
+			Disks: &DomainCheckpointDisks{
+				Disks: []DomainCheckpointDisk{
+					DomainCheckpointDisk{
+						Name:       "vda",
+						Checkpoint: "bitmap",
+						Bitmap:     "1525889631",
+						Size:       1048576,
+					},
+					DomainCheckpointDisk{
+						Name:       "vdb",
+						Checkpoint: "no",
+					},
+				},
+			},
+			Domain: &Domain{
+				Name: "fedora",
+				Memory: &DomainMemory{
+					Value: 1048576,
+				},
+				Devices: &DomainDeviceList{
+					Disks: []DomainDisk{
+						DomainDisk{
+							Device: "disk",
+							Driver: &DomainDiskDriver{
+								Name: "qemu",
+								Type: "qcow2",
+							},
+							Source: &DomainDiskSource{
+								File: &DomainDiskSourceFile{
+									File: "/path/to/vda",
+								},
+							},
+							Target: &DomainDiskTarget{
+								Dev: "vda",
+								Bus: "virtio",
+							},
+						},
+					},
+				},
+			},
+		},
+		Expected: []string{
+			`<domaincheckpoint>`,
+			`  <name>1525889631</name>`,
+			`  <description>Completion of updates after OS install</description>`,
+			`  <creationTime>1525889631</creationTime>`,
+			`  <parent>`,
+			`    <name>1525111885</name>`,
+			`  </parent>`,
+			`  <disks>`,
+			`    <disk name="vda" checkpoint="bitmap" bitmap="1525889631" size="1048576"></disk>`,
+			`    <disk name="vdb" checkpoint="no"></disk>`,
+			`  </disks>`,
+			`  <domain>`,
+			`    <name>fedora</name>`,
+			`    <memory>1048576</memory>`,
+			`    <devices>`,
+			`      <disk type="file" device="disk">`,
+			`        <driver name="qemu" type="qcow2"></driver>`,
+			`        <source file="/path/to/vda"></source>`,
+			`        <target dev="vda" bus="virtio"></target>`,
+			`      </disk>`,
+			`    </devices>`,
+			`  </domain>`,
+			`</domaincheckpoint>`,
+		},
+	},
+}
+
+func TestDomainCheckpoint(t *testing.T) {
+	for _, test := range domainCheckpointTestData {
+		doc, err := test.Object.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expect := strings.Join(test.Expected, "\n")
+
+		if doc != expect {
+			t.Fatal("Bad xml:\n", string(doc), "\n does not match\n", expect, "\n")
+		}
+	}
+}
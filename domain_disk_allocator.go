@@ -0,0 +1,249 @@
+/*
+ * This file is part of the libvirt-go-xml project
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ *
+ * Copyright (C) 2020 Red Hat, Inc.
+ *
+ */
+
+package libvirtxml
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var diskBusPrefixes = map[string]string{
+	"ide":    "hd",
+	"fdc":    "fd",
+	"scsi":   "sd",
+	"sata":   "sd",
+	"usb":    "sd",
+	"virtio": "vd",
+	"xen":    "xvd",
+	"uml":    "ubd",
+	"sd":     "mmcblk",
+}
+
+// Buses whose target names are numbered from zero, as in
+// "mmcblk0", rather than lettered
+var diskBusNumbered = map[string]bool{
+	"sd": true,
+}
+
+// Layout of the drive addresses on each kind of disk controller,
+// matching the address libvirt derives from the target name
+var diskBusDriveLayout = map[string]struct {
+	buses uint
+	units uint
+}{
+	"ide":  {2, 2},
+	"fdc":  {1, 2},
+	"scsi": {1, 7},
+	"sata": {1, 6},
+}
+
+// Converts a disk name index to its letters, as in 0 -> "a",
+// 25 -> "z" and 26 -> "aa"
+func diskIndexName(prefix string, idx uint) string {
+	name := []byte{}
+	for i := int(idx); i >= 0; i = i/26 - 1 {
+		name = append([]byte{byte('a' + i%26)}, name...)
+	}
+	return prefix + string(name)
+}
+
+// Converts a disk name back to its index, which is only
+// possible if it has the expected prefix
+func diskNameIndex(prefix, name string) (uint, bool) {
+	if !strings.HasPrefix(name, prefix) || len(name) == len(prefix) {
+		return 0, false
+	}
+	idx := 0
+	for _, c := range name[len(prefix):] {
+		if c < 'a' || c > 'z' {
+			return 0, false
+		}
+		idx = idx*26 + int(c-'a') + 1
+	}
+	return uint(idx - 1), true
+}
+
+// Like diskIndexName and diskNameIndex, for numbered names
+func diskNumberName(prefix string, idx uint) string {
+	return prefix + strconv.FormatUint(uint64(idx), 10)
+}
+
+func diskNameNumber(prefix, name string) (uint, bool) {
+	if !strings.HasPrefix(name, prefix) {
+		return 0, false
+	}
+	idx, err := strconv.ParseUint(name[len(prefix):], 10, 32)
+	if err != nil || diskNumberName(prefix, uint(idx)) != name {
+		return 0, false
+	}
+	return uint(idx), true
+}
+
+func (l *DomainDeviceList) usedDriveAddresses(bus string) map[DriveAddress]bool {
+	used := make(map[DriveAddress]bool)
+	for _, disk := range l.Disks {
+		if disk.Target == nil || disk.Target.Bus != bus ||
+			disk.Address == nil || disk.Address.Drive == nil {
+			continue
+		}
+		used[disk.Address.Drive.DriveAddress()] = true
+	}
+	if bus == "scsi" {
+		for _, hostdev := range l.Hostdevs {
+			if hostdev.SubsysSCSI == nil ||
+				hostdev.Address == nil || hostdev.Address.Drive == nil {
+				continue
+			}
+			used[hostdev.Address.Drive.DriveAddress()] = true
+		}
+	}
+	return used
+}
+
+func (l *DomainDeviceList) hasController(typ string, index uint) bool {
+	for i := range l.Controllers {
+		if l.Controllers[i].Type == typ && controllerIndex(&l.Controllers[i]) == index {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Domain) assignDiskTarget(disk *DomainDisk) error {
+	bus := disk.Target.Bus
+	prefix, ok := diskBusPrefixes[bus]
+	if !ok {
+		return fmt.Errorf("unsupported disk bus '%s'", bus)
+	}
+	indexName, nameIndex := diskIndexName, diskNameIndex
+	if diskBusNumbered[bus] {
+		indexName, nameIndex = diskNumberName, diskNameNumber
+	}
+
+	used := make(map[uint]bool)
+	for _, other := range d.Devices.Disks {
+		if other.Target == nil {
+			continue
+		}
+		if other.Target.Dev == disk.Target.Dev && disk.Target.Dev != "" {
+			return fmt.Errorf("disk target '%s' is already in use", disk.Target.Dev)
+		}
+		if idx, ok := nameIndex(prefix, other.Target.Dev); ok {
+			used[idx] = true
+		}
+	}
+	if disk.Target.Dev != "" {
+		return nil
+	}
+
+	idx := uint(0)
+	for used[idx] {
+		idx++
+	}
+	disk.Target.Dev = indexName(prefix, idx)
+	return nil
+}
+
+func (d *Domain) assignDiskDriveAddress(disk *DomainDisk) error {
+	bus := disk.Target.Bus
+	layout, ok := diskBusDriveLayout[bus]
+	if !ok || disk.Address != nil {
+		return nil
+	}
+
+	// Start from the address libvirt would derive from the target
+	// name, moving on to the next free one if that is taken
+	start := uint(0)
+	if idx, ok := diskNameIndex(diskBusPrefixes[bus], disk.Target.Dev); ok {
+		start = idx
+	}
+	used := d.Devices.usedDriveAddresses(bus)
+	perController := layout.buses * layout.units
+	for idx := start; ; idx++ {
+		controller := idx / perController
+		if bus == "fdc" && controller > 0 {
+			return fmt.Errorf("no free floppy drive addresses left")
+		}
+		if controller > 255 {
+			return fmt.Errorf("no free %s drive addresses left", bus)
+		}
+		addr := DriveAddress{
+			Controller: controller,
+			Bus:        (idx % perController) / layout.units,
+			Unit:       idx % layout.units,
+		}
+		if used[addr] {
+			continue
+		}
+
+		disk.Address = &DomainAddress{
+			Drive: addr.DomainAddressDrive(),
+		}
+		if !d.Devices.hasController(bus, controller) {
+			index := controller
+			ctrl := DomainController{
+				Type:  bus,
+				Index: &index,
+			}
+			if bus == "scsi" {
+				ctrl.Model = "virtio-scsi"
+			}
+			d.Devices.Controllers = append(d.Devices.Controllers, ctrl)
+		}
+		return nil
+	}
+}
+
+// AddDisk appends a disk to the domain, picking the next free
+// target name for its bus if none is set, such as "vdb", "sdc" or
+// "mmcblk1".
+// Disks on IDE, SCSI, SATA and floppy buses are also given a free
+// drive address if none is set, adding the disk controller it
+// refers to if the domain lacks it. The disk's target bus must
+// be set.
+func (d *Domain) AddDisk(disk DomainDisk) error {
+	if disk.Target == nil || disk.Target.Bus == "" {
+		return fmt.Errorf("disk target bus must be set")
+	}
+	if d.Devices == nil {
+		d.Devices = &DomainDeviceList{}
+	}
+
+	target := *disk.Target
+	disk.Target = &target
+	err := d.assignDiskTarget(&disk)
+	if err != nil {
+		return err
+	}
+	err = d.assignDiskDriveAddress(&disk)
+	if err != nil {
+		return err
+	}
+
+	d.Devices.Disks = append(d.Devices.Disks, disk)
+	return nil
+}
@@ -0,0 +1,195 @@
+/*
+ * This file is part of the libvirt-go-xml project
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ *
+ * Copyright (C) 2020 Red Hat, Inc.
+ *
+ */
+
+package libvirtxml
+
+import (
+	"strings"
+	"testing"
+)
+
+var diskAllocZero uint = 0
+
+func diskAllocDomain() *Domain {
+	return &Domain{
+		Type: "kvm",
+		Name: "demo",
+		Devices: &DomainDeviceList{
+			Disks: []DomainDisk{
+				DomainDisk{
+					Target: &DomainDiskTarget{
+						Dev: "vda",
+						Bus: "virtio",
+					},
+				},
+				DomainDisk{
+					Target: &DomainDiskTarget{
+						Dev: "sda",
+						Bus: "sata",
+					},
+					Address: &DomainAddress{
+						Drive: &DomainAddressDrive{
+							Controller: &diskAllocZero,
+							Bus:        &diskAllocZero,
+							Target:     &diskAllocZero,
+							Unit:       &diskAllocZero,
+						},
+					},
+				},
+			},
+			Controllers: []DomainController{
+				DomainController{
+					Type:  "sata",
+					Index: &diskAllocZero,
+				},
+			},
+		},
+	}
+}
+
+func TestDomainAddDisk(t *testing.T) {
+	dom := diskAllocDomain()
+	for _, bus := range []string{"virtio", "sata", "scsi", "ide", "ide", "ide", "sd", "sd"} {
+		err := dom.AddDisk(DomainDisk{
+			Target: &DomainDiskTarget{
+				Bus: bus,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	doc, err := dom.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := strings.Join([]string{
+		`<domain type="kvm">`,
+		`  <name>demo</name>`,
+		`  <devices>`,
+		`    <disk>`,
+		`      <target dev="vda" bus="virtio"></target>`,
+		`    </disk>`,
+		`    <disk>`,
+		`      <target dev="sda" bus="sata"></target>`,
+		`      <address type="drive" controller="0" bus="0" target="0" unit="0"></address>`,
+		`    </disk>`,
+		`    <disk>`,
+		`      <target dev="vdb" bus="virtio"></target>`,
+		`    </disk>`,
+		`    <disk>`,
+		`      <target dev="sdb" bus="sata"></target>`,
+		`      <address type="drive" controller="0" bus="0" target="0" unit="1"></address>`,
+		`    </disk>`,
+		`    <disk>`,
+		`      <target dev="sdc" bus="scsi"></target>`,
+		`      <address type="drive" controller="0" bus="0" target="0" unit="2"></address>`,
+		`    </disk>`,
+		`    <disk>`,
+		`      <target dev="hda" bus="ide"></target>`,
+		`      <address type="drive" controller="0" bus="0" target="0" unit="0"></address>`,
+		`    </disk>`,
+		`    <disk>`,
+		`      <target dev="hdb" bus="ide"></target>`,
+		`      <address type="drive" controller="0" bus="0" target="0" unit="1"></address>`,
+		`    </disk>`,
+		`    <disk>`,
+		`      <target dev="hdc" bus="ide"></target>`,
+		`      <address type="drive" controller="0" bus="1" target="0" unit="0"></address>`,
+		`    </disk>`,
+		`    <disk>`,
+		`      <target dev="mmcblk0" bus="sd"></target>`,
+		`    </disk>`,
+		`    <disk>`,
+		`      <target dev="mmcblk1" bus="sd"></target>`,
+		`    </disk>`,
+		`    <controller type="sata" index="0"></controller>`,
+		`    <controller type="scsi" index="0" model="virtio-scsi"></controller>`,
+		`    <controller type="ide" index="0"></controller>`,
+		`  </devices>`,
+		`</domain>`,
+	}, "\n")
+
+	if doc != expect {
+		t.Fatal("Bad xml:\n", string(doc), "\n does not match\n", expect, "\n")
+	}
+}
+
+func TestDomainAddDiskErrors(t *testing.T) {
+	dom := diskAllocDomain()
+	err := dom.AddDisk(DomainDisk{
+		Target: &DomainDiskTarget{
+			Dev: "vda",
+			Bus: "virtio",
+		},
+	})
+	if err == nil {
+		t.Fatal("Expected an error for a duplicate target")
+	}
+
+	err = dom.AddDisk(DomainDisk{})
+	if err == nil {
+		t.Fatal("Expected an error for a missing target bus")
+	}
+}
+
+func TestDiskIndexName(t *testing.T) {
+	for idx, name := range map[uint]string{
+		0:   "sda",
+		25:  "sdz",
+		26:  "sdaa",
+		701: "sdzz",
+		702: "sdaaa",
+	} {
+		if got := diskIndexName("sd", idx); got != name {
+			t.Fatalf("Expected %s for %d, got %s", name, idx, got)
+		}
+		if got, ok := diskNameIndex("sd", name); !ok || got != idx {
+			t.Fatalf("Expected %d for %s, got %d", idx, name, got)
+		}
+	}
+}
+
+func TestDiskNumberName(t *testing.T) {
+	for idx, name := range map[uint]string{
+		0:  "mmcblk0",
+		9:  "mmcblk9",
+		10: "mmcblk10",
+	} {
+		if got := diskNumberName("mmcblk", idx); got != name {
+			t.Fatalf("Expected %s for %d, got %s", name, idx, got)
+		}
+		if got, ok := diskNameNumber("mmcblk", name); !ok || got != idx {
+			t.Fatalf("Expected %d for %s, got %d", idx, name, got)
+		}
+	}
+	for _, name := range []string{"mmcblk", "mmcblka", "mmcblk01", "sda"} {
+		if _, ok := diskNameNumber("mmcblk", name); ok {
+			t.Fatalf("Unexpected index for %s", name)
+		}
+	}
+}
@@ -0,0 +1,374 @@
+/*
+ * This file is part of the libvirt-go-xml project
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ *
+ * Copyright (C) 2020 Red Hat, Inc.
+ *
+ */
+
+package libvirtxml
+
+import (
+	"encoding/xml"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// DomainDeviceAction is the live operation to apply to a device
+type DomainDeviceAction string
+
+const (
+	DomainDeviceAttach DomainDeviceAction = "attach"
+	DomainDeviceDetach DomainDeviceAction = "detach"
+	DomainDeviceUpdate DomainDeviceAction = "update"
+)
+
+// DomainDeviceOperation is a device change to apply to a running
+// domain. XML is the device XML to pass to the attach, detach or
+// update device APIs, and Path is where the device is in the
+// current domain for detach, or the desired domain otherwise.
+type DomainDeviceOperation struct {
+	Action DomainDeviceAction
+	Device string
+	Path   string
+	XML    string
+}
+
+// DomainHotplugPlan lists the operations which turn a running
+// domain into the desired configuration, along with the changes
+// which can only take effect when the domain is restarted
+type DomainHotplugPlan struct {
+	Operations      []DomainDeviceOperation
+	RestartRequired []Difference
+}
+
+// NeedsRestart reports whether some changes can not be applied
+// to the running domain
+func (p *DomainHotplugPlan) NeedsRestart() bool {
+	return len(p.RestartRequired) != 0
+}
+
+type domainHotplugDevice struct {
+	node *xmlNode
+	xml  string
+}
+
+// The order in which devices are attached. Controllers go first
+// so that devices can be attached to them, and are detached last.
+var domainHotplugOrder = []string{
+	"controller", "disk", "lease", "filesystem", "interface", "smartcard",
+	"serial", "parallel", "console", "channel", "input", "tpm", "graphics",
+	"sound", "audio", "video", "hostdev", "redirdev", "redirfilter", "hub",
+	"watchdog", "memballoon", "rng", "nvram", "panic", "shmem", "memory",
+	"iommu", "vsock",
+}
+
+var domainHotplugCapable = map[string]bool{
+	"controller": true,
+	"disk":       true,
+	"lease":      true,
+	"interface":  true,
+	"serial":     true,
+	"console":    true,
+	"channel":    true,
+	"input":      true,
+	"hostdev":    true,
+	"redirdev":   true,
+	"watchdog":   true,
+	"rng":        true,
+	"shmem":      true,
+	"memory":     true,
+	"vsock":      true,
+}
+
+// The parts of each kind of device which can be changed with
+// the update device API rather than by replugging the device
+var domainHotplugUpdatable = map[string][]string{
+	"disk":      []string{"/source[0]", "/@type"},
+	"interface": []string{"/link[0]", "/bandwidth[0]", "/filterref[0]", "/source[0]"},
+	"graphics":  []string{"/@passwd", "/@passwdValidTo", "/@connected"},
+}
+
+// Parts of the domain outside of the devices which can be
+// changed while it is running
+var domainHotplugLivePaths = []string{
+	"/domain[0]/title[0]",
+	"/domain[0]/description[0]",
+	"/domain[0]/metadata[0]",
+	"/domain[0]/currentMemory[0]",
+	"/domain[0]/vcpu[0]/@current",
+	"/domain[0]/memtune[0]",
+	"/domain[0]/blkiotune[0]",
+	"/domain[0]/cputune[0]",
+}
+
+func hasPathPrefix(path, prefix string) bool {
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// Returns every device along with its element name, taken from
+// the field tags since some device types have no XMLName
+func (l *DomainDeviceList) hotplugDevices() ([]string, []interface{}) {
+	names := []string{}
+	devs := []interface{}{}
+	v := reflect.ValueOf(l).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		name := strings.Split(v.Type().Field(i).Tag.Get("xml"), ",")[0]
+		switch field.Kind() {
+		case reflect.Slice:
+			for j := 0; j < field.Len(); j++ {
+				names = append(names, name)
+				devs = append(devs, field.Index(j).Addr().Interface())
+			}
+		case reflect.Ptr:
+			if !field.IsNil() {
+				names = append(names, name)
+				devs = append(devs, field.Interface())
+			}
+		}
+	}
+	return names, devs
+}
+
+// Marshals every device, grouping them by element name
+func domainHotplugDevices(dom *Domain) (map[string][]domainHotplugDevice, error) {
+	devs := make(map[string][]domainHotplugDevice)
+	if dom.Devices == nil {
+		return devs, nil
+	}
+	names, list := dom.Devices.hotplugDevices()
+	for i, dev := range list {
+		var buf strings.Builder
+		enc := xml.NewEncoder(&buf)
+		enc.Indent("", "  ")
+		err := enc.EncodeElement(dev, xml.StartElement{Name: xml.Name{Local: names[i]}})
+		if err != nil {
+			return nil, err
+		}
+		doc := buf.String()
+		node, err := parseXMLNode(doc)
+		if err != nil {
+			return nil, err
+		}
+		devs[names[i]] = append(devs[names[i]], domainHotplugDevice{
+			node: node,
+			xml:  doc,
+		})
+	}
+	return devs, nil
+}
+
+// Reports whether all the changes to a device can be made in place.
+// Removals only have a path in the old document, so are relative to
+// the device's old path, and other changes to its new path.
+func domainHotplugCanUpdate(name string, node *xmlNode, oldPath, newPath string, diffs []Difference) bool {
+	prefixes, ok := domainHotplugUpdatable[name]
+	if !ok {
+		return false
+	}
+	if name == "disk" {
+		device, _ := node.getAttr("", "device")
+		if device != "cdrom" && device != "floppy" {
+			return false
+		}
+	}
+	for _, diff := range diffs {
+		rel := strings.TrimPrefix(diff.NewPath, newPath)
+		if diff.Type == DiffRemoved {
+			rel = strings.TrimPrefix(diff.OldPath, oldPath)
+		}
+		found := false
+		for _, prefix := range prefixes {
+			if hasPathPrefix(rel, prefix) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func planDomainHotplugDevices(plan *DomainHotplugPlan, current, desired *Domain) error {
+	currentDevs, err := domainHotplugDevices(current)
+	if err != nil {
+		return err
+	}
+	desiredDevs, err := domainHotplugDevices(desired)
+	if err != nil {
+		return err
+	}
+
+	detach := []DomainDeviceOperation{}
+	update := []DomainDeviceOperation{}
+	attach := []DomainDeviceOperation{}
+	devicesPath := "/domain[0]/devices[0]"
+	for _, name := range domainHotplugOrder {
+		olds, news := currentDevs[name], desiredDevs[name]
+		oldNodes := make([]*xmlNode, len(olds))
+		for i, dev := range olds {
+			oldNodes[i] = dev.node
+		}
+		newNodes := make([]*xmlNode, len(news))
+		for i, dev := range news {
+			newNodes[i] = dev.node
+		}
+		oldPaths := diffElementPaths(devicesPath, oldNodes)
+		newPaths := diffElementPaths(devicesPath, newNodes)
+
+		matches := diffMatchElements(oldNodes, newNodes)
+		matched := make([]bool, len(news))
+		capable := domainHotplugCapable[name]
+		for i, j := range matches {
+			if j == -1 {
+				if !capable {
+					plan.RestartRequired = append(plan.RestartRequired, Difference{
+						Type:     DiffRemoved,
+						OldPath:  oldPaths[i],
+						OldValue: olds[i].xml,
+					})
+					continue
+				}
+				detach = append([]DomainDeviceOperation{DomainDeviceOperation{
+					Action: DomainDeviceDetach,
+					Device: name,
+					Path:   oldPaths[i],
+					XML:    olds[i].xml,
+				}}, detach...)
+				continue
+			}
+			matched[j] = true
+
+			diffs, err := diffXMLNode(oldNodes[i], newNodes[j], oldPaths[i], newPaths[j])
+			if err != nil {
+				return err
+			}
+			if len(diffs) == 0 {
+				continue
+			}
+			if domainHotplugCanUpdate(name, oldNodes[i], oldPaths[i], newPaths[j], diffs) {
+				update = append(update, DomainDeviceOperation{
+					Action: DomainDeviceUpdate,
+					Device: name,
+					Path:   newPaths[j],
+					XML:    news[j].xml,
+				})
+			} else if capable {
+				detach = append([]DomainDeviceOperation{DomainDeviceOperation{
+					Action: DomainDeviceDetach,
+					Device: name,
+					Path:   oldPaths[i],
+					XML:    olds[i].xml,
+				}}, detach...)
+				attach = append(attach, DomainDeviceOperation{
+					Action: DomainDeviceAttach,
+					Device: name,
+					Path:   newPaths[j],
+					XML:    news[j].xml,
+				})
+			} else {
+				plan.RestartRequired = append(plan.RestartRequired, diffs...)
+			}
+		}
+
+		for j := range news {
+			if matched[j] {
+				continue
+			}
+			if !capable {
+				plan.RestartRequired = append(plan.RestartRequired, Difference{
+					Type:     DiffAdded,
+					NewPath:  newPaths[j],
+					NewValue: news[j].xml,
+				})
+				continue
+			}
+			attach = append(attach, DomainDeviceOperation{
+				Action: DomainDeviceAttach,
+				Device: name,
+				Path:   newPaths[j],
+				XML:    news[j].xml,
+			})
+		}
+	}
+
+	plan.Operations = append(plan.Operations, detach...)
+	plan.Operations = append(plan.Operations, update...)
+	plan.Operations = append(plan.Operations, attach...)
+	return nil
+}
+
+// PlanDomainHotplug works out how to turn a running domain with
+// the current configuration into the desired configuration. Devices
+// are matched by identity, and each one which was added, removed or
+// changed becomes an operation. Devices are detached first, in
+// reverse order, then updated and then attached. Changes which can
+// not be made live, such as to the CPU model, the machine type or
+// devices which do not support hotplug, are reported as requiring
+// a restart instead.
+func PlanDomainHotplug(current, desired *Domain) (*DomainHotplugPlan, error) {
+	plan := &DomainHotplugPlan{}
+
+	currentBase, desiredBase := *current, *desired
+	currentBase.Devices, desiredBase.Devices = nil, nil
+	diffs, err := Diff(&currentBase, &desiredBase)
+	if err != nil {
+		return nil, err
+	}
+	for _, diff := range diffs {
+		live := false
+		for _, prefix := range domainHotplugLivePaths {
+			if hasPathPrefix(diff.Path(), prefix) {
+				live = true
+				break
+			}
+		}
+		if !live {
+			plan.RestartRequired = append(plan.RestartRequired, diff)
+		}
+	}
+
+	var currentEmulator, desiredEmulator string
+	if current.Devices != nil {
+		currentEmulator = current.Devices.Emulator
+	}
+	if desired.Devices != nil {
+		desiredEmulator = desired.Devices.Emulator
+	}
+	if currentEmulator != desiredEmulator {
+		plan.RestartRequired = append(plan.RestartRequired, Difference{
+			Type:     DiffModified,
+			OldPath:  "/domain[0]/devices[0]/emulator[0]/text()",
+			NewPath:  "/domain[0]/devices[0]/emulator[0]/text()",
+			OldValue: currentEmulator,
+			NewValue: desiredEmulator,
+		})
+	}
+
+	err = planDomainHotplugDevices(plan, current, desired)
+	if err != nil {
+		return nil, fmt.Errorf("unable to compare devices: %s", err)
+	}
+	return plan, nil
+}
@@ -0,0 +1,310 @@
+/*
+ * This file is part of the libvirt-go-xml project
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ *
+ * Copyright (C) 2020 Red Hat, Inc.
+ *
+ */
+
+package libvirtxml
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func hotplugTestDomain() *Domain {
+	return &Domain{
+		Type: "kvm",
+		Name: "demo",
+		OS: &DomainOS{
+			Type: &DomainOSType{
+				Arch:    "x86_64",
+				Machine: "pc-q35-5.0",
+				Type:    "hvm",
+			},
+		},
+		CurrentMemory: &DomainCurrentMemory{
+			Value: 1048576,
+		},
+		Devices: &DomainDeviceList{
+			Emulator: "/usr/bin/qemu-system-x86_64",
+			Disks: []DomainDisk{
+				DomainDisk{
+					Device: "disk",
+					Source: &DomainDiskSource{
+						File: &DomainDiskSourceFile{
+							File: "/var/lib/libvirt/images/a.img",
+						},
+					},
+					Target: &DomainDiskTarget{
+						Dev: "vda",
+						Bus: "virtio",
+					},
+				},
+				DomainDisk{
+					Device: "cdrom",
+					Source: &DomainDiskSource{
+						File: &DomainDiskSourceFile{
+							File: "/var/lib/libvirt/images/a.iso",
+						},
+					},
+					Target: &DomainDiskTarget{
+						Dev: "sda",
+						Bus: "sata",
+					},
+				},
+			},
+			Interfaces: []DomainInterface{
+				DomainInterface{
+					MAC: &DomainInterfaceMAC{
+						Address: "52:54:00:00:00:01",
+					},
+					Source: &DomainInterfaceSource{
+						Network: &DomainInterfaceSourceNetwork{
+							Network: "default",
+						},
+					},
+				},
+			},
+			Videos: []DomainVideo{
+				DomainVideo{
+					Model: DomainVideoModel{
+						Type: "qxl",
+					},
+				},
+			},
+		},
+	}
+}
+
+var hotplugTests = []struct {
+	Modify     func(dom *Domain)
+	Operations []string
+	Restart    []string
+}{
+	{
+		Modify: func(dom *Domain) {},
+	},
+	{
+		Modify: func(dom *Domain) {
+			dom.CurrentMemory.Value = 524288
+		},
+	},
+	{
+		Modify: func(dom *Domain) {
+			dom.Devices.Disks[1].Source.File.File = "/var/lib/libvirt/images/b.iso"
+		},
+		Operations: []string{
+			"update disk /domain[0]/devices[0]/disk[1]",
+		},
+	},
+	{
+		Modify: func(dom *Domain) {
+			dom.Devices.Disks = dom.Devices.Disks[1:]
+			dom.Devices.Disks[0].Source = nil
+		},
+		Operations: []string{
+			"detach disk /domain[0]/devices[0]/disk[0]",
+			"update disk /domain[0]/devices[0]/disk[0]",
+		},
+	},
+	{
+		Modify: func(dom *Domain) {
+			dom.Devices.Disks[0].Source.File.File = "/var/lib/libvirt/images/b.img"
+		},
+		Operations: []string{
+			"detach disk /domain[0]/devices[0]/disk[0]",
+			"attach disk /domain[0]/devices[0]/disk[0]",
+		},
+	},
+	{
+		Modify: func(dom *Domain) {
+			dom.Devices.Interfaces[0].Link = &DomainInterfaceLink{
+				State: "down",
+			}
+		},
+		Operations: []string{
+			"update interface /domain[0]/devices[0]/interface[0]",
+		},
+	},
+	{
+		Modify: func(dom *Domain) {
+			dom.Devices.Disks = append(dom.Devices.Disks, DomainDisk{
+				Device: "disk",
+				Target: &DomainDiskTarget{
+					Dev: "vdb",
+					Bus: "virtio",
+				},
+			})
+			dom.Devices.Interfaces[0].MAC.Address = "52:54:00:00:00:02"
+			dom.Devices.Controllers = []DomainController{
+				DomainController{
+					Type:  "scsi",
+					Model: "virtio-scsi",
+				},
+			}
+		},
+		Operations: []string{
+			"detach interface /domain[0]/devices[0]/interface[0]",
+			"attach controller /domain[0]/devices[0]/controller[0]",
+			"attach disk /domain[0]/devices[0]/disk[2]",
+			"attach interface /domain[0]/devices[0]/interface[0]",
+		},
+	},
+	{
+		Modify: func(dom *Domain) {
+			dom.Devices.Disks = dom.Devices.Disks[0:1]
+			dom.Devices.Videos[0].Model.Type = "virtio"
+		},
+		Operations: []string{
+			"detach disk /domain[0]/devices[0]/disk[1]",
+		},
+		Restart: []string{
+			"modified /domain[0]/devices[0]/video[0]/model[0]/@type: 'qxl' -> 'virtio'",
+		},
+	},
+	{
+		Modify: func(dom *Domain) {
+			dom.OS.Type.Machine = "pc-q35-6.0"
+			dom.CPU = &DomainCPU{
+				Mode: "custom",
+				Model: &DomainCPUModel{
+					Value: "Skylake-Client",
+				},
+			}
+			dom.Devices.Emulator = "/usr/libexec/qemu-kvm"
+		},
+		Restart: []string{
+			"modified /domain[0]/os[0]/type[0]/@machine: 'pc-q35-5.0' -> 'pc-q35-6.0'",
+			"added /domain[0]/cpu[0]",
+			"modified /domain[0]/devices[0]/emulator[0]/text(): '/usr/bin/qemu-system-x86_64' -> '/usr/libexec/qemu-kvm'",
+		},
+	},
+}
+
+func TestDomainHotplug(t *testing.T) {
+	for i, test := range hotplugTests {
+		current := hotplugTestDomain()
+		desired := hotplugTestDomain()
+		test.Modify(desired)
+
+		plan, err := PlanDomainHotplug(current, desired)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ops := []string{}
+		for _, op := range plan.Operations {
+			ops = append(ops, string(op.Action)+" "+op.Device+" "+op.Path)
+			if op.XML == "" {
+				t.Errorf("Test %d: missing XML for %s", i, op.Path)
+			}
+		}
+		if len(ops) != len(test.Operations) {
+			t.Fatalf("Test %d: expected operations %v, got %v", i, test.Operations, ops)
+		}
+		for j := range ops {
+			if ops[j] != test.Operations[j] {
+				t.Fatalf("Test %d: expected operations %v, got %v", i, test.Operations, ops)
+			}
+		}
+
+		restart := []string{}
+		for _, diff := range plan.RestartRequired {
+			restart = append(restart, diff.String())
+		}
+		if plan.NeedsRestart() != (len(test.Restart) != 0) || len(restart) != len(test.Restart) {
+			t.Fatalf("Test %d: expected restart %v, got %v", i, test.Restart, restart)
+		}
+		for j := range restart {
+			if restart[j] != test.Restart[j] {
+				t.Fatalf("Test %d: expected restart %v, got %v", i, test.Restart, restart)
+			}
+		}
+	}
+}
+
+func TestDomainHotplugAllDevices(t *testing.T) {
+	current := &Domain{Type: "kvm", Name: "demo", Devices: &DomainDeviceList{}}
+	desired := &Domain{}
+	err := desired.Unmarshal(`<domain type="kvm">
+  <name>demo</name>
+  <devices>
+    <disk type="file" device="disk"><source file="/a.img"/><target dev="vda" bus="virtio"/></disk>
+    <controller type="scsi" index="0"/>
+    <lease><lockspace>ls</lockspace><key>k</key><target path="/lease"/></lease>
+    <filesystem type="mount"><source dir="/src"/><target dir="tag"/></filesystem>
+    <interface type="network"><mac address="52:54:00:00:00:09"/><source network="default"/></interface>
+    <smartcard mode="host"/>
+    <serial type="pty"><target port="0"/></serial>
+    <parallel type="pty"><target port="0"/></parallel>
+    <console type="pty"><target type="serial" port="0"/></console>
+    <channel type="unix"><target type="virtio" name="org.qemu.guest_agent.0"/></channel>
+    <input type="tablet" bus="usb"/>
+    <tpm model="tpm-crb"><backend type="emulator"/></tpm>
+    <graphics type="vnc" port="-1"/>
+    <sound model="ich9"/>
+    <audio id="1" type="none"/>
+    <video><model type="virtio"/></video>
+    <hostdev mode="subsystem" type="pci"><source><address domain="0" bus="1" slot="0" function="0"/></source></hostdev>
+    <redirdev bus="usb" type="spicevmc"/>
+    <redirfilter><usbdev allow="yes"/></redirfilter>
+    <hub type="usb"/>
+    <watchdog model="i6300esb"/>
+    <memballoon model="virtio"/>
+    <rng model="virtio"><backend model="random">/dev/urandom</backend></rng>
+    <nvram/>
+    <panic model="isa"/>
+    <shmem name="shm"/>
+    <memory model="dimm"><target><size unit="KiB">524288</size><node>0</node></target></memory>
+    <iommu model="intel"/>
+    <vsock model="virtio"><cid auto="yes"/></vsock>
+  </devices>
+</domain>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := PlanDomainHotplug(current, desired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	for _, op := range plan.Operations {
+		seen[op.Device] = true
+	}
+	for _, diff := range plan.RestartRequired {
+		path := strings.TrimPrefix(diff.Path(), "/domain[0]/devices[0]/")
+		seen[path[:strings.Index(path, "[")]] = true
+	}
+
+	typ := reflect.TypeOf(DomainDeviceList{})
+	for i := 0; i < typ.NumField(); i++ {
+		name := strings.Split(typ.Field(i).Tag.Get("xml"), ",")[0]
+		if name == "emulator" {
+			continue
+		}
+		if !seen[name] {
+			t.Errorf("device %s is neither planned nor restart-required", name)
+		}
+	}
+}
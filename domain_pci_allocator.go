@@ -0,0 +1,636 @@
+/*
+ * This file is part of the libvirt-go-xml project
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ *
+ * Copyright (C) 2020 Red Hat, Inc.
+ *
+ */
+
+package libvirtxml
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// The kinds of device which can be plugged into a PCI bus
+type pciConnect uint
+
+const (
+	pciConnectPCI pciConnect = 1 << iota
+	pciConnectPCIe
+	pciConnectIntegrated
+	pciConnectRootPort
+	pciConnectSwitchUpstreamPort
+	pciConnectSwitchDownstreamPort
+	pciConnectPCIBridge
+	pciConnectPCIeToPCIBridge
+	pciConnectDMIToPCIBridge
+)
+
+type pciAllocBus struct {
+	index   uint
+	model   string
+	accepts pciConnect
+	minSlot uint
+	maxSlot uint
+	// Bitmask of the functions in use for each slot
+	used map[uint]uint8
+}
+
+func newPCIAllocBus(index uint, model string) *pciAllocBus {
+	bus := &pciAllocBus{
+		index: index,
+		model: model,
+		used:  make(map[uint]uint8),
+	}
+	switch model {
+	case "pci-root", "pci-expander-bus", "pci-bridge", "pcie-to-pci-bridge":
+		bus.accepts = pciConnectPCI | pciConnectPCIBridge
+		bus.minSlot, bus.maxSlot = 1, 31
+	case "pcie-root", "pcie-expander-bus":
+		bus.accepts = pciConnectIntegrated | pciConnectRootPort | pciConnectDMIToPCIBridge
+		bus.minSlot, bus.maxSlot = 1, 31
+	case "pcie-root-port", "pcie-switch-downstream-port":
+		bus.accepts = pciConnectPCIe | pciConnectPCIeToPCIBridge | pciConnectSwitchUpstreamPort
+		bus.minSlot, bus.maxSlot = 0, 0
+	case "dmi-to-pci-bridge":
+		bus.accepts = pciConnectPCIBridge
+		bus.minSlot, bus.maxSlot = 0, 31
+	case "pcie-switch-upstream-port":
+		bus.accepts = pciConnectSwitchDownstreamPort
+		bus.minSlot, bus.maxSlot = 0, 31
+	}
+	return bus
+}
+
+func pciControllerConnect(model string) pciConnect {
+	switch model {
+	case "pcie-root-port":
+		return pciConnectRootPort
+	case "pcie-switch-upstream-port":
+		return pciConnectSwitchUpstreamPort
+	case "pcie-switch-downstream-port":
+		return pciConnectSwitchDownstreamPort
+	case "pci-bridge":
+		return pciConnectPCIBridge
+	case "pcie-to-pci-bridge":
+		return pciConnectPCIeToPCIBridge
+	case "dmi-to-pci-bridge":
+		return pciConnectDMIToPCIBridge
+	case "pci-expander-bus":
+		return pciConnectPCI
+	case "pcie-expander-bus":
+		return pciConnectIntegrated
+	}
+	return 0
+}
+
+type pciAllocator struct {
+	pcie      bool
+	buses     []*pciAllocBus
+	nextIndex uint
+	// Controllers added while allocating, which are appended
+	// to the device list once allocation is complete so that
+	// pointers into it stay valid
+	controllers []DomainController
+	// Location of the most recently placed root port, so that
+	// further root ports can share its slot as functions
+	portAddr     *DomainAddressPCI
+	portBus      *pciAllocBus
+	portSlot     uint
+	portFunction uint
+	// Addresses as they were before being assigned, so the
+	// domain can be put back as it was if allocation fails
+	undo []pciAddressUndo
+}
+
+type pciAddressUndo struct {
+	addr   **DomainAddress
+	ptr    *DomainAddress
+	val    DomainAddress
+	pciPtr *DomainAddressPCI
+	pciVal DomainAddressPCI
+}
+
+func (a *pciAllocator) rollback() {
+	for i := len(a.undo) - 1; i >= 0; i-- {
+		u := a.undo[i]
+		*u.addr = u.ptr
+		if u.ptr != nil {
+			*u.ptr = u.val
+		}
+		if u.pciPtr != nil {
+			*u.pciPtr = u.pciVal
+		}
+	}
+	a.undo = nil
+}
+
+func (a *pciAllocator) findBus(index uint) *pciAllocBus {
+	for _, bus := range a.buses {
+		if bus.index == index {
+			return bus
+		}
+	}
+	return nil
+}
+
+func (a *pciAllocator) addBus(bus *pciAllocBus) {
+	a.buses = append(a.buses, bus)
+	sort.Slice(a.buses, func(i, j int) bool { return a.buses[i].index < a.buses[j].index })
+	if bus.index >= a.nextIndex {
+		a.nextIndex = bus.index + 1
+	}
+}
+
+func pciAddressComplete(addr *DomainAddress) bool {
+	if addr == nil {
+		return false
+	}
+	if addr.PCI == nil {
+		return true
+	}
+	return addr.PCI.Bus != nil && addr.PCI.Slot != nil
+}
+
+func (a *pciAllocator) setAddress(addr **DomainAddress, bus *pciAllocBus, slot, function uint) *DomainAddressPCI {
+	undo := pciAddressUndo{addr: addr, ptr: *addr}
+	if *addr != nil {
+		undo.val = **addr
+		if (*addr).PCI != nil {
+			undo.pciPtr, undo.pciVal = (*addr).PCI, *(*addr).PCI
+		}
+	}
+	a.undo = append(a.undo, undo)
+	if *addr == nil {
+		*addr = &DomainAddress{}
+	}
+	if (*addr).PCI == nil {
+		(*addr).PCI = &DomainAddressPCI{}
+	}
+	pci := (*addr).PCI
+	domain, index := uint(0), bus.index
+	pci.Domain = &domain
+	pci.Bus = &index
+	pci.Slot = &slot
+	pci.Function = &function
+	bus.used[slot] |= 1 << function
+	return pci
+}
+
+func (a *pciAllocator) markAddress(path string, addr *DomainAddressPCI) error {
+	if addr.Domain != nil && *addr.Domain != 0 {
+		return nil
+	}
+	bus := a.findBus(*addr.Bus)
+	if bus == nil {
+		return fmt.Errorf("%s: PCI bus %d does not exist", path, *addr.Bus)
+	}
+	function := uint(0)
+	if addr.Function != nil {
+		function = *addr.Function
+	}
+	if *addr.Slot > 31 || function > 7 {
+		return fmt.Errorf("%s: invalid PCI address %02x.%x", path, *addr.Slot, function)
+	}
+	if bus.used[*addr.Slot]&(1<<function) != 0 {
+		return fmt.Errorf("%s: PCI address %02x:%02x.%x is already in use",
+			path, bus.index, *addr.Slot, function)
+	}
+	bus.used[*addr.Slot] |= 1 << function
+	return nil
+}
+
+func (a *pciAllocator) findSlot(conn pciConnect) (*pciAllocBus, uint, bool) {
+	for _, bus := range a.buses {
+		if bus.accepts&conn == 0 {
+			continue
+		}
+		for slot := bus.minSlot; slot <= bus.maxSlot; slot++ {
+			if bus.used[slot] == 0 {
+				return bus, slot, true
+			}
+		}
+	}
+	return nil, 0, false
+}
+
+// Adds a controller providing a new bus which accepts the
+// given kind of device
+func (a *pciAllocator) addController(conn pciConnect) (*pciAllocBus, error) {
+	model := "pci-bridge"
+	if a.pcie {
+		if conn&(pciConnectPCIe|pciConnectPCIeToPCIBridge|pciConnectSwitchUpstreamPort) != 0 {
+			model = "pcie-root-port"
+		} else if conn&(pciConnectPCI|pciConnectPCIBridge) != 0 {
+			model = "pcie-to-pci-bridge"
+		} else {
+			return nil, fmt.Errorf("no free PCI slots left on the root bus")
+		}
+	}
+
+	// The controller has to be placed before its index is
+	// picked, as any controllers added to hold it must have
+	// a lower index
+	var addr *DomainAddress
+	err := a.assign(&addr, pciControllerConnect(model))
+	if err != nil {
+		return nil, err
+	}
+	index := a.nextIndex
+	if index > 255 {
+		return nil, fmt.Errorf("no free PCI buses left")
+	}
+	a.controllers = append(a.controllers, DomainController{
+		Type:    "pci",
+		Index:   &index,
+		Model:   model,
+		Address: addr,
+	})
+	bus := newPCIAllocBus(index, model)
+	a.addBus(bus)
+	return bus, nil
+}
+
+func (a *pciAllocator) assignRootPort(addr **DomainAddress) error {
+	if a.portAddr != nil && a.portFunction < 7 {
+		function := a.portFunction + 1
+		if a.portBus.used[a.portSlot]&(1<<function) == 0 {
+			a.portAddr.MultiFunction = "on"
+			a.setAddress(addr, a.portBus, a.portSlot, function)
+			a.portFunction = function
+			return nil
+		}
+	}
+	bus, slot, ok := a.findSlot(pciConnectRootPort)
+	if !ok {
+		return fmt.Errorf("no free PCI slots left on the root bus")
+	}
+	a.portAddr = a.setAddress(addr, bus, slot, 0)
+	a.portBus, a.portSlot, a.portFunction = bus, slot, 0
+	return nil
+}
+
+func (a *pciAllocator) assign(addr **DomainAddress, conn pciConnect) error {
+	if conn == pciConnectRootPort {
+		return a.assignRootPort(addr)
+	}
+	bus, slot, ok := a.findSlot(conn)
+	if !ok {
+		var err error
+		bus, err = a.addController(conn)
+		if err != nil {
+			return err
+		}
+		slot = bus.minSlot
+	}
+	a.setAddress(addr, bus, slot, 0)
+	return nil
+}
+
+// Picks how a device connects, depending on whether it is a
+// PCI Express device when the machine has a PCI Express root
+func (a *pciAllocator) connect(express bool) pciConnect {
+	if a.pcie && express {
+		return pciConnectPCIe
+	}
+	return pciConnectPCI
+}
+
+type pciDevice struct {
+	path    string
+	addr    **DomainAddress
+	connect pciConnect
+}
+
+func (a *pciAllocator) controllerConnect(ctrl *DomainController) (pciConnect, bool) {
+	switch ctrl.Type {
+	case "virtio-serial", "sata":
+		return a.connect(true), true
+	case "scsi":
+		switch ctrl.Model {
+		case "virtio-scsi", "virtio-transitional", "virtio-non-transitional":
+			return a.connect(true), true
+		case "", "lsilogic", "lsisas1068", "lsisas1078", "vmpvscsi", "am53c974", "dc390":
+			return a.connect(false), true
+		}
+	case "usb":
+		switch ctrl.Model {
+		case "none", "qusb1", "qusb2":
+			return 0, false
+		case "qemu-xhci", "nec-xhci":
+			return a.connect(true), true
+		case "":
+			return a.connect(a.pcie), true
+		}
+		return a.connect(false), true
+	}
+	return 0, false
+}
+
+func pciVideoModel(video *DomainVideo) bool {
+	switch video.Model.Type {
+	case "vga", "cirrus", "vmvga", "qxl", "virtio", "bochs":
+		return true
+	}
+	return false
+}
+
+func (a *pciAllocator) interfaceConnect(iface *DomainInterface) (pciConnect, bool) {
+	if iface.Model == nil {
+		return a.connect(false), true
+	}
+	switch iface.Model.Type {
+	case "spapr-vlan", "virtio-mmio", "usb-net", "lan9118", "smc91c111":
+		return 0, false
+	case "rtl8139", "e1000", "ne2k_pci", "pcnet", "i82551", "i82557b", "i82559er":
+		return a.connect(false), true
+	}
+	return a.connect(true), true
+}
+
+func (a *pciAllocator) devices(l *DomainDeviceList, path string) []pciDevice {
+	devs := []pciDevice{}
+	add := func(name string, idx int, addr **DomainAddress, conn pciConnect) {
+		if pciAddressComplete(*addr) {
+			return
+		}
+		devs = append(devs, pciDevice{
+			path:    fmt.Sprintf("%s/%s[%d]", path, name, idx),
+			addr:    addr,
+			connect: conn,
+		})
+	}
+
+	// PCI controllers go first, in index order, so the bus
+	// topology is laid out before any endpoints
+	pciCtrls := []int{}
+	for i := range l.Controllers {
+		if l.Controllers[i].Type == "pci" {
+			pciCtrls = append(pciCtrls, i)
+		}
+	}
+	sort.SliceStable(pciCtrls, func(i, j int) bool {
+		return controllerIndex(&l.Controllers[pciCtrls[i]]) < controllerIndex(&l.Controllers[pciCtrls[j]])
+	})
+	for _, i := range pciCtrls {
+		ctrl := &l.Controllers[i]
+		conn := pciControllerConnect(a.controllerModel(ctrl))
+		if conn != 0 {
+			add("controller", i, &ctrl.Address, conn)
+		}
+	}
+	for i := range l.Controllers {
+		ctrl := &l.Controllers[i]
+		if conn, ok := a.controllerConnect(ctrl); ok {
+			add("controller", i, &ctrl.Address, conn)
+		}
+	}
+	for i := range l.Filesystems {
+		add("filesystem", i, &l.Filesystems[i].Address, a.connect(true))
+	}
+	for i := range l.Interfaces {
+		if conn, ok := a.interfaceConnect(&l.Interfaces[i]); ok {
+			add("interface", i, &l.Interfaces[i].Address, conn)
+		}
+	}
+	for i := range l.Sounds {
+		switch l.Sounds[i].Model {
+		case "ich6", "ich9", "es1370", "ac97":
+			add("sound", i, &l.Sounds[i].Address, a.connect(false))
+		}
+	}
+	for i := range l.Hostdevs {
+		if l.Hostdevs[i].SubsysPCI != nil {
+			add("hostdev", i, &l.Hostdevs[i].Address, a.connect(true))
+		}
+	}
+	for i := range l.Disks {
+		if l.Disks[i].Target != nil && l.Disks[i].Target.Bus == "virtio" {
+			add("disk", i, &l.Disks[i].Address, a.connect(true))
+		}
+	}
+	for i := range l.Videos {
+		video := &l.Videos[i]
+		if pciVideoModel(video) {
+			add("video", i, &video.Address, a.connect(video.Model.Type == "virtio"))
+		}
+	}
+	for i := range l.Inputs {
+		if l.Inputs[i].Bus == "virtio" {
+			add("input", i, &l.Inputs[i].Address, a.connect(true))
+		}
+	}
+	for i := range l.Shmems {
+		add("shmem", i, &l.Shmems[i].Address, a.connect(false))
+	}
+	if l.Watchdog != nil && l.Watchdog.Model == "i6300esb" {
+		add("watchdog", 0, &l.Watchdog.Address, a.connect(false))
+	}
+	if l.MemBalloon != nil && strings.HasPrefix(l.MemBalloon.Model, "virtio") {
+		add("memballoon", 0, &l.MemBalloon.Address, a.connect(true))
+	}
+	for i := range l.RNGs {
+		if strings.HasPrefix(l.RNGs[i].Model, "virtio") {
+			add("rng", i, &l.RNGs[i].Address, a.connect(true))
+		}
+	}
+	if l.VSock != nil && (l.VSock.Model == "" || strings.HasPrefix(l.VSock.Model, "virtio")) {
+		add("vsock", 0, &l.VSock.Address, a.connect(true))
+	}
+
+	return devs
+}
+
+func controllerIndex(ctrl *DomainController) uint {
+	if ctrl.Index == nil {
+		return 0
+	}
+	return *ctrl.Index
+}
+
+func (a *pciAllocator) controllerModel(ctrl *DomainController) string {
+	if ctrl.Model != "" {
+		return ctrl.Model
+	}
+	if controllerIndex(ctrl) == 0 {
+		if a.pcie {
+			return "pcie-root"
+		}
+		return "pci-root"
+	}
+	if a.pcie {
+		return "pcie-root-port"
+	}
+	return "pci-bridge"
+}
+
+func isPCIeMachine(dom *Domain) bool {
+	if dom.Devices != nil {
+		for i := range dom.Devices.Controllers {
+			ctrl := &dom.Devices.Controllers[i]
+			if ctrl.Type == "pci" && controllerIndex(ctrl) == 0 && ctrl.Model != "" {
+				return ctrl.Model == "pcie-root"
+			}
+		}
+	}
+	if dom.OS == nil || dom.OS.Type == nil {
+		return false
+	}
+	machine := dom.OS.Type.Machine
+	return strings.Contains(machine, "q35") || strings.HasPrefix(machine, "virt")
+}
+
+func isI440FXMachine(dom *Domain) bool {
+	if dom.OS == nil || dom.OS.Type == nil {
+		return false
+	}
+	machine := dom.OS.Type.Machine
+	return machine == "pc" || strings.HasPrefix(machine, "pc-i440fx") ||
+		strings.HasPrefix(machine, "pc-0") || strings.HasPrefix(machine, "pc-1")
+}
+
+func isQ35Machine(dom *Domain) bool {
+	if dom.OS == nil || dom.OS.Type == nil {
+		return false
+	}
+	machine := dom.OS.Type.Machine
+	return machine == "q35" || strings.HasPrefix(machine, "pc-q35")
+}
+
+// Places the devices which QEMU builds into the chipset of
+// the x86 machine types at their fixed addresses
+func (a *pciAllocator) assignBuiltin(dom *Domain) {
+	root := a.findBus(0)
+	l := dom.Devices
+
+	var primary *DomainVideo
+	for i := range l.Videos {
+		if l.Videos[i].Model.Primary == "yes" {
+			primary = &l.Videos[i]
+			break
+		}
+	}
+	if primary == nil && len(l.Videos) > 0 {
+		primary = &l.Videos[0]
+	}
+
+	if isI440FXMachine(dom) && !a.pcie {
+		// PIIX3 ISA bridge, IDE, USB and power management
+		root.used[1] = 0xff
+		for i := range l.Controllers {
+			ctrl := &l.Controllers[i]
+			if ctrl.Type == "usb" && controllerIndex(ctrl) == 0 &&
+				(ctrl.Model == "" || ctrl.Model == "piix3-uhci") &&
+				!pciAddressComplete(ctrl.Address) {
+				a.setAddress(&ctrl.Address, root, 1, 2)
+			}
+		}
+		if primary != nil && pciVideoModel(primary) &&
+			!pciAddressComplete(primary.Address) && root.used[2] == 0 {
+			a.setAddress(&primary.Address, root, 2, 0)
+		}
+	} else if isQ35Machine(dom) && a.pcie {
+		// ICH9 LPC, SATA and SMBus
+		root.used[0x1f] = 0xff
+		for i := range l.Controllers {
+			ctrl := &l.Controllers[i]
+			if ctrl.Type == "sata" && controllerIndex(ctrl) == 0 &&
+				!pciAddressComplete(ctrl.Address) {
+				a.setAddress(&ctrl.Address, root, 0x1f, 2)
+			}
+		}
+		if primary != nil && pciVideoModel(primary) &&
+			!pciAddressComplete(primary.Address) && root.used[1] == 0 {
+			a.setAddress(&primary.Address, root, 1, 0)
+		}
+	}
+}
+
+// AssignPCIAddresses fills in the PCI address of every device
+// which needs one and does not have it set already. Addresses
+// which are already set are left alone. Buses are provided by
+// the pci type controllers, and pcie-root-port, pcie-to-pci-bridge
+// or pci-bridge controllers are added as required when the
+// existing buses are full. For a given configuration the same
+// addresses are always assigned. If allocation fails the domain
+// is left unchanged.
+func (d *Domain) AssignPCIAddresses() error {
+	if d.Devices == nil {
+		d.Devices = &DomainDeviceList{}
+	}
+	l := d.Devices
+	a := &pciAllocator{
+		pcie: isPCIeMachine(d),
+	}
+
+	for i := range l.Controllers {
+		ctrl := &l.Controllers[i]
+		if ctrl.Type != "pci" {
+			continue
+		}
+		index := controllerIndex(ctrl)
+		if a.findBus(index) != nil {
+			return fmt.Errorf("/domain[0]/devices[0]/controller[%d]: duplicate PCI controller index %d", i, index)
+		}
+		a.addBus(newPCIAllocBus(index, a.controllerModel(ctrl)))
+	}
+	if a.findBus(0) == nil {
+		model := "pci-root"
+		if a.pcie {
+			model = "pcie-root"
+		}
+		index := uint(0)
+		a.controllers = append(a.controllers, DomainController{
+			Type:  "pci",
+			Index: &index,
+			Model: model,
+		})
+		a.addBus(newPCIAllocBus(0, model))
+	}
+
+	for _, dev := range l.deviceInfo("/domain[0]/devices[0]") {
+		if dev.Address == nil || dev.Address.PCI == nil || !pciAddressComplete(dev.Address) {
+			continue
+		}
+		err := a.markAddress(dev.Path+"/address[0]", dev.Address.PCI)
+		if err != nil {
+			return err
+		}
+	}
+
+	a.assignBuiltin(d)
+
+	for _, dev := range a.devices(l, "/domain[0]/devices[0]") {
+		if pciAddressComplete(*dev.addr) {
+			continue
+		}
+		err := a.assign(dev.addr, dev.connect)
+		if err != nil {
+			a.rollback()
+			return fmt.Errorf("%s: %s", dev.path, err)
+		}
+	}
+
+	l.Controllers = append(l.Controllers, a.controllers...)
+	return nil
+}
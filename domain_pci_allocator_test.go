@@ -0,0 +1,306 @@
+/*
+ * This file is part of the libvirt-go-xml project
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ *
+ * Copyright (C) 2020 Red Hat, Inc.
+ *
+ */
+
+package libvirtxml
+
+import (
+	"strings"
+	"testing"
+)
+
+var pciAllocZero uint = 0
+var pciAllocSlot uint = 3
+var pciAllocBusMissing uint = 7
+
+var pciAllocatorTestData = []struct {
+	Object   *Domain
+	Expected []string
+}{
+	{
+		Object: &Domain{
+			Type: "kvm",
+			Name: "demo",
+			OS: &DomainOS{
+				Type: &DomainOSType{
+					Type:    "hvm",
+					Machine: "pc-q35-5.0",
+				},
+			},
+			Devices: &DomainDeviceList{
+				Disks: []DomainDisk{
+					DomainDisk{
+						Target: &DomainDiskTarget{
+							Dev: "vda",
+							Bus: "virtio",
+						},
+					},
+				},
+				Controllers: []DomainController{
+					DomainController{
+						Type:  "usb",
+						Model: "qemu-xhci",
+					},
+				},
+				Interfaces: []DomainInterface{
+					DomainInterface{
+						Model: &DomainInterfaceModel{
+							Type: "virtio",
+						},
+					},
+					DomainInterface{
+						Model: &DomainInterfaceModel{
+							Type: "e1000",
+						},
+					},
+				},
+				Videos: []DomainVideo{
+					DomainVideo{
+						Model: DomainVideoModel{
+							Type: "qxl",
+						},
+					},
+				},
+			},
+		},
+		Expected: []string{
+			`<domain type="kvm">`,
+			`  <name>demo</name>`,
+			`  <os>`,
+			`    <type machine="pc-q35-5.0">hvm</type>`,
+			`  </os>`,
+			`  <devices>`,
+			`    <disk>`,
+			`      <target dev="vda" bus="virtio"></target>`,
+			`      <address type="pci" domain="0x0000" bus="0x05" slot="0x00" function="0x0"></address>`,
+			`    </disk>`,
+			`    <controller type="usb" model="qemu-xhci">`,
+			`      <address type="pci" domain="0x0000" bus="0x01" slot="0x00" function="0x0"></address>`,
+			`    </controller>`,
+			`    <controller type="pci" index="0" model="pcie-root"></controller>`,
+			`    <controller type="pci" index="1" model="pcie-root-port">`,
+			`      <address type="pci" domain="0x0000" bus="0x00" slot="0x02" function="0x0" multifunction="on"></address>`,
+			`    </controller>`,
+			`    <controller type="pci" index="2" model="pcie-root-port">`,
+			`      <address type="pci" domain="0x0000" bus="0x00" slot="0x02" function="0x1"></address>`,
+			`    </controller>`,
+			`    <controller type="pci" index="3" model="pcie-root-port">`,
+			`      <address type="pci" domain="0x0000" bus="0x00" slot="0x02" function="0x2"></address>`,
+			`    </controller>`,
+			`    <controller type="pci" index="4" model="pcie-to-pci-bridge">`,
+			`      <address type="pci" domain="0x0000" bus="0x03" slot="0x00" function="0x0"></address>`,
+			`    </controller>`,
+			`    <controller type="pci" index="5" model="pcie-root-port">`,
+			`      <address type="pci" domain="0x0000" bus="0x00" slot="0x02" function="0x3"></address>`,
+			`    </controller>`,
+			`    <interface>`,
+			`      <model type="virtio"></model>`,
+			`      <address type="pci" domain="0x0000" bus="0x02" slot="0x00" function="0x0"></address>`,
+			`    </interface>`,
+			`    <interface>`,
+			`      <model type="e1000"></model>`,
+			`      <address type="pci" domain="0x0000" bus="0x04" slot="0x01" function="0x0"></address>`,
+			`    </interface>`,
+			`    <video>`,
+			`      <model type="qxl"></model>`,
+			`      <address type="pci" domain="0x0000" bus="0x00" slot="0x01" function="0x0"></address>`,
+			`    </video>`,
+			`  </devices>`,
+			`</domain>`,
+		},
+	},
+	{
+		Object: &Domain{
+			Type: "kvm",
+			Name: "demo",
+			OS: &DomainOS{
+				Type: &DomainOSType{
+					Type:    "hvm",
+					Machine: "pc-i440fx-5.0",
+				},
+			},
+			Devices: &DomainDeviceList{
+				Disks: []DomainDisk{
+					DomainDisk{
+						Target: &DomainDiskTarget{
+							Dev: "vda",
+							Bus: "virtio",
+						},
+					},
+				},
+				Controllers: []DomainController{
+					DomainController{
+						Type: "usb",
+					},
+				},
+				Interfaces: []DomainInterface{
+					DomainInterface{
+						Model: &DomainInterfaceModel{
+							Type: "virtio",
+						},
+						Address: &DomainAddress{
+							PCI: &DomainAddressPCI{
+								Domain:   &pciAllocZero,
+								Bus:      &pciAllocZero,
+								Slot:     &pciAllocSlot,
+								Function: &pciAllocZero,
+							},
+						},
+					},
+					DomainInterface{
+						Model: &DomainInterfaceModel{
+							Type: "e1000",
+						},
+					},
+				},
+				Videos: []DomainVideo{
+					DomainVideo{
+						Model: DomainVideoModel{
+							Type: "qxl",
+						},
+					},
+				},
+			},
+		},
+		Expected: []string{
+			`<domain type="kvm">`,
+			`  <name>demo</name>`,
+			`  <os>`,
+			`    <type machine="pc-i440fx-5.0">hvm</type>`,
+			`  </os>`,
+			`  <devices>`,
+			`    <disk>`,
+			`      <target dev="vda" bus="virtio"></target>`,
+			`      <address type="pci" domain="0x0000" bus="0x00" slot="0x05" function="0x0"></address>`,
+			`    </disk>`,
+			`    <controller type="usb">`,
+			`      <address type="pci" domain="0x0000" bus="0x00" slot="0x01" function="0x2"></address>`,
+			`    </controller>`,
+			`    <controller type="pci" index="0" model="pci-root"></controller>`,
+			`    <interface>`,
+			`      <model type="virtio"></model>`,
+			`      <address type="pci" domain="0x0000" bus="0x00" slot="0x03" function="0x0"></address>`,
+			`    </interface>`,
+			`    <interface>`,
+			`      <model type="e1000"></model>`,
+			`      <address type="pci" domain="0x0000" bus="0x00" slot="0x04" function="0x0"></address>`,
+			`    </interface>`,
+			`    <video>`,
+			`      <model type="qxl"></model>`,
+			`      <address type="pci" domain="0x0000" bus="0x00" slot="0x02" function="0x0"></address>`,
+			`    </video>`,
+			`  </devices>`,
+			`</domain>`,
+		},
+	},
+}
+
+func TestDomainAssignPCIAddresses(t *testing.T) {
+	for _, test := range pciAllocatorTestData {
+		err := test.Object.AssignPCIAddresses()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		doc, err := test.Object.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expect := strings.Join(test.Expected, "\n")
+
+		if doc != expect {
+			t.Fatal("Bad xml:\n", string(doc), "\n does not match\n", expect, "\n")
+		}
+	}
+}
+
+func TestDomainAssignPCIAddressesMissingBus(t *testing.T) {
+	dom := &Domain{
+		Devices: &DomainDeviceList{
+			Interfaces: []DomainInterface{
+				DomainInterface{
+					Address: &DomainAddress{
+						PCI: &DomainAddressPCI{
+							Bus:  &pciAllocBusMissing,
+							Slot: &pciAllocSlot,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	err := dom.AssignPCIAddresses()
+	if err == nil {
+		t.Fatal("Expected an error for a missing PCI bus")
+	}
+}
+
+func TestDomainAssignPCIAddressesRollback(t *testing.T) {
+	domain := uint(0)
+	rootPort := uint(1)
+	dom := &Domain{
+		OS: &DomainOS{
+			Type: &DomainOSType{
+				Arch:    "x86_64",
+				Machine: "pc-i440fx-5.0",
+				Type:    "hvm",
+			},
+		},
+		Devices: &DomainDeviceList{
+			Controllers: []DomainController{
+				DomainController{
+					Type:  "pci",
+					Index: &rootPort,
+					Model: "pcie-root-port",
+				},
+			},
+			Videos: []DomainVideo{
+				DomainVideo{
+					Model: DomainVideoModel{
+						Type: "cirrus",
+					},
+					Address: &DomainAddress{
+						PCI: &DomainAddressPCI{
+							Domain: &domain,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	err := dom.AssignPCIAddresses()
+	if err == nil {
+		t.Fatal("Expected an error for a root port on a PCI machine")
+	}
+	if len(dom.Devices.Controllers) != 1 || dom.Devices.Controllers[0].Address != nil {
+		t.Errorf("Expected controllers to be unchanged, got %+v", dom.Devices.Controllers)
+	}
+	addr := dom.Devices.Videos[0].Address
+	if addr == nil || addr.PCI == nil || addr.PCI.Domain != &domain || addr.PCI.Bus != nil || addr.PCI.Slot != nil {
+		t.Errorf("Expected video address to be unchanged, got %+v", addr.PCI)
+	}
+}
@@ -0,0 +1,126 @@
+/*
+ * This file is part of the libvirt-go-xml project
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ *
+ * Copyright (C) 2020 Red Hat, Inc.
+ *
+ */
+
+package libvirtxml
+
+import "encoding/xml"
+
+// DomainStatusPrivate holds a private status element which is not
+// otherwise modelled, so that it survives a parse/format cycle.
+type DomainStatusPrivate struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr `xml:",any,attr"`
+	XML     string     `xml:",innerxml"`
+}
+
+type DomainStatusTaint struct {
+	Flag string `xml:"flag,attr"`
+}
+
+type DomainStatusMonitor struct {
+	Path string `xml:"path,attr"`
+	Type string `xml:"type,attr,omitempty"`
+	JSON string `xml:"json,attr,omitempty"`
+}
+
+type DomainStatusVCPU struct {
+	ID  uint `xml:"id,attr"`
+	PID uint `xml:"pid,attr"`
+}
+
+type DomainStatusVCPUs struct {
+	VCPUs []DomainStatusVCPU `xml:"vcpu"`
+}
+
+type DomainStatusQEMUCapsFlag struct {
+	Name string `xml:"name,attr"`
+}
+
+type DomainStatusQEMUCaps struct {
+	Flags []DomainStatusQEMUCapsFlag `xml:"flag"`
+}
+
+type DomainStatusDevice struct {
+	Alias string `xml:"alias,attr"`
+}
+
+type DomainStatusDevices struct {
+	Devices []DomainStatusDevice `xml:"device"`
+}
+
+type DomainStatusPath struct {
+	Path string `xml:"path,attr"`
+}
+
+type DomainStatusAllowReboot struct {
+	Value string `xml:"value,attr"`
+}
+
+type DomainStatusJobDisk struct {
+	Dev       string `xml:"dev,attr"`
+	Migrating string `xml:"migrating,attr,omitempty"`
+}
+
+type DomainStatusJob struct {
+	Type  string                `xml:"type,attr,omitempty"`
+	Async string                `xml:"async,attr,omitempty"`
+	Phase string                `xml:"phase,attr,omitempty"`
+	Flags string                `xml:"flags,attr,omitempty"`
+	Disks []DomainStatusJobDisk `xml:"disk"`
+	Extra []DomainStatusPrivate `xml:",any"`
+}
+
+// DomainStatus is the document libvirtd keeps on disk for each
+// running guest, wrapping the live domain config with the
+// driver's private runtime state.
+type DomainStatus struct {
+	XMLName          xml.Name                 `xml:"domstatus"`
+	State            string                   `xml:"state,attr,omitempty"`
+	Reason           string                   `xml:"reason,attr,omitempty"`
+	PID              uint                     `xml:"pid,attr,omitempty"`
+	Taints           []DomainStatusTaint      `xml:"taint"`
+	Monitor          *DomainStatusMonitor     `xml:"monitor"`
+	VCPUs            *DomainStatusVCPUs       `xml:"vcpus"`
+	QEMUCaps         *DomainStatusQEMUCaps    `xml:"qemuCaps"`
+	Devices          *DomainStatusDevices     `xml:"devices"`
+	LibDir           *DomainStatusPath        `xml:"libDir"`
+	ChannelTargetDir *DomainStatusPath        `xml:"channelTargetDir"`
+	AllowReboot      *DomainStatusAllowReboot `xml:"allowReboot"`
+	Job              *DomainStatusJob         `xml:"job"`
+	Extra            []DomainStatusPrivate    `xml:",any"`
+	Domain           *Domain                  `xml:"domain"`
+}
+
+func (s *DomainStatus) Unmarshal(doc string) error {
+	return xml.Unmarshal([]byte(doc), s)
+}
+
+func (s *DomainStatus) Marshal() (string, error) {
+	doc, err := xml.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(doc), nil
+}
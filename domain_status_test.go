@@ -0,0 +1,172 @@
+/*
+ * This file is part of the libvirt-go-xml project
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ *
+ * Copyright (C) 2020 Red Hat, Inc.
+ *
+ */
+
+package libvirtxml
+
+import (
+	"encoding/xml"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var domainStatusID = 1
+
+var domainStatusTestData = []struct {
+	Object   *DomainStatus
+	Expected []string
+}{
+	{
+		Object: &DomainStatus{
+			State:  "running",
+			Reason: "booted",
+			PID:    3870,
+			Taints: []DomainStatusTaint{
+				DomainStatusTaint{Flag: "high-privileges"},
+			},
+			Monitor: &DomainStatusMonitor{
+				Path: "/var/lib/libvirt/qemu/domain-1-demo/monitor.sock",
+				Type: "unix",
+			},
+			VCPUs: &DomainStatusVCPUs{
+				VCPUs: []DomainStatusVCPU{
+					DomainStatusVCPU{ID: 0, PID: 3874},
+					DomainStatusVCPU{ID: 1, PID: 3875},
+				},
+			},
+			QEMUCaps: &DomainStatusQEMUCaps{
+				Flags: []DomainStatusQEMUCapsFlag{
+					DomainStatusQEMUCapsFlag{Name: "kvm"},
+					DomainStatusQEMUCapsFlag{Name: "blockdev"},
+				},
+			},
+			Devices: &DomainStatusDevices{
+				Devices: []DomainStatusDevice{
+					DomainStatusDevice{Alias: "virtio-disk0"},
+				},
+			},
+			LibDir: &DomainStatusPath{
+				Path: "/var/lib/libvirt/qemu/domain-1-demo",
+			},
+			AllowReboot: &DomainStatusAllowReboot{
+				Value: "yes",
+			},
+			Job: &DomainStatusJob{
+				Type:  "none",
+				Async: "migration out",
+				Phase: "perform3",
+				Flags: "0x0",
+				Disks: []DomainStatusJobDisk{
+					DomainStatusJobDisk{Dev: "vda", Migrating: "yes"},
+				},
+			},
+			Domain: &Domain{
+				Type: "kvm",
+				ID:   &domainStatusID,
+				Name: "demo",
+			},
+		},
+		Expected: []string{
+			`<domstatus state="running" reason="booted" pid="3870">`,
+			`  <taint flag="high-privileges"></taint>`,
+			`  <monitor path="/var/lib/libvirt/qemu/domain-1-demo/monitor.sock" type="unix"></monitor>`,
+			`  <vcpus>`,
+			`    <vcpu id="0" pid="3874"></vcpu>`,
+			`    <vcpu id="1" pid="3875"></vcpu>`,
+			`  </vcpus>`,
+			`  <qemuCaps>`,
+			`    <flag name="kvm"></flag>`,
+			`    <flag name="blockdev"></flag>`,
+			`  </qemuCaps>`,
+			`  <devices>`,
+			`    <device alias="virtio-disk0"></device>`,
+			`  </devices>`,
+			`  <libDir path="/var/lib/libvirt/qemu/domain-1-demo"></libDir>`,
+			`  <allowReboot value="yes"></allowReboot>`,
+			`  <job type="none" async="migration out" phase="perform3" flags="0x0">`,
+			`    <disk dev="vda" migrating="yes"></disk>`,
+			`  </job>`,
+			`  <domain type="kvm" id="1">`,
+			`    <name>demo</name>`,
+			`  </domain>`,
+			`</domstatus>`,
+		},
+	},
+	{
+		Object: &DomainStatus{
+			State:  "paused",
+			Reason: "user",
+			Extra: []DomainStatusPrivate{
+				DomainStatusPrivate{
+					XMLName: xml.Name{Local: "agentTimeout"},
+					XML:     "-2",
+				},
+			},
+		},
+		Expected: []string{
+			`<domstatus state="paused" reason="user">`,
+			`  <agentTimeout>-2</agentTimeout>`,
+			`</domstatus>`,
+		},
+	},
+}
+
+func TestDomainStatus(t *testing.T) {
+	for _, test := range domainStatusTestData {
+		doc, err := test.Object.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expect := strings.Join(test.Expected, "\n")
+
+		if doc != expect {
+			t.Fatal("Bad initial xml:\n", string(doc), "\n does not match\n", expect, "\n")
+		}
+
+		typ := reflect.ValueOf(test.Object).Elem().Type()
+
+		newobj := reflect.New(typ)
+
+		newdocobj, ok := newobj.Interface().(Document)
+		if !ok {
+			t.Fatalf("Could not clone %s", newobj.Interface())
+		}
+
+		err = newdocobj.Unmarshal(expect)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		doc, err = newdocobj.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if doc != expect {
+			t.Fatal("Bad roundtrip xml:\n", string(doc), "\n does not match\n", expect, "\n")
+		}
+	}
+}
@@ -31,20 +31,6 @@ import (
 	"testing"
 )
 
-type PCIAddress struct {
-	Domain   uint
-	Bus      uint
-	Slot     uint
-	Function uint
-}
-
-type DriveAddress struct {
-	Controller uint
-	Bus        uint
-	Target     uint
-	Unit       uint
-}
-
 type ISAAddress struct {
 	IOBase uint
 }
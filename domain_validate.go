@@ -0,0 +1,400 @@
+/*
+ * This file is part of the libvirt-go-xml project
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ *
+ * Copyright (C) 2020 Red Hat, Inc.
+ *
+ */
+
+package libvirtxml
+
+import "fmt"
+
+// domainDeviceInfo locates a single device within a
+// DomainDeviceList along with its identifying elements
+type domainDeviceInfo struct {
+	Path    string
+	Alias   *DomainAlias
+	Address *DomainAddress
+}
+
+func (l *DomainDeviceList) deviceInfo(path string) []domainDeviceInfo {
+	devs := []domainDeviceInfo{}
+	add := func(name string, idx int, alias *DomainAlias, addr *DomainAddress) {
+		devs = append(devs, domainDeviceInfo{
+			Path:    fmt.Sprintf("%s/%s[%d]", path, name, idx),
+			Alias:   alias,
+			Address: addr,
+		})
+	}
+
+	for i, dev := range l.Disks {
+		add("disk", i, dev.Alias, dev.Address)
+	}
+	for i, dev := range l.Controllers {
+		add("controller", i, dev.Alias, dev.Address)
+	}
+	for i, dev := range l.Filesystems {
+		add("filesystem", i, dev.Alias, dev.Address)
+	}
+	for i, dev := range l.Interfaces {
+		add("interface", i, dev.Alias, dev.Address)
+	}
+	for i, dev := range l.Smartcards {
+		add("smartcard", i, dev.Alias, dev.Address)
+	}
+	for i, dev := range l.Serials {
+		add("serial", i, dev.Alias, dev.Address)
+	}
+	for i, dev := range l.Parallels {
+		add("parallel", i, dev.Alias, dev.Address)
+	}
+	for i, dev := range l.Consoles {
+		add("console", i, dev.Alias, dev.Address)
+	}
+	for i, dev := range l.Channels {
+		add("channel", i, dev.Alias, dev.Address)
+	}
+	for i, dev := range l.Inputs {
+		add("input", i, dev.Alias, dev.Address)
+	}
+	for i, dev := range l.TPMs {
+		add("tpm", i, dev.Alias, dev.Address)
+	}
+	for i, dev := range l.Sounds {
+		add("sound", i, dev.Alias, dev.Address)
+	}
+	for i, dev := range l.Videos {
+		add("video", i, dev.Alias, dev.Address)
+	}
+	for i, dev := range l.Hostdevs {
+		add("hostdev", i, dev.Alias, dev.Address)
+	}
+	for i, dev := range l.RedirDevs {
+		add("redirdev", i, dev.Alias, dev.Address)
+	}
+	for i, dev := range l.Hubs {
+		add("hub", i, dev.Alias, dev.Address)
+	}
+	if l.Watchdog != nil {
+		add("watchdog", 0, l.Watchdog.Alias, l.Watchdog.Address)
+	}
+	if l.MemBalloon != nil {
+		add("memballoon", 0, l.MemBalloon.Alias, l.MemBalloon.Address)
+	}
+	for i, dev := range l.RNGs {
+		add("rng", i, dev.Alias, dev.Address)
+	}
+	if l.NVRAM != nil {
+		add("nvram", 0, l.NVRAM.Alias, l.NVRAM.Address)
+	}
+	for i, dev := range l.Panics {
+		add("panic", i, dev.Alias, dev.Address)
+	}
+	for i, dev := range l.Shmems {
+		add("shmem", i, dev.Alias, dev.Address)
+	}
+	for i, dev := range l.Memorydevs {
+		add("memory", i, dev.Alias, dev.Address)
+	}
+	if l.VSock != nil {
+		add("vsock", 0, l.VSock.Alias, l.VSock.Address)
+	}
+
+	return devs
+}
+
+func validateDomainDiskSource(v *validator, path string, src *DomainDiskSource) {
+	if src == nil {
+		return
+	}
+	set := []string{}
+	if src.File != nil {
+		set = append(set, "file")
+	}
+	if src.Block != nil {
+		set = append(set, "block")
+	}
+	if src.Dir != nil {
+		set = append(set, "dir")
+	}
+	if src.Network != nil {
+		set = append(set, "network")
+	}
+	if src.Volume != nil {
+		set = append(set, "volume")
+	}
+	if src.NVME != nil {
+		set = append(set, "nvme")
+	}
+	if src.VHostUser != nil {
+		set = append(set, "vhostuser")
+	}
+	if len(set) > 1 {
+		v.add(path, "only one disk source type may be set, got %v", set)
+	}
+}
+
+func validateDomainChardevSource(v *validator, path string, src *DomainChardevSource) {
+	if src == nil {
+		return
+	}
+	set := []string{}
+	if src.Null != nil {
+		set = append(set, "null")
+	}
+	if src.VC != nil {
+		set = append(set, "vc")
+	}
+	if src.Pty != nil {
+		set = append(set, "pty")
+	}
+	if src.Dev != nil {
+		set = append(set, "dev")
+	}
+	if src.File != nil {
+		set = append(set, "file")
+	}
+	if src.Pipe != nil {
+		set = append(set, "pipe")
+	}
+	if src.StdIO != nil {
+		set = append(set, "stdio")
+	}
+	if src.UDP != nil {
+		set = append(set, "udp")
+	}
+	if src.TCP != nil {
+		set = append(set, "tcp")
+	}
+	if src.UNIX != nil {
+		set = append(set, "unix")
+	}
+	if src.SpiceVMC != nil {
+		set = append(set, "spicevmc")
+	}
+	if src.SpicePort != nil {
+		set = append(set, "spiceport")
+	}
+	if src.NMDM != nil {
+		set = append(set, "nmdm")
+	}
+	if len(set) > 1 {
+		v.add(path, "only one character device source type may be set, got %v", set)
+	}
+}
+
+func validateDomainInterfaceSource(v *validator, path string, src *DomainInterfaceSource) {
+	if src == nil {
+		return
+	}
+	set := []string{}
+	if src.User != nil {
+		set = append(set, "user")
+	}
+	if src.Ethernet != nil {
+		set = append(set, "ethernet")
+	}
+	if src.VHostUser != nil {
+		set = append(set, "vhostuser")
+	}
+	if src.Server != nil {
+		set = append(set, "server")
+	}
+	if src.Client != nil {
+		set = append(set, "client")
+	}
+	if src.MCast != nil {
+		set = append(set, "mcast")
+	}
+	if src.Network != nil {
+		set = append(set, "network")
+	}
+	if src.Bridge != nil {
+		set = append(set, "bridge")
+	}
+	if src.Internal != nil {
+		set = append(set, "internal")
+	}
+	if src.Direct != nil {
+		set = append(set, "direct")
+	}
+	if src.Hostdev != nil {
+		set = append(set, "hostdev")
+	}
+	if src.UDP != nil {
+		set = append(set, "udp")
+	}
+	if src.VDPA != nil {
+		set = append(set, "vdpa")
+	}
+	if len(set) > 1 {
+		v.add(path, "only one interface source type may be set, got %v", set)
+	}
+}
+
+func validateDomainAddressPCI(v *validator, path string, addr *DomainAddressPCI) {
+	if addr.Domain != nil && *addr.Domain > 0xffff {
+		v.add(path+"/@domain", "PCI domain 0x%x exceeds 0xffff", *addr.Domain)
+	}
+	if addr.Bus != nil && *addr.Bus > 0xff {
+		v.add(path+"/@bus", "PCI bus 0x%x exceeds 0xff", *addr.Bus)
+	}
+	if addr.Slot != nil && *addr.Slot > 0x1f {
+		v.add(path+"/@slot", "PCI slot 0x%x exceeds 0x1f", *addr.Slot)
+	}
+	if addr.Function != nil && *addr.Function > 0x7 {
+		v.add(path+"/@function", "PCI function 0x%x exceeds 0x7", *addr.Function)
+	}
+}
+
+func validateDomainAddress(v *validator, path string, addr *DomainAddress) {
+	if addr == nil {
+		return
+	}
+	set := []string{}
+	if addr.PCI != nil {
+		set = append(set, "pci")
+		validateDomainAddressPCI(v, path, addr.PCI)
+	}
+	if addr.Drive != nil {
+		set = append(set, "drive")
+	}
+	if addr.VirtioSerial != nil {
+		set = append(set, "virtio-serial")
+	}
+	if addr.CCID != nil {
+		set = append(set, "ccid")
+	}
+	if addr.USB != nil {
+		set = append(set, "usb")
+	}
+	if addr.SpaprVIO != nil {
+		set = append(set, "spapr-vio")
+	}
+	if addr.VirtioS390 != nil {
+		set = append(set, "virtio-s390")
+	}
+	if addr.CCW != nil {
+		set = append(set, "ccw")
+	}
+	if addr.VirtioMMIO != nil {
+		set = append(set, "virtio-mmio")
+	}
+	if addr.ISA != nil {
+		set = append(set, "isa")
+	}
+	if addr.DIMM != nil {
+		set = append(set, "dimm")
+	}
+	if addr.Unassigned != nil {
+		set = append(set, "unassigned")
+	}
+	if len(set) > 1 {
+		v.add(path, "only one address type may be set, got %v", set)
+	}
+}
+
+func validateDomainDevices(v *validator, path string, devs *DomainDeviceList) {
+	for i, disk := range devs.Disks {
+		diskPath := fmt.Sprintf("%s/disk[%d]", path, i)
+		validateDomainDiskSource(v, diskPath+"/source[0]", disk.Source)
+		bsPath := diskPath
+		for bs := disk.BackingStore; bs != nil; bs = bs.BackingStore {
+			bsPath += "/backingStore[0]"
+			validateDomainDiskSource(v, bsPath+"/source[0]", bs.Source)
+		}
+		if disk.Mirror != nil {
+			validateDomainDiskSource(v, diskPath+"/mirror[0]/source[0]", disk.Mirror.Source)
+		}
+	}
+
+	for i, iface := range devs.Interfaces {
+		ifacePath := fmt.Sprintf("%s/interface[%d]", path, i)
+		validateDomainInterfaceSource(v, ifacePath+"/source[0]", iface.Source)
+		if iface.MAC != nil {
+			mac, err := ParseMACAddress(iface.MAC.Address)
+			if err != nil {
+				v.add(ifacePath+"/mac[0]/@address", "%s", err)
+			} else if mac.IsMulticast() {
+				v.add(ifacePath+"/mac[0]/@address", "multicast MAC address '%s' is not allowed", iface.MAC.Address)
+			}
+		}
+	}
+
+	for i, dev := range devs.Serials {
+		validateDomainChardevSource(v, fmt.Sprintf("%s/serial[%d]/source[0]", path, i), dev.Source)
+	}
+	for i, dev := range devs.Parallels {
+		validateDomainChardevSource(v, fmt.Sprintf("%s/parallel[%d]/source[0]", path, i), dev.Source)
+	}
+	for i, dev := range devs.Consoles {
+		validateDomainChardevSource(v, fmt.Sprintf("%s/console[%d]/source[0]", path, i), dev.Source)
+	}
+	for i, dev := range devs.Channels {
+		validateDomainChardevSource(v, fmt.Sprintf("%s/channel[%d]/source[0]", path, i), dev.Source)
+	}
+	for i, dev := range devs.RedirDevs {
+		validateDomainChardevSource(v, fmt.Sprintf("%s/redirdev[%d]/source[0]", path, i), dev.Source)
+	}
+
+	aliases := make(map[string]string)
+	for _, dev := range devs.deviceInfo(path) {
+		validateDomainAddress(v, dev.Path+"/address[0]", dev.Address)
+
+		if dev.Alias == nil || dev.Alias.Name == "" {
+			continue
+		}
+		other, ok := aliases[dev.Alias.Name]
+		if ok {
+			v.add(dev.Path+"/alias[0]/@name", "duplicate device alias '%s', also used by %s",
+				dev.Alias.Name, other)
+		} else {
+			aliases[dev.Alias.Name] = dev.Path
+		}
+	}
+}
+
+// Validate performs structural checks on the domain configuration,
+// reporting problems which would cause libvirt to reject it. It
+// returns nil if no problems are found, otherwise ValidationErrors.
+// Checks requiring knowledge of the host are not performed.
+func (d *Domain) Validate() error {
+	v := &validator{}
+	path := "/domain[0]"
+
+	if d.Name == "" {
+		v.add(path+"/name[0]", "missing domain name")
+	}
+	if d.UUID != "" && !isValidUUID(d.UUID) {
+		v.add(path+"/uuid[0]", "invalid UUID '%s'", d.UUID)
+	}
+	if d.Memory == nil || d.Memory.Value == 0 {
+		v.add(path+"/memory[0]", "missing domain memory size")
+	}
+	if d.OS == nil || d.OS.Type == nil || d.OS.Type.Type == "" {
+		v.add(path+"/os[0]/type[0]", "missing OS type")
+	}
+	if d.Devices != nil {
+		validateDomainDevices(v, path+"/devices[0]", d.Devices)
+	}
+
+	return v.result()
+}
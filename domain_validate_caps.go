@@ -0,0 +1,325 @@
+/*
+ * This file is part of the libvirt-go-xml project
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ *
+ * Copyright (C) 2020 Red Hat, Inc.
+ *
+ */
+
+package libvirtxml
+
+import (
+	"fmt"
+	"strings"
+)
+
+func checkDomainCapsEnum(v *validator, path, what string, enums []DomainCapsEnum, name, value string) {
+	if value == "" {
+		return
+	}
+	for _, enum := range enums {
+		if enum.Name != name {
+			continue
+		}
+		for _, val := range enum.Values {
+			if val == value {
+				return
+			}
+		}
+		v.add(path, "%s '%s' is not supported by the host", what, value)
+		return
+	}
+}
+
+// Reports whether the device capabilities are known and
+// supported, adding an error if they are known not to be
+func checkDomainCapsDevice(v *validator, path, what string, dev *DomainCapsDevice) bool {
+	if dev == nil {
+		return false
+	}
+	if dev.Supported == "no" {
+		v.add(path, "%s devices are not supported by the host", what)
+		return false
+	}
+	return true
+}
+
+func checkDomainCapsSupported(v *validator, path, what string, supported string) {
+	if supported == "no" {
+		v.add(path, "%s is not supported by the host", what)
+	}
+}
+
+func getGraphicType(graphic *DomainGraphic) string {
+	if graphic.SDL != nil {
+		return "sdl"
+	} else if graphic.VNC != nil {
+		return "vnc"
+	} else if graphic.RDP != nil {
+		return "rdp"
+	} else if graphic.Desktop != nil {
+		return "desktop"
+	} else if graphic.Spice != nil {
+		return "spice"
+	} else if graphic.EGLHeadless != nil {
+		return "egl-headless"
+	}
+	return ""
+}
+
+func getHostdevTypes(hostdev *DomainHostdev) (string, string) {
+	if hostdev.SubsysUSB != nil {
+		return "subsystem", "usb"
+	} else if hostdev.SubsysSCSI != nil {
+		return "subsystem", "scsi"
+	} else if hostdev.SubsysSCSIHost != nil {
+		return "subsystem", "scsi_host"
+	} else if hostdev.SubsysPCI != nil {
+		return "subsystem", "pci"
+	} else if hostdev.SubsysMDev != nil {
+		return "subsystem", "mdev"
+	} else if hostdev.CapsStorage != nil {
+		return "capabilities", "storage"
+	} else if hostdev.CapsMisc != nil {
+		return "capabilities", "misc"
+	} else if hostdev.CapsNet != nil {
+		return "capabilities", "net"
+	}
+	return "", ""
+}
+
+func getRNGBackendModel(backend *DomainRNGBackend) string {
+	if backend == nil {
+		return ""
+	}
+	if backend.Random != nil {
+		return "random"
+	} else if backend.EGD != nil {
+		return "egd"
+	} else if backend.BuiltIn != nil {
+		return "builtin"
+	}
+	return ""
+}
+
+func checkDomainCapsDevices(v *validator, path string, devs *DomainDeviceList, caps *DomainCapsDevices) {
+	for i, disk := range devs.Disks {
+		diskPath := fmt.Sprintf("%s/disk[%d]", path, i)
+		if !checkDomainCapsDevice(v, diskPath, "disk", caps.Disk) {
+			continue
+		}
+		checkDomainCapsEnum(v, diskPath+"/@device", "disk device", caps.Disk.Enums, "diskDevice", disk.Device)
+		checkDomainCapsEnum(v, diskPath+"/@model", "disk model", caps.Disk.Enums, "model", disk.Model)
+		if disk.Target != nil {
+			checkDomainCapsEnum(v, diskPath+"/target[0]/@bus", "disk bus", caps.Disk.Enums, "bus", disk.Target.Bus)
+		}
+	}
+
+	for i, graphic := range devs.Graphics {
+		graphicPath := fmt.Sprintf("%s/graphics[%d]", path, i)
+		if !checkDomainCapsDevice(v, graphicPath, "graphics", caps.Graphics) {
+			continue
+		}
+		checkDomainCapsEnum(v, graphicPath+"/@type", "graphics type", caps.Graphics.Enums, "type", getGraphicType(&graphic))
+	}
+
+	for i, video := range devs.Videos {
+		videoPath := fmt.Sprintf("%s/video[%d]", path, i)
+		if !checkDomainCapsDevice(v, videoPath, "video", caps.Video) {
+			continue
+		}
+		checkDomainCapsEnum(v, videoPath+"/model[0]/@type", "video model", caps.Video.Enums, "modelType", video.Model.Type)
+	}
+
+	for i, hostdev := range devs.Hostdevs {
+		hostdevPath := fmt.Sprintf("%s/hostdev[%d]", path, i)
+		if !checkDomainCapsDevice(v, hostdevPath, "host", caps.HostDev) {
+			continue
+		}
+		mode, typ := getHostdevTypes(&hostdev)
+		checkDomainCapsEnum(v, hostdevPath+"/@mode", "host device mode", caps.HostDev.Enums, "mode", mode)
+		if mode == "subsystem" {
+			checkDomainCapsEnum(v, hostdevPath+"/@type", "host device type", caps.HostDev.Enums, "subsysType", typ)
+		} else {
+			checkDomainCapsEnum(v, hostdevPath+"/@type", "host device type", caps.HostDev.Enums, "capsType", typ)
+		}
+		if hostdev.SubsysPCI != nil && hostdev.SubsysPCI.Driver != nil {
+			checkDomainCapsEnum(v, hostdevPath+"/driver[0]/@name", "PCI backend", caps.HostDev.Enums, "pciBackend", hostdev.SubsysPCI.Driver.Name)
+		}
+	}
+
+	for i, rng := range devs.RNGs {
+		rngPath := fmt.Sprintf("%s/rng[%d]", path, i)
+		if !checkDomainCapsDevice(v, rngPath, "RNG", caps.RNG) {
+			continue
+		}
+		checkDomainCapsEnum(v, rngPath+"/@model", "RNG model", caps.RNG.Enums, "model", rng.Model)
+		checkDomainCapsEnum(v, rngPath+"/backend[0]/@model", "RNG backend", caps.RNG.Enums, "backendModel", getRNGBackendModel(rng.Backend))
+	}
+
+	for i, fs := range devs.Filesystems {
+		fsPath := fmt.Sprintf("%s/filesystem[%d]", path, i)
+		if !checkDomainCapsDevice(v, fsPath, "filesystem", caps.FileSystem) {
+			continue
+		}
+		if fs.Driver != nil {
+			checkDomainCapsEnum(v, fsPath+"/driver[0]/@type", "filesystem driver", caps.FileSystem.Enums, "driverType", fs.Driver.Type)
+		}
+	}
+}
+
+func checkDomainCapsCPU(v *validator, path string, cpu *DomainCPU, caps *DomainCapsCPU) {
+	mode := cpu.Mode
+	if mode == "" {
+		if cpu.Model == nil || cpu.Model.Value == "" {
+			return
+		}
+		mode = "custom"
+	}
+
+	var capsMode *DomainCapsCPUMode
+	for i := range caps.Modes {
+		if caps.Modes[i].Name == mode {
+			capsMode = &caps.Modes[i]
+			break
+		}
+	}
+	if capsMode == nil || capsMode.Supported != "yes" {
+		v.add(path+"/@mode", "CPU mode '%s' is not supported by the host", mode)
+		return
+	}
+
+	if mode != "custom" || cpu.Model == nil || cpu.Model.Value == "" {
+		return
+	}
+	for _, model := range capsMode.Models {
+		if model.Name != cpu.Model.Value {
+			continue
+		}
+		if model.Usable == "no" {
+			v.add(path+"/model[0]", "CPU model '%s' is not usable on the host", model.Name)
+		}
+		return
+	}
+	v.add(path+"/model[0]", "CPU model '%s' is not supported by the host", cpu.Model.Value)
+}
+
+func checkDomainCapsOS(v *validator, path string, os *DomainOS, caps *DomainCapsOS) {
+	checkDomainCapsSupported(v, path, "OS configuration", caps.Supported)
+	checkDomainCapsEnum(v, path+"/@firmware", "firmware", caps.Enums, "firmware", os.Firmware)
+	if os.Loader == nil || caps.Loader == nil {
+		return
+	}
+	loaderPath := path + "/loader[0]"
+	checkDomainCapsSupported(v, loaderPath, "firmware loader", caps.Loader.Supported)
+	checkDomainCapsEnum(v, loaderPath+"/@type", "loader type", caps.Loader.Enums, "type", os.Loader.Type)
+	checkDomainCapsEnum(v, loaderPath+"/@readonly", "loader readonly", caps.Loader.Enums, "readonly", os.Loader.Readonly)
+	checkDomainCapsEnum(v, loaderPath+"/@secure", "loader secure", caps.Loader.Enums, "secure", os.Loader.Secure)
+}
+
+func checkDomainCapsFeatures(v *validator, path string, d *Domain, caps *DomainCapsFeatures) {
+	if d.Features != nil && d.Features.GIC != nil && caps.GIC != nil {
+		gicPath := path + "/features[0]/gic[0]"
+		checkDomainCapsSupported(v, gicPath, "GIC", caps.GIC.Supported)
+		if caps.GIC.Supported != "no" {
+			checkDomainCapsEnum(v, gicPath+"/@version", "GIC version", caps.GIC.Enums, "version", d.Features.GIC.Version)
+		}
+	}
+	if d.Features != nil && d.Features.VMCoreInfo != nil &&
+		d.Features.VMCoreInfo.State != "off" && caps.VMCoreInfo != nil {
+		checkDomainCapsSupported(v, path+"/features[0]/vmcoreinfo[0]", "vmcoreinfo", caps.VMCoreInfo.Supported)
+	}
+	if d.GenID != nil && caps.GenID != nil {
+		checkDomainCapsSupported(v, path+"/genid[0]", "genid", caps.GenID.Supported)
+	}
+	if d.LaunchSecurity != nil && d.LaunchSecurity.SEV != nil && caps.SEV != nil {
+		checkDomainCapsSupported(v, path+"/launchSecurity[0]", "SEV", caps.SEV.Supported)
+	}
+}
+
+// Aliases of machine types which do not expand to a versioned
+// name by appending the version
+var domainCapsMachineAliases = map[string]string{
+	"pc":  "pc-i440fx-",
+	"q35": "pc-q35-",
+}
+
+// Reports whether a domain machine type is the machine type of
+// the capabilities. The capabilities report the versioned name,
+// which the domain may refer to by an alias such as "pc" or "virt".
+func domainCapsMachineMatches(machine, capsMachine string) bool {
+	if machine == capsMachine {
+		return true
+	}
+	if prefix, ok := domainCapsMachineAliases[machine]; ok {
+		return strings.HasPrefix(capsMachine, prefix)
+	}
+	return strings.HasPrefix(capsMachine, machine+"-")
+}
+
+// ValidateCaps checks the domain configuration against the
+// capabilities of the host hypervisor, as reported by libvirt's
+// domain capabilities API for the same emulator, arch, machine
+// and virt type. It returns nil if no problems are found,
+// otherwise ValidationErrors. Parts of the configuration which
+// the capabilities do not describe are not checked.
+func (d *Domain) ValidateCaps(caps *DomainCaps) error {
+	if caps == nil {
+		return fmt.Errorf("no domain capabilities to validate against")
+	}
+	v := &validator{}
+	path := "/domain[0]"
+
+	if caps.Domain != "" && d.Type != "" && d.Type != caps.Domain {
+		v.add(path+"/@type", "domain type '%s' does not match capabilities type '%s'", d.Type, caps.Domain)
+	}
+	if d.OS != nil && d.OS.Type != nil && d.OS.Type.Arch != "" &&
+		caps.Arch != "" && d.OS.Type.Arch != caps.Arch {
+		v.add(path+"/os[0]/type[0]/@arch", "architecture '%s' does not match capabilities architecture '%s'",
+			d.OS.Type.Arch, caps.Arch)
+	}
+	if d.OS != nil && d.OS.Type != nil && d.OS.Type.Machine != "" &&
+		caps.Machine != "" && !domainCapsMachineMatches(d.OS.Type.Machine, caps.Machine) {
+		v.add(path+"/os[0]/type[0]/@machine", "machine type '%s' does not match capabilities machine type '%s'",
+			d.OS.Type.Machine, caps.Machine)
+	}
+
+	if d.VCPU != nil && caps.VCPU != nil && d.VCPU.Value > caps.VCPU.Max {
+		v.add(path+"/vcpu[0]", "vCPU count %d exceeds host maximum %d", d.VCPU.Value, caps.VCPU.Max)
+	}
+	if (d.IOThreads != 0 || d.IOThreadIDs != nil) && caps.IOThreads != nil {
+		checkDomainCapsSupported(v, path+"/iothreads[0]", "I/O threads", caps.IOThreads.Supported)
+	}
+
+	if d.OS != nil && caps.OS != nil {
+		checkDomainCapsOS(v, path+"/os[0]", d.OS, caps.OS)
+	}
+	if d.CPU != nil && caps.CPU != nil {
+		checkDomainCapsCPU(v, path+"/cpu[0]", d.CPU, caps.CPU)
+	}
+	if d.Devices != nil && caps.Devices != nil {
+		checkDomainCapsDevices(v, path+"/devices[0]", d.Devices, caps.Devices)
+	}
+	if caps.Features != nil {
+		checkDomainCapsFeatures(v, path, d, caps.Features)
+	}
+
+	return v.result()
+}
@@ -0,0 +1,263 @@
+/*
+ * This file is part of the libvirt-go-xml project
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ *
+ * Copyright (C) 2020 Red Hat, Inc.
+ *
+ */
+
+package libvirtxml
+
+import (
+	"reflect"
+	"testing"
+)
+
+const validateDomainCapsXML = `<domainCapabilities>
+  <path>/usr/bin/qemu-system-x86_64</path>
+  <domain>kvm</domain>
+  <machine>pc-q35-5.0</machine>
+  <arch>x86_64</arch>
+  <vcpu max="255"/>
+  <iothreads supported="yes"/>
+  <os supported="yes">
+    <enum name="firmware">
+      <value>efi</value>
+    </enum>
+    <loader supported="yes">
+      <value>/usr/share/OVMF/OVMF_CODE.fd</value>
+      <enum name="type">
+        <value>rom</value>
+        <value>pflash</value>
+      </enum>
+      <enum name="readonly">
+        <value>yes</value>
+        <value>no</value>
+      </enum>
+      <enum name="secure">
+        <value>no</value>
+      </enum>
+    </loader>
+  </os>
+  <cpu>
+    <mode name="host-passthrough" supported="yes"/>
+    <mode name="host-model" supported="no"/>
+    <mode name="custom" supported="yes">
+      <model usable="yes">Skylake-Client</model>
+      <model usable="no">EPYC</model>
+    </mode>
+  </cpu>
+  <devices>
+    <disk supported="yes">
+      <enum name="diskDevice">
+        <value>disk</value>
+        <value>cdrom</value>
+      </enum>
+      <enum name="bus">
+        <value>sata</value>
+        <value>virtio</value>
+      </enum>
+    </disk>
+    <graphics supported="yes">
+      <enum name="type">
+        <value>vnc</value>
+      </enum>
+    </graphics>
+    <hostdev supported="no"/>
+    <rng supported="yes">
+      <enum name="model">
+        <value>virtio</value>
+      </enum>
+      <enum name="backendModel">
+        <value>random</value>
+      </enum>
+    </rng>
+  </devices>
+  <features>
+    <gic supported="no"/>
+    <genid supported="yes"/>
+    <sev supported="no"/>
+  </features>
+</domainCapabilities>`
+
+var domainValidateCapsTestData = []struct {
+	Modify func(dom *Domain)
+	Paths  []string
+}{
+	{
+		Modify: func(dom *Domain) {
+			dom.OS.Type.Arch = "x86_64"
+			dom.CPU = &DomainCPU{
+				Mode: "custom",
+				Model: &DomainCPUModel{
+					Value: "Skylake-Client",
+				},
+			}
+		},
+	},
+	{
+		Modify: func(dom *Domain) {
+			dom.Type = "qemu"
+			dom.OS.Type.Arch = "aarch64"
+			dom.VCPU = &DomainVCPU{
+				Value: 512,
+			}
+		},
+		Paths: []string{
+			"/domain[0]/@type",
+			"/domain[0]/os[0]/type[0]/@arch",
+			"/domain[0]/vcpu[0]",
+		},
+	},
+	{
+		Modify: func(dom *Domain) {
+			dom.OS.Type.Machine = "q35"
+		},
+	},
+	{
+		Modify: func(dom *Domain) {
+			dom.OS.Type.Machine = "pc-i440fx-5.0"
+		},
+		Paths: []string{
+			"/domain[0]/os[0]/type[0]/@machine",
+		},
+	},
+	{
+		Modify: func(dom *Domain) {
+			dom.OS.Firmware = "bios"
+			dom.OS.Loader = &DomainLoader{
+				Path:   "/usr/share/OVMF/OVMF_CODE.secboot.fd",
+				Type:   "pflash",
+				Secure: "yes",
+			}
+		},
+		Paths: []string{
+			"/domain[0]/os[0]/@firmware",
+			"/domain[0]/os[0]/loader[0]/@secure",
+		},
+	},
+	{
+		Modify: func(dom *Domain) {
+			dom.CPU = &DomainCPU{
+				Mode: "host-model",
+			}
+		},
+		Paths: []string{
+			"/domain[0]/cpu[0]/@mode",
+		},
+	},
+	{
+		Modify: func(dom *Domain) {
+			dom.CPU = &DomainCPU{
+				Model: &DomainCPUModel{
+					Value: "EPYC",
+				},
+			}
+		},
+		Paths: []string{
+			"/domain[0]/cpu[0]/model[0]",
+		},
+	},
+	{
+		Modify: func(dom *Domain) {
+			dom.Devices.Disks[0].Device = "lun"
+			dom.Devices.Disks[0].Target.Bus = "scsi"
+			dom.Devices.Graphics = []DomainGraphic{
+				DomainGraphic{
+					Spice: &DomainGraphicSpice{},
+				},
+			}
+			dom.Devices.Hostdevs = []DomainHostdev{
+				DomainHostdev{
+					SubsysPCI: &DomainHostdevSubsysPCI{},
+				},
+			}
+			dom.Devices.RNGs = []DomainRNG{
+				DomainRNG{
+					Model: "virtio",
+					Backend: &DomainRNGBackend{
+						EGD: &DomainRNGBackendEGD{},
+					},
+				},
+			}
+		},
+		Paths: []string{
+			"/domain[0]/devices[0]/disk[0]/@device",
+			"/domain[0]/devices[0]/disk[0]/target[0]/@bus",
+			"/domain[0]/devices[0]/graphics[0]/@type",
+			"/domain[0]/devices[0]/hostdev[0]",
+			"/domain[0]/devices[0]/rng[0]/backend[0]/@model",
+		},
+	},
+	{
+		Modify: func(dom *Domain) {
+			dom.Features = &DomainFeatureList{
+				GIC: &DomainFeatureGIC{
+					Version: "3",
+				},
+			}
+			dom.GenID = &DomainGenID{}
+			dom.LaunchSecurity = &DomainLaunchSecurity{
+				SEV: &DomainLaunchSecuritySEV{},
+			}
+		},
+		Paths: []string{
+			"/domain[0]/features[0]/gic[0]",
+			"/domain[0]/launchSecurity[0]",
+		},
+	},
+}
+
+func TestDomainValidateCaps(t *testing.T) {
+	caps := &DomainCaps{}
+	err := caps.Unmarshal(validateDomainCapsXML)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range domainValidateCapsTestData {
+		dom := validDomain()
+		test.Modify(dom)
+
+		err := dom.ValidateCaps(caps)
+		if len(test.Paths) == 0 {
+			if err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+
+		errs, ok := err.(ValidationErrors)
+		if !ok {
+			t.Fatalf("Expected validation errors, got %v", err)
+		}
+		paths := []string{}
+		for _, err := range errs {
+			paths = append(paths, err.Path)
+		}
+		if !reflect.DeepEqual(paths, test.Paths) {
+			t.Fatalf("Expected errors at %v, got %v", test.Paths, errs)
+		}
+	}
+
+	if err := validDomain().ValidateCaps(nil); err == nil {
+		t.Fatal("Expected an error without capabilities")
+	}
+}
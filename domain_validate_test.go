@@ -0,0 +1,212 @@
+/*
+ * This file is part of the libvirt-go-xml project
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ *
+ * Copyright (C) 2020 Red Hat, Inc.
+ *
+ */
+
+package libvirtxml
+
+import (
+	"reflect"
+	"testing"
+)
+
+var validatePCIDomain uint = 0
+var validatePCIBus uint = 0
+var validatePCISlotOK uint = 0x1f
+var validatePCISlotBad uint = 0x20
+var validatePCIFunctionBad uint = 8
+
+func validDomain() *Domain {
+	return &Domain{
+		Type: "kvm",
+		Name: "demo",
+		UUID: "8f99e332-06c4-463a-9099-330fb244e1b3",
+		Memory: &DomainMemory{
+			Value: 1048576,
+			Unit:  "KiB",
+		},
+		OS: &DomainOS{
+			Type: &DomainOSType{
+				Type: "hvm",
+			},
+		},
+		Devices: &DomainDeviceList{
+			Disks: []DomainDisk{
+				DomainDisk{
+					Source: &DomainDiskSource{
+						File: &DomainDiskSourceFile{
+							File: "/var/lib/libvirt/images/demo.img",
+						},
+					},
+					Target: &DomainDiskTarget{
+						Dev: "vda",
+						Bus: "virtio",
+					},
+					Alias: &DomainAlias{
+						Name: "ua-disk0",
+					},
+					Address: &DomainAddress{
+						PCI: &DomainAddressPCI{
+							Domain:   &validatePCIDomain,
+							Bus:      &validatePCIBus,
+							Slot:     &validatePCISlotOK,
+							Function: &validatePCIDomain,
+						},
+					},
+				},
+			},
+			Interfaces: []DomainInterface{
+				DomainInterface{
+					MAC: &DomainInterfaceMAC{
+						Address: "52:54:00:39:97:ac",
+					},
+					Source: &DomainInterfaceSource{
+						Network: &DomainInterfaceSourceNetwork{
+							Network: "default",
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+var domainValidateTestData = []struct {
+	Modify func(dom *Domain)
+	Paths  []string
+}{
+	{
+		Modify: func(dom *Domain) {},
+	},
+	{
+		Modify: func(dom *Domain) {
+			dom.Name = ""
+			dom.Memory = nil
+			dom.OS = nil
+		},
+		Paths: []string{
+			"/domain[0]/name[0]",
+			"/domain[0]/memory[0]",
+			"/domain[0]/os[0]/type[0]",
+		},
+	},
+	{
+		Modify: func(dom *Domain) {
+			dom.UUID = "-8f99e332--06c4463a-90-99-330fb244e1b3"
+		},
+	},
+	{
+		Modify: func(dom *Domain) {
+			dom.UUID = "8f99e332-06c4-463a-9099-330fb244e1bz"
+			dom.Devices.Interfaces[0].MAC.Address = "52:54:00:39:97"
+		},
+		Paths: []string{
+			"/domain[0]/uuid[0]",
+			"/domain[0]/devices[0]/interface[0]/mac[0]/@address",
+		},
+	},
+	{
+		Modify: func(dom *Domain) {
+			dom.Devices.Disks[0].Source.Block = &DomainDiskSourceBlock{
+				Dev: "/dev/sda",
+			}
+			dom.Devices.Interfaces[0].Source.Bridge = &DomainInterfaceSourceBridge{
+				Bridge: "br0",
+			}
+		},
+		Paths: []string{
+			"/domain[0]/devices[0]/disk[0]/source[0]",
+			"/domain[0]/devices[0]/interface[0]/source[0]",
+		},
+	},
+	{
+		Modify: func(dom *Domain) {
+			dom.Devices.Disks[0].Address.PCI.Slot = &validatePCISlotBad
+			dom.Devices.Disks[0].Address.PCI.Function = &validatePCIFunctionBad
+		},
+		Paths: []string{
+			"/domain[0]/devices[0]/disk[0]/address[0]/@slot",
+			"/domain[0]/devices[0]/disk[0]/address[0]/@function",
+		},
+	},
+	{
+		Modify: func(dom *Domain) {
+			dom.Devices.Interfaces[0].Alias = &DomainAlias{
+				Name: "ua-disk0",
+			}
+		},
+		Paths: []string{
+			"/domain[0]/devices[0]/interface[0]/alias[0]/@name",
+		},
+	},
+}
+
+func TestDomainValidate(t *testing.T) {
+	for _, test := range domainValidateTestData {
+		dom := validDomain()
+		test.Modify(dom)
+
+		err := dom.Validate()
+		if len(test.Paths) == 0 {
+			if err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+
+		errs, ok := err.(ValidationErrors)
+		if !ok {
+			t.Fatalf("Expected validation errors, got %v", err)
+		}
+		paths := []string{}
+		for _, err := range errs {
+			paths = append(paths, err.Path)
+		}
+		if !reflect.DeepEqual(paths, test.Paths) {
+			t.Fatalf("Expected errors at %v, got %v", test.Paths, errs)
+		}
+	}
+}
+
+func TestIsValidUUID(t *testing.T) {
+	tests := []struct {
+		UUID  string
+		Valid bool
+	}{
+		{"8f99e332-06c4-463a-9099-330fb244e1b3", true},
+		{"8F99E33206C4463A9099330FB244E1B3", true},
+		{"  8f99e332-06c4-463a-9099-330fb244e1b3 ", true},
+		{"-8f99e332--06c4-463a-9099-330fb244e1b3", true},
+		{"8f-99-e3-32-06-c4-46-3a-90-99-33-0f-b2-44-e1-b3", true},
+		{"8f99e332-06c4-463a-9099-330fb244e1b3-", false},
+		{"8f99e332-06c4-463a-9099-330fb244e1b", false},
+		{"8f99e332-06c4-463a-9099-330fb244e1b3aa", false},
+		{"8f99e33-206c4-463a-9099-330fb244e1b3", false},
+		{"8f99e332-06c4-463a-9099-330fb244e1bz", false},
+	}
+	for _, test := range tests {
+		if isValidUUID(test.UUID) != test.Valid {
+			t.Errorf("Expected '%s' valid %v", test.UUID, test.Valid)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+/*
+ * This file is part of the libvirt-go-xml project
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ *
+ * Copyright (C) 2020 Red Hat, Inc.
+ *
+ */
+
+package libvirtxml
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MACAddress is an Ethernet hardware address
+type MACAddress [6]byte
+
+// The OUI libvirt and QEMU use for generated guest addresses
+var macAddressPrefix = [3]byte{0x52, 0x54, 0x00}
+
+// ParseMACAddress parses an address in the syntax libvirt accepts,
+// which is six octets of one or two hex digits separated by colons
+// or dashes
+func ParseMACAddress(mac string) (MACAddress, error) {
+	addr := MACAddress{}
+	octets := strings.FieldsFunc(strings.TrimSpace(mac), func(c rune) bool {
+		return c == ':' || c == '-'
+	})
+	if len(octets) != 6 || strings.Count(mac, ":")+strings.Count(mac, "-") != 5 {
+		return addr, fmt.Errorf("invalid MAC address '%s'", mac)
+	}
+	for i, octet := range octets {
+		if len(octet) < 1 || len(octet) > 2 || !isHexString(octet) {
+			return addr, fmt.Errorf("invalid MAC address '%s'", mac)
+		}
+		val, err := strconv.ParseUint(octet, 16, 8)
+		if err != nil {
+			return addr, fmt.Errorf("invalid MAC address '%s'", mac)
+		}
+		addr[i] = byte(val)
+	}
+	return addr, nil
+}
+
+// NormalizeMACAddress returns the address in the canonical
+// lower case, colon separated form
+func NormalizeMACAddress(mac string) (string, error) {
+	addr, err := ParseMACAddress(mac)
+	if err != nil {
+		return "", err
+	}
+	return addr.String(), nil
+}
+
+func (m MACAddress) String() string {
+	return fmt.Sprintf("%02x:%02x:%02x:%02x:%02x:%02x", m[0], m[1], m[2], m[3], m[4], m[5])
+}
+
+// IsMulticast reports whether the address is a multicast
+// address, which can not be used by a network interface
+func (m MACAddress) IsMulticast() bool {
+	return m[0]&0x01 != 0
+}
+
+// GenerateMACAddress returns a random address in the libvirt OUI
+func GenerateMACAddress() (MACAddress, error) {
+	addr := MACAddress{macAddressPrefix[0], macAddressPrefix[1], macAddressPrefix[2]}
+	_, err := rand.Read(addr[3:])
+	if err != nil {
+		return addr, err
+	}
+	return addr, nil
+}
+
+// GenerateMACAddressFromUUID returns an address in the libvirt OUI
+// derived from the domain UUID and the index of the interface, so
+// that the same domain and interface always get the same address
+func GenerateMACAddressFromUUID(uuid string, index uint) (MACAddress, error) {
+	addr := MACAddress{macAddressPrefix[0], macAddressPrefix[1], macAddressPrefix[2]}
+	if !isValidUUID(uuid) {
+		return addr, fmt.Errorf("invalid UUID '%s'", uuid)
+	}
+	uuid = strings.ToLower(strings.Replace(strings.TrimSpace(uuid), "-", "", -1))
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s/%d", uuid, index)))
+	copy(addr[3:], sum[:3])
+	return addr, nil
+}
+
+// MACAddressUse records where an address is used
+type MACAddressUse struct {
+	Document Document
+	// Name of the domain or network the address belongs to
+	Name string
+	Path string
+}
+
+// MACAddressConflict lists the places where an address is
+// used more than once
+type MACAddressConflict struct {
+	Address MACAddress
+	Uses    []MACAddressUse
+}
+
+type macAddressRef struct {
+	address string
+	use     MACAddressUse
+}
+
+func macAddressRefs(doc Document) []macAddressRef {
+	refs := []macAddressRef{}
+	switch obj := doc.(type) {
+	case *Domain:
+		if obj.Devices == nil {
+			break
+		}
+		for i, iface := range obj.Devices.Interfaces {
+			if iface.MAC == nil {
+				continue
+			}
+			refs = append(refs, macAddressRef{
+				address: iface.MAC.Address,
+				use: MACAddressUse{
+					Document: doc,
+					Name:     obj.Name,
+					Path:     fmt.Sprintf("/domain[0]/devices[0]/interface[%d]/mac[0]/@address", i),
+				},
+			})
+		}
+	case *Network:
+		if obj.MAC != nil {
+			refs = append(refs, macAddressRef{
+				address: obj.MAC.Address,
+				use: MACAddressUse{
+					Document: doc,
+					Name:     obj.Name,
+					Path:     "/network[0]/mac[0]/@address",
+				},
+			})
+		}
+	}
+	return refs
+}
+
+// FindMACAddressConflicts scans the interfaces of domains and the
+// bridge addresses of networks, reporting every address which is
+// used more than once. Addresses which can not be parsed are
+// ignored. Conflicts are sorted by address.
+func FindMACAddressConflicts(docs ...Document) []MACAddressConflict {
+	uses := make(map[MACAddress][]MACAddressUse)
+	for _, doc := range docs {
+		for _, ref := range macAddressRefs(doc) {
+			addr, err := ParseMACAddress(ref.address)
+			if err != nil {
+				continue
+			}
+			uses[addr] = append(uses[addr], ref.use)
+		}
+	}
+
+	conflicts := []MACAddressConflict{}
+	for addr, addrUses := range uses {
+		if len(addrUses) < 2 {
+			continue
+		}
+		conflicts = append(conflicts, MACAddressConflict{
+			Address: addr,
+			Uses:    addrUses,
+		})
+	}
+	sort.Slice(conflicts, func(i, j int) bool {
+		return conflicts[i].Address.String() < conflicts[j].Address.String()
+	})
+	return conflicts
+}
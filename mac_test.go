@@ -0,0 +1,181 @@
+/*
+ * This file is part of the libvirt-go-xml project
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ *
+ * Copyright (C) 2020 Red Hat, Inc.
+ *
+ */
+
+package libvirtxml
+
+import (
+	"reflect"
+	"testing"
+)
+
+var macAddressParseTestData = []struct {
+	Input    string
+	Expected string
+}{
+	{"52:54:00:39:97:ac", "52:54:00:39:97:ac"},
+	{"52:54:0:A:b:C", "52:54:00:0a:0b:0c"},
+	{"52-54-00-39-97-AC", "52:54:00:39:97:ac"},
+	{"52:54:00:39:97", ""},
+	{"52:54:00:39:97:ac:01", ""},
+	{"52:54:00:39::ac", ""},
+	{"52:54:00:39:97:1ac", ""},
+	{"52:54:00:39:97:zz", ""},
+}
+
+func TestNormalizeMACAddress(t *testing.T) {
+	for _, test := range macAddressParseTestData {
+		mac, err := NormalizeMACAddress(test.Input)
+		if test.Expected == "" {
+			if err == nil {
+				t.Fatalf("Expected error parsing %s, got %s", test.Input, mac)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mac != test.Expected {
+			t.Fatalf("Expected %s for %s, got %s", test.Expected, test.Input, mac)
+		}
+	}
+}
+
+func TestGenerateMACAddress(t *testing.T) {
+	mac, err := GenerateMACAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mac[0] != 0x52 || mac[1] != 0x54 || mac[2] != 0x00 {
+		t.Fatalf("Unexpected prefix in %s", mac)
+	}
+
+	uuid := "8f99e332-06c4-463a-9099-330fb244e1b3"
+	mac0, err := GenerateMACAddressFromUUID(uuid, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := GenerateMACAddressFromUUID("8F99E33206C4463A9099330FB244E1B3", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mac0 != again {
+		t.Fatalf("Expected the same address for the same UUID, got %s and %s", mac0, again)
+	}
+	mac1, err := GenerateMACAddressFromUUID(uuid, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mac0 == mac1 {
+		t.Fatalf("Expected different addresses for different interfaces, got %s", mac0)
+	}
+	if mac1[0] != 0x52 || mac1[1] != 0x54 || mac1[2] != 0x00 {
+		t.Fatalf("Unexpected prefix in %s", mac1)
+	}
+
+	_, err = GenerateMACAddressFromUUID("not-a-uuid", 0)
+	if err == nil {
+		t.Fatal("Expected an error for an invalid UUID")
+	}
+}
+
+func TestFindMACAddressConflicts(t *testing.T) {
+	dom1 := &Domain{
+		Name: "one",
+		Devices: &DomainDeviceList{
+			Interfaces: []DomainInterface{
+				DomainInterface{
+					MAC: &DomainInterfaceMAC{
+						Address: "52:54:00:39:97:ac",
+					},
+				},
+				DomainInterface{
+					MAC: &DomainInterfaceMAC{
+						Address: "52:54:00:00:00:01",
+					},
+				},
+			},
+		},
+	}
+	dom2 := &Domain{
+		Name: "two",
+		Devices: &DomainDeviceList{
+			Interfaces: []DomainInterface{
+				DomainInterface{
+					MAC: &DomainInterfaceMAC{
+						Address: "52:54:00:39:97:AC",
+					},
+				},
+				DomainInterface{
+					MAC: &DomainInterfaceMAC{
+						Address: "bogus",
+					},
+				},
+			},
+		},
+	}
+	net := &Network{
+		Name: "default",
+		MAC: &NetworkMAC{
+			Address: "52:54:0:0:0:1",
+		},
+	}
+
+	conflicts := FindMACAddressConflicts(dom1, dom2, net)
+	expected := []MACAddressConflict{
+		MACAddressConflict{
+			Address: MACAddress{0x52, 0x54, 0x00, 0x00, 0x00, 0x01},
+			Uses: []MACAddressUse{
+				MACAddressUse{
+					Document: dom1,
+					Name:     "one",
+					Path:     "/domain[0]/devices[0]/interface[1]/mac[0]/@address",
+				},
+				MACAddressUse{
+					Document: net,
+					Name:     "default",
+					Path:     "/network[0]/mac[0]/@address",
+				},
+			},
+		},
+		MACAddressConflict{
+			Address: MACAddress{0x52, 0x54, 0x00, 0x39, 0x97, 0xac},
+			Uses: []MACAddressUse{
+				MACAddressUse{
+					Document: dom1,
+					Name:     "one",
+					Path:     "/domain[0]/devices[0]/interface[0]/mac[0]/@address",
+				},
+				MACAddressUse{
+					Document: dom2,
+					Name:     "two",
+					Path:     "/domain[0]/devices[0]/interface[0]/mac[0]/@address",
+				},
+			},
+		},
+	}
+	if !reflect.DeepEqual(conflicts, expected) {
+		t.Fatalf("Expected conflicts %v, got %v", expected, conflicts)
+	}
+}
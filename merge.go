@@ -0,0 +1,365 @@
+/*
+ * This file is part of the libvirt-go-xml project
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ *
+ * Copyright (C) 2020 Red Hat, Inc.
+ *
+ */
+
+package libvirtxml
+
+import (
+	"encoding/xml"
+	"fmt"
+)
+
+// MergeConflict is an attribute, text or element which was changed
+// differently on both sides of a three-way merge. Paths are as for a
+// Difference, in our document where the item is present there. The
+// values are empty where the item is absent, and hold the XML of the
+// whole element where an element was removed on one side and changed
+// on the other.
+type MergeConflict struct {
+	Path   string
+	Base   string
+	Ours   string
+	Theirs string
+}
+
+func (c MergeConflict) String() string {
+	return fmt.Sprintf("conflict %s: base '%s' ours '%s' theirs '%s'",
+		c.Path, c.Base, c.Ours, c.Theirs)
+}
+
+type xmlMerger struct {
+	conflicts []MergeConflict
+}
+
+// Picks the result of merging a single value, preferring ours
+// when both sides changed it differently
+func (m *xmlMerger) mergeValue(path string, base, ours, theirs string, inBase, inOurs, inTheirs bool) (string, bool) {
+	if inOurs == inTheirs && ours == theirs {
+		return ours, inOurs
+	}
+	if inBase == inOurs && base == ours {
+		return theirs, inTheirs
+	}
+	if inBase == inTheirs && base == theirs {
+		return ours, inOurs
+	}
+	m.conflicts = append(m.conflicts, MergeConflict{
+		Path:   path,
+		Base:   base,
+		Ours:   ours,
+		Theirs: theirs,
+	})
+	return ours, inOurs
+}
+
+func findXMLNodeAttr(n *xmlNode, space, local string) (xmlNodeAttr, bool) {
+	for _, attr := range n.Attrs {
+		if attr.Space == space && attr.Local == local {
+			return attr, true
+		}
+	}
+	return xmlNodeAttr{}, false
+}
+
+func (m *xmlMerger) mergeAttrs(merged, base, ours, theirs *xmlNode, path string) {
+	names := []xmlNodeAttr{}
+	seen := make(map[xml.Name]bool)
+	for _, n := range []*xmlNode{ours, theirs, base} {
+		for _, attr := range n.Attrs {
+			name := xml.Name{Space: attr.Space, Local: attr.Local}
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, attr)
+			}
+		}
+	}
+
+	for _, name := range names {
+		baseAttr, inBase := findXMLNodeAttr(base, name.Space, name.Local)
+		ourAttr, inOurs := findXMLNodeAttr(ours, name.Space, name.Local)
+		theirAttr, inTheirs := findXMLNodeAttr(theirs, name.Space, name.Local)
+		if name.Space == "xmlns" {
+			if inOurs {
+				merged.Attrs = append(merged.Attrs, ourAttr)
+			} else if inTheirs {
+				merged.Attrs = append(merged.Attrs, theirAttr)
+			}
+			continue
+		}
+
+		attrPath := path + "/@" + xmlName(name.Space, xml.Name{Local: name.Local})
+		val, ok := m.mergeValue(attrPath, baseAttr.Value, ourAttr.Value, theirAttr.Value,
+			inBase, inOurs, inTheirs)
+		if !ok {
+			continue
+		}
+		if inOurs && val == ourAttr.Value {
+			merged.Attrs = append(merged.Attrs, ourAttr)
+		} else {
+			merged.Attrs = append(merged.Attrs, theirAttr)
+		}
+	}
+}
+
+func unchangedXMLNode(base, n *xmlNode) (bool, error) {
+	diffs, err := diffXMLNode(base, n, "", "")
+	if err != nil {
+		return false, err
+	}
+	return len(diffs) == 0, nil
+}
+
+// Merges an element which was removed on one side and may have
+// been changed on the other, returning whether to keep it
+func (m *xmlMerger) mergeRemoved(path string, base, other *xmlNode, oursRemoved bool) (bool, error) {
+	unchanged, err := unchangedXMLNode(base, other)
+	if err != nil || unchanged {
+		return false, err
+	}
+	baseDoc, err := formatXMLNode(base)
+	if err != nil {
+		return false, err
+	}
+	otherDoc, err := formatXMLNode(other)
+	if err != nil {
+		return false, err
+	}
+	conflict := MergeConflict{
+		Path: path,
+		Base: baseDoc,
+	}
+	if oursRemoved {
+		conflict.Theirs = otherDoc
+	} else {
+		conflict.Ours = otherDoc
+	}
+	m.conflicts = append(m.conflicts, conflict)
+	return !oursRemoved, nil
+}
+
+// Merges the changes from base to theirs into ours. Child elements
+// are paired up by identity as for a diff, and keep the order they
+// have in ours, with elements only added in theirs placed after the
+// last element of the same name. A nil base merges elements which
+// were added on both sides.
+func (m *xmlMerger) mergeNode(base, ours, theirs *xmlNode, path, theirPath string) (*xmlNode, error) {
+	if base == nil {
+		base = &xmlNode{Space: ours.Space, Local: ours.Local, Raw: ours.Raw}
+	}
+	merged := &xmlNode{
+		Space: ours.Space,
+		Local: ours.Local,
+		Raw:   ours.Raw,
+	}
+	m.mergeAttrs(merged, base, ours, theirs, path)
+
+	baseText, ourText, theirText := base.content(), ours.content(), theirs.content()
+	text, _ := m.mergeValue(path+"/text()", baseText, ourText, theirText,
+		baseText != "", ourText != "", theirText != "")
+	if text != "" {
+		merged.Children = append(merged.Children, &xmlNode{Text: text})
+	}
+
+	bases, ourEls, theirEls := diffElements(base), diffElements(ours), diffElements(theirs)
+	ourPaths := diffElementPaths(path, ourEls)
+	theirPaths := diffElementPaths(theirPath, theirEls)
+	ourMatches := diffMatchElements(bases, ourEls)
+	theirMatches := diffMatchElements(bases, theirEls)
+
+	ourBase := make([]int, len(ourEls))
+	theirBase := make([]int, len(theirEls))
+	for j := range ourBase {
+		ourBase[j] = -1
+	}
+	for k := range theirBase {
+		theirBase[k] = -1
+	}
+	for i := range bases {
+		if ourMatches[i] != -1 {
+			ourBase[ourMatches[i]] = i
+		}
+		if theirMatches[i] != -1 {
+			theirBase[theirMatches[i]] = i
+		}
+	}
+
+	ourAdded, theirAdded := []*xmlNode{}, []*xmlNode{}
+	ourAddedIdx, theirAddedIdx := []int{}, []int{}
+	for j, i := range ourBase {
+		if i == -1 {
+			ourAdded = append(ourAdded, ourEls[j])
+			ourAddedIdx = append(ourAddedIdx, j)
+		}
+	}
+	for k, i := range theirBase {
+		if i == -1 {
+			theirAdded = append(theirAdded, theirEls[k])
+			theirAddedIdx = append(theirAddedIdx, k)
+		}
+	}
+	addedMatches := diffMatchElements(ourAdded, theirAdded)
+	ourAddedTheirs := make(map[int]int)
+	theirAddedUsed := make(map[int]bool)
+	for a, b := range addedMatches {
+		if b != -1 {
+			ourAddedTheirs[ourAddedIdx[a]] = theirAddedIdx[b]
+			theirAddedUsed[theirAddedIdx[b]] = true
+		}
+	}
+
+	for _, child := range ours.Children {
+		if child.Comment {
+			merged.Children = append(merged.Children, child)
+		}
+	}
+	for j, child := range ourEls {
+		i := ourBase[j]
+		if i == -1 {
+			k, ok := ourAddedTheirs[j]
+			if !ok {
+				merged.Children = append(merged.Children, child)
+				continue
+			}
+			sub, err := m.mergeNode(nil, child, theirEls[k], ourPaths[j], theirPaths[k])
+			if err != nil {
+				return nil, err
+			}
+			merged.Children = append(merged.Children, sub)
+			continue
+		}
+
+		k := theirMatches[i]
+		if k == -1 {
+			keep, err := m.mergeRemoved(ourPaths[j], bases[i], child, false)
+			if err != nil {
+				return nil, err
+			}
+			if keep {
+				merged.Children = append(merged.Children, child)
+			}
+			continue
+		}
+		sub, err := m.mergeNode(bases[i], child, theirEls[k], ourPaths[j], theirPaths[k])
+		if err != nil {
+			return nil, err
+		}
+		merged.Children = append(merged.Children, sub)
+	}
+
+	for k, child := range theirEls {
+		i := theirBase[k]
+		if i != -1 {
+			if ourMatches[i] == -1 {
+				_, err := m.mergeRemoved(theirPaths[k], bases[i], child, true)
+				if err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		if theirAddedUsed[k] {
+			continue
+		}
+
+		pos := len(merged.Children)
+		for n := len(merged.Children) - 1; n >= 0; n-- {
+			other := merged.Children[n]
+			if other.isElement() && other.Space == child.Space && other.Local == child.Local {
+				pos = n + 1
+				break
+			}
+		}
+		merged.Children = append(merged.Children, nil)
+		copy(merged.Children[pos+1:], merged.Children[pos:])
+		merged.Children[pos] = child
+	}
+
+	return merged, nil
+}
+
+// MergeXML performs a three-way merge of two XML documents which
+// were both derived from a common base document. Changes made on
+// only one side are combined, while changes made differently on
+// both sides are returned as conflicts and resolved in favour of
+// our document.
+func MergeXML(baseDoc, oursDoc, theirsDoc string) (string, []MergeConflict, error) {
+	roots := make([]*xmlNode, 3)
+	for i, doc := range []string{baseDoc, oursDoc, theirsDoc} {
+		root, err := parseXMLNode(doc)
+		if err != nil {
+			return "", nil, err
+		}
+		roots[i] = root
+	}
+
+	names := make([]string, 3)
+	for i, root := range roots {
+		names[i] = xmlName(root.Space, xml.Name{Local: root.Local})
+	}
+	if names[0] != names[1] || names[0] != names[2] {
+		return "", nil, fmt.Errorf("root elements '%s', '%s' and '%s' do not match",
+			names[0], names[1], names[2])
+	}
+
+	m := &xmlMerger{
+		conflicts: []MergeConflict{},
+	}
+	path := "/" + names[0] + "[0]"
+	merged, err := m.mergeNode(roots[0], roots[1], roots[2], path, path)
+	if err != nil {
+		return "", nil, err
+	}
+	doc, err := formatXMLNode(merged)
+	if err != nil {
+		return "", nil, err
+	}
+	return doc, m.conflicts, nil
+}
+
+// MergeDomain performs a three-way merge of two domain
+// configurations which were both edited from a common base.
+// Devices are matched by identity, so that a device changed
+// on one side and another device added on the other are both
+// kept. Conflicting changes are resolved in favour of ours.
+func MergeDomain(base, ours, theirs *Domain) (*Domain, []MergeConflict, error) {
+	docs := make([]string, 3)
+	for i, dom := range []*Domain{base, ours, theirs} {
+		doc, err := dom.Marshal()
+		if err != nil {
+			return nil, nil, err
+		}
+		docs[i] = doc
+	}
+
+	doc, conflicts, err := MergeXML(docs[0], docs[1], docs[2])
+	if err != nil {
+		return nil, nil, err
+	}
+	merged := &Domain{}
+	err = merged.Unmarshal(doc)
+	if err != nil {
+		return nil, nil, err
+	}
+	return merged, conflicts, nil
+}
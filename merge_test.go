@@ -0,0 +1,198 @@
+/*
+ * This file is part of the libvirt-go-xml project
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ *
+ * Copyright (C) 2020 Red Hat, Inc.
+ *
+ */
+
+package libvirtxml
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func mergeTestDomain() *Domain {
+	return &Domain{
+		Type: "kvm",
+		Name: "demo",
+		Memory: &DomainMemory{
+			Value: 1048576,
+			Unit:  "KiB",
+		},
+		VCPU: &DomainVCPU{
+			Value: 2,
+		},
+		Devices: &DomainDeviceList{
+			Disks: []DomainDisk{
+				DomainDisk{
+					Device: "disk",
+					Source: &DomainDiskSource{
+						File: &DomainDiskSourceFile{
+							File: "/var/lib/libvirt/images/a.img",
+						},
+					},
+					Target: &DomainDiskTarget{
+						Dev: "vda",
+						Bus: "virtio",
+					},
+				},
+			},
+			Interfaces: []DomainInterface{
+				DomainInterface{
+					MAC: &DomainInterfaceMAC{
+						Address: "52:54:00:00:00:01",
+					},
+					Source: &DomainInterfaceSource{
+						Network: &DomainInterfaceSourceNetwork{
+							Network: "default",
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func mergeTestDisk(dev string) DomainDisk {
+	return DomainDisk{
+		Device: "disk",
+		Source: &DomainDiskSource{
+			File: &DomainDiskSourceFile{
+				File: "/var/lib/libvirt/images/" + dev + ".img",
+			},
+		},
+		Target: &DomainDiskTarget{
+			Dev: dev,
+			Bus: "virtio",
+		},
+	}
+}
+
+func TestMergeDomainClean(t *testing.T) {
+	base := mergeTestDomain()
+
+	ours := mergeTestDomain()
+	ours.Memory.Value = 2097152
+	ours.Devices.Disks = append(ours.Devices.Disks, mergeTestDisk("vdb"))
+
+	theirs := mergeTestDomain()
+	theirs.Devices.Disks[0].Source.File.File = "/var/lib/libvirt/images/b.img"
+	theirs.Devices.Disks = append(theirs.Devices.Disks, mergeTestDisk("vdc"))
+	theirs.Devices.Interfaces = append(theirs.Devices.Interfaces, DomainInterface{
+		MAC: &DomainInterfaceMAC{
+			Address: "52:54:00:00:00:02",
+		},
+		Source: &DomainInterfaceSource{
+			Network: &DomainInterfaceSourceNetwork{
+				Network: "default",
+			},
+		},
+	})
+
+	merged, conflicts, err := MergeDomain(base, ours, theirs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conflicts) != 0 {
+		t.Fatalf("Unexpected conflicts %v", conflicts)
+	}
+
+	expected := mergeTestDomain()
+	expected.Memory.Value = 2097152
+	expected.Devices.Disks[0].Source.File.File = "/var/lib/libvirt/images/b.img"
+	expected.Devices.Disks = append(expected.Devices.Disks, mergeTestDisk("vdb"), mergeTestDisk("vdc"))
+	expected.Devices.Interfaces = theirs.Devices.Interfaces
+
+	expectedDoc, err := expected.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mergedDoc, err := merged.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mergedDoc != expectedDoc {
+		t.Fatalf("Expected\n%s\ngot\n%s", expectedDoc, mergedDoc)
+	}
+}
+
+func TestMergeDomainConflicts(t *testing.T) {
+	base := mergeTestDomain()
+
+	ours := mergeTestDomain()
+	ours.VCPU.Value = 4
+	ours.Devices.Interfaces = nil
+	ours.Devices.Disks = append(ours.Devices.Disks, mergeTestDisk("vdb"))
+
+	theirs := mergeTestDomain()
+	theirs.VCPU.Value = 8
+	theirs.Devices.Interfaces[0].Model = &DomainInterfaceModel{
+		Type: "virtio",
+	}
+	theirs.Devices.Disks = append(theirs.Devices.Disks, mergeTestDisk("vdb"))
+	theirs.Devices.Disks[1].Target.Bus = "scsi"
+
+	merged, conflicts, err := MergeDomain(base, ours, theirs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []MergeConflict{
+		MergeConflict{
+			Path:   "/domain[0]/vcpu[0]/text()",
+			Base:   "2",
+			Ours:   "4",
+			Theirs: "8",
+		},
+		MergeConflict{
+			Path:   "/domain[0]/devices[0]/disk[1]/target[0]/@bus",
+			Ours:   "virtio",
+			Theirs: "scsi",
+		},
+		MergeConflict{
+			Path: "/domain[0]/devices[0]/interface[0]",
+			Base: strings.Join([]string{
+				`<interface type="network">`,
+				`  <mac address="52:54:00:00:00:01"></mac>`,
+				`  <source network="default"></source>`,
+				`</interface>`,
+			}, "\n"),
+			Theirs: strings.Join([]string{
+				`<interface type="network">`,
+				`  <mac address="52:54:00:00:00:01"></mac>`,
+				`  <source network="default"></source>`,
+				`  <model type="virtio"></model>`,
+				`</interface>`,
+			}, "\n"),
+		},
+	}
+	if !reflect.DeepEqual(conflicts, expected) {
+		t.Fatalf("Expected conflicts\n%v\ngot\n%v", expected, conflicts)
+	}
+
+	if merged.VCPU.Value != 4 || len(merged.Devices.Interfaces) != 0 ||
+		len(merged.Devices.Disks) != 2 || merged.Devices.Disks[1].Target.Bus != "virtio" {
+		doc, _ := merged.Marshal()
+		t.Fatalf("Expected conflicts resolved as ours, got\n%s", doc)
+	}
+}
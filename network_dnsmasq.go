@@ -0,0 +1,427 @@
+/*
+ * This file is part of the libvirt-go-xml project
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ *
+ * Copyright (C) 2020 Red Hat, Inc.
+ *
+ */
+
+package libvirtxml
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// NetworkDnsmasqConfig holds the files libvirt generates to run
+// dnsmasq for a network. The configuration refers to the hosts
+// files by their path in the directory passed when generating it.
+type NetworkDnsmasqConfig struct {
+	Conf      string
+	HostsFile string
+	AddnHosts string
+}
+
+func (ip *NetworkIP) isIPv6() bool {
+	return ip.Family == "ipv6" || strings.Contains(ip.Address, ":")
+}
+
+// Returns the prefix length of the network, defaulting to the
+// classful prefix for IPv4 and 64 for IPv6
+func (ip *NetworkIP) prefixLength() (int, error) {
+	addr := net.ParseIP(ip.Address)
+	if addr == nil {
+		return 0, fmt.Errorf("invalid IP address '%s'", ip.Address)
+	}
+	if ip.Netmask != "" {
+		mask := net.ParseIP(ip.Netmask)
+		if mask == nil || mask.To4() == nil {
+			return 0, fmt.Errorf("invalid netmask '%s'", ip.Netmask)
+		}
+		ones, bits := net.IPMask(mask.To4()).Size()
+		if bits == 0 {
+			return 0, fmt.Errorf("invalid netmask '%s'", ip.Netmask)
+		}
+		return ones, nil
+	}
+	if ip.Prefix != 0 {
+		return int(ip.Prefix), nil
+	}
+	if ip.isIPv6() {
+		return 64, nil
+	}
+	first := addr.To4()[0]
+	switch {
+	case first < 128:
+		return 8, nil
+	case first < 192:
+		return 16, nil
+	case first < 224:
+		return 24, nil
+	}
+	return 0, fmt.Errorf("no default prefix for IP address '%s'", ip.Address)
+}
+
+// Returns the reverse DNS zone covering the network, for
+// prefixes which fall on a label boundary
+func (ip *NetworkIP) ptrDomain() (string, error) {
+	prefix, err := ip.prefixLength()
+	if err != nil {
+		return "", err
+	}
+	addr := net.ParseIP(ip.Address)
+	labels := []string{}
+	if v4 := addr.To4(); v4 != nil && !ip.isIPv6() {
+		if prefix%8 != 0 {
+			return "", fmt.Errorf("PTR domain for %s network with prefix %d cannot be automatically created", ip.Address, prefix)
+		}
+		for i := prefix/8 - 1; i >= 0; i-- {
+			labels = append(labels, strconv.Itoa(int(v4[i])))
+		}
+		return strings.Join(append(labels, "in-addr", "arpa"), "."), nil
+	}
+	if prefix%4 != 0 {
+		return "", fmt.Errorf("PTR domain for %s network with prefix %d cannot be automatically created", ip.Address, prefix)
+	}
+	v6 := addr.To16()
+	for i := prefix/4 - 1; i >= 0; i-- {
+		nibble := v6[i/2] >> 4
+		if i%2 == 1 {
+			nibble = v6[i/2] & 0xf
+		}
+		labels = append(labels, strconv.FormatUint(uint64(nibble), 16))
+	}
+	return strings.Join(append(labels, "ip6", "arpa"), "."), nil
+}
+
+func dnsmasqLeaseTime(lease *NetworkDHCPLease) string {
+	if lease == nil {
+		return ""
+	}
+	if lease.Expiry == 0 {
+		return "infinite"
+	}
+	unit := lease.Unit
+	if unit == "" {
+		unit = "minutes"
+	}
+	return fmt.Sprintf("%d%c", lease.Expiry, unit[0])
+}
+
+func dnsmasqRangeSize(rng *NetworkDHCPRange) (int, error) {
+	start, end := net.ParseIP(rng.Start), net.ParseIP(rng.End)
+	if start == nil || end == nil {
+		return 0, fmt.Errorf("invalid DHCP range '%s' - '%s'", rng.Start, rng.End)
+	}
+	size := new(big.Int).Sub(new(big.Int).SetBytes(end.To16()), new(big.Int).SetBytes(start.To16()))
+	size.Add(size, big.NewInt(1))
+	if size.Sign() <= 0 || !size.IsInt64() || size.Int64() > 1<<31-1 {
+		return 0, fmt.Errorf("invalid DHCP range '%s' - '%s'", rng.Start, rng.End)
+	}
+	return int(size.Int64()), nil
+}
+
+func dnsmasqDHCPHost(host *NetworkDHCPHost, ipv6 bool) string {
+	var entry string
+	if ipv6 {
+		if host.Name != "" && host.ID != "" {
+			entry = fmt.Sprintf("id:%s,%s,[%s]", host.ID, host.Name, host.IP)
+		} else if host.Name != "" {
+			entry = fmt.Sprintf("%s,[%s]", host.Name, host.IP)
+		} else {
+			entry = fmt.Sprintf("id:%s,[%s]", host.ID, host.IP)
+		}
+	} else if host.Name != "" && host.MAC != "" {
+		entry = fmt.Sprintf("%s,%s,%s", host.MAC, host.IP, host.Name)
+	} else if host.Name != "" {
+		entry = fmt.Sprintf("%s,%s", host.Name, host.IP)
+	} else {
+		entry = fmt.Sprintf("%s,%s", host.MAC, host.IP)
+	}
+	if lease := dnsmasqLeaseTime(host.Lease); lease != "" {
+		entry += "," + lease
+	}
+	return entry
+}
+
+// DnsmasqConfig generates the dnsmasq configuration libvirt would
+// use for the network, along with the contents of the DHCP hosts
+// file and the additional DNS hosts file. These are referred to
+// from the configuration as <name>.hostsfile and <name>.addnhosts
+// in the directory given, which libvirt places in
+// /var/lib/libvirt/dnsmasq.
+func (n *Network) DnsmasqConfig(dir string) (*NetworkDnsmasqConfig, error) {
+	mode := ""
+	if n.Forward != nil {
+		mode = n.Forward.Mode
+	}
+	switch mode {
+	case "", "nat", "route", "open":
+	default:
+		return nil, fmt.Errorf("network with forward mode '%s' does not use dnsmasq", mode)
+	}
+	if n.Bridge == nil || n.Bridge.Name == "" {
+		return nil, fmt.Errorf("network has no bridge name")
+	}
+
+	var conf strings.Builder
+	fmt.Fprintf(&conf, "##WARNING:  THIS IS AN AUTO-GENERATED FILE. CHANGES TO IT ARE LIKELY TO BE\n"+
+		"##OVERWRITTEN AND LOST.  Changes to this configuration should be made using:\n"+
+		"##    virsh net-edit %s\n"+
+		"## or other application using the libvirt API.\n"+
+		"##\n"+
+		"## dnsmasq conf file created by libvirt\n"+
+		"strict-order\n", n.Name)
+
+	dns := n.DNS
+	if dns == nil {
+		dns = &NetworkDNS{}
+	}
+	wantDNS := dns.Enable != "no"
+	if !wantDNS {
+		conf.WriteString("port=0\n")
+	}
+
+	noResolv := false
+	if wantDNS {
+		for _, fwd := range dns.Forwarders {
+			conf.WriteString("server=")
+			if fwd.Domain != "" {
+				fmt.Fprintf(&conf, "/%s/", fwd.Domain)
+			}
+			if fwd.Addr != "" {
+				fmt.Fprintf(&conf, "%s\n", fwd.Addr)
+				if fwd.Domain == "" {
+					noResolv = true
+				}
+			} else {
+				conf.WriteString("#\n")
+			}
+		}
+	}
+
+	if n.Domain != nil && n.Domain.Name != "" {
+		if n.Domain.LocalOnly == "yes" {
+			fmt.Fprintf(&conf, "local=/%s/\n", n.Domain.Name)
+		}
+		fmt.Fprintf(&conf, "domain=%s\nexpand-hosts\n", n.Domain.Name)
+	}
+
+	if wantDNS {
+		for i := range n.IPs {
+			if n.IPs[i].LocalPtr != "yes" {
+				continue
+			}
+			domain, err := n.IPs[i].ptrDomain()
+			if err != nil {
+				return nil, err
+			}
+			fmt.Fprintf(&conf, "local=/%s/\n", domain)
+		}
+		if dns.ForwardPlainNames == "no" {
+			conf.WriteString("domain-needed\nlocal=//\n")
+		}
+	}
+	if noResolv {
+		conf.WriteString("no-resolv\n")
+	}
+
+	fmt.Fprintf(&conf, "except-interface=lo\nbind-dynamic\ninterface=%s\n", n.Bridge.Name)
+
+	if mode == "" {
+		conf.WriteString("dhcp-option=3\nno-resolv\nra-param=*,0,0\n")
+	}
+
+	if wantDNS {
+		for _, txt := range dns.TXTs {
+			fmt.Fprintf(&conf, "txt-record=%s,%s\n", txt.Name, txt.Value)
+		}
+		for _, srv := range dns.SRVs {
+			if srv.Service == "" || srv.Protocol == "" {
+				return nil, fmt.Errorf("DNS SRV record requires a service and a protocol")
+			}
+			fmt.Fprintf(&conf, "srv-host=_%s._%s", srv.Service, srv.Protocol)
+			if srv.Domain != "" {
+				fmt.Fprintf(&conf, ".%s", srv.Domain)
+			}
+			if srv.Target != "" && srv.Target != "." {
+				fmt.Fprintf(&conf, ",%s", srv.Target)
+				if srv.Port != 0 || srv.Priority != 0 || srv.Weight != 0 {
+					port := srv.Port
+					if port == 0 {
+						port = 1
+					}
+					fmt.Fprintf(&conf, ",%d", port)
+				}
+				if srv.Priority != 0 || srv.Weight != 0 {
+					fmt.Fprintf(&conf, ",%d", srv.Priority)
+				}
+				if srv.Weight != 0 {
+					fmt.Fprintf(&conf, ",%d", srv.Weight)
+				}
+			}
+			conf.WriteString("\n")
+		}
+	}
+
+	var ipv4, ipv6 *NetworkIP
+	for i := range n.IPs {
+		ip := &n.IPs[i]
+		hasDHCP := ip.DHCP != nil && (len(ip.DHCP.Ranges) != 0 || len(ip.DHCP.Hosts) != 0)
+		if !ip.isIPv6() {
+			if hasDHCP {
+				if ipv4 != nil {
+					return nil, fmt.Errorf("for IPv4, multiple DHCP definitions cannot be specified")
+				}
+				ipv4 = ip
+			}
+		} else if hasDHCP {
+			if ipv6 != nil {
+				return nil, fmt.Errorf("for IPv6, multiple DHCP definitions cannot be specified")
+			}
+			ipv6 = ip
+		}
+	}
+
+	hosts := []string{}
+	leases := 0
+	for _, ip := range []*NetworkIP{ipv4, ipv6} {
+		if ip == nil {
+			continue
+		}
+		prefix, err := ip.prefixLength()
+		if err != nil {
+			return nil, err
+		}
+		for i := range ip.DHCP.Ranges {
+			rng := &ip.DHCP.Ranges[i]
+			if ip.isIPv6() {
+				fmt.Fprintf(&conf, "dhcp-range=%s,%s,%d", rng.Start, rng.End, prefix)
+			} else {
+				mask := net.IP(net.CIDRMask(prefix, 32))
+				fmt.Fprintf(&conf, "dhcp-range=%s,%s,%s", rng.Start, rng.End, mask)
+			}
+			if lease := dnsmasqLeaseTime(rng.Lease); lease != "" {
+				fmt.Fprintf(&conf, ",%s", lease)
+			}
+			conf.WriteString("\n")
+
+			size, err := dnsmasqRangeSize(rng)
+			if err != nil {
+				return nil, err
+			}
+			leases += size
+		}
+
+		if len(ip.DHCP.Ranges) == 0 {
+			fmt.Fprintf(&conf, "dhcp-range=%s,static", ip.Address)
+			if ip.isIPv6() {
+				fmt.Fprintf(&conf, ",%d", prefix)
+			}
+			conf.WriteString("\n")
+		}
+
+		for i := range ip.DHCP.Hosts {
+			if ip.DHCP.Hosts[i].IP != "" {
+				hosts = append(hosts, dnsmasqDHCPHost(&ip.DHCP.Hosts[i], ip.isIPv6()))
+			}
+		}
+
+		if !ip.isIPv6() {
+			conf.WriteString("dhcp-no-override\ndhcp-authoritative\n")
+			if ip.TFTP != nil && ip.TFTP.Root != "" {
+				fmt.Fprintf(&conf, "enable-tftp\ntftp-root=%s\n", ip.TFTP.Root)
+			}
+			if len(ip.DHCP.Bootp) != 0 && ip.DHCP.Bootp[0].File != "" {
+				bootp := ip.DHCP.Bootp[0]
+				if bootp.Server != "" {
+					fmt.Fprintf(&conf, "dhcp-boot=%s,,%s\n", bootp.File, bootp.Server)
+				} else {
+					fmt.Fprintf(&conf, "dhcp-boot=%s\n", bootp.File)
+				}
+			}
+		}
+	}
+
+	if leases > 0 {
+		fmt.Fprintf(&conf, "dhcp-lease-max=%d\n", leases)
+	}
+
+	if ipv4 != nil || ipv6 != nil {
+		fmt.Fprintf(&conf, "dhcp-hostsfile=%s\n", filepath.Join(dir, n.Name+".hostsfile"))
+	}
+	if wantDNS {
+		fmt.Fprintf(&conf, "addn-hosts=%s\n", filepath.Join(dir, n.Name+".addnhosts"))
+	}
+
+	if n.MTU != nil && n.MTU.Size > 0 {
+		fmt.Fprintf(&conf, "dhcp-option=option:mtu,%d\n", n.MTU.Size)
+	}
+
+	// Router advertisements tell clients to use DHCPv6 if it
+	// is configured, otherwise they advertise the prefix of each
+	// IPv6 address for SLAAC
+	if ipv6 != nil {
+		conf.WriteString("enable-ra\n")
+	} else {
+		for i := range n.IPs {
+			ip := &n.IPs[i]
+			if ip.isIPv6() && (ip.DHCP == nil || (len(ip.DHCP.Ranges) == 0 && len(ip.DHCP.Hosts) == 0)) {
+				fmt.Fprintf(&conf, "dhcp-range=%s,ra-only\n", ip.Address)
+			}
+		}
+	}
+
+	if n.DnsmasqOptions != nil {
+		for _, opt := range n.DnsmasqOptions.Option {
+			fmt.Fprintf(&conf, "%s\n", opt.Value)
+		}
+	}
+
+	// Entries for the same address are combined onto one line
+	addnHosts := []string{}
+	addnIndex := make(map[string]int)
+	for _, host := range dns.Host {
+		idx, ok := addnIndex[host.IP]
+		if !ok {
+			idx = len(addnHosts)
+			addnIndex[host.IP] = idx
+			addnHosts = append(addnHosts, host.IP+"\t")
+		}
+		for _, name := range host.Hostnames {
+			addnHosts[idx] += name.Hostname + "\t"
+		}
+	}
+
+	cfg := &NetworkDnsmasqConfig{
+		Conf: conf.String(),
+	}
+	for _, host := range hosts {
+		cfg.HostsFile += host + "\n"
+	}
+	for _, host := range addnHosts {
+		cfg.AddnHosts += host + "\n"
+	}
+	return cfg, nil
+}
@@ -0,0 +1,282 @@
+/*
+ * This file is part of the libvirt-go-xml project
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ *
+ * Copyright (C) 2020 Red Hat, Inc.
+ *
+ */
+
+package libvirtxml
+
+import (
+	"testing"
+)
+
+const dnsmasqTestHeader = "##WARNING:  THIS IS AN AUTO-GENERATED FILE. CHANGES TO IT ARE LIKELY TO BE\n" +
+	"##OVERWRITTEN AND LOST.  Changes to this configuration should be made using:\n" +
+	"##    virsh net-edit default\n" +
+	"## or other application using the libvirt API.\n" +
+	"##\n" +
+	"## dnsmasq conf file created by libvirt\n" +
+	"strict-order\n"
+
+var dnsmasqTests = []struct {
+	XML       string
+	Conf      string
+	HostsFile string
+	AddnHosts string
+}{
+	{
+		XML: `<network>
+  <name>default</name>
+  <forward mode="nat"/>
+  <bridge name="virbr0"/>
+  <domain name="example.com" localOnly="yes"/>
+  <mtu size="9000"/>
+  <dns>
+    <forwarder domain="example.org" addr="192.168.1.1"/>
+    <forwarder domain="local.lan"/>
+    <txt name="example" value="example value"/>
+    <srv service="name" protocol="tcp" domain="test-domain-name" target="." port="1024" priority="10" weight="10"/>
+    <srv service="ldap" protocol="tcp" target="ldap.example.com" port="389"/>
+    <host ip="192.168.122.2">
+      <hostname>myhost</hostname>
+      <hostname>myhostalias</hostname>
+    </host>
+  </dns>
+  <ip address="192.168.122.1" netmask="255.255.255.0" localPtr="yes">
+    <tftp root="/var/lib/tftp"/>
+    <dhcp>
+      <range start="192.168.122.2" end="192.168.122.254">
+        <lease expiry="1" unit="hours"/>
+      </range>
+      <host mac="00:16:3e:77:e2:ed" name="a.example.com" ip="192.168.122.10"/>
+      <host name="b.example.com" ip="192.168.122.11">
+        <lease expiry="0"/>
+      </host>
+      <bootp file="pxelinux.0" server="192.168.122.1"/>
+    </dhcp>
+  </ip>
+  <ip family="ipv6" address="2001:db8:ac10:fe01::1" prefix="64">
+    <dhcp>
+      <range start="2001:db8:ac10:fe01::1:10" end="2001:db8:ac10:fe01::1:ff"/>
+      <host id="0:4:7e:7d:f0:7d:a8:bc:c5:d2:13:32:11:ed:16:ea:84:63" name="c" ip="2001:db8:ac10:fe01::1:20"/>
+    </dhcp>
+  </ip>
+</network>`,
+		Conf: dnsmasqTestHeader +
+			"server=/example.org/192.168.1.1\n" +
+			"server=/local.lan/#\n" +
+			"local=/example.com/\n" +
+			"domain=example.com\n" +
+			"expand-hosts\n" +
+			"local=/122.168.192.in-addr.arpa/\n" +
+			"except-interface=lo\n" +
+			"bind-dynamic\n" +
+			"interface=virbr0\n" +
+			"txt-record=example,example value\n" +
+			"srv-host=_name._tcp.test-domain-name\n" +
+			"srv-host=_ldap._tcp,ldap.example.com,389\n" +
+			"dhcp-range=192.168.122.2,192.168.122.254,255.255.255.0,1h\n" +
+			"dhcp-no-override\n" +
+			"dhcp-authoritative\n" +
+			"enable-tftp\n" +
+			"tftp-root=/var/lib/tftp\n" +
+			"dhcp-boot=pxelinux.0,,192.168.122.1\n" +
+			"dhcp-range=2001:db8:ac10:fe01::1:10,2001:db8:ac10:fe01::1:ff,64\n" +
+			"dhcp-lease-max=493\n" +
+			"dhcp-hostsfile=/var/lib/libvirt/dnsmasq/default.hostsfile\n" +
+			"addn-hosts=/var/lib/libvirt/dnsmasq/default.addnhosts\n" +
+			"dhcp-option=option:mtu,9000\n" +
+			"enable-ra\n",
+		HostsFile: "00:16:3e:77:e2:ed,192.168.122.10,a.example.com\n" +
+			"b.example.com,192.168.122.11,infinite\n" +
+			"id:0:4:7e:7d:f0:7d:a8:bc:c5:d2:13:32:11:ed:16:ea:84:63,c,[2001:db8:ac10:fe01::1:20]\n",
+		AddnHosts: "192.168.122.2\tmyhost\tmyhostalias\t\n",
+	},
+	{
+		XML: `<network xmlns:dnsmasq="http://libvirt.org/schemas/network/dnsmasq/1.0">
+  <name>default</name>
+  <bridge name="virbr1"/>
+  <dns enable="no"/>
+  <ip address="192.168.152.1" prefix="24">
+    <dhcp>
+      <host mac="00:16:3e:77:e2:ed" ip="192.168.152.10"/>
+    </dhcp>
+  </ip>
+  <ip family="ipv6" address="2001:db8:ac10:fd01::1" prefix="64"/>
+  <dnsmasq:options>
+    <dnsmasq:option value="foo=bar"/>
+  </dnsmasq:options>
+</network>`,
+		Conf: dnsmasqTestHeader +
+			"port=0\n" +
+			"except-interface=lo\n" +
+			"bind-dynamic\n" +
+			"interface=virbr1\n" +
+			"dhcp-option=3\n" +
+			"no-resolv\n" +
+			"ra-param=*,0,0\n" +
+			"dhcp-range=192.168.152.1,static\n" +
+			"dhcp-no-override\n" +
+			"dhcp-authoritative\n" +
+			"dhcp-hostsfile=/var/lib/libvirt/dnsmasq/default.hostsfile\n" +
+			"dhcp-range=2001:db8:ac10:fd01::1,ra-only\n" +
+			"foo=bar\n",
+		HostsFile: "00:16:3e:77:e2:ed,192.168.152.10\n",
+	},
+	{
+		XML: `<network>
+  <name>default</name>
+  <forward mode="route"/>
+  <bridge name="virbr2"/>
+  <dns forwardPlainNames="no">
+    <forwarder addr="8.8.8.8"/>
+  </dns>
+  <ip family="ipv6" address="2001:db8:ac10:fc01::1" prefix="64" localPtr="yes"/>
+</network>`,
+		Conf: dnsmasqTestHeader +
+			"server=8.8.8.8\n" +
+			"local=/1.0.c.f.0.1.c.a.8.b.d.0.1.0.0.2.ip6.arpa/\n" +
+			"domain-needed\n" +
+			"local=//\n" +
+			"no-resolv\n" +
+			"except-interface=lo\n" +
+			"bind-dynamic\n" +
+			"interface=virbr2\n" +
+			"addn-hosts=/var/lib/libvirt/dnsmasq/default.addnhosts\n" +
+			"dhcp-range=2001:db8:ac10:fc01::1,ra-only\n",
+	},
+	{
+		// libvirt networkxml2confdata/nat-network
+		XML: `<network>
+  <name>default</name>
+  <forward dev="eth1" mode="nat"/>
+  <bridge name="virbr0" stp="on" delay="0"/>
+  <ip address="192.168.122.1" netmask="255.255.255.0">
+    <dhcp>
+      <range start="192.168.122.2" end="192.168.122.254"/>
+      <host mac="00:16:3e:77:e2:ed" name="a.example.com" ip="192.168.122.10"/>
+      <host mac="00:16:3e:3e:a9:1a" name="b.example.com" ip="192.168.122.11"/>
+    </dhcp>
+  </ip>
+  <ip family="ipv4" address="192.168.123.1" netmask="255.255.255.0">
+  </ip>
+  <ip family="ipv6" address="2001:db8:ac10:fe01::1" prefix="64">
+  </ip>
+  <ip family="ipv6" address="2001:db8:ac10:fd01::1" prefix="64">
+  </ip>
+  <ip family="ipv4" address="10.24.10.1">
+  </ip>
+</network>`,
+		Conf: dnsmasqTestHeader +
+			"except-interface=lo\n" +
+			"bind-dynamic\n" +
+			"interface=virbr0\n" +
+			"dhcp-range=192.168.122.2,192.168.122.254,255.255.255.0\n" +
+			"dhcp-no-override\n" +
+			"dhcp-authoritative\n" +
+			"dhcp-lease-max=253\n" +
+			"dhcp-hostsfile=/var/lib/libvirt/dnsmasq/default.hostsfile\n" +
+			"addn-hosts=/var/lib/libvirt/dnsmasq/default.addnhosts\n" +
+			"dhcp-range=2001:db8:ac10:fe01::1,ra-only\n" +
+			"dhcp-range=2001:db8:ac10:fd01::1,ra-only\n",
+		HostsFile: "00:16:3e:77:e2:ed,192.168.122.10,a.example.com\n" +
+			"00:16:3e:3e:a9:1a,192.168.122.11,b.example.com\n",
+	},
+	{
+		// libvirt networkxml2confdata/dhcp6-nat-network
+		XML: `<network>
+  <name>default</name>
+  <forward dev="eth1" mode="nat"/>
+  <bridge name="virbr0" stp="on" delay="0"/>
+  <ip address="192.168.122.1" netmask="255.255.255.0">
+    <dhcp>
+      <range start="192.168.122.2" end="192.168.122.254"/>
+    </dhcp>
+  </ip>
+  <ip family="ipv6" address="2001:db8:ac10:fe01::1" prefix="64">
+  </ip>
+  <ip family="ipv6" address="2001:db8:ac10:fd01::1" prefix="64">
+    <dhcp>
+      <range start="2001:db8:ac10:fd01::1:10" end="2001:db8:ac10:fd01::1:ff"/>
+    </dhcp>
+  </ip>
+  <ip family="ipv4" address="10.24.10.1">
+  </ip>
+</network>`,
+		Conf: dnsmasqTestHeader +
+			"except-interface=lo\n" +
+			"bind-dynamic\n" +
+			"interface=virbr0\n" +
+			"dhcp-range=192.168.122.2,192.168.122.254,255.255.255.0\n" +
+			"dhcp-no-override\n" +
+			"dhcp-authoritative\n" +
+			"dhcp-range=2001:db8:ac10:fd01::1:10,2001:db8:ac10:fd01::1:ff,64\n" +
+			"dhcp-lease-max=493\n" +
+			"dhcp-hostsfile=/var/lib/libvirt/dnsmasq/default.hostsfile\n" +
+			"addn-hosts=/var/lib/libvirt/dnsmasq/default.addnhosts\n" +
+			"enable-ra\n",
+	},
+}
+
+func TestNetworkDnsmasqConfig(t *testing.T) {
+	for i, test := range dnsmasqTests {
+		net := &Network{}
+		if err := net.Unmarshal(test.XML); err != nil {
+			t.Fatalf("test %d: %s", i, err)
+		}
+		cfg, err := net.DnsmasqConfig("/var/lib/libvirt/dnsmasq")
+		if err != nil {
+			t.Fatalf("test %d: %s", i, err)
+		}
+		if cfg.Conf != test.Conf {
+			t.Errorf("test %d: expected conf\n%s\ngot\n%s", i, test.Conf, cfg.Conf)
+		}
+		if cfg.HostsFile != test.HostsFile {
+			t.Errorf("test %d: expected hostsfile\n%s\ngot\n%s", i, test.HostsFile, cfg.HostsFile)
+		}
+		if cfg.AddnHosts != test.AddnHosts {
+			t.Errorf("test %d: expected addnhosts\n%q\ngot\n%q", i, test.AddnHosts, cfg.AddnHosts)
+		}
+	}
+}
+
+func TestNetworkDnsmasqConfigErrors(t *testing.T) {
+	tests := []string{
+		`<network><name>br</name><forward mode="bridge"/><bridge name="br0"/></network>`,
+		`<network><name>nobr</name></network>`,
+		`<network><name>ptr</name><bridge name="virbr0"/><ip address="10.0.0.1" prefix="20" localPtr="yes"/></network>`,
+		`<network><name>multi</name><bridge name="virbr0"/>` +
+			`<ip address="10.0.0.1" prefix="24"><dhcp><range start="10.0.0.2" end="10.0.0.9"/></dhcp></ip>` +
+			`<ip address="10.0.1.1" prefix="24"><dhcp><range start="10.0.1.2" end="10.0.1.9"/></dhcp></ip></network>`,
+		`<network><name>range</name><bridge name="virbr0"/>` +
+			`<ip address="10.0.0.1" prefix="24"><dhcp><range start="10.0.0.9" end="10.0.0.2"/></dhcp></ip></network>`,
+	}
+	for _, doc := range tests {
+		net := &Network{}
+		if err := net.Unmarshal(doc); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := net.DnsmasqConfig("/tmp"); err == nil {
+			t.Errorf("expected error for %s", doc)
+		}
+	}
+}
@@ -0,0 +1,370 @@
+/*
+ * This file is part of the libvirt-go-xml project
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ *
+ * Copyright (C) 2020 Red Hat, Inc.
+ *
+ */
+
+package libvirtxml
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// NetworkFirewall holds the host firewall rules for a virtual
+// network. The iptables and ip6tables rules are lists of command
+// arguments, including the command name, which insert rules into
+// the LIBVIRT_* chains. The nftables rules are a script inserting
+// rules into the libvirt_network tables. The chains themselves are
+// created by the rules from NetworkFirewallChains.
+type NetworkFirewall struct {
+	IPTables  [][]string
+	IP6Tables [][]string
+	NFTables  string
+}
+
+// NetworkFirewallChains returns the rules creating the chains shared
+// by all virtual networks, which must exist before the rules of any
+// individual network are added.
+func NetworkFirewallChains() *NetworkFirewall {
+	fw := &NetworkFirewall{}
+	chains := []struct {
+		table, chain, parent string
+	}{
+		{"filter", "LIBVIRT_INP", "INPUT"},
+		{"filter", "LIBVIRT_OUT", "OUTPUT"},
+		{"filter", "LIBVIRT_FWO", "FORWARD"},
+		{"filter", "LIBVIRT_FWI", "FORWARD"},
+		{"filter", "LIBVIRT_FWX", "FORWARD"},
+		{"nat", "LIBVIRT_PRT", "POSTROUTING"},
+		{"mangle", "LIBVIRT_PRT", "POSTROUTING"},
+	}
+	for _, cmd := range []string{"iptables", "ip6tables"} {
+		rules := [][]string{}
+		for _, c := range chains {
+			rules = append(rules,
+				[]string{cmd, "--table", c.table, "--new-chain", c.chain},
+				[]string{cmd, "--table", c.table, "--insert", c.parent, "--jump", c.chain})
+		}
+		if cmd == "iptables" {
+			fw.IPTables = rules
+		} else {
+			fw.IP6Tables = rules
+		}
+	}
+
+	var nft strings.Builder
+	for _, family := range []string{"ip", "ip6"} {
+		fmt.Fprintf(&nft, "table %s libvirt_network {\n", family)
+		nft.WriteString("  chain forward {\n" +
+			"    type filter hook forward priority filter; policy accept;\n" +
+			"    counter jump guest_cross\n" +
+			"    counter jump guest_input\n" +
+			"    counter jump guest_output\n" +
+			"  }\n" +
+			"  chain guest_output {\n  }\n" +
+			"  chain guest_input {\n  }\n" +
+			"  chain guest_cross {\n  }\n" +
+			"  chain guest_nat {\n" +
+			"    type nat hook postrouting priority srcnat; policy accept;\n" +
+			"  }\n" +
+			"}\n")
+	}
+	fw.NFTables = nft.String()
+	return fw
+}
+
+type networkFirewallBuilder struct {
+	bridge string
+	dev    string
+	fw     NetworkFirewall
+	nft    strings.Builder
+}
+
+func (b *networkFirewallBuilder) iptables(ipv6 bool, table, chain string, args ...string) {
+	if ipv6 {
+		b.fw.IP6Tables = append(b.fw.IP6Tables, append([]string{"ip6tables", "--table", table, "--insert", chain}, args...))
+	} else {
+		b.fw.IPTables = append(b.fw.IPTables, append([]string{"iptables", "--table", table, "--insert", chain}, args...))
+	}
+}
+
+func (b *networkFirewallBuilder) nftables(ipv6 bool, chain string, exprs ...string) {
+	family := "ip"
+	if ipv6 {
+		family = "ip6"
+	}
+	fmt.Fprintf(&b.nft, "insert rule %s libvirt_network %s %s\n", family, chain, strings.Join(exprs, " "))
+}
+
+func (b *networkFirewallBuilder) port(ipv6 bool, chain, dir, proto string, port int) {
+	iface := "--in-interface"
+	if dir == "out" {
+		iface = "--out-interface"
+	}
+	b.iptables(ipv6, "filter", chain, iface, b.bridge, "--protocol", proto,
+		"--destination-port", fmt.Sprint(port), "--jump", "ACCEPT")
+}
+
+// Rejects traffic to and from the network which no other rule
+// accepts, and allows traffic between guests on the network
+func (b *networkFirewallBuilder) general(ipv6 bool) {
+	b.iptables(ipv6, "filter", "LIBVIRT_FWO", "--in-interface", b.bridge, "--jump", "REJECT")
+	b.iptables(ipv6, "filter", "LIBVIRT_FWI", "--out-interface", b.bridge, "--jump", "REJECT")
+	b.iptables(ipv6, "filter", "LIBVIRT_FWX", "--in-interface", b.bridge, "--out-interface", b.bridge, "--jump", "ACCEPT")
+
+	b.nftables(ipv6, "guest_output", "iifname", b.bridge, "counter", "reject")
+	b.nftables(ipv6, "guest_input", "oifname", b.bridge, "counter", "reject")
+	b.nftables(ipv6, "guest_cross", "iifname", b.bridge, "oifname", b.bridge, "counter", "accept")
+}
+
+func (b *networkFirewallBuilder) forward(ipv6 bool, network string, established bool) {
+	args := []string{"--source", network, "--in-interface", b.bridge}
+	nft := []string{nftAddrFamily(ipv6), "saddr", network, "iifname", b.bridge}
+	if b.dev != "" {
+		args = append(args, "--out-interface", b.dev)
+		nft = append(nft, "oifname", b.dev)
+	}
+	b.iptables(ipv6, "filter", "LIBVIRT_FWO", append(args, "--jump", "ACCEPT")...)
+	b.nftables(ipv6, "guest_output", append(nft, "counter", "accept")...)
+
+	args = []string{"--destination", network}
+	nft = []string{}
+	if b.dev != "" {
+		args = append(args, "--in-interface", b.dev)
+		nft = append(nft, "iifname", b.dev)
+	}
+	args = append(args, "--out-interface", b.bridge)
+	nft = append(nft, "oifname", b.bridge, nftAddrFamily(ipv6), "daddr", network)
+	if established {
+		args = append(args, "--match", "conntrack", "--ctstate", "ESTABLISHED,RELATED")
+		nft = append(nft, "ct", "state", "related,established")
+	}
+	b.iptables(ipv6, "filter", "LIBVIRT_FWI", append(args, "--jump", "ACCEPT")...)
+	b.nftables(ipv6, "guest_input", append(nft, "counter", "accept")...)
+}
+
+func nftAddrFamily(ipv6 bool) string {
+	if ipv6 {
+		return "ip6"
+	}
+	return "ip"
+}
+
+func (b *networkFirewallBuilder) masquerade(ipv6 bool, network string, nat *NetworkForwardNAT) error {
+	b.forward(ipv6, network, true)
+
+	addr := ""
+	portStart, portEnd := uint(1024), uint(65535)
+	if nat != nil {
+		if len(nat.Addresses) > 1 || len(nat.Ports) > 1 {
+			return fmt.Errorf("only a single NAT address and port range is supported")
+		}
+		if len(nat.Addresses) == 1 && !ipv6 {
+			addrStart, addrEnd := nat.Addresses[0].Start, nat.Addresses[0].End
+			if net.ParseIP(addrStart) == nil || (addrEnd != "" && net.ParseIP(addrEnd) == nil) {
+				return fmt.Errorf("invalid NAT address range '%s' - '%s'", addrStart, addrEnd)
+			}
+			addr = addrStart
+			if addrEnd != "" && addrEnd != addrStart {
+				addr += "-" + addrEnd
+			}
+		}
+		if len(nat.Ports) == 1 {
+			portStart, portEnd = nat.Ports[0].Start, nat.Ports[0].End
+			if portStart == 0 || portEnd < portStart || portEnd > 65535 {
+				return fmt.Errorf("invalid NAT port range %d - %d", portStart, portEnd)
+			}
+		}
+	}
+
+	for _, proto := range []string{"", "udp", "tcp"} {
+		args := []string{"--source", network}
+		nft := []string{}
+		if proto != "" {
+			args = append(args, "-p", proto)
+			nft = append(nft, "meta", "l4proto", proto)
+		}
+		args = append(args, "!", "--destination", network)
+		nft = append(nft, nftAddrFamily(ipv6), "saddr", network, nftAddrFamily(ipv6), "daddr", "!=", network)
+		if b.dev != "" {
+			args = append(args, "--out-interface", b.dev)
+			nft = append(nft, "oifname", b.dev)
+		}
+		nft = append(nft, "counter")
+
+		ports := ""
+		if proto != "" {
+			ports = fmt.Sprintf("%d-%d", portStart, portEnd)
+		}
+		if addr != "" {
+			to := addr
+			if ports != "" {
+				to += ":" + ports
+			}
+			args = append(args, "--jump", "SNAT", "--to-source", to)
+			nft = append(nft, "snat", "to", to)
+		} else {
+			args = append(args, "--jump", "MASQUERADE")
+			nft = append(nft, "masquerade")
+			if ports != "" {
+				args = append(args, "--to-ports", ports)
+				nft = append(nft, "to", ":"+ports)
+			}
+		}
+		b.iptables(ipv6, "nat", "LIBVIRT_PRT", args...)
+		b.nftables(ipv6, "guest_nat", nft...)
+	}
+
+	// Broadcast and multicast traffic must not be translated
+	dests := []string{"255.255.255.255/32", "224.0.0.0/24"}
+	if ipv6 {
+		dests = []string{"ff02::/16"}
+	}
+	for _, dest := range dests {
+		args := []string{"--source", network, "--destination", dest}
+		nft := []string{nftAddrFamily(ipv6), "saddr", network, nftAddrFamily(ipv6), "daddr", dest}
+		if b.dev != "" {
+			args = append(args, "--out-interface", b.dev)
+			nft = append(nft, "oifname", b.dev)
+		}
+		b.iptables(ipv6, "nat", "LIBVIRT_PRT", append(args, "--jump", "RETURN")...)
+		b.nftables(ipv6, "guest_nat", append(nft, "counter", "return")...)
+	}
+	return nil
+}
+
+func (ip *NetworkIP) networkCIDR() (string, error) {
+	prefix, err := ip.prefixLength()
+	if err != nil {
+		return "", err
+	}
+	addr := net.ParseIP(ip.Address)
+	bits := 32
+	if ip.isIPv6() {
+		bits = 128
+	} else {
+		addr = addr.To4()
+	}
+	if addr == nil || prefix > bits {
+		return "", fmt.Errorf("invalid IP address '%s'", ip.Address)
+	}
+	return fmt.Sprintf("%s/%d", addr.Mask(net.CIDRMask(prefix, bits)), prefix), nil
+}
+
+// FirewallRules generates the host firewall rules libvirt installs
+// for a network with no forwarding or forwarding in nat or route
+// mode. Networks in open mode get no rules, and other modes are
+// not supported.
+func (n *Network) FirewallRules() (*NetworkFirewall, error) {
+	mode := ""
+	dev := ""
+	var nat *NetworkForwardNAT
+	if n.Forward != nil {
+		mode, dev, nat = n.Forward.Mode, n.Forward.Dev, n.Forward.NAT
+	}
+	switch mode {
+	case "open":
+		return &NetworkFirewall{}, nil
+	case "", "nat", "route":
+	default:
+		return nil, fmt.Errorf("network with forward mode '%s' has no firewall rules", mode)
+	}
+	if n.Bridge == nil || n.Bridge.Name == "" {
+		return nil, fmt.Errorf("network has no bridge name")
+	}
+	if mode == "" {
+		dev = ""
+	}
+
+	b := &networkFirewallBuilder{bridge: n.Bridge.Name, dev: dev}
+	haveIPv6 := false
+	dhcpv4 := false
+	tftp := false
+	for i := range n.IPs {
+		ip := &n.IPs[i]
+		if ip.isIPv6() {
+			haveIPv6 = true
+			continue
+		}
+		if ip.DHCP != nil && (len(ip.DHCP.Ranges) != 0 || len(ip.DHCP.Hosts) != 0) {
+			dhcpv4 = true
+		}
+		if ip.TFTP != nil && ip.TFTP.Root != "" {
+			tftp = true
+		}
+	}
+
+	b.port(false, "LIBVIRT_INP", "in", "tcp", 67)
+	b.port(false, "LIBVIRT_INP", "in", "udp", 67)
+	b.port(false, "LIBVIRT_OUT", "out", "tcp", 68)
+	b.port(false, "LIBVIRT_OUT", "out", "udp", 68)
+	// Like libvirt, DNS is let through even with the DNS server off
+	b.port(false, "LIBVIRT_INP", "in", "tcp", 53)
+	b.port(false, "LIBVIRT_INP", "in", "udp", 53)
+	b.port(false, "LIBVIRT_OUT", "out", "tcp", 53)
+	b.port(false, "LIBVIRT_OUT", "out", "udp", 53)
+	if tftp {
+		b.port(false, "LIBVIRT_INP", "in", "udp", 69)
+		b.port(false, "LIBVIRT_OUT", "out", "udp", 69)
+	}
+	b.general(false)
+
+	if haveIPv6 || n.IPv6 == "yes" {
+		b.general(true)
+		if haveIPv6 {
+			b.port(true, "LIBVIRT_INP", "in", "tcp", 53)
+			b.port(true, "LIBVIRT_INP", "in", "udp", 53)
+			b.port(true, "LIBVIRT_OUT", "out", "tcp", 53)
+			b.port(true, "LIBVIRT_OUT", "out", "udp", 53)
+			b.port(true, "LIBVIRT_INP", "in", "udp", 547)
+			b.port(true, "LIBVIRT_OUT", "out", "udp", 546)
+		}
+	}
+
+	if mode != "" {
+		for i := range n.IPs {
+			ip := &n.IPs[i]
+			network, err := ip.networkCIDR()
+			if err != nil {
+				return nil, err
+			}
+			ipv6 := ip.isIPv6()
+			if mode == "nat" && (!ipv6 || (nat != nil && nat.IPv6 == "yes")) {
+				if err := b.masquerade(ipv6, network, nat); err != nil {
+					return nil, err
+				}
+			} else {
+				b.forward(ipv6, network, false)
+			}
+		}
+	}
+
+	if dhcpv4 {
+		b.iptables(false, "mangle", "LIBVIRT_PRT", "--out-interface", b.bridge,
+			"--protocol", "udp", "--destination-port", "68", "--jump", "CHECKSUM", "--checksum-fill")
+	}
+
+	b.fw.NFTables = b.nft.String()
+	return &b.fw, nil
+}
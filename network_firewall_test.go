@@ -0,0 +1,259 @@
+/*
+ * This file is part of the libvirt-go-xml project
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ *
+ * Copyright (C) 2020 Red Hat, Inc.
+ *
+ */
+
+package libvirtxml
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func networkFirewallArgs(rules [][]string) []string {
+	lines := []string{}
+	for _, rule := range rules {
+		lines = append(lines, strings.Join(rule, " "))
+	}
+	return lines
+}
+
+var networkFirewallTests = []struct {
+	XML       string
+	IPTables  []string
+	IP6Tables []string
+	NFTables  string
+}{
+	{
+		XML: `<network>
+  <name>default</name>
+  <forward mode="nat"/>
+  <bridge name="virbr0"/>
+  <ip address="192.168.122.1" netmask="255.255.255.0">
+    <dhcp>
+      <range start="192.168.122.2" end="192.168.122.254"/>
+    </dhcp>
+  </ip>
+</network>`,
+		IPTables: []string{
+			"iptables --table filter --insert LIBVIRT_INP --in-interface virbr0 --protocol tcp --destination-port 67 --jump ACCEPT",
+			"iptables --table filter --insert LIBVIRT_INP --in-interface virbr0 --protocol udp --destination-port 67 --jump ACCEPT",
+			"iptables --table filter --insert LIBVIRT_OUT --out-interface virbr0 --protocol tcp --destination-port 68 --jump ACCEPT",
+			"iptables --table filter --insert LIBVIRT_OUT --out-interface virbr0 --protocol udp --destination-port 68 --jump ACCEPT",
+			"iptables --table filter --insert LIBVIRT_INP --in-interface virbr0 --protocol tcp --destination-port 53 --jump ACCEPT",
+			"iptables --table filter --insert LIBVIRT_INP --in-interface virbr0 --protocol udp --destination-port 53 --jump ACCEPT",
+			"iptables --table filter --insert LIBVIRT_OUT --out-interface virbr0 --protocol tcp --destination-port 53 --jump ACCEPT",
+			"iptables --table filter --insert LIBVIRT_OUT --out-interface virbr0 --protocol udp --destination-port 53 --jump ACCEPT",
+			"iptables --table filter --insert LIBVIRT_FWO --in-interface virbr0 --jump REJECT",
+			"iptables --table filter --insert LIBVIRT_FWI --out-interface virbr0 --jump REJECT",
+			"iptables --table filter --insert LIBVIRT_FWX --in-interface virbr0 --out-interface virbr0 --jump ACCEPT",
+			"iptables --table filter --insert LIBVIRT_FWO --source 192.168.122.0/24 --in-interface virbr0 --jump ACCEPT",
+			"iptables --table filter --insert LIBVIRT_FWI --destination 192.168.122.0/24 --out-interface virbr0 --match conntrack --ctstate ESTABLISHED,RELATED --jump ACCEPT",
+			"iptables --table nat --insert LIBVIRT_PRT --source 192.168.122.0/24 ! --destination 192.168.122.0/24 --jump MASQUERADE",
+			"iptables --table nat --insert LIBVIRT_PRT --source 192.168.122.0/24 -p udp ! --destination 192.168.122.0/24 --jump MASQUERADE --to-ports 1024-65535",
+			"iptables --table nat --insert LIBVIRT_PRT --source 192.168.122.0/24 -p tcp ! --destination 192.168.122.0/24 --jump MASQUERADE --to-ports 1024-65535",
+			"iptables --table nat --insert LIBVIRT_PRT --source 192.168.122.0/24 --destination 255.255.255.255/32 --jump RETURN",
+			"iptables --table nat --insert LIBVIRT_PRT --source 192.168.122.0/24 --destination 224.0.0.0/24 --jump RETURN",
+			"iptables --table mangle --insert LIBVIRT_PRT --out-interface virbr0 --protocol udp --destination-port 68 --jump CHECKSUM --checksum-fill",
+		},
+		NFTables: "insert rule ip libvirt_network guest_output iifname virbr0 counter reject\n" +
+			"insert rule ip libvirt_network guest_input oifname virbr0 counter reject\n" +
+			"insert rule ip libvirt_network guest_cross iifname virbr0 oifname virbr0 counter accept\n" +
+			"insert rule ip libvirt_network guest_output ip saddr 192.168.122.0/24 iifname virbr0 counter accept\n" +
+			"insert rule ip libvirt_network guest_input oifname virbr0 ip daddr 192.168.122.0/24 ct state related,established counter accept\n" +
+			"insert rule ip libvirt_network guest_nat ip saddr 192.168.122.0/24 ip daddr != 192.168.122.0/24 counter masquerade\n" +
+			"insert rule ip libvirt_network guest_nat meta l4proto udp ip saddr 192.168.122.0/24 ip daddr != 192.168.122.0/24 counter masquerade to :1024-65535\n" +
+			"insert rule ip libvirt_network guest_nat meta l4proto tcp ip saddr 192.168.122.0/24 ip daddr != 192.168.122.0/24 counter masquerade to :1024-65535\n" +
+			"insert rule ip libvirt_network guest_nat ip saddr 192.168.122.0/24 ip daddr 255.255.255.255/32 counter return\n" +
+			"insert rule ip libvirt_network guest_nat ip saddr 192.168.122.0/24 ip daddr 224.0.0.0/24 counter return\n",
+	},
+	{
+		XML: `<network>
+  <name>snat</name>
+  <forward mode="nat" dev="eth0">
+    <nat>
+      <address start="10.0.0.1" end="10.0.0.5"/>
+      <port start="2000" end="3000"/>
+    </nat>
+  </forward>
+  <bridge name="virbr1"/>
+  <dns enable="no"/>
+  <ip address="192.168.128.1" prefix="24"/>
+  <ip family="ipv6" address="2001:db8:ca2:2::1" prefix="64"/>
+</network>`,
+		IPTables: []string{
+			"iptables --table filter --insert LIBVIRT_INP --in-interface virbr1 --protocol tcp --destination-port 67 --jump ACCEPT",
+			"iptables --table filter --insert LIBVIRT_INP --in-interface virbr1 --protocol udp --destination-port 67 --jump ACCEPT",
+			"iptables --table filter --insert LIBVIRT_OUT --out-interface virbr1 --protocol tcp --destination-port 68 --jump ACCEPT",
+			"iptables --table filter --insert LIBVIRT_OUT --out-interface virbr1 --protocol udp --destination-port 68 --jump ACCEPT",
+			"iptables --table filter --insert LIBVIRT_INP --in-interface virbr1 --protocol tcp --destination-port 53 --jump ACCEPT",
+			"iptables --table filter --insert LIBVIRT_INP --in-interface virbr1 --protocol udp --destination-port 53 --jump ACCEPT",
+			"iptables --table filter --insert LIBVIRT_OUT --out-interface virbr1 --protocol tcp --destination-port 53 --jump ACCEPT",
+			"iptables --table filter --insert LIBVIRT_OUT --out-interface virbr1 --protocol udp --destination-port 53 --jump ACCEPT",
+			"iptables --table filter --insert LIBVIRT_FWO --in-interface virbr1 --jump REJECT",
+			"iptables --table filter --insert LIBVIRT_FWI --out-interface virbr1 --jump REJECT",
+			"iptables --table filter --insert LIBVIRT_FWX --in-interface virbr1 --out-interface virbr1 --jump ACCEPT",
+			"iptables --table filter --insert LIBVIRT_FWO --source 192.168.128.0/24 --in-interface virbr1 --out-interface eth0 --jump ACCEPT",
+			"iptables --table filter --insert LIBVIRT_FWI --destination 192.168.128.0/24 --in-interface eth0 --out-interface virbr1 --match conntrack --ctstate ESTABLISHED,RELATED --jump ACCEPT",
+			"iptables --table nat --insert LIBVIRT_PRT --source 192.168.128.0/24 ! --destination 192.168.128.0/24 --out-interface eth0 --jump SNAT --to-source 10.0.0.1-10.0.0.5",
+			"iptables --table nat --insert LIBVIRT_PRT --source 192.168.128.0/24 -p udp ! --destination 192.168.128.0/24 --out-interface eth0 --jump SNAT --to-source 10.0.0.1-10.0.0.5:2000-3000",
+			"iptables --table nat --insert LIBVIRT_PRT --source 192.168.128.0/24 -p tcp ! --destination 192.168.128.0/24 --out-interface eth0 --jump SNAT --to-source 10.0.0.1-10.0.0.5:2000-3000",
+			"iptables --table nat --insert LIBVIRT_PRT --source 192.168.128.0/24 --destination 255.255.255.255/32 --out-interface eth0 --jump RETURN",
+			"iptables --table nat --insert LIBVIRT_PRT --source 192.168.128.0/24 --destination 224.0.0.0/24 --out-interface eth0 --jump RETURN",
+		},
+		IP6Tables: []string{
+			"ip6tables --table filter --insert LIBVIRT_FWO --in-interface virbr1 --jump REJECT",
+			"ip6tables --table filter --insert LIBVIRT_FWI --out-interface virbr1 --jump REJECT",
+			"ip6tables --table filter --insert LIBVIRT_FWX --in-interface virbr1 --out-interface virbr1 --jump ACCEPT",
+			"ip6tables --table filter --insert LIBVIRT_INP --in-interface virbr1 --protocol tcp --destination-port 53 --jump ACCEPT",
+			"ip6tables --table filter --insert LIBVIRT_INP --in-interface virbr1 --protocol udp --destination-port 53 --jump ACCEPT",
+			"ip6tables --table filter --insert LIBVIRT_OUT --out-interface virbr1 --protocol tcp --destination-port 53 --jump ACCEPT",
+			"ip6tables --table filter --insert LIBVIRT_OUT --out-interface virbr1 --protocol udp --destination-port 53 --jump ACCEPT",
+			"ip6tables --table filter --insert LIBVIRT_INP --in-interface virbr1 --protocol udp --destination-port 547 --jump ACCEPT",
+			"ip6tables --table filter --insert LIBVIRT_OUT --out-interface virbr1 --protocol udp --destination-port 546 --jump ACCEPT",
+			"ip6tables --table filter --insert LIBVIRT_FWO --source 2001:db8:ca2:2::/64 --in-interface virbr1 --out-interface eth0 --jump ACCEPT",
+			"ip6tables --table filter --insert LIBVIRT_FWI --destination 2001:db8:ca2:2::/64 --in-interface eth0 --out-interface virbr1 --jump ACCEPT",
+		},
+		NFTables: "insert rule ip libvirt_network guest_output iifname virbr1 counter reject\n" +
+			"insert rule ip libvirt_network guest_input oifname virbr1 counter reject\n" +
+			"insert rule ip libvirt_network guest_cross iifname virbr1 oifname virbr1 counter accept\n" +
+			"insert rule ip6 libvirt_network guest_output iifname virbr1 counter reject\n" +
+			"insert rule ip6 libvirt_network guest_input oifname virbr1 counter reject\n" +
+			"insert rule ip6 libvirt_network guest_cross iifname virbr1 oifname virbr1 counter accept\n" +
+			"insert rule ip libvirt_network guest_output ip saddr 192.168.128.0/24 iifname virbr1 oifname eth0 counter accept\n" +
+			"insert rule ip libvirt_network guest_input iifname eth0 oifname virbr1 ip daddr 192.168.128.0/24 ct state related,established counter accept\n" +
+			"insert rule ip libvirt_network guest_nat ip saddr 192.168.128.0/24 ip daddr != 192.168.128.0/24 oifname eth0 counter snat to 10.0.0.1-10.0.0.5\n" +
+			"insert rule ip libvirt_network guest_nat meta l4proto udp ip saddr 192.168.128.0/24 ip daddr != 192.168.128.0/24 oifname eth0 counter snat to 10.0.0.1-10.0.0.5:2000-3000\n" +
+			"insert rule ip libvirt_network guest_nat meta l4proto tcp ip saddr 192.168.128.0/24 ip daddr != 192.168.128.0/24 oifname eth0 counter snat to 10.0.0.1-10.0.0.5:2000-3000\n" +
+			"insert rule ip libvirt_network guest_nat ip saddr 192.168.128.0/24 ip daddr 255.255.255.255/32 oifname eth0 counter return\n" +
+			"insert rule ip libvirt_network guest_nat ip saddr 192.168.128.0/24 ip daddr 224.0.0.0/24 oifname eth0 counter return\n" +
+			"insert rule ip6 libvirt_network guest_output ip6 saddr 2001:db8:ca2:2::/64 iifname virbr1 oifname eth0 counter accept\n" +
+			"insert rule ip6 libvirt_network guest_input iifname eth0 oifname virbr1 ip6 daddr 2001:db8:ca2:2::/64 counter accept\n",
+	},
+	{
+		XML: `<network>
+  <name>routed</name>
+  <forward mode="route"/>
+  <bridge name="virbr2"/>
+  <dns enable="no"/>
+  <ip address="10.20.0.1" prefix="16">
+    <tftp root="/srv/tftp"/>
+  </ip>
+</network>`,
+		IPTables: []string{
+			"iptables --table filter --insert LIBVIRT_INP --in-interface virbr2 --protocol tcp --destination-port 67 --jump ACCEPT",
+			"iptables --table filter --insert LIBVIRT_INP --in-interface virbr2 --protocol udp --destination-port 67 --jump ACCEPT",
+			"iptables --table filter --insert LIBVIRT_OUT --out-interface virbr2 --protocol tcp --destination-port 68 --jump ACCEPT",
+			"iptables --table filter --insert LIBVIRT_OUT --out-interface virbr2 --protocol udp --destination-port 68 --jump ACCEPT",
+			"iptables --table filter --insert LIBVIRT_INP --in-interface virbr2 --protocol tcp --destination-port 53 --jump ACCEPT",
+			"iptables --table filter --insert LIBVIRT_INP --in-interface virbr2 --protocol udp --destination-port 53 --jump ACCEPT",
+			"iptables --table filter --insert LIBVIRT_OUT --out-interface virbr2 --protocol tcp --destination-port 53 --jump ACCEPT",
+			"iptables --table filter --insert LIBVIRT_OUT --out-interface virbr2 --protocol udp --destination-port 53 --jump ACCEPT",
+			"iptables --table filter --insert LIBVIRT_INP --in-interface virbr2 --protocol udp --destination-port 69 --jump ACCEPT",
+			"iptables --table filter --insert LIBVIRT_OUT --out-interface virbr2 --protocol udp --destination-port 69 --jump ACCEPT",
+			"iptables --table filter --insert LIBVIRT_FWO --in-interface virbr2 --jump REJECT",
+			"iptables --table filter --insert LIBVIRT_FWI --out-interface virbr2 --jump REJECT",
+			"iptables --table filter --insert LIBVIRT_FWX --in-interface virbr2 --out-interface virbr2 --jump ACCEPT",
+			"iptables --table filter --insert LIBVIRT_FWO --source 10.20.0.0/16 --in-interface virbr2 --jump ACCEPT",
+			"iptables --table filter --insert LIBVIRT_FWI --destination 10.20.0.0/16 --out-interface virbr2 --jump ACCEPT",
+		},
+		NFTables: "insert rule ip libvirt_network guest_output iifname virbr2 counter reject\n" +
+			"insert rule ip libvirt_network guest_input oifname virbr2 counter reject\n" +
+			"insert rule ip libvirt_network guest_cross iifname virbr2 oifname virbr2 counter accept\n" +
+			"insert rule ip libvirt_network guest_output ip saddr 10.20.0.0/16 iifname virbr2 counter accept\n" +
+			"insert rule ip libvirt_network guest_input oifname virbr2 ip daddr 10.20.0.0/16 counter accept\n",
+	},
+	{
+		XML: `<network>
+  <name>open</name>
+  <forward mode="open"/>
+  <bridge name="virbr3"/>
+  <ip address="10.30.0.1" prefix="24"/>
+</network>`,
+	},
+}
+
+func TestNetworkFirewallRules(t *testing.T) {
+	for i, test := range networkFirewallTests {
+		net := &Network{}
+		if err := net.Unmarshal(test.XML); err != nil {
+			t.Fatalf("test %d: %s", i, err)
+		}
+		fw, err := net.FirewallRules()
+		if err != nil {
+			t.Fatalf("test %d: %s", i, err)
+		}
+		ipt := networkFirewallArgs(fw.IPTables)
+		if len(ipt) != 0 || len(test.IPTables) != 0 {
+			if !reflect.DeepEqual(ipt, test.IPTables) {
+				t.Errorf("test %d: expected iptables\n%s\ngot\n%s", i,
+					strings.Join(test.IPTables, "\n"), strings.Join(ipt, "\n"))
+			}
+		}
+		ip6t := networkFirewallArgs(fw.IP6Tables)
+		if len(ip6t) != 0 || len(test.IP6Tables) != 0 {
+			if !reflect.DeepEqual(ip6t, test.IP6Tables) {
+				t.Errorf("test %d: expected ip6tables\n%s\ngot\n%s", i,
+					strings.Join(test.IP6Tables, "\n"), strings.Join(ip6t, "\n"))
+			}
+		}
+		if fw.NFTables != test.NFTables {
+			t.Errorf("test %d: expected nftables\n%s\ngot\n%s", i, test.NFTables, fw.NFTables)
+		}
+	}
+}
+
+func TestNetworkFirewallRulesErrors(t *testing.T) {
+	tests := []string{
+		`<network><name>br</name><forward mode="bridge"/><bridge name="br0"/></network>`,
+		`<network><name>nobr</name><forward mode="nat"/></network>`,
+		`<network><name>addr</name><forward mode="nat"/><bridge name="virbr0"/><ip address="bogus" prefix="24"/></network>`,
+		`<network><name>port</name><forward mode="nat"><nat><port start="3000" end="2000"/></nat></forward>` +
+			`<bridge name="virbr0"/><ip address="10.0.0.1" prefix="24"/></network>`,
+	}
+	for _, doc := range tests {
+		net := &Network{}
+		if err := net.Unmarshal(doc); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := net.FirewallRules(); err == nil {
+			t.Errorf("expected error for %s", doc)
+		}
+	}
+}
+
+func TestNetworkFirewallChains(t *testing.T) {
+	fw := NetworkFirewallChains()
+	if len(fw.IPTables) != 14 || len(fw.IP6Tables) != 14 {
+		t.Fatalf("unexpected chain rules %v %v", fw.IPTables, fw.IP6Tables)
+	}
+	expect := "iptables --table nat --insert POSTROUTING --jump LIBVIRT_PRT"
+	if got := strings.Join(fw.IPTables[11], " "); got != expect {
+		t.Errorf("expected %s got %s", expect, got)
+	}
+	if !strings.Contains(fw.NFTables, "table ip6 libvirt_network {") ||
+		!strings.Contains(fw.NFTables, "type nat hook postrouting priority srcnat;") {
+		t.Errorf("unexpected nftables chains\n%s", fw.NFTables)
+	}
+}
@@ -0,0 +1,449 @@
+/*
+ * This file is part of the libvirt-go-xml project
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ *
+ * Copyright (C) 2020 Red Hat, Inc.
+ *
+ */
+
+package libvirtxml
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// NetworkUpdateCommand matches the virNetworkUpdateCommand
+// values accepted by virNetworkUpdate
+type NetworkUpdateCommand int
+
+const (
+	NetworkUpdateCommandModify   NetworkUpdateCommand = 1
+	NetworkUpdateCommandDelete   NetworkUpdateCommand = 2
+	NetworkUpdateCommandAddLast  NetworkUpdateCommand = 3
+	NetworkUpdateCommandAddFirst NetworkUpdateCommand = 4
+)
+
+var networkUpdateCommandNames = map[NetworkUpdateCommand]string{
+	NetworkUpdateCommandModify:   "modify",
+	NetworkUpdateCommandDelete:   "delete",
+	NetworkUpdateCommandAddLast:  "add-last",
+	NetworkUpdateCommandAddFirst: "add-first",
+}
+
+func (c NetworkUpdateCommand) String() string {
+	if name, ok := networkUpdateCommandNames[c]; ok {
+		return name
+	}
+	return strconv.Itoa(int(c))
+}
+
+// NetworkUpdateSection matches the virNetworkUpdateSection
+// values accepted by virNetworkUpdate
+type NetworkUpdateSection int
+
+const (
+	NetworkUpdateSectionBridge           NetworkUpdateSection = 1
+	NetworkUpdateSectionDomain           NetworkUpdateSection = 2
+	NetworkUpdateSectionIP               NetworkUpdateSection = 3
+	NetworkUpdateSectionIPDHCPHost       NetworkUpdateSection = 4
+	NetworkUpdateSectionIPDHCPRange      NetworkUpdateSection = 5
+	NetworkUpdateSectionForward          NetworkUpdateSection = 6
+	NetworkUpdateSectionForwardInterface NetworkUpdateSection = 7
+	NetworkUpdateSectionForwardPF        NetworkUpdateSection = 8
+	NetworkUpdateSectionPortGroup        NetworkUpdateSection = 9
+	NetworkUpdateSectionDNSHost          NetworkUpdateSection = 10
+	NetworkUpdateSectionDNSTXT           NetworkUpdateSection = 11
+	NetworkUpdateSectionDNSSRV           NetworkUpdateSection = 12
+)
+
+var networkUpdateSectionNames = map[NetworkUpdateSection]string{
+	NetworkUpdateSectionBridge:           "bridge",
+	NetworkUpdateSectionDomain:           "domain",
+	NetworkUpdateSectionIP:               "ip",
+	NetworkUpdateSectionIPDHCPHost:       "ip-dhcp-host",
+	NetworkUpdateSectionIPDHCPRange:      "ip-dhcp-range",
+	NetworkUpdateSectionForward:          "forward",
+	NetworkUpdateSectionForwardInterface: "forward-interface",
+	NetworkUpdateSectionForwardPF:        "forward-pf",
+	NetworkUpdateSectionPortGroup:        "portgroup",
+	NetworkUpdateSectionDNSHost:          "dns-host",
+	NetworkUpdateSectionDNSTXT:           "dns-txt",
+	NetworkUpdateSectionDNSSRV:           "dns-srv",
+}
+
+func (s NetworkUpdateSection) String() string {
+	if name, ok := networkUpdateSectionNames[s]; ok {
+		return name
+	}
+	return strconv.Itoa(int(s))
+}
+
+// NetworkUpdate holds the arguments for one call to virNetworkUpdate.
+// ParentIndex is the index of the <ip> element for the DHCP sections
+// and -1 otherwise.
+type NetworkUpdate struct {
+	Command     NetworkUpdateCommand
+	Section     NetworkUpdateSection
+	ParentIndex int
+	XML         string
+}
+
+func (u NetworkUpdate) String() string {
+	return fmt.Sprintf("%s %s %d %s", u.Command, u.Section, u.ParentIndex, u.XML)
+}
+
+// NetworkUpdatePlan lists the updates which turn a running
+// network into the desired configuration, along with the changes
+// which can only take effect when the network is restarted
+type NetworkUpdatePlan struct {
+	Updates         []NetworkUpdate
+	RestartRequired []Difference
+}
+
+// NeedsRestart reports whether some changes can not be applied
+// to the running network
+func (p *NetworkUpdatePlan) NeedsRestart() bool {
+	return len(p.RestartRequired) != 0
+}
+
+type networkUpdateItem struct {
+	key string
+	xml string
+}
+
+type networkUpdatePlanner struct {
+	deletes  []NetworkUpdate
+	modifies []NetworkUpdate
+	adds     []NetworkUpdate
+}
+
+// Items are matched by the same key libvirt uses to find them.
+// Sections which libvirt can not modify in place have their
+// changed items deleted and added back instead.
+func (p *networkUpdatePlanner) section(section NetworkUpdateSection, parent int, olds, news []networkUpdateItem, modify bool) {
+	oldItems := make(map[string]networkUpdateItem)
+	for _, item := range olds {
+		oldItems[item.key] = item
+	}
+	newItems := make(map[string]networkUpdateItem)
+	for _, item := range news {
+		newItems[item.key] = item
+	}
+
+	for _, oldItem := range olds {
+		newItem, ok := newItems[oldItem.key]
+		if ok && (newItem.xml == oldItem.xml || modify) {
+			continue
+		}
+		p.deletes = append(p.deletes, NetworkUpdate{
+			Command:     NetworkUpdateCommandDelete,
+			Section:     section,
+			ParentIndex: parent,
+			XML:         oldItem.xml,
+		})
+	}
+	for _, newItem := range news {
+		oldItem, ok := oldItems[newItem.key]
+		if ok && newItem.xml == oldItem.xml {
+			continue
+		}
+		if ok && modify {
+			p.modifies = append(p.modifies, NetworkUpdate{
+				Command:     NetworkUpdateCommandModify,
+				Section:     section,
+				ParentIndex: parent,
+				XML:         newItem.xml,
+			})
+			continue
+		}
+		p.adds = append(p.adds, NetworkUpdate{
+			Command:     NetworkUpdateCommandAddLast,
+			Section:     section,
+			ParentIndex: parent,
+			XML:         newItem.xml,
+		})
+	}
+}
+
+func networkDHCPHostItems(ip *NetworkIP) ([]networkUpdateItem, error) {
+	items := []networkUpdateItem{}
+	if ip.DHCP == nil {
+		return items, nil
+	}
+	for i := range ip.DHCP.Hosts {
+		host := &ip.DHCP.Hosts[i]
+		doc, err := host.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		key := host.MAC
+		if key == "" {
+			key = host.ID
+		}
+		if key == "" {
+			key = host.Name
+		}
+		items = append(items, networkUpdateItem{key, doc})
+	}
+	return items, nil
+}
+
+func networkDHCPRangeItems(ip *NetworkIP) ([]networkUpdateItem, error) {
+	items := []networkUpdateItem{}
+	if ip.DHCP == nil {
+		return items, nil
+	}
+	for i := range ip.DHCP.Ranges {
+		rng := &ip.DHCP.Ranges[i]
+		doc, err := rng.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, networkUpdateItem{rng.Start + "-" + rng.End, doc})
+	}
+	return items, nil
+}
+
+func networkForwardInterfaceItems(net *Network) ([]networkUpdateItem, error) {
+	items := []networkUpdateItem{}
+	if net.Forward == nil {
+		return items, nil
+	}
+	for i := range net.Forward.Interfaces {
+		iface := &net.Forward.Interfaces[i]
+		doc, err := iface.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, networkUpdateItem{iface.Dev, doc})
+	}
+	return items, nil
+}
+
+func networkPortGroupItems(net *Network) ([]networkUpdateItem, error) {
+	items := []networkUpdateItem{}
+	for i := range net.PortGroups {
+		pg := &net.PortGroups[i]
+		doc, err := pg.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, networkUpdateItem{pg.Name, doc})
+	}
+	return items, nil
+}
+
+func networkDNSHostItems(net *Network) ([]networkUpdateItem, error) {
+	items := []networkUpdateItem{}
+	if net.DNS == nil {
+		return items, nil
+	}
+	for i := range net.DNS.Host {
+		host := &net.DNS.Host[i]
+		doc, err := host.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, networkUpdateItem{host.IP, doc})
+	}
+	return items, nil
+}
+
+func networkDNSTXTItems(net *Network) ([]networkUpdateItem, error) {
+	items := []networkUpdateItem{}
+	if net.DNS == nil {
+		return items, nil
+	}
+	for i := range net.DNS.TXTs {
+		txt := &net.DNS.TXTs[i]
+		doc, err := txt.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, networkUpdateItem{txt.Name, doc})
+	}
+	return items, nil
+}
+
+func networkDNSSRVItems(net *Network) ([]networkUpdateItem, error) {
+	items := []networkUpdateItem{}
+	if net.DNS == nil {
+		return items, nil
+	}
+	for i := range net.DNS.SRVs {
+		srv := &net.DNS.SRVs[i]
+		doc, err := srv.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		key := fmt.Sprintf("%s/%s/%s/%s/%d", srv.Service, srv.Protocol, srv.Domain, srv.Target, srv.Port)
+		items = append(items, networkUpdateItem{key, doc})
+	}
+	return items, nil
+}
+
+func networkIPKey(ip *NetworkIP) string {
+	return ip.Family + "/" + ip.Address
+}
+
+// Pairs up the <ip> elements, returning the index into news
+// of each element of olds, or -1
+func networkMatchIPs(olds, news []NetworkIP) []int {
+	matches := make([]int, len(olds))
+	used := make([]bool, len(news))
+	for i := range olds {
+		matches[i] = -1
+		for j := range news {
+			if !used[j] && networkIPKey(&olds[i]) == networkIPKey(&news[j]) {
+				matches[i] = j
+				used[j] = true
+				break
+			}
+		}
+	}
+	return matches
+}
+
+// Removes the parts of the network which can be updated live,
+// leaving those which must be compared for a restart
+func networkStripUpdatable(net *Network, ips []bool) {
+	for i := range net.IPs {
+		if !ips[i] || net.IPs[i].DHCP == nil {
+			continue
+		}
+		net.IPs[i].DHCP.Hosts = nil
+		net.IPs[i].DHCP.Ranges = nil
+		if len(net.IPs[i].DHCP.Bootp) == 0 {
+			net.IPs[i].DHCP = nil
+		}
+	}
+	if net.Forward != nil {
+		net.Forward.Interfaces = nil
+	}
+	net.PortGroups = nil
+	if net.DNS != nil {
+		net.DNS.Host = nil
+		net.DNS.TXTs = nil
+		net.DNS.SRVs = nil
+		if net.DNS.Enable == "" && net.DNS.ForwardPlainNames == "" && len(net.DNS.Forwarders) == 0 {
+			net.DNS = nil
+		}
+	}
+}
+
+func networkCopy(net *Network) (*Network, error) {
+	doc, err := net.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	dup := &Network{}
+	err = dup.Unmarshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	return dup, nil
+}
+
+// PlanNetworkUpdate works out the virNetworkUpdate calls which turn
+// a running network with the old configuration into the new one.
+// Only DHCP hosts and ranges, forward interfaces, port groups and
+// DNS records can be updated live, so any other change, such as to
+// the bridge name, is reported as requiring a restart instead.
+func PlanNetworkUpdate(oldNet, newNet *Network) (*NetworkUpdatePlan, error) {
+	p := &networkUpdatePlanner{}
+
+	ipMatches := networkMatchIPs(oldNet.IPs, newNet.IPs)
+	oldIPs := make([]bool, len(oldNet.IPs))
+	newIPs := make([]bool, len(newNet.IPs))
+	for i, j := range ipMatches {
+		if j == -1 {
+			continue
+		}
+		oldIPs[i] = true
+		newIPs[j] = true
+
+		olds, err := networkDHCPRangeItems(&oldNet.IPs[i])
+		if err != nil {
+			return nil, err
+		}
+		news, err := networkDHCPRangeItems(&newNet.IPs[j])
+		if err != nil {
+			return nil, err
+		}
+		p.section(NetworkUpdateSectionIPDHCPRange, i, olds, news, false)
+
+		olds, err = networkDHCPHostItems(&oldNet.IPs[i])
+		if err != nil {
+			return nil, err
+		}
+		news, err = networkDHCPHostItems(&newNet.IPs[j])
+		if err != nil {
+			return nil, err
+		}
+		p.section(NetworkUpdateSectionIPDHCPHost, i, olds, news, true)
+	}
+
+	sections := []struct {
+		section NetworkUpdateSection
+		items   func(*Network) ([]networkUpdateItem, error)
+		modify  bool
+	}{
+		{NetworkUpdateSectionForwardInterface, networkForwardInterfaceItems, false},
+		{NetworkUpdateSectionPortGroup, networkPortGroupItems, true},
+		{NetworkUpdateSectionDNSHost, networkDNSHostItems, false},
+		{NetworkUpdateSectionDNSTXT, networkDNSTXTItems, false},
+		{NetworkUpdateSectionDNSSRV, networkDNSSRVItems, false},
+	}
+	for _, section := range sections {
+		olds, err := section.items(oldNet)
+		if err != nil {
+			return nil, err
+		}
+		news, err := section.items(newNet)
+		if err != nil {
+			return nil, err
+		}
+		p.section(section.section, -1, olds, news, section.modify)
+	}
+
+	oldBase, err := networkCopy(oldNet)
+	if err != nil {
+		return nil, err
+	}
+	newBase, err := networkCopy(newNet)
+	if err != nil {
+		return nil, err
+	}
+	networkStripUpdatable(oldBase, oldIPs)
+	networkStripUpdatable(newBase, newIPs)
+	diffs, err := Diff(oldBase, newBase)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &NetworkUpdatePlan{
+		RestartRequired: diffs,
+	}
+	plan.Updates = append(plan.Updates, p.deletes...)
+	plan.Updates = append(plan.Updates, p.modifies...)
+	plan.Updates = append(plan.Updates, p.adds...)
+	return plan, nil
+}
@@ -0,0 +1,208 @@
+/*
+ * This file is part of the libvirt-go-xml project
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ *
+ * Copyright (C) 2020 Red Hat, Inc.
+ *
+ */
+
+package libvirtxml
+
+import (
+	"strings"
+	"testing"
+)
+
+func networkUpdateTestNetwork() *Network {
+	return &Network{
+		Name: "default",
+		Forward: &NetworkForward{
+			Mode: "nat",
+		},
+		Bridge: &NetworkBridge{
+			Name: "virbr0",
+		},
+		IPs: []NetworkIP{
+			NetworkIP{
+				Address: "192.168.122.1",
+				Netmask: "255.255.255.0",
+				DHCP: &NetworkDHCP{
+					Ranges: []NetworkDHCPRange{
+						NetworkDHCPRange{
+							Start: "192.168.122.2",
+							End:   "192.168.122.254",
+						},
+					},
+					Hosts: []NetworkDHCPHost{
+						NetworkDHCPHost{
+							MAC:  "52:54:00:00:00:01",
+							Name: "one",
+							IP:   "192.168.122.10",
+						},
+					},
+				},
+			},
+			NetworkIP{
+				Family:  "ipv6",
+				Address: "2001:db8:ca2:2::1",
+				Prefix:  64,
+			},
+		},
+		PortGroups: []NetworkPortGroup{
+			NetworkPortGroup{
+				Name: "engineering",
+			},
+		},
+	}
+}
+
+var networkUpdateTests = []struct {
+	Modify  func(net *Network)
+	Updates []string
+	Restart []string
+}{
+	{
+		Modify: func(net *Network) {},
+	},
+	{
+		Modify: func(net *Network) {
+			net.IPs[0].DHCP.Hosts[0].IP = "192.168.122.11"
+			net.IPs[0].DHCP.Hosts = append(net.IPs[0].DHCP.Hosts, NetworkDHCPHost{
+				MAC:  "52:54:00:00:00:02",
+				Name: "two",
+				IP:   "192.168.122.12",
+			})
+		},
+		Updates: []string{
+			`modify ip-dhcp-host 0 <host mac="52:54:00:00:00:01" name="one" ip="192.168.122.11"></host>`,
+			`add-last ip-dhcp-host 0 <host mac="52:54:00:00:00:02" name="two" ip="192.168.122.12"></host>`,
+		},
+	},
+	{
+		Modify: func(net *Network) {
+			net.IPs[0].DHCP.Ranges[0].Start = "192.168.122.100"
+		},
+		Updates: []string{
+			`delete ip-dhcp-range 0 <range start="192.168.122.2" end="192.168.122.254"></range>`,
+			`add-last ip-dhcp-range 0 <range start="192.168.122.100" end="192.168.122.254"></range>`,
+		},
+	},
+	{
+		Modify: func(net *Network) {
+			net.IPs[0].DHCP = nil
+			net.IPs[1].DHCP = &NetworkDHCP{
+				Hosts: []NetworkDHCPHost{
+					NetworkDHCPHost{
+						ID:   "0:3:0:1:0:16:3e:11:22:33",
+						Name: "three",
+						IP:   "2001:db8:ca2:2::3",
+					},
+				},
+			}
+		},
+		Updates: []string{
+			`delete ip-dhcp-range 0 <range start="192.168.122.2" end="192.168.122.254"></range>`,
+			`delete ip-dhcp-host 0 <host mac="52:54:00:00:00:01" name="one" ip="192.168.122.10"></host>`,
+			`add-last ip-dhcp-host 1 <host id="0:3:0:1:0:16:3e:11:22:33" name="three" ip="2001:db8:ca2:2::3"></host>`,
+		},
+	},
+	{
+		Modify: func(net *Network) {
+			net.PortGroups[0].Default = "yes"
+			net.DNS = &NetworkDNS{
+				TXTs: []NetworkDNSTXT{
+					NetworkDNSTXT{
+						Name:  "example",
+						Value: "example value",
+					},
+				},
+				Host: []NetworkDNSHost{
+					NetworkDNSHost{
+						IP: "192.168.122.2",
+						Hostnames: []NetworkDNSHostHostname{
+							NetworkDNSHostHostname{
+								Hostname: "myhost",
+							},
+						},
+					},
+				},
+			}
+		},
+		Updates: []string{
+			`modify portgroup -1 <portgroup name="engineering" default="yes"></portgroup>`,
+			`add-last dns-host -1 <host ip="192.168.122.2"><hostname>myhost</hostname></host>`,
+			`add-last dns-txt -1 <txt name="example" value="example value"></txt>`,
+		},
+	},
+	{
+		Modify: func(net *Network) {
+			net.Bridge.Name = "virbr1"
+			net.IPs = net.IPs[0:1]
+		},
+		Restart: []string{
+			"removed /network[0]/ip[1]",
+			"modified /network[0]/bridge[0]/@name: 'virbr0' -> 'virbr1'",
+		},
+	},
+}
+
+func TestNetworkUpdate(t *testing.T) {
+	for i, test := range networkUpdateTests {
+		oldNet := networkUpdateTestNetwork()
+		newNet := networkUpdateTestNetwork()
+		test.Modify(newNet)
+
+		plan, err := PlanNetworkUpdate(oldNet, newNet)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		updates := []string{}
+		for _, update := range plan.Updates {
+			doc := strings.Replace(update.XML, "\n", "", -1)
+			doc = strings.Replace(doc, "  ", "", -1)
+			update.XML = doc
+			updates = append(updates, update.String())
+		}
+		if len(updates) != len(test.Updates) {
+			t.Fatalf("Test %d: expected updates\n%s\ngot\n%s", i,
+				strings.Join(test.Updates, "\n"), strings.Join(updates, "\n"))
+		}
+		for j := range updates {
+			if updates[j] != test.Updates[j] {
+				t.Fatalf("Test %d: expected updates\n%s\ngot\n%s", i,
+					strings.Join(test.Updates, "\n"), strings.Join(updates, "\n"))
+			}
+		}
+
+		restart := []string{}
+		for _, diff := range plan.RestartRequired {
+			restart = append(restart, diff.String())
+		}
+		if plan.NeedsRestart() != (len(test.Restart) != 0) || len(restart) != len(test.Restart) {
+			t.Fatalf("Test %d: expected restart %v, got %v", i, test.Restart, restart)
+		}
+		for j := range restart {
+			if restart[j] != test.Restart[j] {
+				t.Fatalf("Test %d: expected restart %v, got %v", i, test.Restart, restart)
+			}
+		}
+	}
+}
@@ -358,7 +358,7 @@ func (s *NWFilterField) MarshalXMLAttr(name xml.Name) (xml.Attr, error) {
 	} else if s.Var != "" {
 		return xml.Attr{
 			Name:  name,
-			Value: "$" + s.Str,
+			Value: "$" + s.Var,
 		}, nil
 	} else if s.Uint != nil {
 		return xml.Attr{
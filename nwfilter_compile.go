@@ -0,0 +1,1176 @@
+/*
+ * This file is part of the libvirt-go-xml project
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ *
+ * Copyright (C) 2020 Red Hat, Inc.
+ *
+ */
+
+package libvirtxml
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// NWFilterFirewall holds the firewall rules implementing a filter
+// binding. The ebtables, iptables and ip6tables rules are lists of
+// command arguments, including the command name, which create and
+// fill the chains for the port device. The nftables rules are an
+// equivalent ruleset using a single bridge table. libvirt itself has
+// no nftables backend for filters, so the ruleset is a translation of
+// the same chains rather than a copy of anything libvirt generates.
+// It is empty when a rule uses a match nftables cannot express, such
+// as an ipset or gratuitous ARP.
+type NWFilterFirewall struct {
+	EBTables  [][]string
+	IPTables  [][]string
+	IP6Tables [][]string
+	NFTables  string
+}
+
+var nwfilterEtherTypes = map[string]string{
+	"ipv4": "0x800",
+	"ipv6": "0x86dd",
+	"arp":  "0x806",
+	"rarp": "0x8035",
+	"vlan": "0x8100",
+}
+
+// The ARP opcode names shared by libvirt and ebtables, which nftables
+// only knows by number
+var nwfilterARPOpcodes = map[string]string{
+	"Request":         "1",
+	"Reply":           "2",
+	"Request_Reverse": "3",
+	"Reply_Reverse":   "4",
+	"DRARP_Request":   "5",
+	"DRARP_Reply":     "6",
+	"DRARP_Error":     "7",
+	"InARP_Request":   "8",
+	"ARP_NAK":         "10",
+}
+
+var nwfilterChainMatches = map[string]nwfilterMatch{
+	"stp":  nwfilterMatch{key: "ether-dst", value: "01:80:c2:00:00:00"},
+	"vlan": nwfilterMatch{key: "ether-type", value: "0x8100"},
+	"ipv4": nwfilterMatch{key: "ether-type", value: "0x800"},
+	"ipv6": nwfilterMatch{key: "ether-type", value: "0x86dd"},
+	"arp":  nwfilterMatch{key: "ether-type", value: "0x806"},
+	"rarp": nwfilterMatch{key: "ether-type", value: "0x8035"},
+}
+
+// A single condition of a firewall rule, independent of the
+// tool which enforces it. The mask holds the mask of an address,
+// the end of a range or the code of an ICMP type.
+type nwfilterMatch struct {
+	key   string
+	value string
+	mask  string
+	neg   bool
+}
+
+type nwfilterFWRule struct {
+	priority int
+	ipv6     bool
+	matches  []nwfilterMatch
+	action   string
+	target   string
+	// iptables target for accepted traffic
+	accept string
+}
+
+type nwfilterFWChain struct {
+	name  string
+	rules []nwfilterFWRule
+}
+
+type nwfilterCompiler struct {
+	ifname string
+	// ebtables chains, indexed by their name
+	ebtChains map[string]*nwfilterFWChain
+	ebtOrder  []string
+	// iptables chains, shared by IPv4 and IPv6
+	iptChains map[string]*nwfilterFWChain
+	hasIPv4   bool
+	hasIPv6   bool
+}
+
+type nwfilterRuleBuilder struct {
+	neg     bool
+	matches []nwfilterMatch
+}
+
+func (b *nwfilterRuleBuilder) value(f NWFilterField) (string, error) {
+	if f.Var != "" {
+		return "", fmt.Errorf("variable '%s' is not resolved", f.Var)
+	}
+	if f.Str != "" {
+		return f.Str, nil
+	}
+	if f.Uint != nil {
+		return strconv.FormatUint(uint64(*f.Uint), 10), nil
+	}
+	return "", nil
+}
+
+// Adds a match on a field, if it is set, with an optional mask
+// or end of range
+func (b *nwfilterRuleBuilder) add(key string, value, mask NWFilterField) error {
+	val, err := b.value(value)
+	if err != nil || val == "" {
+		return err
+	}
+	msk, err := b.value(mask)
+	if err != nil {
+		return err
+	}
+	b.matches = append(b.matches, nwfilterMatch{key: key, value: val, mask: msk, neg: b.neg})
+	return nil
+}
+
+// Adds a match implied by the protocol, which is never negated
+func (b *nwfilterRuleBuilder) implied(key, value string) {
+	b.matches = append(b.matches, nwfilterMatch{key: key, value: value})
+}
+
+func (b *nwfilterRuleBuilder) etherType(key string, value NWFilterField) error {
+	val, err := b.value(value)
+	if err != nil || val == "" {
+		return err
+	}
+	if typ, ok := nwfilterEtherTypes[val]; ok {
+		val = typ
+	}
+	b.matches = append(b.matches, nwfilterMatch{key: key, value: val, neg: b.neg})
+	return nil
+}
+
+func (b *nwfilterRuleBuilder) comment(comment string) {
+	if comment != "" {
+		b.implied("comment", comment)
+	}
+}
+
+func (b *nwfilterRuleBuilder) commonMAC(m *NWFilterRuleCommonMAC) error {
+	err := b.add("ether-src", m.SrcMACAddr, m.SrcMACMask)
+	if err != nil {
+		return err
+	}
+	return b.add("ether-dst", m.DstMACAddr, m.DstMACMask)
+}
+
+func (b *nwfilterRuleBuilder) commonPort(p *NWFilterRuleCommonPort) error {
+	err := b.add("sport", p.SrcPortStart, p.SrcPortEnd)
+	if err != nil {
+		return err
+	}
+	return b.add("dport", p.DstPortStart, p.DstPortEnd)
+}
+
+func (b *nwfilterRuleBuilder) commonIP(ip *NWFilterRuleCommonIP) error {
+	fields := []struct {
+		key   string
+		value NWFilterField
+		mask  NWFilterField
+	}{
+		{"ether-src", ip.SrcMACAddr, NWFilterField{}},
+		{"ip-src", ip.SrcIPAddr, ip.SrcIPMask},
+		{"ip-dst", ip.DstIPAddr, ip.DstIPMask},
+		{"ip-src-range", ip.SrcIPFrom, ip.SrcIPTo},
+		{"ip-dst-range", ip.DstIPFrom, ip.DstIPTo},
+		{"dscp", ip.DSCP, NWFilterField{}},
+		{"connlimit", ip.ConnLimitAbove, NWFilterField{}},
+		{"state", ip.State, NWFilterField{}},
+		{"ipset", ip.IPSet, ip.IPSetFlags},
+	}
+	for _, field := range fields {
+		err := b.add(field.key, field.value, field.mask)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *nwfilterRuleBuilder) arp(m *NWFilterRuleCommonMAC, fields []NWFilterField) error {
+	err := b.commonMAC(m)
+	if err != nil {
+		return err
+	}
+	keys := []string{"arp-htype", "arp-ptype", "arp-op", "arp-mac-src", "arp-mac-dst"}
+	for i, key := range keys {
+		if key == "arp-ptype" {
+			err = b.etherType(key, fields[i])
+		} else {
+			err = b.add(key, fields[i], NWFilterField{})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	err = b.add("arp-ip-src", fields[5], fields[6])
+	if err != nil {
+		return err
+	}
+	err = b.add("arp-ip-dst", fields[7], fields[8])
+	if err != nil {
+		return err
+	}
+	gratuitous, err := b.value(fields[9])
+	if err != nil {
+		return err
+	}
+	if gratuitous == "true" || gratuitous == "1" {
+		b.matches = append(b.matches, nwfilterMatch{key: "arp-gratuitous", neg: b.neg})
+	}
+	return nil
+}
+
+func (b *nwfilterRuleBuilder) stp(stp *NWFilterRuleSTP) error {
+	b.implied("ether-dst", "01:80:c2:00:00:00")
+	err := b.add("ether-src", stp.SrcMACAddr, stp.SrcMACMask)
+	if err != nil {
+		return err
+	}
+	fields := []struct {
+		key   string
+		value NWFilterField
+		mask  NWFilterField
+	}{
+		{"stp-type", stp.Type, NWFilterField{}},
+		{"stp-flags", stp.Flags, NWFilterField{}},
+		{"stp-root-prio", stp.RootPriority, stp.RootPriorityHi},
+		{"stp-root-addr", stp.RootAddress, stp.RootAddressMask},
+		{"stp-root-cost", stp.RootCost, stp.RootCostHi},
+		{"stp-sender-prio", stp.SenderPriority, stp.SenderPriorityHi},
+		{"stp-sender-addr", stp.SenderAddress, stp.SenderAddressMask},
+		{"stp-port", stp.Port, stp.PortHi},
+		{"stp-msg-age", stp.Age, stp.AgeHi},
+		{"stp-max-age", stp.MaxAge, stp.MaxAgeHi},
+		{"stp-hello-time", stp.HelloTime, stp.HelloTimeHi},
+		{"stp-forward-delay", stp.ForwardDelay, stp.ForwardDelayHi},
+	}
+	for _, field := range fields {
+		err := b.add(field.key, field.value, field.mask)
+		if err != nil {
+			return err
+		}
+	}
+	b.comment(stp.Comment)
+	return nil
+}
+
+// Builds the matches of an ethernet protocol rule, returning
+// false if the rule is for a layer 3 protocol
+func (b *nwfilterRuleBuilder) ethernet(rule *NWFilterRule) (bool, error) {
+	if rule.MAC != nil {
+		b.neg = rule.MAC.Match == "no"
+		err := b.commonMAC(&rule.MAC.NWFilterRuleCommonMAC)
+		if err == nil {
+			err = b.etherType("ether-type", rule.MAC.ProtocolID)
+		}
+		b.comment(rule.MAC.Comment)
+		return true, err
+	} else if rule.VLAN != nil {
+		b.neg = rule.VLAN.Match == "no"
+		b.implied("ether-type", "0x8100")
+		err := b.commonMAC(&rule.VLAN.NWFilterRuleCommonMAC)
+		if err == nil {
+			err = b.add("vlan-id", rule.VLAN.VLANID, NWFilterField{})
+		}
+		if err == nil {
+			err = b.etherType("vlan-encap", rule.VLAN.EncapProtocol)
+		}
+		b.comment(rule.VLAN.Comment)
+		return true, err
+	} else if rule.STP != nil {
+		b.neg = rule.STP.Match.Str == "no"
+		return true, b.stp(rule.STP)
+	} else if rule.ARP != nil {
+		arp := rule.ARP
+		b.neg = arp.Match == "no"
+		b.implied("ether-type", "0x806")
+		err := b.arp(&arp.NWFilterRuleCommonMAC, []NWFilterField{
+			arp.HWType, arp.ProtocolType, arp.OpCode, arp.ARPSrcMACAddr, arp.ARPDstMACAddr,
+			arp.ARPSrcIPAddr, arp.ARPSrcIPMask, arp.ARPDstIPAddr, arp.ARPDstIPMask, arp.Gratuitous,
+		})
+		b.comment(arp.Comment)
+		return true, err
+	} else if rule.RARP != nil {
+		rarp := rule.RARP
+		b.neg = rarp.Match == "no"
+		b.implied("ether-type", "0x8035")
+		err := b.arp(&rarp.NWFilterRuleCommonMAC, []NWFilterField{
+			rarp.HWType, rarp.ProtocolType, rarp.OpCode, rarp.ARPSrcMACAddr, rarp.ARPDstMACAddr,
+			rarp.ARPSrcIPAddr, rarp.ARPSrcIPMask, rarp.ARPDstIPAddr, rarp.ARPDstIPMask, rarp.Gratuitous,
+		})
+		b.comment(rarp.Comment)
+		return true, err
+	} else if rule.IP != nil {
+		ip := rule.IP
+		b.neg = ip.Match == "no"
+		b.implied("ether-type", "0x800")
+		err := b.commonMAC(&ip.NWFilterRuleCommonMAC)
+		if err == nil {
+			err = b.add("ip-src", ip.SrcIPAddr, ip.SrcIPMask)
+		}
+		if err == nil {
+			err = b.add("ip-dst", ip.DstIPAddr, ip.DstIPMask)
+		}
+		if err == nil {
+			err = b.add("ip-proto", ip.Protocol, NWFilterField{})
+		}
+		if err == nil {
+			err = b.commonPort(&ip.NWFilterRuleCommonPort)
+		}
+		if err == nil {
+			err = b.add("dscp", ip.DSCP, NWFilterField{})
+		}
+		b.comment(ip.Comment)
+		return true, err
+	} else if rule.IPv6 != nil {
+		ip := rule.IPv6
+		b.neg = ip.Match == "no"
+		b.implied("ether-type", "0x86dd")
+		err := b.commonMAC(&ip.NWFilterRuleCommonMAC)
+		if err == nil {
+			err = b.add("ip-src", ip.SrcIPAddr, ip.SrcIPMask)
+		}
+		if err == nil {
+			err = b.add("ip-dst", ip.DstIPAddr, ip.DstIPMask)
+		}
+		if err == nil {
+			err = b.add("ip-proto", ip.Protocol, NWFilterField{})
+		}
+		if err == nil {
+			err = b.commonPort(&ip.NWFilterRuleCommonPort)
+		}
+		if err == nil {
+			err = b.icmpType(ip.Type, ip.TypeEnd, ip.Code, ip.CodeEnd)
+		}
+		b.comment(ip.Comment)
+		return true, err
+	}
+	return false, nil
+}
+
+func (b *nwfilterRuleBuilder) icmpType(typ, typeEnd, code, codeEnd NWFilterField) error {
+	vals := make([]string, 4)
+	for i, f := range []NWFilterField{typ, typeEnd, code, codeEnd} {
+		val, err := b.value(f)
+		if err != nil {
+			return err
+		}
+		vals[i] = val
+	}
+	if vals[0] == "" {
+		return nil
+	}
+	match := nwfilterMatch{key: "icmp-type", value: vals[0], mask: vals[2], neg: b.neg}
+	if vals[1] != "" {
+		match.value += ":" + vals[1]
+	}
+	if vals[2] != "" && vals[3] != "" {
+		match.mask += ":" + vals[3]
+	}
+	b.matches = append(b.matches, match)
+	return nil
+}
+
+// Builds the matches of a layer 3 protocol rule, returning
+// whether it is for IPv6
+func (b *nwfilterRuleBuilder) layer3(rule *NWFilterRule) (bool, error) {
+	var match, comment string
+	var ip *NWFilterRuleCommonIP
+	var port *NWFilterRuleCommonPort
+	var tcpOption, tcpFlags, icmpType, icmpCode *NWFilterField
+	proto, ipv6 := "", false
+
+	switch {
+	case rule.TCP != nil:
+		match, comment, ip, port = rule.TCP.Match, rule.TCP.Comment, &rule.TCP.NWFilterRuleCommonIP, &rule.TCP.NWFilterRuleCommonPort
+		tcpOption, tcpFlags, proto = &rule.TCP.Option, &rule.TCP.Flags, "tcp"
+	case rule.UDP != nil:
+		match, comment, ip, port = rule.UDP.Match, rule.UDP.Comment, &rule.UDP.NWFilterRuleCommonIP, &rule.UDP.NWFilterRuleCommonPort
+		proto = "udp"
+	case rule.UDPLite != nil:
+		match, comment, ip, proto = rule.UDPLite.Match, rule.UDPLite.Comment, &rule.UDPLite.NWFilterRuleCommonIP, "udplite"
+	case rule.ESP != nil:
+		match, comment, ip, proto = rule.ESP.Match, rule.ESP.Comment, &rule.ESP.NWFilterRuleCommonIP, "esp"
+	case rule.AH != nil:
+		match, comment, ip, proto = rule.AH.Match, rule.AH.Comment, &rule.AH.NWFilterRuleCommonIP, "ah"
+	case rule.SCTP != nil:
+		match, comment, ip, port = rule.SCTP.Match, rule.SCTP.Comment, &rule.SCTP.NWFilterRuleCommonIP, &rule.SCTP.NWFilterRuleCommonPort
+		proto = "sctp"
+	case rule.ICMP != nil:
+		match, comment, ip, proto = rule.ICMP.Match, rule.ICMP.Comment, &rule.ICMP.NWFilterRuleCommonIP, "icmp"
+		icmpType, icmpCode = &rule.ICMP.Type, &rule.ICMP.Code
+	case rule.IGMP != nil:
+		match, comment, ip, proto = rule.IGMP.Match, rule.IGMP.Comment, &rule.IGMP.NWFilterRuleCommonIP, "igmp"
+	case rule.All != nil:
+		match, comment, ip = rule.All.Match, rule.All.Comment, &rule.All.NWFilterRuleCommonIP
+	case rule.TCPIPv6 != nil:
+		match, comment, ip, port = rule.TCPIPv6.Match, rule.TCPIPv6.Comment, &rule.TCPIPv6.NWFilterRuleCommonIP, &rule.TCPIPv6.NWFilterRuleCommonPort
+		tcpOption, proto, ipv6 = &rule.TCPIPv6.Option, "tcp", true
+	case rule.UDPIPv6 != nil:
+		match, comment, ip, port = rule.UDPIPv6.Match, rule.UDPIPv6.Comment, &rule.UDPIPv6.NWFilterRuleCommonIP, &rule.UDPIPv6.NWFilterRuleCommonPort
+		proto, ipv6 = "udp", true
+	case rule.UDPLiteIPv6 != nil:
+		match, comment, ip = rule.UDPLiteIPv6.Match, rule.UDPLiteIPv6.Comment, &rule.UDPLiteIPv6.NWFilterRuleCommonIP
+		proto, ipv6 = "udplite", true
+	case rule.ESPIPv6 != nil:
+		match, comment, ip = rule.ESPIPv6.Match, rule.ESPIPv6.Comment, &rule.ESPIPv6.NWFilterRuleCommonIP
+		proto, ipv6 = "esp", true
+	case rule.AHIPv6 != nil:
+		match, comment, ip = rule.AHIPv6.Match, rule.AHIPv6.Comment, &rule.AHIPv6.NWFilterRuleCommonIP
+		proto, ipv6 = "ah", true
+	case rule.SCTPIPv6 != nil:
+		match, comment, ip, port = rule.SCTPIPv6.Match, rule.SCTPIPv6.Comment, &rule.SCTPIPv6.NWFilterRuleCommonIP, &rule.SCTPIPv6.NWFilterRuleCommonPort
+		proto, ipv6 = "sctp", true
+	case rule.ICMPv6 != nil:
+		match, comment, ip = rule.ICMPv6.Match, rule.ICMPv6.Comment, &rule.ICMPv6.NWFilterRuleCommonIP
+		icmpType, icmpCode, proto, ipv6 = &rule.ICMPv6.Type, &rule.ICMPv6.Code, "icmpv6", true
+	case rule.AllIPv6 != nil:
+		match, comment, ip, ipv6 = rule.AllIPv6.Match, rule.AllIPv6.Comment, &rule.AllIPv6.NWFilterRuleCommonIP, true
+	default:
+		return false, fmt.Errorf("rule has no protocol")
+	}
+
+	b.neg = match == "no"
+	if proto != "" {
+		b.implied("ip-proto", proto)
+	} else {
+		b.implied("l3", "")
+	}
+	err := b.commonIP(ip)
+	if err == nil && port != nil {
+		err = b.commonPort(port)
+	}
+	if err == nil && icmpType != nil {
+		err = b.icmpType(*icmpType, NWFilterField{}, *icmpCode, NWFilterField{})
+	}
+	if err == nil && tcpOption != nil {
+		err = b.add("tcp-option", *tcpOption, NWFilterField{})
+	}
+	if err == nil && tcpFlags != nil {
+		var flags string
+		flags, err = b.value(*tcpFlags)
+		if err == nil && flags != "" {
+			parts := strings.SplitN(flags, "/", 2)
+			if len(parts) != 2 {
+				return ipv6, fmt.Errorf("invalid TCP flags '%s'", flags)
+			}
+			b.matches = append(b.matches, nwfilterMatch{key: "tcp-flags", value: parts[0], mask: parts[1], neg: b.neg})
+		}
+	}
+	b.comment(comment)
+	return ipv6, err
+}
+
+var nwfilterReversedKeys = map[string]string{
+	"ether-src":    "ether-dst",
+	"ether-dst":    "ether-src",
+	"ip-src":       "ip-dst",
+	"ip-dst":       "ip-src",
+	"ip-src-range": "ip-dst-range",
+	"ip-dst-range": "ip-src-range",
+	"sport":        "dport",
+	"dport":        "sport",
+	"arp-mac-src":  "arp-mac-dst",
+	"arp-mac-dst":  "arp-mac-src",
+	"arp-ip-src":   "arp-ip-dst",
+	"arp-ip-dst":   "arp-ip-src",
+}
+
+// Swaps the source and destination of the matches, for the
+// rules matching traffic in the opposite direction
+func nwfilterReverse(matches []nwfilterMatch, layer3 bool) []nwfilterMatch {
+	reversed := []nwfilterMatch{}
+	for _, match := range matches {
+		if layer3 && match.key == "ether-src" {
+			continue
+		}
+		if key, ok := nwfilterReversedKeys[match.key]; ok {
+			match.key = key
+		}
+		if match.key == "ipset" {
+			flags := strings.Split(match.mask, ",")
+			for i, flag := range flags {
+				if flag == "src" {
+					flags[i] = "dst"
+				} else if flag == "dst" {
+					flags[i] = "src"
+				}
+			}
+			match.mask = strings.Join(flags, ",")
+		}
+		reversed = append(reversed, match)
+	}
+	return reversed
+}
+
+func (c *nwfilterCompiler) ebtChain(name string) *nwfilterFWChain {
+	chain, ok := c.ebtChains[name]
+	if !ok {
+		chain = &nwfilterFWChain{name: name}
+		c.ebtChains[name] = chain
+		c.ebtOrder = append(c.ebtOrder, name)
+	}
+	return chain
+}
+
+func (c *nwfilterCompiler) addEthernet(inst *NWFilterResolvedRule, matches []nwfilterMatch) {
+	// Traffic from the guest goes through the "I" chains and
+	// traffic to it through the "O" chains. Rules for both
+	// directions are written for traffic to the guest.
+	type direction struct {
+		prefix  string
+		reverse bool
+	}
+	dirs := []direction{}
+	dir := inst.Rule.Direction
+	if dir == "out" || dir == "inout" {
+		dirs = append(dirs, direction{"I", dir == "inout"})
+	}
+	if dir == "in" || dir == "inout" {
+		dirs = append(dirs, direction{"O", false})
+	}
+
+	for _, d := range dirs {
+		root := c.ebtChain("libvirt-" + d.prefix + "-" + c.ifname)
+		chain := root
+		if inst.Chain != "root" {
+			name := d.prefix + "-" + c.ifname + "-" + inst.Chain
+			if _, ok := c.ebtChains[name]; !ok {
+				jump := nwfilterFWRule{
+					priority: inst.ChainPriority,
+					action:   "jump",
+					target:   name,
+				}
+				if match, ok := nwfilterChainMatches[strings.SplitN(inst.Chain, "-", 2)[0]]; ok {
+					jump.matches = []nwfilterMatch{match}
+				}
+				root.rules = append(root.rules, jump)
+			}
+			chain = c.ebtChain(name)
+		}
+		rule := nwfilterFWRule{
+			priority: inst.Priority,
+			matches:  matches,
+			action:   inst.Rule.Action,
+		}
+		if d.reverse {
+			rule.matches = nwfilterReverse(matches, false)
+		}
+		chain.rules = append(chain.rules, rule)
+	}
+}
+
+func (c *nwfilterCompiler) addLayer3(inst *NWFilterResolvedRule, matches []nwfilterMatch, ipv6 bool) {
+	if ipv6 {
+		c.hasIPv6 = true
+	} else {
+		c.hasIPv4 = true
+	}
+
+	// Like libvirt, every rule lands in all three chains, reversed
+	// on those carrying traffic against the rule's direction. Rules
+	// with their own state match are left out of the reversed chains
+	// unless state matching is off, as are connection limits, which
+	// only apply to connections from the guest.
+	directionIn := inst.Rule.Direction == "in" || inst.Rule.Direction == "inout"
+	inout := inst.Rule.Direction == "inout"
+	stateMatch := inst.Rule.StateMatch != "false" && inst.Rule.StateMatch != "0"
+	stateful := stateMatch && !inout
+	icmpType, connlimit, ownState := false, false, false
+	for _, match := range matches {
+		switch match.key {
+		case "state":
+			stateful = false
+			ownState = stateMatch && !inout
+		case "icmp-type":
+			icmpType = true
+		case "connlimit":
+			connlimit = true
+		}
+	}
+	state := func(in bool) string {
+		if !stateful {
+			return ""
+		}
+		if in {
+			return "ESTABLISHED"
+		}
+		return "NEW,ESTABLISHED"
+	}
+
+	instances := []struct {
+		chain   string
+		reverse bool
+		accept  string
+		skip    bool
+	}{
+		{"FI-" + c.ifname, directionIn, "RETURN", directionIn || inout},
+		{"FO-" + c.ifname, !directionIn, "ACCEPT", !directionIn || inout},
+		{"HI-" + c.ifname, directionIn, "RETURN", directionIn},
+	}
+	for _, i := range instances {
+		// ICMP types only make sense in the rule's own direction
+		if icmpType && i.skip {
+			continue
+		}
+		if (ownState || connlimit) && i.reverse {
+			continue
+		}
+		rule := nwfilterFWRule{
+			priority: inst.Priority,
+			ipv6:     ipv6,
+			matches:  matches,
+			action:   inst.Rule.Action,
+			accept:   i.accept,
+		}
+		if i.reverse {
+			rule.matches = nwfilterReverse(matches, true)
+		}
+		if ctstate := state(i.reverse); ctstate != "" {
+			rule.matches = append(append([]nwfilterMatch{}, rule.matches...),
+				nwfilterMatch{key: "ctstate", value: ctstate})
+		}
+		c.iptChains[i.chain].rules = append(c.iptChains[i.chain].rules, rule)
+	}
+}
+
+func nwfilterSortRules(chain *nwfilterFWChain) {
+	sort.SliceStable(chain.rules, func(i, j int) bool {
+		return chain.rules[i].priority < chain.rules[j].priority
+	})
+}
+
+func nwfilterMaskedValue(match nwfilterMatch, sep string) string {
+	if match.mask == "" {
+		return match.value
+	}
+	return match.value + sep + match.mask
+}
+
+func nwfilterEBTablesArgs(rule *nwfilterFWRule) []string {
+	args := []string{}
+	ipv6 := false
+	for _, match := range rule.matches {
+		if match.key == "ether-type" && match.value == "0x86dd" {
+			ipv6 = true
+		}
+	}
+	ipPrefix := "--ip-"
+	if ipv6 {
+		ipPrefix = "--ip6-"
+	}
+
+	for _, match := range rule.matches {
+		var opt []string
+		switch match.key {
+		case "ether-src":
+			opt = []string{"-s", nwfilterMaskedValue(match, "/")}
+		case "ether-dst":
+			opt = []string{"-d", nwfilterMaskedValue(match, "/")}
+		case "ether-type":
+			opt = []string{"-p", match.value}
+		case "vlan-id", "vlan-encap", "arp-htype", "arp-ptype", "arp-op", "arp-mac-src", "arp-mac-dst":
+			opt = []string{"--" + match.key, match.value}
+		case "arp-ip-src", "arp-ip-dst":
+			opt = []string{"--" + match.key, nwfilterMaskedValue(match, "/")}
+		case "arp-gratuitous":
+			opt = []string{"--arp-gratuitous"}
+		case "ip-src":
+			opt = []string{ipPrefix + "source", nwfilterMaskedValue(match, "/")}
+		case "ip-dst":
+			opt = []string{ipPrefix + "destination", nwfilterMaskedValue(match, "/")}
+		case "ip-proto":
+			opt = []string{ipPrefix + "protocol", match.value}
+		case "sport":
+			opt = []string{ipPrefix + "source-port", nwfilterMaskedValue(match, ":")}
+		case "dport":
+			opt = []string{ipPrefix + "destination-port", nwfilterMaskedValue(match, ":")}
+		case "dscp":
+			opt = []string{"--ip-tos", match.value}
+		case "icmp-type":
+			opt = []string{"--ip6-icmp-type", nwfilterMaskedValue(match, "/")}
+		default:
+			if strings.HasPrefix(match.key, "stp-") {
+				sep := ":"
+				if strings.HasSuffix(match.key, "-addr") {
+					sep = "/"
+				}
+				opt = []string{"--" + match.key, nwfilterMaskedValue(match, sep)}
+			}
+		}
+		if opt == nil {
+			continue
+		}
+		if match.neg {
+			args = append(args, "!")
+		}
+		args = append(args, opt...)
+	}
+
+	switch rule.action {
+	case "jump":
+		args = append(args, "-j", rule.target)
+	case "drop", "reject":
+		args = append(args, "-j", "DROP")
+	case "return":
+		args = append(args, "-j", "RETURN")
+	case "continue":
+		args = append(args, "-j", "CONTINUE")
+	default:
+		args = append(args, "-j", "ACCEPT")
+	}
+	return args
+}
+
+func nwfilterIPTablesArgs(rule *nwfilterFWRule) []string {
+	args := []string{}
+	var comment []string
+	not := func(match nwfilterMatch) []string {
+		if match.neg {
+			return []string{"!"}
+		}
+		return nil
+	}
+	for _, match := range rule.matches {
+		switch match.key {
+		case "ip-proto":
+			args = append(args, "-p", match.value)
+		case "ether-src":
+			args = append(append(append(args, "-m", "mac"), not(match)...), "--mac-source", match.value)
+		case "ip-src":
+			args = append(append(args, not(match)...), "-s", nwfilterMaskedValue(match, "/"))
+		case "ip-dst":
+			args = append(append(args, not(match)...), "-d", nwfilterMaskedValue(match, "/"))
+		case "ip-src-range":
+			args = append(append(append(args, "-m", "iprange"), not(match)...), "--src-range", nwfilterMaskedValue(match, "-"))
+		case "ip-dst-range":
+			args = append(append(append(args, "-m", "iprange"), not(match)...), "--dst-range", nwfilterMaskedValue(match, "-"))
+		case "sport":
+			args = append(append(args, not(match)...), "--sport", nwfilterMaskedValue(match, ":"))
+		case "dport":
+			args = append(append(args, not(match)...), "--dport", nwfilterMaskedValue(match, ":"))
+		case "dscp":
+			args = append(append(append(args, "-m", "dscp"), not(match)...), "--dscp", match.value)
+		case "icmp-type":
+			opt := "--icmp-type"
+			if rule.ipv6 {
+				opt = "--icmpv6-type"
+			}
+			args = append(append(args, not(match)...), opt, nwfilterMaskedValue(match, "/"))
+		case "tcp-flags":
+			args = append(append(args, not(match)...), "--tcp-flags", match.value, match.mask)
+		case "tcp-option":
+			args = append(append(args, not(match)...), "--tcp-option", match.value)
+		case "connlimit":
+			args = append(append(append(args, "-m", "connlimit"), not(match)...), "--connlimit-above", match.value)
+		case "ipset":
+			args = append(append(append(args, "-m", "set"), not(match)...), "--match-set", match.value, match.mask)
+		case "state":
+			args = append(append(append(args, "-m", "state"), not(match)...), "--state", match.value)
+		case "ctstate":
+			args = append(args, "-m", "conntrack", "--ctstate", match.value)
+		case "comment":
+			comment = []string{"-m", "comment", "--comment", match.value}
+		}
+	}
+	args = append(args, comment...)
+
+	switch rule.action {
+	case "continue":
+	case "drop":
+		args = append(args, "-j", "DROP")
+	case "reject":
+		args = append(args, "-j", "REJECT")
+	case "return":
+		args = append(args, "-j", "RETURN")
+	default:
+		args = append(args, "-j", rule.accept)
+	}
+	return args
+}
+
+// Converts a netmask, either a prefix length or in dotted
+// form, into a prefix length
+func nwfilterPrefixLength(mask string) (string, error) {
+	if _, err := strconv.ParseUint(mask, 10, 8); err == nil {
+		return mask, nil
+	}
+	ip := net.ParseIP(mask)
+	if ip == nil {
+		return "", fmt.Errorf("invalid netmask '%s'", mask)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	ones, bits := net.IPMask(ip).Size()
+	if bits == 0 {
+		return "", fmt.Errorf("non-contiguous netmask '%s'", mask)
+	}
+	return strconv.Itoa(ones), nil
+}
+
+func nwfilterNFTRange(val string) string {
+	return strings.Replace(val, ":", "-", -1)
+}
+
+func nwfilterNFTExprs(rule *nwfilterFWRule) ([]string, error) {
+	exprs := []string{}
+	ipFamily := "ip"
+	if rule.ipv6 {
+		ipFamily = "ip6"
+	}
+	for _, match := range rule.matches {
+		if match.key == "ether-type" && match.value == "0x86dd" {
+			ipFamily = "ip6"
+		}
+	}
+	var comment string
+	for _, match := range rule.matches {
+		op := " "
+		if match.neg {
+			op = " != "
+		}
+		var expr string
+		switch match.key {
+		case "ether-src", "ether-dst", "arp-mac-src", "arp-mac-dst":
+			field := map[string]string{
+				"ether-src":   "ether saddr",
+				"ether-dst":   "ether daddr",
+				"arp-mac-src": "arp saddr ether",
+				"arp-mac-dst": "arp daddr ether",
+			}[match.key]
+			if match.mask != "" {
+				if op == " " {
+					op = " == "
+				}
+				expr = field + " & " + match.mask + op + match.value
+			} else {
+				expr = field + op + match.value
+			}
+		case "ether-type":
+			expr = "ether type" + op + match.value
+		case "l3":
+			expr = "ether type " + ipFamily
+		case "vlan-id":
+			expr = "vlan id" + op + match.value
+		case "vlan-encap":
+			expr = "vlan type" + op + match.value
+		case "arp-htype":
+			expr = "arp htype" + op + match.value
+		case "arp-ptype":
+			expr = "arp ptype" + op + match.value
+		case "arp-op":
+			val := match.value
+			if num, ok := nwfilterARPOpcodes[val]; ok {
+				val = num
+			}
+			expr = "arp operation" + op + val
+		case "ip-src", "ip-dst", "arp-ip-src", "arp-ip-dst":
+			field := map[string]string{
+				"ip-src":     ipFamily + " saddr",
+				"ip-dst":     ipFamily + " daddr",
+				"arp-ip-src": "arp saddr ip",
+				"arp-ip-dst": "arp daddr ip",
+			}[match.key]
+			val := match.value
+			if match.mask != "" {
+				prefix, err := nwfilterPrefixLength(match.mask)
+				if err != nil {
+					return nil, err
+				}
+				val += "/" + prefix
+			}
+			expr = field + op + val
+		case "ip-src-range":
+			expr = ipFamily + " saddr" + op + match.value + "-" + match.mask
+		case "ip-dst-range":
+			expr = ipFamily + " daddr" + op + match.value + "-" + match.mask
+		case "ip-proto":
+			if ipFamily == "ip6" {
+				expr = "ip6 nexthdr" + op + match.value
+			} else {
+				expr = "ip protocol" + op + match.value
+			}
+		case "sport", "dport":
+			val := match.value
+			if match.mask != "" {
+				val += "-" + match.mask
+			}
+			expr = "th " + match.key + op + val
+		case "dscp":
+			expr = ipFamily + " dscp" + op + match.value
+		case "icmp-type":
+			icmp := "icmp"
+			if ipFamily == "ip6" {
+				icmp = "icmpv6"
+			}
+			expr = icmp + " type" + op + nwfilterNFTRange(match.value)
+			if match.mask != "" {
+				expr += " " + icmp + " code" + op + nwfilterNFTRange(match.mask)
+			}
+		case "tcp-flags":
+			flags := func(val string) string {
+				if val == "ALL" {
+					val = "FIN,SYN,RST,PSH,ACK,URG"
+				} else if val == "NONE" {
+					return "0x0"
+				}
+				return strings.ToLower(strings.Replace(val, ",", "|", -1))
+			}
+			if op == " " {
+				op = " == "
+			}
+			expr = "tcp flags & (" + flags(match.value) + ")" + op + flags(match.mask)
+		case "tcp-option":
+			expr = "tcp option @" + match.value + " exists"
+			if match.neg {
+				expr = "tcp option @" + match.value + " missing"
+			}
+		case "connlimit":
+			expr = "ct count over " + match.value
+		case "state", "ctstate":
+			expr = "ct state" + op + strings.ToLower(match.value)
+		case "comment":
+			comment = match.value
+		default:
+			return nil, fmt.Errorf("match on '%s' is not supported by nftables", match.key)
+		}
+		if expr != "" {
+			exprs = append(exprs, expr)
+		}
+	}
+
+	switch rule.action {
+	case "jump":
+		exprs = append(exprs, "jump "+nwfilterNFTName(rule.target))
+	case "drop", "reject", "return", "continue":
+		exprs = append(exprs, rule.action)
+	default:
+		exprs = append(exprs, "accept")
+	}
+	if comment != "" {
+		exprs = append(exprs, "comment "+strconv.Quote(comment))
+	}
+	return exprs, nil
+}
+
+func nwfilterNFTName(name string) string {
+	return strings.Replace(name, "-", "_", -1)
+}
+
+func nwfilterNFTChain(buf *strings.Builder, name string, header []string, rules []string) {
+	fmt.Fprintf(buf, "\tchain %s {\n", nwfilterNFTName(name))
+	for _, line := range header {
+		fmt.Fprintf(buf, "\t\t%s\n", line)
+	}
+	for _, line := range rules {
+		fmt.Fprintf(buf, "\t\t%s\n", line)
+	}
+	buf.WriteString("\t}\n")
+}
+
+// The base chains hook the bridge where the ebtables and iptables
+// rules hook PREROUTING, POSTROUTING and the libvirt chains, jumping
+// to one chain per libvirt chain with its rules translated.
+func (c *nwfilterCompiler) nftables() (string, error) {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "table bridge %s {\n", nwfilterNFTName("libvirt-nwfilter-"+c.ifname))
+
+	ifname := strconv.Quote(c.ifname)
+	if len(c.ebtOrder) != 0 {
+		nwfilterNFTChain(&buf, "prerouting", []string{
+			"type filter hook prerouting priority -300; policy accept;",
+			"iifname " + ifname + " jump " + nwfilterNFTName("libvirt-I-"+c.ifname),
+		}, nil)
+		nwfilterNFTChain(&buf, "postrouting", []string{
+			"type filter hook postrouting priority -300; policy accept;",
+			"oifname " + ifname + " jump " + nwfilterNFTName("libvirt-O-"+c.ifname),
+		}, nil)
+	}
+	if c.hasIPv4 || c.hasIPv6 {
+		nwfilterNFTChain(&buf, "forward", []string{
+			"type filter hook forward priority 0; policy accept;",
+			"iifname " + ifname + " jump " + nwfilterNFTName("FI-"+c.ifname),
+			"oifname " + ifname + " jump " + nwfilterNFTName("FO-"+c.ifname),
+		}, nil)
+		nwfilterNFTChain(&buf, "input", []string{
+			"type filter hook input priority 0; policy accept;",
+			"iifname " + ifname + " jump " + nwfilterNFTName("HI-"+c.ifname),
+		}, nil)
+	}
+
+	chains := []*nwfilterFWChain{}
+	for _, name := range c.ebtOrder {
+		chains = append(chains, c.ebtChains[name])
+	}
+	if c.hasIPv4 || c.hasIPv6 {
+		for _, name := range []string{"FI-", "FO-", "HI-"} {
+			chains = append(chains, c.iptChains[name+c.ifname])
+		}
+	}
+	for _, chain := range chains {
+		rules := []string{}
+		for i := range chain.rules {
+			exprs, err := nwfilterNFTExprs(&chain.rules[i])
+			if err != nil {
+				return "", err
+			}
+			rules = append(rules, strings.Join(exprs, " "))
+		}
+		nwfilterNFTChain(&buf, chain.name, nil, rules)
+	}
+	buf.WriteString("}\n")
+	return buf.String(), nil
+}
+
+func (c *nwfilterCompiler) ebtables() [][]string {
+	cmds := [][]string{}
+	if len(c.ebtOrder) == 0 {
+		return cmds
+	}
+	for _, name := range c.ebtOrder {
+		cmds = append(cmds, []string{"ebtables", "-t", "nat", "-N", name})
+	}
+	for _, name := range c.ebtOrder {
+		for i := range c.ebtChains[name].rules {
+			args := []string{"ebtables", "-t", "nat", "-A", name}
+			cmds = append(cmds, append(args, nwfilterEBTablesArgs(&c.ebtChains[name].rules[i])...))
+		}
+	}
+	if _, ok := c.ebtChains["libvirt-I-"+c.ifname]; ok {
+		cmds = append(cmds, []string{"ebtables", "-t", "nat", "-A", "PREROUTING", "-i", c.ifname, "-j", "libvirt-I-" + c.ifname})
+	}
+	if _, ok := c.ebtChains["libvirt-O-"+c.ifname]; ok {
+		cmds = append(cmds, []string{"ebtables", "-t", "nat", "-A", "POSTROUTING", "-o", c.ifname, "-j", "libvirt-O-" + c.ifname})
+	}
+	return cmds
+}
+
+func (c *nwfilterCompiler) iptables(cmd string, ipv6 bool) [][]string {
+	cmds := [][]string{}
+	names := []string{"FO-" + c.ifname, "FI-" + c.ifname, "HI-" + c.ifname}
+	for _, name := range names {
+		cmds = append(cmds, []string{cmd, "-N", name})
+	}
+	for _, name := range names {
+		for i, rule := range c.iptChains[name].rules {
+			if rule.ipv6 != ipv6 {
+				continue
+			}
+			args := []string{cmd, "-A", name}
+			cmds = append(cmds, append(args, nwfilterIPTablesArgs(&c.iptChains[name].rules[i])...))
+		}
+	}
+	return append(cmds,
+		[]string{cmd, "-A", "libvirt-out", "-m", "physdev", "--physdev-is-bridged", "--physdev-out", c.ifname, "-g", names[0]},
+		[]string{cmd, "-A", "libvirt-in", "-m", "physdev", "--physdev-in", c.ifname, "-g", names[1]},
+		[]string{cmd, "-A", "libvirt-host-in", "-m", "physdev", "--physdev-in", c.ifname, "-g", names[2]},
+		[]string{cmd, "-A", "libvirt-in-post", "-m", "physdev", "--physdev-in", c.ifname, "-j", "ACCEPT"})
+}
+
+// CompileNWFilterBinding turns the filter referenced by a binding,
+// along with all the filters it references in turn, into firewall
+// rules for the binding's port device. The filters are resolved
+// as by NWFilterBinding.ResolveFilters.
+func CompileNWFilterBinding(binding *NWFilterBinding, filters []NWFilter) (*NWFilterFirewall, error) {
+	if binding.PortDev == nil || binding.PortDev.Name == "" {
+		return nil, fmt.Errorf("binding has no port device")
+	}
+	instances, err := binding.ResolveFilters(filters)
+	if err != nil {
+		return nil, err
+	}
+
+	ifname := binding.PortDev.Name
+	c := &nwfilterCompiler{
+		ifname:    ifname,
+		ebtChains: make(map[string]*nwfilterFWChain),
+		iptChains: map[string]*nwfilterFWChain{
+			"FI-" + ifname: &nwfilterFWChain{name: "FI-" + ifname},
+			"FO-" + ifname: &nwfilterFWChain{name: "FO-" + ifname},
+			"HI-" + ifname: &nwfilterFWChain{name: "HI-" + ifname},
+		},
+	}
+	for i := range instances {
+		inst := &instances[i]
+		switch inst.Rule.Direction {
+		case "in", "out", "inout":
+		default:
+			return nil, fmt.Errorf("rule in filter '%s' has invalid direction '%s'",
+				inst.Filter, inst.Rule.Direction)
+		}
+		switch inst.Rule.Action {
+		case "accept", "drop", "reject", "return", "continue":
+		default:
+			return nil, fmt.Errorf("rule in filter '%s' has invalid action '%s'",
+				inst.Filter, inst.Rule.Action)
+		}
+
+		b := &nwfilterRuleBuilder{}
+		isEthernet, err := b.ethernet(&inst.Rule)
+		if err != nil {
+			return nil, fmt.Errorf("rule in filter '%s': %s", inst.Filter, err)
+		}
+		if isEthernet {
+			c.addEthernet(inst, b.matches)
+			continue
+		}
+		ipv6, err := b.layer3(&inst.Rule)
+		if err != nil {
+			return nil, fmt.Errorf("rule in filter '%s': %s", inst.Filter, err)
+		}
+		c.addLayer3(inst, b.matches, ipv6)
+	}
+
+	for _, chain := range c.ebtChains {
+		nwfilterSortRules(chain)
+	}
+	for _, chain := range c.iptChains {
+		nwfilterSortRules(chain)
+	}
+
+	fw := &NWFilterFirewall{
+		EBTables:  c.ebtables(),
+		IPTables:  [][]string{},
+		IP6Tables: [][]string{},
+	}
+	if c.hasIPv4 {
+		fw.IPTables = c.iptables("iptables", false)
+	}
+	if c.hasIPv6 {
+		fw.IP6Tables = c.iptables("ip6tables", true)
+	}
+	// Some matches, such as ipsets, have no nftables equivalent
+	fw.NFTables, err = c.nftables()
+	if err != nil {
+		fw.NFTables = ""
+	}
+	return fw, nil
+}
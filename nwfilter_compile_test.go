@@ -0,0 +1,599 @@
+/*
+ * This file is part of the libvirt-go-xml project
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ *
+ * Copyright (C) 2020 Red Hat, Inc.
+ *
+ */
+
+package libvirtxml
+
+import (
+	"strings"
+	"testing"
+)
+
+var nwfilterCompileTestFilters = []string{
+	`<filter name='test' chain='root'>
+  <filterref filter='no-mac-spoofing'/>
+  <rule action='accept' direction='out' priority='100'>
+    <tcp srcipaddr='$IP' dstportstart='22' dstportend='23' comment='ssh'/>
+  </rule>
+  <rule action='drop' direction='inout'>
+    <all-ipv6/>
+  </rule>
+  <rule action='accept' direction='in' statematch='false'>
+    <icmp type='8' code='0'/>
+  </rule>
+  <rule action='accept' direction='in' statematch='false'>
+    <udp srcportstart='53' dstipaddr='$IP'/>
+  </rule>
+</filter>`,
+	`<filter name='no-mac-spoofing' chain='mac'>
+  <rule action='return' direction='out'>
+    <mac srcmacaddr='$MAC'/>
+  </rule>
+  <rule action='drop' direction='out'>
+    <mac/>
+  </rule>
+</filter>`,
+	`<filter name='no-arp-spoofing' chain='arp'>
+  <rule action='accept' direction='inout'>
+    <arp srcmacaddr='$MAC' arpsrcipaddr='$IP' arpsrcipmask='255.255.255.0'/>
+  </rule>
+</filter>`,
+	`<filter name='loop-a'>
+  <filterref filter='loop-b'/>
+</filter>`,
+	`<filter name='loop-b'>
+  <filterref filter='loop-a'/>
+</filter>`,
+	`<filter name='missing'>
+  <filterref filter='does-not-exist'/>
+</filter>`,
+	`<filter name='undefined'>
+  <rule action='accept' direction='out'>
+    <udp srcipaddr='$OTHER'/>
+  </rule>
+</filter>`,
+}
+
+func nwfilterCompileTestBinding(filter string) *NWFilterBinding {
+	return &NWFilterBinding{
+		PortDev: &NWFilterBindingPortDev{
+			Name: "vnet0",
+		},
+		MAC: &NWFilterBindingMAC{
+			Address: "52:54:00:00:00:01",
+		},
+		FilterRef: &NWFilterBindingFilterRef{
+			Filter: filter,
+			Parameters: []NWFilterBindingFilterParam{
+				NWFilterBindingFilterParam{
+					Name:  "IP",
+					Value: "192.168.122.10",
+				},
+			},
+		},
+	}
+}
+
+func nwfilterCompileTestFilterList(t *testing.T) []NWFilter {
+	filters := []NWFilter{}
+	for _, doc := range nwfilterCompileTestFilters {
+		filter := NWFilter{}
+		err := filter.Unmarshal(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		filters = append(filters, filter)
+	}
+	return filters
+}
+
+func nwfilterCompileCommands(cmds [][]string) []string {
+	lines := []string{}
+	for _, cmd := range cmds {
+		lines = append(lines, strings.Join(cmd, " "))
+	}
+	return lines
+}
+
+// The rules added to the port's chains, leaving out the commands
+// creating the chains and hooking them up
+func nwfilterCompileRules(cmds [][]string) []string {
+	hooks := map[string]bool{
+		"PREROUTING":      true,
+		"POSTROUTING":     true,
+		"libvirt-out":     true,
+		"libvirt-in":      true,
+		"libvirt-host-in": true,
+		"libvirt-in-post": true,
+	}
+	lines := []string{}
+	for _, cmd := range cmds {
+		for i := range cmd {
+			if cmd[i] == "-A" && i+1 < len(cmd) && !hooks[cmd[i+1]] {
+				lines = append(lines, strings.Join(cmd, " "))
+				break
+			}
+		}
+	}
+	return lines
+}
+
+// The rules of the non-base chains in an nftables ruleset, each
+// prefixed with its chain's name
+func nwfilterCompileNFTRules(ruleset string) []string {
+	lines := []string{}
+	chain := ""
+	for _, line := range strings.Split(ruleset, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "chain "):
+			chain = strings.TrimSuffix(strings.TrimPrefix(line, "chain "), " {")
+		case line == "}":
+			chain = ""
+		case strings.HasPrefix(line, "type filter hook"):
+			chain = ""
+		case chain != "" && line != "":
+			lines = append(lines, chain+": "+line)
+		}
+	}
+	return lines
+}
+
+func TestNWFilterCompile(t *testing.T) {
+	fw, err := CompileNWFilterBinding(nwfilterCompileTestBinding("test"), nwfilterCompileTestFilterList(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"ebtables -t nat -N libvirt-I-vnet0",
+		"ebtables -t nat -N I-vnet0-mac",
+		"ebtables -t nat -A libvirt-I-vnet0 -j I-vnet0-mac",
+		"ebtables -t nat -A I-vnet0-mac -s 52:54:00:00:00:01 -j RETURN",
+		"ebtables -t nat -A I-vnet0-mac -j DROP",
+		"ebtables -t nat -A PREROUTING -i vnet0 -j libvirt-I-vnet0",
+	}
+	actual := nwfilterCompileCommands(fw.EBTables)
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("Expected ebtables\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(actual, "\n"))
+	}
+
+	expected = []string{
+		"iptables -N FO-vnet0",
+		"iptables -N FI-vnet0",
+		"iptables -N HI-vnet0",
+		"iptables -A FO-vnet0 -p tcp -d 192.168.122.10 --sport 22:23 -m conntrack --ctstate ESTABLISHED -m comment --comment ssh -j ACCEPT",
+		"iptables -A FO-vnet0 -p icmp --icmp-type 8/0 -j ACCEPT",
+		"iptables -A FO-vnet0 -p udp -d 192.168.122.10 --sport 53 -j ACCEPT",
+		"iptables -A FI-vnet0 -p tcp -s 192.168.122.10 --dport 22:23 -m conntrack --ctstate NEW,ESTABLISHED -m comment --comment ssh -j RETURN",
+		"iptables -A FI-vnet0 -p udp -s 192.168.122.10 --dport 53 -j RETURN",
+		"iptables -A HI-vnet0 -p tcp -s 192.168.122.10 --dport 22:23 -m conntrack --ctstate NEW,ESTABLISHED -m comment --comment ssh -j RETURN",
+		"iptables -A HI-vnet0 -p udp -s 192.168.122.10 --dport 53 -j RETURN",
+		"iptables -A libvirt-out -m physdev --physdev-is-bridged --physdev-out vnet0 -g FO-vnet0",
+		"iptables -A libvirt-in -m physdev --physdev-in vnet0 -g FI-vnet0",
+		"iptables -A libvirt-host-in -m physdev --physdev-in vnet0 -g HI-vnet0",
+		"iptables -A libvirt-in-post -m physdev --physdev-in vnet0 -j ACCEPT",
+	}
+	actual = nwfilterCompileCommands(fw.IPTables)
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("Expected iptables\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(actual, "\n"))
+	}
+
+	expected = []string{
+		"ip6tables -N FO-vnet0",
+		"ip6tables -N FI-vnet0",
+		"ip6tables -N HI-vnet0",
+		"ip6tables -A FO-vnet0 -j DROP",
+		"ip6tables -A FI-vnet0 -j DROP",
+		"ip6tables -A HI-vnet0 -j DROP",
+		"ip6tables -A libvirt-out -m physdev --physdev-is-bridged --physdev-out vnet0 -g FO-vnet0",
+		"ip6tables -A libvirt-in -m physdev --physdev-in vnet0 -g FI-vnet0",
+		"ip6tables -A libvirt-host-in -m physdev --physdev-in vnet0 -g HI-vnet0",
+		"ip6tables -A libvirt-in-post -m physdev --physdev-in vnet0 -j ACCEPT",
+	}
+	actual = nwfilterCompileCommands(fw.IP6Tables)
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("Expected ip6tables\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(actual, "\n"))
+	}
+
+	nft := strings.Join([]string{
+		"table bridge libvirt_nwfilter_vnet0 {",
+		"	chain prerouting {",
+		"		type filter hook prerouting priority -300; policy accept;",
+		"		iifname \"vnet0\" jump libvirt_I_vnet0",
+		"	}",
+		"	chain postrouting {",
+		"		type filter hook postrouting priority -300; policy accept;",
+		"		oifname \"vnet0\" jump libvirt_O_vnet0",
+		"	}",
+		"	chain forward {",
+		"		type filter hook forward priority 0; policy accept;",
+		"		iifname \"vnet0\" jump FI_vnet0",
+		"		oifname \"vnet0\" jump FO_vnet0",
+		"	}",
+		"	chain input {",
+		"		type filter hook input priority 0; policy accept;",
+		"		iifname \"vnet0\" jump HI_vnet0",
+		"	}",
+		"	chain libvirt_I_vnet0 {",
+		"		jump I_vnet0_mac",
+		"	}",
+		"	chain I_vnet0_mac {",
+		"		ether saddr 52:54:00:00:00:01 return",
+		"		drop",
+		"	}",
+		"	chain FI_vnet0 {",
+		"		ip protocol tcp ip saddr 192.168.122.10 th dport 22-23 ct state new,established accept comment \"ssh\"",
+		"		ether type ip6 drop",
+		"		ip protocol udp ip saddr 192.168.122.10 th dport 53 accept",
+		"	}",
+		"	chain FO_vnet0 {",
+		"		ip protocol tcp ip daddr 192.168.122.10 th sport 22-23 ct state established accept comment \"ssh\"",
+		"		ether type ip6 drop",
+		"		ip protocol icmp icmp type 8 icmp code 0 accept",
+		"		ip protocol udp ip daddr 192.168.122.10 th sport 53 accept",
+		"	}",
+		"	chain HI_vnet0 {",
+		"		ip protocol tcp ip saddr 192.168.122.10 th dport 22-23 ct state new,established accept comment \"ssh\"",
+		"		ether type ip6 drop",
+		"		ip protocol udp ip saddr 192.168.122.10 th dport 53 accept",
+		"	}",
+		"}",
+		"",
+	}, "\n")
+	if fw.NFTables != nft {
+		t.Fatalf("Expected nftables\n%s\ngot\n%s", nft, fw.NFTables)
+	}
+}
+
+func TestNWFilterCompileARP(t *testing.T) {
+	fw, err := CompileNWFilterBinding(nwfilterCompileTestBinding("no-arp-spoofing"), nwfilterCompileTestFilterList(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"ebtables -t nat -N libvirt-I-vnet0",
+		"ebtables -t nat -N I-vnet0-arp",
+		"ebtables -t nat -N libvirt-O-vnet0",
+		"ebtables -t nat -N O-vnet0-arp",
+		"ebtables -t nat -A libvirt-I-vnet0 -p 0x806 -j I-vnet0-arp",
+		"ebtables -t nat -A I-vnet0-arp -p 0x806 -d 52:54:00:00:00:01 --arp-ip-dst 192.168.122.10/255.255.255.0 -j ACCEPT",
+		"ebtables -t nat -A libvirt-O-vnet0 -p 0x806 -j O-vnet0-arp",
+		"ebtables -t nat -A O-vnet0-arp -p 0x806 -s 52:54:00:00:00:01 --arp-ip-src 192.168.122.10/255.255.255.0 -j ACCEPT",
+		"ebtables -t nat -A PREROUTING -i vnet0 -j libvirt-I-vnet0",
+		"ebtables -t nat -A POSTROUTING -o vnet0 -j libvirt-O-vnet0",
+	}
+	actual := nwfilterCompileCommands(fw.EBTables)
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("Expected ebtables\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(actual, "\n"))
+	}
+	if len(fw.IPTables) != 0 || len(fw.IP6Tables) != 0 {
+		t.Fatalf("Unexpected iptables rules %v %v", fw.IPTables, fw.IP6Tables)
+	}
+	if !strings.Contains(fw.NFTables, "ether type 0x806 ether saddr 52:54:00:00:00:01 arp saddr ip 192.168.122.10/24 accept") {
+		t.Fatalf("Missing ARP rule in nftables\n%s", fw.NFTables)
+	}
+}
+
+// Cases modelled on libvirt's nwfilterxml2firewalldata tests, with
+// the rules placed, reversed and skipped as libvirt's iptables and
+// ebtables drivers do
+var nwfilterCompileRuleTests = []struct {
+	name      string
+	filter    string
+	ebtables  []string
+	iptables  []string
+	ip6tables []string
+	nftables  []string
+}{
+	{
+		name: "tcp",
+		filter: `<filter name='testcase' chain='root'>
+  <rule action='accept' direction='out'>
+    <tcp srcmacaddr='01:02:03:04:05:06' dstipaddr='10.1.2.3' dstipmask='32' dscp='2'/>
+  </rule>
+  <rule action='accept' direction='in'>
+    <tcp srcmacaddr='01:02:03:04:05:06' srcipaddr='10.1.2.3' srcipmask='22' dscp='33' srcportstart='20' srcportend='21' dstportstart='100' dstportend='1111'/>
+  </rule>
+  <rule action='accept' direction='in'>
+    <tcp srcipaddr='10.1.2.3' srcipmask='32' dscp='63' srcportstart='255' srcportend='256' dstportstart='65535'/>
+  </rule>
+  <rule action='accept' direction='in'>
+    <tcp flags='SYN,ACK/SYN'/>
+  </rule>
+</filter>`,
+		iptables: []string{
+			"iptables -A FO-vnet0 -p tcp -s 10.1.2.3/32 -m dscp --dscp 2 -m conntrack --ctstate ESTABLISHED -j ACCEPT",
+			"iptables -A FO-vnet0 -p tcp -m mac --mac-source 01:02:03:04:05:06 -s 10.1.2.3/22 -m dscp --dscp 33 --sport 20:21 --dport 100:1111 -m conntrack --ctstate NEW,ESTABLISHED -j ACCEPT",
+			"iptables -A FO-vnet0 -p tcp -s 10.1.2.3/32 -m dscp --dscp 63 --sport 255:256 --dport 65535 -m conntrack --ctstate NEW,ESTABLISHED -j ACCEPT",
+			"iptables -A FO-vnet0 -p tcp --tcp-flags SYN,ACK SYN -m conntrack --ctstate NEW,ESTABLISHED -j ACCEPT",
+			"iptables -A FI-vnet0 -p tcp -m mac --mac-source 01:02:03:04:05:06 -d 10.1.2.3/32 -m dscp --dscp 2 -m conntrack --ctstate NEW,ESTABLISHED -j RETURN",
+			"iptables -A FI-vnet0 -p tcp -d 10.1.2.3/22 -m dscp --dscp 33 --dport 20:21 --sport 100:1111 -m conntrack --ctstate ESTABLISHED -j RETURN",
+			"iptables -A FI-vnet0 -p tcp -d 10.1.2.3/32 -m dscp --dscp 63 --dport 255:256 --sport 65535 -m conntrack --ctstate ESTABLISHED -j RETURN",
+			"iptables -A FI-vnet0 -p tcp --tcp-flags SYN,ACK SYN -m conntrack --ctstate ESTABLISHED -j RETURN",
+			"iptables -A HI-vnet0 -p tcp -m mac --mac-source 01:02:03:04:05:06 -d 10.1.2.3/32 -m dscp --dscp 2 -m conntrack --ctstate NEW,ESTABLISHED -j RETURN",
+			"iptables -A HI-vnet0 -p tcp -d 10.1.2.3/22 -m dscp --dscp 33 --dport 20:21 --sport 100:1111 -m conntrack --ctstate ESTABLISHED -j RETURN",
+			"iptables -A HI-vnet0 -p tcp -d 10.1.2.3/32 -m dscp --dscp 63 --dport 255:256 --sport 65535 -m conntrack --ctstate ESTABLISHED -j RETURN",
+			"iptables -A HI-vnet0 -p tcp --tcp-flags SYN,ACK SYN -m conntrack --ctstate ESTABLISHED -j RETURN",
+		},
+		nftables: []string{
+			"FI_vnet0: ip protocol tcp ether saddr 01:02:03:04:05:06 ip daddr 10.1.2.3/32 ip dscp 2 ct state new,established accept",
+			"FI_vnet0: ip protocol tcp ip daddr 10.1.2.3/22 ip dscp 33 th dport 20-21 th sport 100-1111 ct state established accept",
+			"FI_vnet0: ip protocol tcp ip daddr 10.1.2.3/32 ip dscp 63 th dport 255-256 th sport 65535 ct state established accept",
+			"FI_vnet0: ip protocol tcp tcp flags & (syn|ack) == syn ct state established accept",
+			"FO_vnet0: ip protocol tcp ip saddr 10.1.2.3/32 ip dscp 2 ct state established accept",
+			"FO_vnet0: ip protocol tcp ether saddr 01:02:03:04:05:06 ip saddr 10.1.2.3/22 ip dscp 33 th sport 20-21 th dport 100-1111 ct state new,established accept",
+			"FO_vnet0: ip protocol tcp ip saddr 10.1.2.3/32 ip dscp 63 th sport 255-256 th dport 65535 ct state new,established accept",
+			"FO_vnet0: ip protocol tcp tcp flags & (syn|ack) == syn ct state new,established accept",
+			"HI_vnet0: ip protocol tcp ether saddr 01:02:03:04:05:06 ip daddr 10.1.2.3/32 ip dscp 2 ct state new,established accept",
+			"HI_vnet0: ip protocol tcp ip daddr 10.1.2.3/22 ip dscp 33 th dport 20-21 th sport 100-1111 ct state established accept",
+			"HI_vnet0: ip protocol tcp ip daddr 10.1.2.3/32 ip dscp 63 th dport 255-256 th sport 65535 ct state established accept",
+			"HI_vnet0: ip protocol tcp tcp flags & (syn|ack) == syn ct state established accept",
+		},
+	},
+	{
+		name: "udp",
+		filter: `<filter name='testcase' chain='root'>
+  <rule action='accept' direction='out'>
+    <udp srcmacaddr='01:02:03:04:05:06' dstipaddr='10.1.2.3' dstipmask='32' dscp='2'/>
+  </rule>
+  <rule action='accept' direction='in'>
+    <udp srcipfrom='10.1.2.3' srcipto='10.1.2.5' dstportstart='53'/>
+  </rule>
+  <rule action='drop' direction='inout'>
+    <udp dstipaddr='$IP' srcportstart='67' srcportend='68'/>
+  </rule>
+</filter>`,
+		iptables: []string{
+			"iptables -A FO-vnet0 -p udp -s 10.1.2.3/32 -m dscp --dscp 2 -m conntrack --ctstate ESTABLISHED -j ACCEPT",
+			"iptables -A FO-vnet0 -p udp -m iprange --src-range 10.1.2.3-10.1.2.5 --dport 53 -m conntrack --ctstate NEW,ESTABLISHED -j ACCEPT",
+			"iptables -A FO-vnet0 -p udp -d 192.168.122.10 --sport 67:68 -j DROP",
+			"iptables -A FI-vnet0 -p udp -m mac --mac-source 01:02:03:04:05:06 -d 10.1.2.3/32 -m dscp --dscp 2 -m conntrack --ctstate NEW,ESTABLISHED -j RETURN",
+			"iptables -A FI-vnet0 -p udp -m iprange --dst-range 10.1.2.3-10.1.2.5 --sport 53 -m conntrack --ctstate ESTABLISHED -j RETURN",
+			"iptables -A FI-vnet0 -p udp -s 192.168.122.10 --dport 67:68 -j DROP",
+			"iptables -A HI-vnet0 -p udp -m mac --mac-source 01:02:03:04:05:06 -d 10.1.2.3/32 -m dscp --dscp 2 -m conntrack --ctstate NEW,ESTABLISHED -j RETURN",
+			"iptables -A HI-vnet0 -p udp -m iprange --dst-range 10.1.2.3-10.1.2.5 --sport 53 -m conntrack --ctstate ESTABLISHED -j RETURN",
+			"iptables -A HI-vnet0 -p udp -s 192.168.122.10 --dport 67:68 -j DROP",
+		},
+		nftables: []string{
+			"FI_vnet0: ip protocol udp ether saddr 01:02:03:04:05:06 ip daddr 10.1.2.3/32 ip dscp 2 ct state new,established accept",
+			"FI_vnet0: ip protocol udp ip daddr 10.1.2.3-10.1.2.5 th sport 53 ct state established accept",
+			"FI_vnet0: ip protocol udp ip saddr 192.168.122.10 th dport 67-68 drop",
+			"FO_vnet0: ip protocol udp ip saddr 10.1.2.3/32 ip dscp 2 ct state established accept",
+			"FO_vnet0: ip protocol udp ip saddr 10.1.2.3-10.1.2.5 th dport 53 ct state new,established accept",
+			"FO_vnet0: ip protocol udp ip daddr 192.168.122.10 th sport 67-68 drop",
+			"HI_vnet0: ip protocol udp ether saddr 01:02:03:04:05:06 ip daddr 10.1.2.3/32 ip dscp 2 ct state new,established accept",
+			"HI_vnet0: ip protocol udp ip daddr 10.1.2.3-10.1.2.5 th sport 53 ct state established accept",
+			"HI_vnet0: ip protocol udp ip saddr 192.168.122.10 th dport 67-68 drop",
+		},
+	},
+	{
+		name: "icmp",
+		filter: `<filter name='testcase' chain='root'>
+  <rule action='accept' direction='out'>
+    <icmp srcmacaddr='01:02:03:04:05:06' dstipaddr='10.1.2.3' dstipmask='32' dscp='2' type='12' code='11'/>
+  </rule>
+  <rule action='accept' direction='in'>
+    <icmp srcipaddr='10.1.2.3' srcipmask='22' type='255'/>
+  </rule>
+  <rule action='accept' direction='inout'>
+    <icmp/>
+  </rule>
+</filter>`,
+		iptables: []string{
+			"iptables -A FO-vnet0 -p icmp -s 10.1.2.3/22 --icmp-type 255 -m conntrack --ctstate NEW,ESTABLISHED -j ACCEPT",
+			"iptables -A FO-vnet0 -p icmp -j ACCEPT",
+			"iptables -A FI-vnet0 -p icmp -m mac --mac-source 01:02:03:04:05:06 -d 10.1.2.3/32 -m dscp --dscp 2 --icmp-type 12/11 -m conntrack --ctstate NEW,ESTABLISHED -j RETURN",
+			"iptables -A FI-vnet0 -p icmp -j RETURN",
+			"iptables -A HI-vnet0 -p icmp -m mac --mac-source 01:02:03:04:05:06 -d 10.1.2.3/32 -m dscp --dscp 2 --icmp-type 12/11 -m conntrack --ctstate NEW,ESTABLISHED -j RETURN",
+			"iptables -A HI-vnet0 -p icmp -j RETURN",
+		},
+		nftables: []string{
+			"FI_vnet0: ip protocol icmp ether saddr 01:02:03:04:05:06 ip daddr 10.1.2.3/32 ip dscp 2 icmp type 12 icmp code 11 ct state new,established accept",
+			"FI_vnet0: ip protocol icmp accept",
+			"FO_vnet0: ip protocol icmp ip saddr 10.1.2.3/22 icmp type 255 ct state new,established accept",
+			"FO_vnet0: ip protocol icmp accept",
+			"HI_vnet0: ip protocol icmp ether saddr 01:02:03:04:05:06 ip daddr 10.1.2.3/32 ip dscp 2 icmp type 12 icmp code 11 ct state new,established accept",
+			"HI_vnet0: ip protocol icmp accept",
+		},
+	},
+	{
+		name: "arp",
+		filter: `<filter name='testcase' chain='root'>
+  <rule action='accept' direction='out'>
+    <arp srcmacaddr='01:02:03:04:05:06' srcmacmask='ff:ff:ff:ff:ff:ff' protocolid='arp'/>
+  </rule>
+  <rule action='accept' direction='out'>
+    <arp opcode='Request' arpsrcmacaddr='01:02:03:04:05:06' arpsrcipaddr='10.0.0.1' arpdstipaddr='10.0.0.2'/>
+  </rule>
+  <rule action='drop' direction='in'>
+    <arp opcode='Reply' arpdstmacaddr='52:54:00:00:00:01'/>
+  </rule>
+</filter>`,
+		ebtables: []string{
+			"ebtables -t nat -A libvirt-I-vnet0 -p 0x806 -s 01:02:03:04:05:06/ff:ff:ff:ff:ff:ff -j ACCEPT",
+			"ebtables -t nat -A libvirt-I-vnet0 -p 0x806 --arp-op Request --arp-mac-src 01:02:03:04:05:06 --arp-ip-src 10.0.0.1 --arp-ip-dst 10.0.0.2 -j ACCEPT",
+			"ebtables -t nat -A libvirt-O-vnet0 -p 0x806 --arp-op Reply --arp-mac-dst 52:54:00:00:00:01 -j DROP",
+		},
+		nftables: []string{
+			"libvirt_I_vnet0: ether type 0x806 ether saddr & ff:ff:ff:ff:ff:ff == 01:02:03:04:05:06 accept",
+			"libvirt_I_vnet0: ether type 0x806 arp operation 1 arp saddr ether 01:02:03:04:05:06 arp saddr ip 10.0.0.1 arp daddr ip 10.0.0.2 accept",
+			"libvirt_O_vnet0: ether type 0x806 arp operation 2 arp daddr ether 52:54:00:00:00:01 drop",
+		},
+	},
+	{
+		name: "ipv6",
+		filter: `<filter name='testcase' chain='root'>
+  <rule action='accept' direction='out'>
+    <ipv6 srcmacaddr='01:02:03:04:05:06' srcipaddr='f:e:d::c:b:a' srcipmask='127' protocol='udp' dstportstart='547'/>
+  </rule>
+  <rule action='accept' direction='out'>
+    <tcp-ipv6 srcipaddr='a:b:c::d:e:f' srcipmask='128' dstportstart='22'/>
+  </rule>
+  <rule action='drop' direction='in'>
+    <icmpv6 type='134'/>
+  </rule>
+</filter>`,
+		ebtables: []string{
+			"ebtables -t nat -A libvirt-I-vnet0 -p 0x86dd -s 01:02:03:04:05:06 --ip6-source f:e:d::c:b:a/127 --ip6-protocol udp --ip6-destination-port 547 -j ACCEPT",
+		},
+		ip6tables: []string{
+			"ip6tables -A FO-vnet0 -p tcp -d a:b:c::d:e:f/128 --sport 22 -m conntrack --ctstate ESTABLISHED -j ACCEPT",
+			"ip6tables -A FO-vnet0 -p icmpv6 --icmpv6-type 134 -m conntrack --ctstate NEW,ESTABLISHED -j DROP",
+			"ip6tables -A FI-vnet0 -p tcp -s a:b:c::d:e:f/128 --dport 22 -m conntrack --ctstate NEW,ESTABLISHED -j RETURN",
+			"ip6tables -A HI-vnet0 -p tcp -s a:b:c::d:e:f/128 --dport 22 -m conntrack --ctstate NEW,ESTABLISHED -j RETURN",
+		},
+		nftables: []string{
+			"libvirt_I_vnet0: ether type 0x86dd ether saddr 01:02:03:04:05:06 ip6 saddr f:e:d::c:b:a/127 ip6 nexthdr udp th dport 547 accept",
+			"FI_vnet0: ip6 nexthdr tcp ip6 saddr a:b:c::d:e:f/128 th dport 22 ct state new,established accept",
+			"FO_vnet0: ip6 nexthdr tcp ip6 daddr a:b:c::d:e:f/128 th sport 22 ct state established accept",
+			"FO_vnet0: ip6 nexthdr icmpv6 icmpv6 type 134 ct state new,established drop",
+			"HI_vnet0: ip6 nexthdr tcp ip6 saddr a:b:c::d:e:f/128 th dport 22 ct state new,established accept",
+		},
+	},
+	{
+		name: "ipset",
+		filter: `<filter name='testcase' chain='root'>
+  <rule action='accept' direction='out'>
+    <all ipset='tck_test' ipsetflags='src,dst'/>
+  </rule>
+  <rule action='accept' direction='in'>
+    <tcp ipset='tck_test' ipsetflags='dst,src' dstportstart='80'/>
+  </rule>
+</filter>`,
+		iptables: []string{
+			"iptables -A FO-vnet0 -m set --match-set tck_test dst,src -m conntrack --ctstate ESTABLISHED -j ACCEPT",
+			"iptables -A FO-vnet0 -p tcp -m set --match-set tck_test dst,src --dport 80 -m conntrack --ctstate NEW,ESTABLISHED -j ACCEPT",
+			"iptables -A FI-vnet0 -m set --match-set tck_test src,dst -m conntrack --ctstate NEW,ESTABLISHED -j RETURN",
+			"iptables -A FI-vnet0 -p tcp -m set --match-set tck_test src,dst --sport 80 -m conntrack --ctstate ESTABLISHED -j RETURN",
+			"iptables -A HI-vnet0 -m set --match-set tck_test src,dst -m conntrack --ctstate NEW,ESTABLISHED -j RETURN",
+			"iptables -A HI-vnet0 -p tcp -m set --match-set tck_test src,dst --sport 80 -m conntrack --ctstate ESTABLISHED -j RETURN",
+		},
+	},
+	{
+		name: "conntrack",
+		filter: `<filter name='testcase' chain='root'>
+  <rule action='drop' direction='out'>
+    <all connlimit-above='1'/>
+  </rule>
+  <rule action='drop' direction='out'>
+    <tcp connlimit-above='2'/>
+  </rule>
+  <rule action='drop' direction='inout'>
+    <icmp connlimit-above='2'/>
+  </rule>
+</filter>`,
+		iptables: []string{
+			"iptables -A FO-vnet0 -p icmp -m connlimit --connlimit-above 2 -j DROP",
+			"iptables -A FI-vnet0 -m connlimit --connlimit-above 1 -m conntrack --ctstate NEW,ESTABLISHED -j DROP",
+			"iptables -A FI-vnet0 -p tcp -m connlimit --connlimit-above 2 -m conntrack --ctstate NEW,ESTABLISHED -j DROP",
+			"iptables -A HI-vnet0 -m connlimit --connlimit-above 1 -m conntrack --ctstate NEW,ESTABLISHED -j DROP",
+			"iptables -A HI-vnet0 -p tcp -m connlimit --connlimit-above 2 -m conntrack --ctstate NEW,ESTABLISHED -j DROP",
+		},
+		nftables: []string{
+			"FI_vnet0: ether type ip ct count over 1 ct state new,established drop",
+			"FI_vnet0: ip protocol tcp ct count over 2 ct state new,established drop",
+			"FO_vnet0: ip protocol icmp ct count over 2 drop",
+			"HI_vnet0: ether type ip ct count over 1 ct state new,established drop",
+			"HI_vnet0: ip protocol tcp ct count over 2 ct state new,established drop",
+		},
+	},
+	{
+		name: "statematch",
+		filter: `<filter name='testcase' chain='root'>
+  <rule action='accept' direction='out'>
+    <tcp state='NEW,ESTABLISHED' dstportstart='80'/>
+  </rule>
+  <rule action='accept' direction='in' statematch='false'>
+    <tcp state='ESTABLISHED' srcportstart='80'/>
+  </rule>
+  <rule action='drop' direction='inout'>
+    <udp state='NEW'/>
+  </rule>
+</filter>`,
+		iptables: []string{
+			"iptables -A FO-vnet0 -p tcp -m state --state ESTABLISHED --sport 80 -j ACCEPT",
+			"iptables -A FO-vnet0 -p udp -m state --state NEW -j DROP",
+			"iptables -A FI-vnet0 -p tcp -m state --state NEW,ESTABLISHED --dport 80 -j RETURN",
+			"iptables -A FI-vnet0 -p tcp -m state --state ESTABLISHED --dport 80 -j RETURN",
+			"iptables -A FI-vnet0 -p udp -m state --state NEW -j DROP",
+			"iptables -A HI-vnet0 -p tcp -m state --state NEW,ESTABLISHED --dport 80 -j RETURN",
+			"iptables -A HI-vnet0 -p tcp -m state --state ESTABLISHED --dport 80 -j RETURN",
+			"iptables -A HI-vnet0 -p udp -m state --state NEW -j DROP",
+		},
+		nftables: []string{
+			"FI_vnet0: ip protocol tcp ct state new,established th dport 80 accept",
+			"FI_vnet0: ip protocol tcp ct state established th dport 80 accept",
+			"FI_vnet0: ip protocol udp ct state new drop",
+			"FO_vnet0: ip protocol tcp ct state established th sport 80 accept",
+			"FO_vnet0: ip protocol udp ct state new drop",
+			"HI_vnet0: ip protocol tcp ct state new,established th dport 80 accept",
+			"HI_vnet0: ip protocol tcp ct state established th dport 80 accept",
+			"HI_vnet0: ip protocol udp ct state new drop",
+		},
+	},
+}
+
+func TestNWFilterCompileRules(t *testing.T) {
+	for _, test := range nwfilterCompileRuleTests {
+		filter := NWFilter{}
+		err := filter.Unmarshal(test.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw, err := CompileNWFilterBinding(nwfilterCompileTestBinding("testcase"), []NWFilter{filter})
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+
+		results := []struct {
+			name     string
+			expected []string
+			actual   []string
+		}{
+			{"ebtables", test.ebtables, nwfilterCompileRules(fw.EBTables)},
+			{"iptables", test.iptables, nwfilterCompileRules(fw.IPTables)},
+			{"ip6tables", test.ip6tables, nwfilterCompileRules(fw.IP6Tables)},
+			{"nftables", test.nftables, nwfilterCompileNFTRules(fw.NFTables)},
+		}
+		for _, result := range results {
+			expected := strings.Join(result.expected, "\n")
+			actual := strings.Join(result.actual, "\n")
+			if actual != expected {
+				t.Errorf("%s: expected %s\n%s\ngot\n%s", test.name, result.name, expected, actual)
+			}
+		}
+	}
+}
+
+func TestNWFilterCompileErrors(t *testing.T) {
+	filters := nwfilterCompileTestFilterList(t)
+	for _, name := range []string{"loop-a", "missing", "undefined", "does-not-exist"} {
+		_, err := CompileNWFilterBinding(nwfilterCompileTestBinding(name), filters)
+		if err == nil {
+			t.Fatalf("Expected error compiling filter '%s'", name)
+		}
+	}
+}
@@ -0,0 +1,361 @@
+/*
+ * This file is part of the libvirt-go-xml project
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ *
+ * Copyright (C) 2020 Red Hat, Inc.
+ *
+ */
+
+package libvirtxml
+
+import (
+	"encoding/xml"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// NWFilterResolvedRule is a rule from one of the filters referenced
+// by a binding, with its variables replaced by one combination of
+// their values. Variables holds the value used for each variable,
+// indexed by the reference as written in the rule, such as "IP" or
+// "IP[@1]".
+type NWFilterResolvedRule struct {
+	Filter        string
+	Chain         string
+	ChainPriority int
+	Priority      int
+	Rule          NWFilterRule
+	Variables     map[string]string
+}
+
+var nwfilterChainPriorities = map[string]int{
+	"root": 0,
+	"stp":  -810,
+	"mac":  -800,
+	"vlan": -750,
+	"ipv4": -700,
+	"ipv6": -600,
+	"arp":  -500,
+	"rarp": -400,
+}
+
+const nwfilterRulePriority = 500
+
+func nwfilterChainPriority(filter *NWFilter) int {
+	if filter.Priority != 0 {
+		return filter.Priority
+	}
+	chain := filter.Chain
+	if chain == "" {
+		chain = "root"
+	}
+	return nwfilterChainPriorities[strings.SplitN(chain, "-", 2)[0]]
+}
+
+// A reference to a variable, either to all of its values, to a
+// single one by index or to one at a time along with the other
+// variables using the same iterator
+type nwfilterVarRef struct {
+	name     string
+	index    int
+	iterator int
+}
+
+func parseNWFilterVarRef(ref string) (nwfilterVarRef, error) {
+	v := nwfilterVarRef{name: ref, index: -1, iterator: -1}
+	start := strings.Index(ref, "[")
+	if start == -1 {
+		return v, nil
+	}
+	if !strings.HasSuffix(ref, "]") {
+		return v, fmt.Errorf("invalid variable reference '%s'", ref)
+	}
+	v.name = ref[0:start]
+	access := ref[start+1 : len(ref)-1]
+	iterator := strings.HasPrefix(access, "@")
+	val, err := strconv.ParseUint(strings.TrimPrefix(access, "@"), 10, 16)
+	if err != nil {
+		return v, fmt.Errorf("invalid variable reference '%s'", ref)
+	}
+	if iterator {
+		v.iterator = int(val)
+	} else {
+		v.index = int(val)
+	}
+	return v, nil
+}
+
+// Returns every field of a rule, in a stable order
+func nwfilterRuleFields(rule *NWFilterRule) []*NWFilterField {
+	fields := []*NWFilterField{}
+	var walk func(v reflect.Value)
+	walk = func(v reflect.Value) {
+		switch v.Kind() {
+		case reflect.Ptr:
+			if !v.IsNil() {
+				walk(v.Elem())
+			}
+		case reflect.Struct:
+			if field, ok := v.Addr().Interface().(*NWFilterField); ok {
+				fields = append(fields, field)
+				return
+			}
+			for i := 0; i < v.NumField(); i++ {
+				walk(v.Field(i))
+			}
+		}
+	}
+	walk(reflect.ValueOf(rule))
+	return fields
+}
+
+func nwfilterCopyRule(rule *NWFilterRule) (NWFilterRule, error) {
+	var dup NWFilterRule
+	doc, err := xml.Marshal(rule)
+	if err != nil {
+		return dup, err
+	}
+	err = xml.Unmarshal(doc, &dup)
+	return dup, err
+}
+
+// Instantiates a rule once for each combination of the values of
+// the variables it uses. Variables used directly each contribute
+// their own dimension, while those using the same iterator are
+// stepped through in parallel.
+func nwfilterExpandRule(base NWFilterResolvedRule, rule *NWFilterRule, vars map[string][]string) ([]NWFilterResolvedRule, error) {
+	type dimension struct {
+		name string
+		size int
+	}
+	dims := []dimension{}
+	dimIndexes := make(map[string]int)
+
+	fields := nwfilterRuleFields(rule)
+	refs := make([]*nwfilterVarRef, len(fields))
+	refDims := make([]int, len(fields))
+	for i, field := range fields {
+		if field.Var == "" {
+			continue
+		}
+		ref, err := parseNWFilterVarRef(field.Var)
+		if err != nil {
+			return nil, err
+		}
+		refs[i] = &ref
+		vals := vars[ref.name]
+		if len(vals) == 0 {
+			return nil, fmt.Errorf("variable '%s' is not defined", ref.name)
+		}
+		if ref.index != -1 {
+			if ref.index >= len(vals) {
+				return nil, fmt.Errorf("index %d of variable '%s' is out of range", ref.index, ref.name)
+			}
+			refDims[i] = -1
+			continue
+		}
+
+		key := "$" + ref.name
+		if ref.iterator != -1 {
+			key = "@" + strconv.Itoa(ref.iterator)
+		}
+		idx, ok := dimIndexes[key]
+		if !ok {
+			idx = len(dims)
+			dimIndexes[key] = idx
+			dims = append(dims, dimension{name: ref.name, size: len(vals)})
+		} else if dims[idx].size != len(vals) {
+			return nil, fmt.Errorf("variables '%s' and '%s' must have the same number of values to be iterated in parallel",
+				dims[idx].name, ref.name)
+		}
+		refDims[i] = idx
+	}
+
+	count := 1
+	for _, dim := range dims {
+		count *= dim.size
+	}
+
+	rules := []NWFilterResolvedRule{}
+	pos := make([]int, len(dims))
+	for n := 0; n < count; n++ {
+		rem := n
+		for d := len(dims) - 1; d >= 0; d-- {
+			pos[d] = rem % dims[d].size
+			rem /= dims[d].size
+		}
+
+		dup, err := nwfilterCopyRule(rule)
+		if err != nil {
+			return nil, err
+		}
+		resolved := base
+		resolved.Variables = make(map[string]string)
+		for i, field := range nwfilterRuleFields(&dup) {
+			ref := refs[i]
+			if ref == nil {
+				continue
+			}
+			idx := ref.index
+			if refDims[i] != -1 {
+				idx = pos[refDims[i]]
+			}
+			resolved.Variables[field.Var] = vars[ref.name][idx]
+			field.Str = vars[ref.name][idx]
+			field.Var = ""
+			field.Uint = nil
+		}
+		resolved.Rule = dup
+		rules = append(rules, resolved)
+	}
+	return rules, nil
+}
+
+func nwfilterResolve(filter *NWFilter, filters map[string]*NWFilter, vars map[string][]string, stack []string) ([]NWFilterResolvedRule, error) {
+	for _, name := range stack {
+		if name == filter.Name {
+			return nil, fmt.Errorf("filter '%s' references itself through %s",
+				filter.Name, strings.Join(append(stack, filter.Name), " -> "))
+		}
+	}
+	stack = append(stack, filter.Name)
+
+	chain := filter.Chain
+	if chain == "" {
+		chain = "root"
+	}
+	rules := []NWFilterResolvedRule{}
+	for _, entry := range filter.Entries {
+		if entry.Rule != nil {
+			base := NWFilterResolvedRule{
+				Filter:        filter.Name,
+				Chain:         chain,
+				ChainPriority: nwfilterChainPriority(filter),
+				Priority:      entry.Rule.Priority,
+			}
+			if base.Priority == 0 {
+				base.Priority = nwfilterRulePriority
+			}
+			expanded, err := nwfilterExpandRule(base, entry.Rule, vars)
+			if err != nil {
+				return nil, fmt.Errorf("rule in filter '%s': %s", filter.Name, err)
+			}
+			rules = append(rules, expanded...)
+		} else if entry.Ref != nil {
+			ref, ok := filters[entry.Ref.Filter]
+			if !ok {
+				return nil, fmt.Errorf("filter '%s' referenced by '%s' does not exist",
+					entry.Ref.Filter, filter.Name)
+			}
+			refVars := make(map[string][]string)
+			for name, vals := range vars {
+				refVars[name] = vals
+			}
+			overridden := make(map[string]bool)
+			for _, param := range entry.Ref.Parameters {
+				if !overridden[param.Name] {
+					refVars[param.Name] = nil
+					overridden[param.Name] = true
+				}
+				refVars[param.Name] = append(refVars[param.Name], param.Value)
+			}
+			refRules, err := nwfilterResolve(ref, filters, refVars, stack)
+			if err != nil {
+				return nil, err
+			}
+			rules = append(rules, refRules...)
+		}
+	}
+	return rules, nil
+}
+
+// Resolves the named filter, ordering the rules as they are
+// evaluated. Rules in the root chain are ordered by their own
+// priority, while the rules of any other chain are evaluated
+// together at the priority of the chain.
+func resolveNWFilters(name string, filters []NWFilter, vars map[string][]string) ([]NWFilterResolvedRule, error) {
+	filterMap := make(map[string]*NWFilter)
+	for i := range filters {
+		if _, ok := filterMap[filters[i].Name]; ok {
+			return nil, fmt.Errorf("filter '%s' is defined more than once", filters[i].Name)
+		}
+		filterMap[filters[i].Name] = &filters[i]
+	}
+	filter, ok := filterMap[name]
+	if !ok {
+		return nil, fmt.Errorf("filter '%s' does not exist", name)
+	}
+
+	rules, err := nwfilterResolve(filter, filterMap, vars, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	order := func(rule *NWFilterResolvedRule) (int, int) {
+		if rule.Chain == "root" {
+			return rule.Priority, rule.Priority
+		}
+		return rule.ChainPriority, rule.Priority
+	}
+	sort.SliceStable(rules, func(i, j int) bool {
+		chainI, prioI := order(&rules[i])
+		chainJ, prioJ := order(&rules[j])
+		if chainI != chainJ {
+			return chainI < chainJ
+		}
+		return prioI < prioJ
+	})
+	return rules, nil
+}
+
+// ResolveFilters flattens the filter referenced by the binding,
+// and every filter it references in turn, into the list of rules
+// libvirt would instantiate, in the order they are evaluated. The
+// binding's parameters, with its MAC address as the default value
+// of the MAC variable, provide the values of variables. Variables
+// with several values, such as a list of IP addresses, give one
+// rule for each combination of values.
+func (b *NWFilterBinding) ResolveFilters(filters []NWFilter) ([]NWFilterResolvedRule, error) {
+	if b.FilterRef == nil {
+		return nil, fmt.Errorf("binding has no filter reference")
+	}
+	vars := make(map[string][]string)
+	for _, param := range b.FilterRef.Parameters {
+		vars[param.Name] = append(vars[param.Name], param.Value)
+	}
+	if _, ok := vars["MAC"]; !ok && b.MAC != nil && b.MAC.Address != "" {
+		vars["MAC"] = []string{b.MAC.Address}
+	}
+	return resolveNWFilters(b.FilterRef.Filter, filters, vars)
+}
+
+// ResolveFilters flattens the filter referenced by an interface
+// as for NWFilterBinding.ResolveFilters, with the parameters of
+// the reference providing the values of variables
+func (f *DomainInterfaceFilterRef) ResolveFilters(filters []NWFilter) ([]NWFilterResolvedRule, error) {
+	vars := make(map[string][]string)
+	for _, param := range f.Parameters {
+		vars[param.Name] = append(vars[param.Name], param.Value)
+	}
+	return resolveNWFilters(f.Filter, filters, vars)
+}
@@ -0,0 +1,196 @@
+/*
+ * This file is part of the libvirt-go-xml project
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ *
+ * Copyright (C) 2020 Red Hat, Inc.
+ *
+ */
+
+package libvirtxml
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+var nwfilterResolveTestFilters = []string{
+	`<filter name='root-filter' chain='root'>
+  <rule action='accept' direction='out' priority='100'>
+    <all/>
+  </rule>
+  <filterref filter='mac-filter'/>
+  <filterref filter='arp-filter'>
+    <parameter name='IP' value='10.0.0.9'/>
+  </filterref>
+  <rule action='drop' direction='inout'>
+    <all/>
+  </rule>
+</filter>`,
+	`<filter name='mac-filter' chain='mac'>
+  <rule action='return' direction='out' priority='200'>
+    <mac srcmacaddr='$MAC'/>
+  </rule>
+  <rule action='drop' direction='out' priority='100'>
+    <mac/>
+  </rule>
+</filter>`,
+	`<filter name='arp-filter' chain='arp'>
+  <rule action='accept' direction='out'>
+    <arp arpsrcipaddr='$IP'/>
+  </rule>
+</filter>`,
+	`<filter name='cartesian'>
+  <rule action='accept' direction='in'>
+    <tcp srcipaddr='$IP' dstportstart='$PORT'/>
+  </rule>
+</filter>`,
+	`<filter name='parallel'>
+  <rule action='accept' direction='in'>
+    <tcp srcipaddr='$IP[@1]' dstportstart='$PORT[@1]' dstipaddr='$IP[0]'/>
+  </rule>
+</filter>`,
+	`<filter name='loop-a'>
+  <filterref filter='loop-b'/>
+</filter>`,
+	`<filter name='loop-b'>
+  <filterref filter='loop-a'/>
+</filter>`,
+	`<filter name='missing'>
+  <filterref filter='does-not-exist'/>
+</filter>`,
+}
+
+func nwfilterResolveTestFilterList(t *testing.T) []NWFilter {
+	filters := []NWFilter{}
+	for _, doc := range nwfilterResolveTestFilters {
+		filter := NWFilter{}
+		err := filter.Unmarshal(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		filters = append(filters, filter)
+	}
+	return filters
+}
+
+func nwfilterResolveTestRef(filter string, params ...string) *DomainInterfaceFilterRef {
+	ref := &DomainInterfaceFilterRef{
+		Filter: filter,
+	}
+	for i := 0; i < len(params); i += 2 {
+		ref.Parameters = append(ref.Parameters, DomainInterfaceFilterParam{
+			Name:  params[i],
+			Value: params[i+1],
+		})
+	}
+	return ref
+}
+
+func TestNWFilterResolveOrder(t *testing.T) {
+	binding := &NWFilterBinding{
+		MAC: &NWFilterBindingMAC{
+			Address: "52:54:00:00:00:01",
+		},
+		FilterRef: &NWFilterBindingFilterRef{
+			Filter: "root-filter",
+		},
+	}
+	rules, err := binding.ResolveFilters(nwfilterResolveTestFilterList(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actual := []string{}
+	for _, rule := range rules {
+		actual = append(actual, fmt.Sprintf("%s %s %d %d %s %v",
+			rule.Filter, rule.Chain, rule.ChainPriority, rule.Priority, rule.Rule.Action, rule.Variables))
+	}
+	expected := []string{
+		"mac-filter mac -800 100 drop map[]",
+		"mac-filter mac -800 200 return map[MAC:52:54:00:00:00:01]",
+		"arp-filter arp -500 500 accept map[IP:10.0.0.9]",
+		"root-filter root 0 100 accept map[]",
+		"root-filter root 0 500 drop map[]",
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("Expected rules\n%v\ngot\n%v", expected, actual)
+	}
+	if rules[1].Rule.MAC.SrcMACAddr.Str != "52:54:00:00:00:01" || rules[1].Rule.MAC.SrcMACAddr.Var != "" {
+		t.Fatalf("Expected MAC variable to be substituted, got %v", rules[1].Rule.MAC.SrcMACAddr)
+	}
+}
+
+func TestNWFilterResolveExpand(t *testing.T) {
+	filters := nwfilterResolveTestFilterList(t)
+
+	ref := nwfilterResolveTestRef("cartesian",
+		"IP", "10.0.0.1", "IP", "10.0.0.2", "PORT", "80", "PORT", "443")
+	rules, err := ref.ResolveFilters(filters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual := []string{}
+	for _, rule := range rules {
+		actual = append(actual, rule.Rule.TCP.SrcIPAddr.Str+":"+rule.Rule.TCP.DstPortStart.Str)
+	}
+	expected := []string{"10.0.0.1:80", "10.0.0.1:443", "10.0.0.2:80", "10.0.0.2:443"}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("Expected combinations %v, got %v", expected, actual)
+	}
+
+	ref = nwfilterResolveTestRef("parallel",
+		"IP", "10.0.0.1", "IP", "10.0.0.2", "PORT", "80", "PORT", "443")
+	rules, err = ref.ResolveFilters(filters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual = []string{}
+	for _, rule := range rules {
+		actual = append(actual, rule.Rule.TCP.SrcIPAddr.Str+":"+rule.Rule.TCP.DstPortStart.Str+">"+rule.Rule.TCP.DstIPAddr.Str)
+	}
+	expected = []string{"10.0.0.1:80>10.0.0.1", "10.0.0.2:443>10.0.0.1"}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("Expected combinations %v, got %v", expected, actual)
+	}
+}
+
+func TestNWFilterResolveErrors(t *testing.T) {
+	filters := nwfilterResolveTestFilterList(t)
+
+	refs := []*DomainInterfaceFilterRef{
+		nwfilterResolveTestRef("loop-a"),
+		nwfilterResolveTestRef("missing"),
+		nwfilterResolveTestRef("does-not-exist"),
+		nwfilterResolveTestRef("cartesian", "IP", "10.0.0.1"),
+		nwfilterResolveTestRef("parallel", "IP", "10.0.0.1", "PORT", "80", "PORT", "443"),
+	}
+	for _, ref := range refs {
+		_, err := ref.ResolveFilters(filters)
+		if err == nil {
+			t.Fatalf("Expected error resolving %v", ref)
+		}
+	}
+
+	_, err := nwfilterResolveTestRef("cartesian").ResolveFilters(append(filters, filters[0]))
+	if err == nil {
+		t.Fatal("Expected error resolving duplicate filters")
+	}
+}
@@ -0,0 +1,98 @@
+/*
+ * This file is part of the libvirt-go-xml project
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ *
+ * Copyright (C) 2020 Red Hat, Inc.
+ *
+ */
+
+package libvirtxml
+
+import (
+	"strings"
+	"testing"
+)
+
+var nwfilterTestData = []struct {
+	Object   *NWFilter
+	Expected []string
+}{
+	{
+		Object: &NWFilter{
+			Name:  "no-ip-spoofing",
+			Chain: "ipv4",
+			Entries: []NWFilterEntry{
+				NWFilterEntry{
+					Rule: &NWFilterRule{
+						Action:    "return",
+						Direction: "out",
+						Priority:  500,
+						IP: &NWFilterRuleIP{
+							NWFilterRuleCommonMAC: NWFilterRuleCommonMAC{
+								SrcMACAddr: NWFilterField{Var: "MAC"},
+							},
+							SrcIPAddr: NWFilterField{Var: "IP"},
+						},
+					},
+				},
+			},
+		},
+		Expected: []string{
+			`<filter name="no-ip-spoofing" chain="ipv4">`,
+			`  <rule action="return" direction="out" priority="500">`,
+			`    <ip srcmacaddr="$MAC" srcipaddr="$IP"></ip>`,
+			`  </rule>`,
+			`</filter>`,
+		},
+	},
+}
+
+func TestNWFilter(t *testing.T) {
+	for _, test := range nwfilterTestData {
+		doc, err := test.Object.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expect := strings.Join(test.Expected, "\n")
+
+		if doc != expect {
+			t.Fatal("Bad xml:\n", string(doc), "\n does not match\n", expect, "\n")
+		}
+
+		filter := &NWFilter{}
+		err = filter.Unmarshal(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ip := filter.Entries[0].Rule.IP
+		if ip.SrcIPAddr.Var != "IP" || ip.SrcMACAddr.Var != "MAC" {
+			t.Fatalf("Expected variable references, got %+v %+v", ip.SrcIPAddr, ip.SrcMACAddr)
+		}
+
+		doc, err = filter.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if doc != expect {
+			t.Fatal("Bad round trip xml:\n", string(doc), "\n does not match\n", expect, "\n")
+		}
+	}
+}
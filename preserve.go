@@ -0,0 +1,674 @@
+/*
+ * This file is part of the libvirt-go-xml project
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ *
+ * Copyright (C) 2020 Red Hat, Inc.
+ *
+ */
+
+package libvirtxml
+
+import (
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type xmlNodeAttr struct {
+	Space string
+	Local string
+	Value string
+	Raw   string
+}
+
+// xmlNode is a generic XML element, text or comment, which
+// remembers names exactly as they were written so that it can
+// be formatted again without changing namespace prefixes
+type xmlNode struct {
+	Space    string
+	Local    string
+	Raw      string
+	Attrs    []xmlNodeAttr
+	Text     string
+	Comment  bool
+	Children []*xmlNode
+}
+
+func (n *xmlNode) isElement() bool {
+	return n.Raw != ""
+}
+
+func (n *xmlNode) content() string {
+	content := ""
+	for _, child := range n.Children {
+		if !child.isElement() && !child.Comment {
+			content += child.Text
+		}
+	}
+	return content
+}
+
+func (n *xmlNode) hasElements() bool {
+	for _, child := range n.Children {
+		if child.isElement() {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *xmlNode) getAttr(space, local string) (string, bool) {
+	for _, attr := range n.Attrs {
+		if attr.Space == space && attr.Local == local {
+			return attr.Value, true
+		}
+	}
+	return "", false
+}
+
+func isXMLNSAttr(name xml.Name) bool {
+	return name.Space == "xmlns" || (name.Space == "" && name.Local == "xmlns")
+}
+
+func rawXMLName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
+
+func parseXMLNode(doc string) (*xmlNode, error) {
+	d := xml.NewDecoder(strings.NewReader(doc))
+	var root *xmlNode
+	stack := []*xmlNode{}
+	scopes := []map[string]string{map[string]string{}}
+	for {
+		t, err := d.RawToken()
+		if err != nil {
+			return nil, err
+		}
+
+		var parent *xmlNode
+		if len(stack) != 0 {
+			parent = stack[len(stack)-1]
+		}
+
+		switch t := t.(type) {
+		case xml.StartElement:
+			scope := make(map[string]string)
+			for prefix, uri := range scopes[len(scopes)-1] {
+				scope[prefix] = uri
+			}
+			for _, a := range t.Attr {
+				if a.Name.Space == "xmlns" {
+					scope[a.Name.Local] = a.Value
+				} else if a.Name.Space == "" && a.Name.Local == "xmlns" {
+					scope[""] = a.Value
+				}
+			}
+			scopes = append(scopes, scope)
+
+			node := &xmlNode{
+				Space: getNamespaceURI(scope, scope[""], t.Name),
+				Local: t.Name.Local,
+				Raw:   rawXMLName(t.Name),
+			}
+			for _, a := range t.Attr {
+				attr := xmlNodeAttr{
+					Local: a.Name.Local,
+					Value: a.Value,
+					Raw:   rawXMLName(a.Name),
+				}
+				if isXMLNSAttr(a.Name) {
+					attr.Space = "xmlns"
+				} else {
+					attr.Space = getNamespaceURI(scope, "", a.Name)
+				}
+				node.Attrs = append(node.Attrs, attr)
+			}
+			if parent == nil {
+				if root != nil {
+					return nil, fmt.Errorf("Unexpected second root element '%s'", node.Raw)
+				}
+				root = node
+			} else {
+				parent.Children = append(parent.Children, node)
+			}
+			stack = append(stack, node)
+		case xml.EndElement:
+			if parent == nil {
+				return nil, fmt.Errorf("Unexpected end element '%s'", rawXMLName(t.Name))
+			}
+			stack = stack[:len(stack)-1]
+			scopes = scopes[:len(scopes)-1]
+		case xml.CharData:
+			if parent != nil && strings.TrimSpace(string(t)) != "" {
+				parent.Children = append(parent.Children, &xmlNode{
+					Text: string(t),
+				})
+			}
+		case xml.Comment:
+			if parent != nil {
+				parent.Children = append(parent.Children, &xmlNode{
+					Text:    string(t),
+					Comment: true,
+				})
+			}
+		}
+
+		if root != nil && len(stack) == 0 {
+			break
+		}
+	}
+
+	return root, nil
+}
+
+func encodeXMLNode(e *xml.Encoder, n *xmlNode) error {
+	if n.Comment {
+		return e.EncodeToken(xml.Comment(n.Text))
+	}
+	if !n.isElement() {
+		return e.EncodeToken(xml.CharData(n.Text))
+	}
+
+	start := xml.StartElement{
+		Name: xml.Name{Local: n.Raw},
+	}
+	for _, attr := range n.Attrs {
+		start.Attr = append(start.Attr, xml.Attr{
+			Name:  xml.Name{Local: attr.Raw},
+			Value: attr.Value,
+		})
+	}
+	err := e.EncodeToken(start)
+	if err != nil {
+		return err
+	}
+	for _, child := range n.Children {
+		err = encodeXMLNode(e, child)
+		if err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+func formatXMLNode(n *xmlNode) (string, error) {
+	var buf strings.Builder
+	e := xml.NewEncoder(&buf)
+	e.Indent("", "  ")
+	err := encodeXMLNode(e, n)
+	if err != nil {
+		return "", err
+	}
+	err = e.Flush()
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// Probes used to identify the same element in two documents,
+// in order of precedence. Devices are mostly told apart by their
+// alias, MAC address, target or address, and other elements by
+// their name or id attributes. The address and attribute probes,
+// from xmlWeakIdentityProbe onwards, only apply to repeated
+// elements, so that readdressing or renaming a single element
+// does not make it a different element.
+var xmlIdentityProbes = []func(n *xmlNode) string{
+	func(n *xmlNode) string { return n.childAttrValue("alias", "name") },
+	func(n *xmlNode) string { return n.childAttrValue("mac", "address") },
+	func(n *xmlNode) string { return n.childAttrValue("target", "dev") },
+	func(n *xmlNode) string {
+		index, ok := n.getAttr("", "index")
+		if !ok {
+			return ""
+		}
+		typ, _ := n.getAttr("", "type")
+		return typ + ":" + index
+	},
+	func(n *xmlNode) string {
+		for _, child := range n.Children {
+			if child.isElement() && child.Space == "" && child.Local == "address" {
+				attrs := []string{}
+				for _, attr := range child.Attrs {
+					attrs = append(attrs, attr.Raw+"="+attr.Value)
+				}
+				sort.Strings(attrs)
+				return strings.Join(attrs, ",")
+			}
+		}
+		return ""
+	},
+	func(n *xmlNode) string { return n.attrValue("name") },
+	func(n *xmlNode) string { return n.attrValue("id") },
+	func(n *xmlNode) string { return n.attrValue("mac") },
+}
+
+const xmlWeakIdentityProbe = 4
+
+func (n *xmlNode) attrValue(name string) string {
+	val, _ := n.getAttr("", name)
+	return val
+}
+
+func (n *xmlNode) childAttrValue(child, name string) string {
+	for _, c := range n.Children {
+		if c.isElement() && c.Space == "" && c.Local == child {
+			return c.attrValue(name)
+		}
+	}
+	return ""
+}
+
+func xmlNodeIdentity(n *xmlNode, repeated bool) []string {
+	keys := make([]string, len(xmlIdentityProbes))
+	for i, probe := range xmlIdentityProbes {
+		if i >= xmlWeakIdentityProbe && !repeated {
+			break
+		}
+		keys[i] = probe(n)
+	}
+	return keys
+}
+
+func xmlIdentityConflicts(a, b []string) bool {
+	for i := range a {
+		if a[i] != "" && b[i] != "" && a[i] != b[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// Scores how closely a candidate element resembles a reference
+// element, returning -1 if the two can never be the same element
+func matchXMLNode(ref, candidate *xmlNode) int {
+	if ref.Space != candidate.Space || ref.Local != candidate.Local {
+		return -1
+	}
+	score := 0
+	for _, attr := range ref.Attrs {
+		if attr.Space == "xmlns" {
+			continue
+		}
+		val, ok := candidate.getAttr(attr.Space, attr.Local)
+		if ok && val == attr.Value {
+			score++
+		}
+	}
+	if content := ref.content(); content != "" && content == candidate.content() {
+		score++
+	}
+	for _, child := range ref.Children {
+		if !child.isElement() {
+			continue
+		}
+		for _, other := range candidate.Children {
+			if other.isElement() && sameXMLNode(child, other) {
+				score++
+				break
+			}
+		}
+	}
+	return score
+}
+
+func sameXMLNode(a, b *xmlNode) bool {
+	if a.Space != b.Space || a.Local != b.Local || a.content() != b.content() {
+		return false
+	}
+	if len(a.Attrs) != len(b.Attrs) {
+		return false
+	}
+	for _, attr := range a.Attrs {
+		val, ok := b.getAttr(attr.Space, attr.Local)
+		if !ok || val != attr.Value {
+			return false
+		}
+	}
+	return true
+}
+
+// Pairs up elements from two lists of siblings, matching the
+// most similar pairs first and, between equally similar pairs,
+// those at the same position among siblings of the same name.
+// Elements whose identities conflict, such as devices with
+// different target names, never match. The returned slice is
+// indexed by position in refs, holding the index into candidates
+// or -1.
+func matchXMLNodes(refs, candidates []*xmlNode) []int {
+	matches := make([]int, len(refs))
+	used := make([]bool, len(candidates))
+	scores := make([][]int, len(refs))
+
+	counts := make(map[string]int)
+	refPositions := make([]int, len(refs))
+	for i, ref := range refs {
+		name := ref.Space + " " + ref.Local
+		refPositions[i] = counts[name]
+		counts[name]++
+	}
+	candidateCounts := make(map[string]int)
+	candidatePositions := make([]int, len(candidates))
+	for j, candidate := range candidates {
+		if candidate.isElement() {
+			name := candidate.Space + " " + candidate.Local
+			candidatePositions[j] = candidateCounts[name]
+			candidateCounts[name]++
+		}
+	}
+	repeated := func(n *xmlNode) bool {
+		name := n.Space + " " + n.Local
+		return counts[name] > 1 || candidateCounts[name] > 1
+	}
+
+	for i, ref := range refs {
+		matches[i] = -1
+		scores[i] = make([]int, len(candidates))
+		refKeys := xmlNodeIdentity(ref, repeated(ref))
+		for j, candidate := range candidates {
+			if !candidate.isElement() {
+				scores[i][j] = -1
+				continue
+			}
+			score := matchXMLNode(ref, candidate)
+			if score == -1 ||
+				xmlIdentityConflicts(refKeys, xmlNodeIdentity(candidate, repeated(candidate))) {
+				scores[i][j] = -1
+				continue
+			}
+			scores[i][j] = score * 2
+			if refPositions[i] == candidatePositions[j] {
+				scores[i][j]++
+			}
+		}
+	}
+
+	for {
+		best, besti, bestj := -1, -1, -1
+		for i := range refs {
+			if matches[i] != -1 {
+				continue
+			}
+			for j := range candidates {
+				if used[j] {
+					continue
+				}
+				if scores[i][j] > best {
+					best, besti, bestj = scores[i][j], i, j
+				}
+			}
+		}
+		if besti == -1 {
+			break
+		}
+		matches[besti] = bestj
+		used[bestj] = true
+	}
+
+	return matches
+}
+
+// Pairs up the elements of a document with the same elements as
+// formatted from the structs. The structs keep repeated elements
+// in order, but may format their values differently, such as a
+// decimal address becoming hex, so elements are paired by their
+// position among siblings of the same name. Where the structs
+// dropped some of the elements of a name, the remaining ones are
+// matched as by matchXMLNodes.
+func matchFormattedXMLNodes(orig, formatted []*xmlNode) []int {
+	matches := make([]int, len(orig))
+	names := []string{}
+	origByName := make(map[string][]int)
+	for i, n := range orig {
+		matches[i] = -1
+		name := n.Space + " " + n.Local
+		if _, ok := origByName[name]; !ok {
+			names = append(names, name)
+		}
+		origByName[name] = append(origByName[name], i)
+	}
+	formattedByName := make(map[string][]int)
+	for j, n := range formatted {
+		if n.isElement() {
+			name := n.Space + " " + n.Local
+			formattedByName[name] = append(formattedByName[name], j)
+		}
+	}
+
+	for _, name := range names {
+		is, js := origByName[name], formattedByName[name]
+		if len(is) == len(js) {
+			for k, i := range is {
+				matches[i] = js[k]
+			}
+			continue
+		}
+		refs := []*xmlNode{}
+		for _, i := range is {
+			refs = append(refs, orig[i])
+		}
+		candidates := []*xmlNode{}
+		for _, j := range js {
+			candidates = append(candidates, formatted[j])
+		}
+		for k, match := range matchXMLNodes(refs, candidates) {
+			if match != -1 {
+				matches[is[k]] = js[match]
+			}
+		}
+	}
+	return matches
+}
+
+type preservedXMLChild struct {
+	// Index of the preceding modelled sibling, or -1
+	After int
+	Node  *xmlNode
+}
+
+// preservedXMLElement records the content of an element which
+// was not consumed by the structs, alongside the element as
+// formatted from the structs so it can be found again later
+type preservedXMLElement struct {
+	Node     *xmlNode
+	Attrs    []xmlNodeAttr
+	Text     string
+	Extra    []preservedXMLChild
+	Children []*preservedXMLElement
+}
+
+func (p *preservedXMLElement) isEmpty() bool {
+	if len(p.Attrs) != 0 || p.Text != "" || len(p.Extra) != 0 {
+		return false
+	}
+	for _, child := range p.Children {
+		if !child.isEmpty() {
+			return false
+		}
+	}
+	return true
+}
+
+func preserveXMLNode(orig, formatted *xmlNode) *preservedXMLElement {
+	ret := &preservedXMLElement{
+		Node: formatted,
+	}
+
+	for _, attr := range orig.Attrs {
+		if _, ok := formatted.getAttr(attr.Space, attr.Local); !ok {
+			ret.Attrs = append(ret.Attrs, attr)
+		}
+	}
+
+	if !orig.hasElements() && !formatted.hasElements() && formatted.content() == "" {
+		ret.Text = orig.content()
+	}
+
+	origElements := []*xmlNode{}
+	for _, child := range orig.Children {
+		if child.isElement() {
+			origElements = append(origElements, child)
+		}
+	}
+	matches := matchFormattedXMLNodes(origElements, formatted.Children)
+
+	after := -1
+	idx := 0
+	for _, child := range orig.Children {
+		if child.Comment {
+			ret.Extra = append(ret.Extra, preservedXMLChild{after, child})
+			continue
+		}
+		if !child.isElement() {
+			continue
+		}
+		match := matches[idx]
+		idx++
+		if match == -1 {
+			ret.Extra = append(ret.Extra, preservedXMLChild{after, child})
+			continue
+		}
+		sub := preserveXMLNode(child, formatted.Children[match])
+		ret.Children = append(ret.Children, sub)
+		after = len(ret.Children) - 1
+	}
+
+	return ret
+}
+
+func restoreXMLNode(p *preservedXMLElement, formatted *xmlNode) {
+	for _, attr := range p.Attrs {
+		if _, ok := formatted.getAttr(attr.Space, attr.Local); !ok {
+			formatted.Attrs = append(formatted.Attrs, attr)
+		}
+	}
+
+	if p.Text != "" && formatted.content() == "" && !formatted.hasElements() {
+		formatted.Children = append(formatted.Children, &xmlNode{
+			Text: p.Text,
+		})
+	}
+
+	refs := []*xmlNode{}
+	for _, child := range p.Children {
+		refs = append(refs, child.Node)
+	}
+	matches := matchXMLNodes(refs, formatted.Children)
+	for i, child := range p.Children {
+		if matches[i] != -1 {
+			restoreXMLNode(child, formatted.Children[matches[i]])
+		}
+	}
+
+	if len(p.Extra) == 0 {
+		return
+	}
+
+	// Work out which formatted child each unmodelled child
+	// must follow, falling back to earlier siblings if the
+	// anchor itself has since been removed
+	before := make(map[int][]*xmlNode)
+	for _, extra := range p.Extra {
+		anchor := -1
+		for after := extra.After; after >= 0; after-- {
+			if matches[after] != -1 {
+				anchor = matches[after]
+				break
+			}
+		}
+		before[anchor] = append(before[anchor], extra.Node)
+	}
+
+	children := append([]*xmlNode{}, before[-1]...)
+	for i, child := range formatted.Children {
+		children = append(children, child)
+		children = append(children, before[i]...)
+	}
+	formatted.Children = children
+}
+
+// PreservingDocument wraps a Document so that any XML elements or
+// attributes the structs do not model are kept when unmarshalling
+// and written back in place when marshalling again. The wrapped
+// Document may be freely modified in between.
+type PreservingDocument struct {
+	Document Document
+
+	preserved *preservedXMLElement
+}
+
+func NewPreservingDocument(doc Document) *PreservingDocument {
+	return &PreservingDocument{
+		Document: doc,
+	}
+}
+
+func (p *PreservingDocument) Unmarshal(doc string) error {
+	p.preserved = nil
+	err := p.Document.Unmarshal(doc)
+	if err != nil {
+		return err
+	}
+
+	formatted, err := p.Document.Marshal()
+	if err != nil {
+		return err
+	}
+
+	origRoot, err := parseXMLNode(doc)
+	if err != nil {
+		return err
+	}
+	formattedRoot, err := parseXMLNode(formatted)
+	if err != nil {
+		return err
+	}
+	if matchXMLNode(origRoot, formattedRoot) == -1 {
+		return nil
+	}
+
+	p.preserved = preserveXMLNode(origRoot, formattedRoot)
+	return nil
+}
+
+func (p *PreservingDocument) Marshal() (string, error) {
+	doc, err := p.Document.Marshal()
+	if err != nil {
+		return "", err
+	}
+	if p.preserved == nil || p.preserved.isEmpty() {
+		return doc, nil
+	}
+
+	root, err := parseXMLNode(doc)
+	if err != nil {
+		return "", err
+	}
+	if matchXMLNode(p.preserved.Node, root) == -1 {
+		return doc, nil
+	}
+	restoreXMLNode(p.preserved, root)
+
+	return formatXMLNode(root)
+}
@@ -0,0 +1,244 @@
+/*
+ * This file is part of the libvirt-go-xml project
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ *
+ * Copyright (C) 2020 Red Hat, Inc.
+ *
+ */
+
+package libvirtxml
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var preservingDocumentTestData = []struct {
+	Object   Document
+	Input    []string
+	Modify   func(doc Document)
+	Expected []string
+}{
+	{
+		Object: &Domain{},
+		Input: []string{
+			`<domain type="kvm" newattr="yes">`,
+			`  <name>demo</name>`,
+			`  <newelement mode="fast">`,
+			`    <child></child>`,
+			`  </newelement>`,
+			`  <memory unit="KiB">1048576</memory>`,
+			`  <devices>`,
+			`    <disk type="file" device="disk" newdiskattr="1">`,
+			`      <source file="/var/lib/libvirt/images/a.img"></source>`,
+			`      <target dev="vda" bus="virtio"></target>`,
+			`      <newdiskelement>a</newdiskelement>`,
+			`    </disk>`,
+			`    <disk type="file" device="disk">`,
+			`      <source file="/var/lib/libvirt/images/b.img"></source>`,
+			`      <target dev="vdb" bus="virtio"></target>`,
+			`      <newdiskelement>b</newdiskelement>`,
+			`    </disk>`,
+			`  </devices>`,
+			`</domain>`,
+		},
+		Modify: func(doc Document) {
+			dom := doc.(*Domain)
+			dom.Name = "renamed"
+			dom.Devices.Disks = dom.Devices.Disks[1:]
+		},
+		Expected: []string{
+			`<domain type="kvm" newattr="yes">`,
+			`  <name>renamed</name>`,
+			`  <newelement mode="fast">`,
+			`    <child></child>`,
+			`  </newelement>`,
+			`  <memory unit="KiB">1048576</memory>`,
+			`  <devices>`,
+			`    <disk type="file" device="disk">`,
+			`      <source file="/var/lib/libvirt/images/b.img"></source>`,
+			`      <target dev="vdb" bus="virtio"></target>`,
+			`      <newdiskelement>b</newdiskelement>`,
+			`    </disk>`,
+			`  </devices>`,
+			`</domain>`,
+		},
+	},
+	{
+		Object: &Domain{},
+		Input: []string{
+			`<domain type="kvm">`,
+			`  <name>demo</name>`,
+			`  <devices>`,
+			`    <disk type="file" device="disk" secret="x">`,
+			`      <source file="/var/lib/libvirt/images/a.img"></source>`,
+			`      <target dev="vda" bus="virtio"></target>`,
+			`      <newdiskelement>a</newdiskelement>`,
+			`    </disk>`,
+			`  </devices>`,
+			`</domain>`,
+		},
+		Modify: func(doc Document) {
+			dom := doc.(*Domain)
+			dom.Devices.Disks = []DomainDisk{
+				DomainDisk{
+					Device: "disk",
+					Source: &DomainDiskSource{
+						File: &DomainDiskSourceFile{
+							File: "/new.img",
+						},
+					},
+					Target: &DomainDiskTarget{
+						Dev: "vdz",
+						Bus: "virtio",
+					},
+				},
+			}
+		},
+		Expected: []string{
+			`<domain type="kvm">`,
+			`  <name>demo</name>`,
+			`  <devices>`,
+			`    <disk type="file" device="disk">`,
+			`      <source file="/new.img"></source>`,
+			`      <target dev="vdz" bus="virtio"></target>`,
+			`    </disk>`,
+			`  </devices>`,
+			`</domain>`,
+		},
+	},
+	{
+		Object: &NodeDevice{},
+		Input: []string{
+			`<device>`,
+			`  <name>pci_0000_00_02_0</name>`,
+			`  <capability type="pci">`,
+			`    <domain>0</domain>`,
+			`    <bus>0</bus>`,
+			`    <slot>2</slot>`,
+			`    <function>0</function>`,
+			`    <product id="0x1234"></product>`,
+			`    <vendor id="0x8086"></vendor>`,
+			`    <iommuGroup number="1">`,
+			`      <address domain="0" bus="0" slot="2" function="0"></address>`,
+			`      <address domain="0" bus="0" slot="2" function="1" newattr="x"></address>`,
+			`    </iommuGroup>`,
+			`  </capability>`,
+			`</device>`,
+		},
+		Expected: []string{
+			`<device>`,
+			`  <name>pci_0000_00_02_0</name>`,
+			`  <capability type="pci">`,
+			`    <domain>0</domain>`,
+			`    <bus>0</bus>`,
+			`    <slot>2</slot>`,
+			`    <function>0</function>`,
+			`    <product id="0x1234"></product>`,
+			`    <vendor id="0x8086"></vendor>`,
+			`    <iommuGroup number="1">`,
+			`      <address domain="0x0000" bus="0x00" slot="0x02" function="0x0"></address>`,
+			`      <address domain="0x0000" bus="0x00" slot="0x02" function="0x1" newattr="x"></address>`,
+			`    </iommuGroup>`,
+			`  </capability>`,
+			`</device>`,
+		},
+	},
+	{
+		Object: &Network{},
+		Input: []string{
+			`<network xmlns:ext="http://example.org/ext">`,
+			`  <name>default</name>`,
+			`  <ext:tag value="1"></ext:tag>`,
+			`  <bridge name="virbr0" ext:owner="me"></bridge>`,
+			`</network>`,
+		},
+		Expected: []string{
+			`<network xmlns:ext="http://example.org/ext">`,
+			`  <name>default</name>`,
+			`  <ext:tag value="1"></ext:tag>`,
+			`  <bridge name="virbr0" ext:owner="me"></bridge>`,
+			`</network>`,
+		},
+	},
+	{
+		Object: &Secret{},
+		Input: []string{
+			`<secret ephemeral="no" private="yes">`,
+			`  <uuid>c1f11a6d-8c5d-4a3e-ac7a-4e171c5e0d4a</uuid>`,
+			`</secret>`,
+		},
+		Expected: []string{
+			`<secret ephemeral="no" private="yes">`,
+			`  <uuid>c1f11a6d-8c5d-4a3e-ac7a-4e171c5e0d4a</uuid>`,
+			`</secret>`,
+		},
+	},
+}
+
+func TestPreservingDocument(t *testing.T) {
+	for _, test := range preservingDocumentTestData {
+		doc := NewPreservingDocument(test.Object)
+
+		err := doc.Unmarshal(strings.Join(test.Input, "\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if test.Modify != nil {
+			test.Modify(test.Object)
+		}
+
+		actual, err := doc.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expect := strings.Join(test.Expected, "\n")
+		if actual != expect {
+			t.Fatal("Bad xml:\n", actual, "\n does not match\n", expect, "\n")
+		}
+	}
+}
+
+func TestPreservingDocumentUnchanged(t *testing.T) {
+	for _, test := range domainTestData {
+		expect, err := test.Object.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		typ := reflect.ValueOf(test.Object).Elem().Type()
+		doc := NewPreservingDocument(reflect.New(typ).Interface().(Document))
+		err = doc.Unmarshal(expect)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		actual, err := doc.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual != expect {
+			t.Fatal("Bad xml:\n", actual, "\n does not match\n", expect, "\n")
+		}
+	}
+}
@@ -0,0 +1,303 @@
+/*
+ * This file is part of the libvirt-go-xml project
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ *
+ * Copyright (C) 2020 Red Hat, Inc.
+ *
+ */
+
+package libvirtxml
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Size is a quantity of memory or storage in bytes
+type Size uint64
+
+const (
+	Byte     Size = 1
+	KiloByte Size = 1000
+	KibiByte Size = 1024
+	MegaByte Size = 1000 * KiloByte
+	MebiByte Size = 1024 * KibiByte
+	GigaByte Size = 1000 * MegaByte
+	GibiByte Size = 1024 * MebiByte
+	TeraByte Size = 1000 * GigaByte
+	TebiByte Size = 1024 * GibiByte
+	PetaByte Size = 1000 * TeraByte
+	PebiByte Size = 1024 * TebiByte
+	ExaByte  Size = 1000 * PetaByte
+	ExbiByte Size = 1024 * PebiByte
+)
+
+// Returns the number of bytes in a unit, accepting every unit
+// libvirt does. As with libvirt, a bare letter or a letter
+// followed by "iB" is a power of 1024 while a letter followed
+// by "B" is a power of 1000, and case is not significant. An
+// empty unit means bytes.
+func sizeUnitScale(unit string) (Size, error) {
+	lower := strings.ToLower(unit)
+	switch lower {
+	case "", "b", "byte", "bytes":
+		return Byte, nil
+	}
+
+	var binary, decimal Size
+	switch lower[0] {
+	case 'k':
+		binary, decimal = KibiByte, KiloByte
+	case 'm':
+		binary, decimal = MebiByte, MegaByte
+	case 'g':
+		binary, decimal = GibiByte, GigaByte
+	case 't':
+		binary, decimal = TebiByte, TeraByte
+	case 'p':
+		binary, decimal = PebiByte, PetaByte
+	case 'e':
+		binary, decimal = ExbiByte, ExaByte
+	default:
+		return 0, fmt.Errorf("unknown size unit '%s'", unit)
+	}
+	switch lower[1:] {
+	case "", "ib":
+		return binary, nil
+	case "b":
+		return decimal, nil
+	}
+	return 0, fmt.Errorf("unknown size unit '%s'", unit)
+}
+
+// NewSize returns the size of a value in the given unit, failing
+// if the unit is unknown or the size does not fit in 64 bits
+func NewSize(value uint64, unit string) (Size, error) {
+	scale, err := sizeUnitScale(unit)
+	if err != nil {
+		return 0, err
+	}
+	if value > math.MaxUint64/uint64(scale) {
+		return 0, fmt.Errorf("size %d%s is too large", value, unit)
+	}
+	return Size(value) * scale, nil
+}
+
+// ParseSize parses a value optionally followed by a unit,
+// such as "512", "4GiB" or "1 TB"
+func ParseSize(size string) (Size, error) {
+	size = strings.TrimSpace(size)
+	end := 0
+	for end < len(size) && size[end] >= '0' && size[end] <= '9' {
+		end++
+	}
+	value, err := strconv.ParseUint(size[:end], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size '%s'", size)
+	}
+	return NewSize(value, strings.TrimSpace(size[end:]))
+}
+
+// Bytes returns the size in bytes
+func (s Size) Bytes() uint64 {
+	return uint64(s)
+}
+
+// In returns the size in the given unit, rounded down
+func (s Size) In(unit string) (uint64, error) {
+	scale, err := sizeUnitScale(unit)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(s / scale), nil
+}
+
+var sizeBinaryUnits = []struct {
+	unit  string
+	scale Size
+}{
+	{"EiB", ExbiByte},
+	{"PiB", PebiByte},
+	{"TiB", TebiByte},
+	{"GiB", GibiByte},
+	{"MiB", MebiByte},
+	{"KiB", KibiByte},
+}
+
+// String formats the size in the largest binary unit it is an
+// exact multiple of, such as "4GiB" or "1536KiB"
+func (s Size) String() string {
+	if s != 0 {
+		for _, unit := range sizeBinaryUnits {
+			if s%unit.scale == 0 {
+				return fmt.Sprintf("%d%s", s/unit.scale, unit.unit)
+			}
+		}
+	}
+	return fmt.Sprintf("%db", uint64(s))
+}
+
+// Picks the value and unit to store a size in a field which can
+// hold values up to max. The preferred unit is used if the size is
+// an exact multiple of it, otherwise the smallest exact binary unit
+// which fits.
+func (s Size) valueAndUnit(preferred string, max uint64) (uint64, string) {
+	scale, err := sizeUnitScale(preferred)
+	if err == nil && s%scale == 0 && uint64(s/scale) <= max {
+		return uint64(s / scale), preferred
+	}
+	if uint64(s) <= max {
+		return uint64(s), "b"
+	}
+	for i := len(sizeBinaryUnits) - 1; i >= 0; i-- {
+		unit := sizeBinaryUnits[i]
+		if s%unit.scale == 0 && uint64(s/unit.scale) <= max {
+			return uint64(s / unit.scale), unit.unit
+		}
+	}
+	return uint64(s / ExbiByte), "EiB"
+}
+
+func sizeWithDefaultUnit(value uint64, unit, defaultUnit string) (Size, error) {
+	if unit == "" {
+		unit = defaultUnit
+	}
+	return NewSize(value, unit)
+}
+
+const maxUint = uint64(^uint(0))
+
+// AsSize returns the memory size, which defaults to KiB
+func (m *DomainMemory) AsSize() (Size, error) {
+	return sizeWithDefaultUnit(uint64(m.Value), m.Unit, "KiB")
+}
+
+// SetSize stores the memory size, in KiB if it is a multiple of it
+func (m *DomainMemory) SetSize(size Size) {
+	value, unit := size.valueAndUnit("KiB", maxUint)
+	m.Value, m.Unit = uint(value), unit
+}
+
+// AsSize returns the memory size, which defaults to KiB
+func (m *DomainCurrentMemory) AsSize() (Size, error) {
+	return sizeWithDefaultUnit(uint64(m.Value), m.Unit, "KiB")
+}
+
+// SetSize stores the memory size, in KiB if it is a multiple of it
+func (m *DomainCurrentMemory) SetSize(size Size) {
+	value, unit := size.valueAndUnit("KiB", maxUint)
+	m.Value, m.Unit = uint(value), unit
+}
+
+// AsSize returns the memory size, which defaults to KiB
+func (m *DomainMaxMemory) AsSize() (Size, error) {
+	return sizeWithDefaultUnit(uint64(m.Value), m.Unit, "KiB")
+}
+
+// SetSize stores the memory size, in KiB if it is a multiple of it
+func (m *DomainMaxMemory) SetSize(size Size) {
+	value, unit := size.valueAndUnit("KiB", maxUint)
+	m.Value, m.Unit = uint(value), unit
+}
+
+// AsSize returns the limit, which defaults to KiB
+func (l *DomainMemoryTuneLimit) AsSize() (Size, error) {
+	return sizeWithDefaultUnit(l.Value, l.Unit, "KiB")
+}
+
+// SetSize stores the limit, in KiB if it is a multiple of it
+func (l *DomainMemoryTuneLimit) SetSize(size Size) {
+	l.Value, l.Unit = size.valueAndUnit("KiB", math.MaxUint64)
+}
+
+// AsSize returns the page size, which defaults to KiB
+func (p *DomainMemoryHugepage) AsSize() (Size, error) {
+	return sizeWithDefaultUnit(uint64(p.Size), p.Unit, "KiB")
+}
+
+// SetSize stores the page size, in KiB if it is a multiple of it
+func (p *DomainMemoryHugepage) SetSize(size Size) {
+	value, unit := size.valueAndUnit("KiB", maxUint)
+	p.Size, p.Unit = uint(value), unit
+}
+
+// AsSize returns the size, which defaults to KiB
+func (s *DomainMemorydevTargetSize) AsSize() (Size, error) {
+	return sizeWithDefaultUnit(uint64(s.Value), s.Unit, "KiB")
+}
+
+// SetSize stores the size, in KiB if it is a multiple of it
+func (s *DomainMemorydevTargetSize) SetSize(size Size) {
+	value, unit := size.valueAndUnit("KiB", maxUint)
+	s.Value, s.Unit = uint(value), unit
+}
+
+// AsSize returns the page size, which defaults to KiB
+func (p *DomainMemorydevSourcePagesize) AsSize() (Size, error) {
+	return sizeWithDefaultUnit(p.Value, p.Unit, "KiB")
+}
+
+// SetSize stores the page size, in KiB if it is a multiple of it
+func (p *DomainMemorydevSourcePagesize) SetSize(size Size) {
+	p.Value, p.Unit = size.valueAndUnit("KiB", math.MaxUint64)
+}
+
+// AsSize returns the size, which defaults to bytes
+func (s *StorageVolumeSize) AsSize() (Size, error) {
+	return sizeWithDefaultUnit(s.Value, s.Unit, "bytes")
+}
+
+// SetSize stores the size in bytes
+func (s *StorageVolumeSize) SetSize(size Size) {
+	s.Value, s.Unit = size.valueAndUnit("bytes", math.MaxUint64)
+}
+
+// AsSize returns the size, which defaults to bytes
+func (s *StoragePoolSize) AsSize() (Size, error) {
+	return sizeWithDefaultUnit(s.Value, s.Unit, "bytes")
+}
+
+// SetSize stores the size in bytes
+func (s *StoragePoolSize) SetSize(size Size) {
+	s.Value, s.Unit = size.valueAndUnit("bytes", math.MaxUint64)
+}
+
+// AsSize returns the page size, which defaults to KiB
+func (p *CapsHostCPUPageSize) AsSize() (Size, error) {
+	if p.Size < 0 {
+		return 0, fmt.Errorf("invalid page size %d", p.Size)
+	}
+	return sizeWithDefaultUnit(uint64(p.Size), p.Unit, "KiB")
+}
+
+// AsSize returns the page size, which defaults to KiB
+func (p *CapsHostNUMAPageInfo) AsSize() (Size, error) {
+	if p.Size < 0 {
+		return 0, fmt.Errorf("invalid page size %d", p.Size)
+	}
+	return sizeWithDefaultUnit(uint64(p.Size), p.Unit, "KiB")
+}
+
+// AsSize returns the memory size, which defaults to KiB
+func (m *CapsHostNUMAMemory) AsSize() (Size, error) {
+	return sizeWithDefaultUnit(m.Size, m.Unit, "KiB")
+}
@@ -0,0 +1,155 @@
+/*
+ * This file is part of the libvirt-go-xml project
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ *
+ * Copyright (C) 2020 Red Hat, Inc.
+ *
+ */
+
+package libvirtxml
+
+import (
+	"testing"
+)
+
+var sizeParseTestData = []struct {
+	Input    string
+	Expected Size
+	Valid    bool
+}{
+	{"512", 512, true},
+	{"512b", 512, true},
+	{"2 bytes", 2, true},
+	{"4k", 4096, true},
+	{"4KiB", 4096, true},
+	{"4kib", 4096, true},
+	{"4KB", 4000, true},
+	{"1M", 1048576, true},
+	{"1MB", 1000000, true},
+	{"2G", 2147483648, true},
+	{"2GB", 2000000000, true},
+	{"1T", 1099511627776, true},
+	{"1TB", 1000000000000, true},
+	{"1PiB", 1125899906842624, true},
+	{"15EiB", 15 * ExbiByte, true},
+	{"16EiB", 0, false},
+	{"18446744073709551615", 18446744073709551615, true},
+	{"18446744073709551616", 0, false},
+	{"4Q", 0, false},
+	{"4KiBB", 0, false},
+	{"GiB", 0, false},
+}
+
+func TestParseSize(t *testing.T) {
+	for _, test := range sizeParseTestData {
+		size, err := ParseSize(test.Input)
+		if !test.Valid {
+			if err == nil {
+				t.Fatalf("Expected error parsing %s, got %d", test.Input, size)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size != test.Expected {
+			t.Fatalf("Expected %d for %s, got %d", test.Expected, test.Input, size)
+		}
+	}
+}
+
+func TestSizeFormat(t *testing.T) {
+	for size, expected := range map[Size]string{
+		0:                "0b",
+		1000:             "1000b",
+		1536 * KibiByte:  "1536KiB",
+		4 * GibiByte:     "4GiB",
+		3 * ExbiByte:     "3EiB",
+		GigaByte + 1:     "1000000001b",
+		1024 * TebiByte:  "1PiB",
+		MebiByte + 1024:  "1025KiB",
+		TebiByte + Byte:  "1099511627777b",
+		TebiByte * 1023:  "1023TiB",
+		GibiByte * 10240: "10TiB",
+	} {
+		if size.String() != expected {
+			t.Fatalf("Expected %s, got %s", expected, size.String())
+		}
+	}
+
+	gib, err := (4 * GibiByte).In("M")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gib != 4096 {
+		t.Fatalf("Expected 4096 MiB, got %d", gib)
+	}
+}
+
+func TestSizeAccessors(t *testing.T) {
+	mem := DomainMemory{
+		Value: 4,
+		Unit:  "GiB",
+	}
+	memSize, err := mem.AsSize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vol := StorageVolumeSize{
+		Value: 4294967296,
+	}
+	volSize, err := vol.AsSize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if memSize != volSize {
+		t.Fatalf("Expected equal sizes, got %s and %s", memSize, volSize)
+	}
+
+	mem.SetSize(2 * GibiByte)
+	if mem.Value != 2097152 || mem.Unit != "KiB" {
+		t.Fatalf("Unexpected memory %d%s", mem.Value, mem.Unit)
+	}
+	mem.SetSize(1000)
+	if mem.Value != 1000 || mem.Unit != "b" {
+		t.Fatalf("Unexpected memory %d%s", mem.Value, mem.Unit)
+	}
+
+	page := DomainMemoryHugepage{
+		Size: 2048,
+	}
+	pageSize, err := page.AsSize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pageSize != 2*MebiByte {
+		t.Fatalf("Unexpected page size %s", pageSize)
+	}
+
+	limit := DomainMemoryTuneLimit{
+		Value: 18014398509481984,
+		Unit:  "KiB",
+	}
+	_, err = limit.AsSize()
+	if err == nil {
+		t.Fatal("Expected overflow error")
+	}
+}
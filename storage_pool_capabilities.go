@@ -0,0 +1,78 @@
+/*
+ * This file is part of the libvirt-go-xml project
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ *
+ * Copyright (C) 2020 Red Hat, Inc.
+ *
+ */
+
+package libvirtxml
+
+import "encoding/xml"
+
+type StoragePoolCapsDefaultFormat struct {
+	Type string `xml:"type,attr"`
+}
+
+type StoragePoolCapsOptions struct {
+	DefaultFormat *StoragePoolCapsDefaultFormat `xml:"defaultFormat"`
+	Enums         []DomainCapsEnum              `xml:"enum"`
+}
+
+type StoragePoolCapsPool struct {
+	Type        string                  `xml:"type,attr"`
+	Supported   string                  `xml:"supported,attr"`
+	PoolOptions *StoragePoolCapsOptions `xml:"poolOptions"`
+	VolOptions  *StoragePoolCapsOptions `xml:"volOptions"`
+}
+
+type StoragePoolCaps struct {
+	XMLName xml.Name              `xml:"storagepoolCapabilities"`
+	Pools   []StoragePoolCapsPool `xml:"pool"`
+}
+
+type StoragePoolSources struct {
+	XMLName xml.Name            `xml:"sources"`
+	Sources []StoragePoolSource `xml:"source"`
+}
+
+func (c *StoragePoolCaps) Unmarshal(doc string) error {
+	return xml.Unmarshal([]byte(doc), c)
+}
+
+func (c *StoragePoolCaps) Marshal() (string, error) {
+	doc, err := xml.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(doc), nil
+}
+
+func (s *StoragePoolSources) Unmarshal(doc string) error {
+	return xml.Unmarshal([]byte(doc), s)
+}
+
+func (s *StoragePoolSources) Marshal() (string, error) {
+	doc, err := xml.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(doc), nil
+}
@@ -0,0 +1,161 @@
+/*
+ * This file is part of the libvirt-go-xml project
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ *
+ * Copyright (C) 2020 Red Hat, Inc.
+ *
+ */
+
+package libvirtxml
+
+import (
+	"strings"
+	"testing"
+)
+
+var storagePoolCapsTestData = []struct {
+	Object   Document
+	Expected []string
+}{
+	{
+		Object: &StoragePoolCaps{
+			Pools: []StoragePoolCapsPool{
+				StoragePoolCapsPool{
+					Type:      "dir",
+					Supported: "yes",
+					VolOptions: &StoragePoolCapsOptions{
+						DefaultFormat: &StoragePoolCapsDefaultFormat{
+							Type: "raw",
+						},
+						Enums: []DomainCapsEnum{
+							DomainCapsEnum{
+								Name:   "targetFormatType",
+								Values: []string{"none", "raw", "qcow2"},
+							},
+						},
+					},
+				},
+				StoragePoolCapsPool{
+					Type:      "fs",
+					Supported: "yes",
+					PoolOptions: &StoragePoolCapsOptions{
+						DefaultFormat: &StoragePoolCapsDefaultFormat{
+							Type: "auto",
+						},
+						Enums: []DomainCapsEnum{
+							DomainCapsEnum{
+								Name:   "sourceFormatType",
+								Values: []string{"auto", "ext4", "xfs"},
+							},
+						},
+					},
+				},
+				StoragePoolCapsPool{
+					Type:      "zfs",
+					Supported: "no",
+				},
+			},
+		},
+		Expected: []string{
+			`<storagepoolCapabilities>`,
+			`  <pool type="dir" supported="yes">`,
+			`    <volOptions>`,
+			`      <defaultFormat type="raw"></defaultFormat>`,
+			`      <enum name="targetFormatType">`,
+			`        <value>none</value>`,
+			`        <value>raw</value>`,
+			`        <value>qcow2</value>`,
+			`      </enum>`,
+			`    </volOptions>`,
+			`  </pool>`,
+			`  <pool type="fs" supported="yes">`,
+			`    <poolOptions>`,
+			`      <defaultFormat type="auto"></defaultFormat>`,
+			`      <enum name="sourceFormatType">`,
+			`        <value>auto</value>`,
+			`        <value>ext4</value>`,
+			`        <value>xfs</value>`,
+			`      </enum>`,
+			`    </poolOptions>`,
+			`  </pool>`,
+			`  <pool type="zfs" supported="no"></pool>`,
+			`</storagepoolCapabilities>`,
+		},
+	},
+	{
+		Object: &StoragePoolSources{
+			Sources: []StoragePoolSource{
+				StoragePoolSource{
+					Host: []StoragePoolSourceHost{
+						StoragePoolSourceHost{
+							Name: "nfs.example.com",
+						},
+					},
+					Dir: &StoragePoolSourceDir{
+						Path: "/export/images",
+					},
+					Format: &StoragePoolSourceFormat{
+						Type: "nfs",
+					},
+				},
+				StoragePoolSource{
+					Name: "vg_data",
+					Device: []StoragePoolSourceDevice{
+						StoragePoolSourceDevice{
+							Path: "/dev/sdb1",
+						},
+					},
+					Format: &StoragePoolSourceFormat{
+						Type: "lvm2",
+					},
+				},
+			},
+		},
+		Expected: []string{
+			`<sources>`,
+			`  <source>`,
+			`    <dir path="/export/images"></dir>`,
+			`    <host name="nfs.example.com"></host>`,
+			`    <format type="nfs"></format>`,
+			`  </source>`,
+			`  <source>`,
+			`    <name>vg_data</name>`,
+			`    <device path="/dev/sdb1"></device>`,
+			`    <format type="lvm2"></format>`,
+			`  </source>`,
+			`</sources>`,
+		},
+	},
+}
+
+func TestStoragePoolCaps(t *testing.T) {
+	for _, test := range storagePoolCapsTestData {
+		doc, err := test.Object.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expect := strings.Join(test.Expected, "\n")
+
+		if doc != expect {
+			t.Fatal("Bad xml:\n", string(doc), "\n does not match\n", expect, "\n")
+		}
+	}
+}
@@ -0,0 +1,126 @@
+/*
+ * This file is part of the libvirt-go-xml project
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ *
+ * Copyright (C) 2020 Red Hat, Inc.
+ *
+ */
+
+package libvirtxml
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+// UnmodelledContentError lists the XML elements and attributes in a
+// document which were not consumed by any struct field. Paths are in
+// the form "/domain[0]/devices[0]/disk[1]/@foo".
+type UnmodelledContentError struct {
+	Paths []string
+}
+
+func (e *UnmodelledContentError) Error() string {
+	return fmt.Sprintf("XML document has unmodelled content: %s",
+		strings.Join(e.Paths, ", "))
+}
+
+func unmodelledXMLPaths(orig, formatted *xmlNode, path string) []string {
+	paths := []string{}
+
+	for _, attr := range orig.Attrs {
+		if attr.Space == "xmlns" {
+			continue
+		}
+		if _, ok := formatted.getAttr(attr.Space, attr.Local); !ok {
+			paths = append(paths, path+"/@"+xmlName(attr.Space, xml.Name{Local: attr.Local}))
+		}
+	}
+
+	if !orig.hasElements() && !formatted.hasElements() &&
+		formatted.content() == "" && orig.content() != "" {
+		paths = append(paths, path+"/text()")
+	}
+
+	origElements := []*xmlNode{}
+	for _, child := range orig.Children {
+		if child.isElement() {
+			origElements = append(origElements, child)
+		}
+	}
+	matches := matchFormattedXMLNodes(origElements, formatted.Children)
+
+	indexes := make(map[string]uint)
+	for i, child := range origElements {
+		name := xmlName(child.Space, xml.Name{Local: child.Local})
+		index := indexes[name]
+		indexes[name] = index + 1
+		subPath := fmt.Sprintf("%s/%s[%d]", path, name, index)
+
+		if matches[i] == -1 {
+			paths = append(paths, subPath)
+			continue
+		}
+		paths = append(paths, unmodelledXMLPaths(child, formatted.Children[matches[i]], subPath)...)
+	}
+
+	return paths
+}
+
+// UnmarshalStrict parses doc into obj like obj.Unmarshal, but fails
+// with an *UnmodelledContentError if any part of doc would be
+// silently dropped because no struct field models it. obj is
+// populated even when this error is returned.
+func UnmarshalStrict(obj Document, doc string) error {
+	err := obj.Unmarshal(doc)
+	if err != nil {
+		return err
+	}
+
+	formatted, err := obj.Marshal()
+	if err != nil {
+		return err
+	}
+
+	origRoot, err := parseXMLNode(doc)
+	if err != nil {
+		return err
+	}
+	formattedRoot, err := parseXMLNode(formatted)
+	if err != nil {
+		return err
+	}
+
+	rootName := xmlName(origRoot.Space, xml.Name{Local: origRoot.Local})
+	if matchXMLNode(origRoot, formattedRoot) == -1 {
+		return &UnmodelledContentError{
+			Paths: []string{"/" + rootName + "[0]"},
+		}
+	}
+
+	paths := unmodelledXMLPaths(origRoot, formattedRoot, "/"+rootName+"[0]")
+	if len(paths) != 0 {
+		return &UnmodelledContentError{
+			Paths: paths,
+		}
+	}
+	return nil
+}
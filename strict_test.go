@@ -0,0 +1,161 @@
+/*
+ * This file is part of the libvirt-go-xml project
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ *
+ * Copyright (C) 2020 Red Hat, Inc.
+ *
+ */
+
+package libvirtxml
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var unmarshalStrictTestData = []struct {
+	Object Document
+	XML    []string
+	Paths  []string
+}{
+	{
+		Object: &Domain{},
+		XML: []string{
+			`<domain type="kvm">`,
+			`  <name>demo</name>`,
+			`  <devices>`,
+			`    <disk type="file" device="disk">`,
+			`      <target dev="vda"></target>`,
+			`    </disk>`,
+			`    <disk type="file" device="disk" foo="bar">`,
+			`      <target dev="vdb"></target>`,
+			`      <wibble></wibble>`,
+			`    </disk>`,
+			`  </devices>`,
+			`</domain>`,
+		},
+		Paths: []string{
+			"/domain[0]/devices[0]/disk[1]/@foo",
+			"/domain[0]/devices[0]/disk[1]/wibble[0]",
+		},
+	},
+	{
+		Object: &StorageVolume{},
+		XML: []string{
+			`<volume xmlns:ext="http://example.org/ext">`,
+			`  <name>vol</name>`,
+			`  <source></source>`,
+			`  <target ext:mode="1">`,
+			`    <path>/some/path</path>`,
+			`  </target>`,
+			`</volume>`,
+		},
+		Paths: []string{
+			"/volume[0]/source[0]",
+			"/volume[0]/target[0]/@mode(http://example.org/ext)",
+		},
+	},
+	{
+		Object: &Domain{},
+		XML: []string{
+			`<domain type="kvm">`,
+			`  <name>demo</name>`,
+			`  <devices>`,
+			`    <controller type="usb" index="0">`,
+			`      <address type="pci" domain="0" bus="0" slot="1" function="2"></address>`,
+			`    </controller>`,
+			`    <controller type="pci" index="0" model="pci-root"></controller>`,
+			`  </devices>`,
+			`</domain>`,
+		},
+	},
+	{
+		Object: &NodeDevice{},
+		XML: []string{
+			`<device>`,
+			`  <name>pci_0000_00_02_0</name>`,
+			`  <capability type="pci">`,
+			`    <domain>0</domain>`,
+			`    <bus>0</bus>`,
+			`    <slot>2</slot>`,
+			`    <function>0</function>`,
+			`    <product id="0x1234"></product>`,
+			`    <vendor id="0x8086"></vendor>`,
+			`    <iommuGroup number="1">`,
+			`      <address domain="0" bus="0" slot="2" function="0"></address>`,
+			`      <address domain="0" bus="0" slot="2" function="0"></address>`,
+			`    </iommuGroup>`,
+			`  </capability>`,
+			`</device>`,
+		},
+	},
+	{
+		Object: &NodeDevice{},
+		XML: []string{
+			`<device>`,
+			`  <name>pci_0000_00_02_0</name>`,
+			`  <capability type="pci">`,
+			`    <domain>0</domain>`,
+			`    <bus>0</bus>`,
+			`    <slot>2</slot>`,
+			`    <function>0</function>`,
+			`    <product id="0x1234"></product>`,
+			`    <vendor id="0x8086"></vendor>`,
+			`    <iommuGroup number="1">`,
+			`      <address domain="0" bus="0" slot="2" function="0"></address>`,
+			`      <address domain="0" bus="0" slot="2" function="1" foo="bar"></address>`,
+			`    </iommuGroup>`,
+			`  </capability>`,
+			`</device>`,
+		},
+		Paths: []string{
+			"/device[0]/capability[0]/iommuGroup[0]/address[1]/@foo",
+		},
+	},
+	{
+		Object: &Secret{},
+		XML: []string{
+			`<secret ephemeral="no" private="yes">`,
+			`  <uuid>c1f11a6d-8c5d-4a3e-ac7a-4e171c5e0d4a</uuid>`,
+			`</secret>`,
+		},
+	},
+}
+
+func TestUnmarshalStrict(t *testing.T) {
+	for _, test := range unmarshalStrictTestData {
+		err := UnmarshalStrict(test.Object, strings.Join(test.XML, "\n"))
+		if len(test.Paths) == 0 {
+			if err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+
+		unmodelled, ok := err.(*UnmodelledContentError)
+		if !ok {
+			t.Fatalf("Expected unmodelled content error, got %v", err)
+		}
+		if !reflect.DeepEqual(unmodelled.Paths, test.Paths) {
+			t.Fatalf("Expected paths %v, got %v", test.Paths, unmodelled.Paths)
+		}
+	}
+}
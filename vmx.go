@@ -0,0 +1,873 @@
+/*
+ * This file is part of the libvirt-go-xml project
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ *
+ * Copyright (C) 2020 Red Hat, Inc.
+ *
+ */
+
+package libvirtxml
+
+import (
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The namespace of the element holding the VMware guest OS in
+// the domain metadata, as the domain has nowhere else to keep it.
+// It belongs to this package rather than to libvirt, which has no
+// such element.
+const vmxMetadataNamespace = "http://libvirt.org/libvirt-go-xml/vmx/1.0"
+
+// The entries of a VMX file, whose keys are case insensitive
+type vmxFile struct {
+	values map[string]string
+}
+
+var vmxSCSIModels = map[string]string{
+	"buslogic":   "buslogic",
+	"lsilogic":   "lsilogic",
+	"lsisas1068": "lsisas1068",
+	"pvscsi":     "vmpvscsi",
+}
+
+var vmxNICModels = []string{"vlance", "vmxnet", "vmxnet2", "vmxnet3", "e1000", "e1000e"}
+
+// Values escape quotes, pipes and line breaks as a pipe
+// followed by two hex digits
+func vmxEscape(val string) string {
+	var buf strings.Builder
+	for i := 0; i < len(val); i++ {
+		switch val[i] {
+		case '"', '|', '\n', '\r':
+			fmt.Fprintf(&buf, "|%02X", val[i])
+		default:
+			buf.WriteByte(val[i])
+		}
+	}
+	return buf.String()
+}
+
+func vmxUnescape(val string) string {
+	var buf strings.Builder
+	for i := 0; i < len(val); i++ {
+		if val[i] == '|' && i+2 < len(val) && isHexString(val[i+1:i+3]) {
+			c, _ := strconv.ParseUint(val[i+1:i+3], 16, 8)
+			buf.WriteByte(byte(c))
+			i += 2
+			continue
+		}
+		buf.WriteByte(val[i])
+	}
+	return buf.String()
+}
+
+func parseVMX(doc string) (*vmxFile, error) {
+	v := &vmxFile{values: make(map[string]string)}
+	for n, line := range strings.Split(doc, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		idx := strings.Index(line, "=")
+		if idx < 0 {
+			return nil, fmt.Errorf("missing '=' on line %d of VMX file", n+1)
+		}
+		key := strings.ToLower(strings.TrimSpace(line[:idx]))
+		val := strings.TrimSpace(line[idx+1:])
+		if len(val) >= 2 && val[0] == '"' && val[len(val)-1] == '"' {
+			val = val[1 : len(val)-1]
+		}
+		v.values[key] = vmxUnescape(val)
+	}
+	return v, nil
+}
+
+func (v *vmxFile) get(key string) string {
+	return v.values[strings.ToLower(key)]
+}
+
+func (v *vmxFile) getBool(key string) (bool, error) {
+	val := v.get(key)
+	switch strings.ToLower(val) {
+	case "", "false", "no", "0":
+		return false, nil
+	case "true", "yes", "1":
+		return true, nil
+	}
+	return false, fmt.Errorf("invalid boolean '%s' for VMX entry '%s'", val, key)
+}
+
+func (v *vmxFile) getUint(key string, def uint) (uint, error) {
+	val := v.get(key)
+	if val == "" {
+		return def, nil
+	}
+	n, err := strconv.ParseUint(val, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number '%s' for VMX entry '%s'", val, key)
+	}
+	return uint(n), nil
+}
+
+// Converts between the "56 4d 9b ef ac d9 b4 e0-c8 f0 ae a8 b9 10
+// 35 15" form of a UUID in VMX files and the usual form
+func vmxParseUUID(val string) (string, error) {
+	hex := strings.NewReplacer(" ", "", "-", "").Replace(val)
+	if len(hex) != 32 || !isHexString(hex) {
+		return "", fmt.Errorf("invalid UUID '%s'", val)
+	}
+	hex = strings.ToLower(hex)
+	return hex[0:8] + "-" + hex[8:12] + "-" + hex[12:16] + "-" + hex[16:20] + "-" + hex[20:], nil
+}
+
+func vmxFormatUUID(uuid string) (string, error) {
+	hex := strings.Replace(uuid, "-", "", -1)
+	if len(hex) != 32 || !isHexString(hex) {
+		return "", fmt.Errorf("invalid UUID '%s'", uuid)
+	}
+	octets := []string{}
+	for i := 0; i < 32; i += 2 {
+		octets = append(octets, strings.ToLower(hex[i:i+2]))
+	}
+	return strings.Join(octets[:8], " ") + "-" + strings.Join(octets[8:], " "), nil
+}
+
+// Converts a VMX disk location to the index of the disk name.
+// SCSI unit 7 is reserved for the controller itself.
+func vmxDiskIndex(bus string, controller, unit uint) uint {
+	switch bus {
+	case "scsi":
+		if unit > 7 {
+			unit--
+		}
+		return controller*15 + unit
+	case "sata":
+		return controller*30 + unit
+	case "ide":
+		return controller*2 + unit
+	}
+	return unit
+}
+
+func vmxDiskLocation(bus string, idx uint) (uint, uint, error) {
+	switch bus {
+	case "scsi":
+		unit := idx % 15
+		if unit >= 7 {
+			unit++
+		}
+		if idx/15 > 3 {
+			break
+		}
+		return idx / 15, unit, nil
+	case "sata":
+		if idx/30 > 3 {
+			break
+		}
+		return idx / 30, idx % 30, nil
+	case "ide":
+		if idx > 3 {
+			break
+		}
+		return idx / 2, idx % 2, nil
+	case "fdc":
+		if idx > 1 {
+			break
+		}
+		return 0, idx, nil
+	}
+	return 0, 0, fmt.Errorf("disk index %d on bus '%s' cannot be represented in VMX", idx, bus)
+}
+
+func vmxDriveAddress(bus string, controller, unit uint) *DomainAddress {
+	zero := uint(0)
+	drive := &DomainAddressDrive{Controller: &controller, Bus: &zero, Target: &zero, Unit: &unit}
+	if bus == "ide" {
+		drive.Controller, drive.Bus = &zero, &controller
+	}
+	return &DomainAddress{Drive: drive}
+}
+
+func (v *vmxFile) disk(prefix, bus string, controller, unit uint) (*DomainDisk, error) {
+	present, err := v.getBool(prefix + ".present")
+	if err != nil || !present {
+		return nil, err
+	}
+	fileName := v.get(prefix + ".fileName")
+	disk := &DomainDisk{
+		Target: &DomainDiskTarget{
+			Dev: diskIndexName(diskBusPrefixes[bus], vmxDiskIndex(bus, controller, unit)),
+			Bus: bus,
+		},
+		Address: vmxDriveAddress(bus, controller, unit),
+	}
+
+	if bus == "fdc" {
+		disk.Device = "floppy"
+		switch strings.ToLower(v.get(prefix + ".fileType")) {
+		case "", "file":
+			disk.Source = &DomainDiskSource{File: &DomainDiskSourceFile{File: fileName}}
+		case "device":
+			disk.Source = &DomainDiskSource{Block: &DomainDiskSourceBlock{Dev: fileName}}
+		default:
+			return nil, fmt.Errorf("invalid file type '%s' for VMX entry '%s'", v.get(prefix+".fileType"), prefix)
+		}
+		return disk, nil
+	}
+
+	deviceType := strings.ToLower(v.get(prefix + ".deviceType"))
+	switch deviceType {
+	case "", "disk", "scsi-harddisk", "ata-harddisk":
+		disk.Device = "disk"
+		if !strings.HasSuffix(strings.ToLower(fileName), ".vmdk") {
+			return nil, fmt.Errorf("disk '%s' has an unsupported file name '%s'", prefix, fileName)
+		}
+		disk.Source = &DomainDiskSource{File: &DomainDiskSourceFile{File: fileName}}
+	case "cdrom-image":
+		disk.Device = "cdrom"
+		disk.Source = &DomainDiskSource{File: &DomainDiskSourceFile{File: fileName}}
+	case "cdrom-raw", "atapi-cdrom":
+		disk.Device = "cdrom"
+		if fileName != "" && !strings.EqualFold(fileName, "auto detect") {
+			disk.Source = &DomainDiskSource{Block: &DomainDiskSourceBlock{Dev: fileName}}
+		}
+	default:
+		return nil, fmt.Errorf("invalid device type '%s' for VMX entry '%s'", v.get(prefix+".deviceType"), prefix)
+	}
+
+	switch strings.ToLower(v.get(prefix + ".mode")) {
+	case "independent-nonpersistent", "nonpersistent":
+		disk.Transient = &DomainDiskTransient{}
+	}
+	writeThrough, err := v.getBool(prefix + ".writeThrough")
+	if err != nil {
+		return nil, err
+	}
+	if writeThrough {
+		disk.Driver = &DomainDiskDriver{Cache: "writethrough"}
+	}
+	return disk, nil
+}
+
+func (v *vmxFile) ethernet(prefix string) (*DomainInterface, error) {
+	present, err := v.getBool(prefix + ".present")
+	if err != nil || !present {
+		return nil, err
+	}
+	iface := &DomainInterface{}
+
+	model := strings.ToLower(v.get(prefix + ".virtualDev"))
+	if model != "" {
+		known := false
+		for _, m := range vmxNICModels {
+			known = known || m == model
+		}
+		if !known {
+			return nil, fmt.Errorf("invalid virtual device '%s' for VMX entry '%s'", model, prefix)
+		}
+		iface.Model = &DomainInterfaceModel{Type: model}
+	}
+
+	mac := ""
+	switch strings.ToLower(v.get(prefix + ".addressType")) {
+	case "", "generated", "vpx":
+		mac = v.get(prefix + ".generatedAddress")
+	case "static":
+		mac = v.get(prefix + ".address")
+	default:
+		return nil, fmt.Errorf("invalid address type '%s' for VMX entry '%s'", v.get(prefix+".addressType"), prefix)
+	}
+	if mac != "" {
+		addr, err := ParseMACAddress(mac)
+		if err != nil {
+			return nil, err
+		}
+		iface.MAC = &DomainInterfaceMAC{Address: addr.String()}
+	}
+
+	networkName := v.get(prefix + ".networkName")
+	switch strings.ToLower(v.get(prefix + ".connectionType")) {
+	case "", "bridged":
+	case "custom":
+		vnet := v.get(prefix + ".vnet")
+		if vnet == "" {
+			return nil, fmt.Errorf("missing vnet for custom VMX entry '%s'", prefix)
+		}
+		iface.Target = &DomainInterfaceTarget{Dev: vnet}
+	default:
+		return nil, fmt.Errorf("unsupported connection type '%s' for VMX entry '%s'", v.get(prefix+".connectionType"), prefix)
+	}
+	if networkName == "" {
+		return nil, fmt.Errorf("missing network name for VMX entry '%s'", prefix)
+	}
+	iface.Source = &DomainInterfaceSource{Bridge: &DomainInterfaceSourceBridge{Bridge: networkName}}
+	return iface, nil
+}
+
+// UnmarshalVMX fills the domain from the contents of a VMware
+// .vmx file. Disk file names are kept as written in the file,
+// usually in the "[datastore] directory/disk.vmdk" form. The
+// guest OS only determines the architecture, so the original
+// value is kept in a guestOS element of the domain metadata.
+func (d *Domain) UnmarshalVMX(doc string) error {
+	v, err := parseVMX(doc)
+	if err != nil {
+		return err
+	}
+	if version := v.get("config.version"); version != "8" {
+		return fmt.Errorf("unsupported VMX config.version '%s'", version)
+	}
+	hwVersion, err := v.getUint("virtualHW.version", 0)
+	if err != nil {
+		return err
+	}
+	if hwVersion < 4 {
+		return fmt.Errorf("unsupported VMX virtualHW.version '%s'", v.get("virtualHW.version"))
+	}
+
+	dom := Domain{
+		Type:        "vmware",
+		Name:        v.get("displayName"),
+		Description: v.get("annotation"),
+	}
+	if uuid := v.get("uuid.bios"); uuid != "" {
+		dom.UUID, err = vmxParseUUID(uuid)
+		if err != nil {
+			return err
+		}
+	}
+
+	memsize, err := v.getUint("memsize", 32)
+	if err != nil {
+		return err
+	}
+	memmax, err := v.getUint("sched.mem.max", memsize)
+	if err != nil {
+		return err
+	}
+	if memmax > memsize {
+		memmax = memsize
+	}
+	dom.Memory = &DomainMemory{Value: memsize * 1024, Unit: "KiB"}
+	dom.CurrentMemory = &DomainCurrentMemory{Value: memmax * 1024, Unit: "KiB"}
+
+	vcpus, err := v.getUint("numvcpus", 1)
+	if err != nil {
+		return err
+	}
+	dom.VCPU = &DomainVCPU{Placement: "static", Value: vcpus}
+	if affinity := v.get("sched.cpu.affinity"); affinity != "" && !strings.EqualFold(affinity, "all") {
+		cpus, err := ParseBitmap(affinity)
+		if err != nil {
+			return fmt.Errorf("invalid VMX sched.cpu.affinity '%s': %s", affinity, err)
+		}
+		if uint(cpus.Count()) < vcpus {
+			return fmt.Errorf("VMX sched.cpu.affinity '%s' has fewer CPUs than numvcpus %d", affinity, vcpus)
+		}
+		dom.VCPU.CPUSet = cpus.String()
+	}
+	cores, err := v.getUint("cpuid.coresPerSocket", 0)
+	if err != nil {
+		return err
+	}
+	if cores > 0 {
+		if vcpus%cores != 0 {
+			return fmt.Errorf("numvcpus %d is not a multiple of cpuid.coresPerSocket %d", vcpus, cores)
+		}
+		dom.CPU = &DomainCPU{
+			Topology: &DomainCPUTopology{Sockets: int(vcpus / cores), Cores: int(cores), Threads: 1},
+		}
+	}
+
+	dom.OS = &DomainOS{Type: &DomainOSType{Arch: "i686", Type: "hvm"}}
+	if guestOS := v.get("guestOS"); guestOS != "" {
+		if strings.HasSuffix(strings.ToLower(guestOS), "-64") {
+			dom.OS.Type.Arch = "x86_64"
+		}
+		var buf strings.Builder
+		xml.EscapeText(&buf, []byte(guestOS))
+		dom.Metadata = &DomainMetadata{
+			XML: fmt.Sprintf("<vmx:guestOS xmlns:vmx=\"%s\">%s</vmx:guestOS>",
+				vmxMetadataNamespace, buf.String()),
+		}
+	}
+	switch firmware := strings.ToLower(v.get("firmware")); firmware {
+	case "", "bios":
+	case "efi":
+		dom.OS.Firmware = "efi"
+	default:
+		return fmt.Errorf("unsupported VMX firmware '%s'", firmware)
+	}
+
+	devices := &DomainDeviceList{}
+	for _, bus := range []string{"scsi", "sata"} {
+		units := uint(16)
+		if bus == "sata" {
+			units = 30
+		}
+		for ctrl := uint(0); ctrl < 4; ctrl++ {
+			prefix := fmt.Sprintf("%s%d", bus, ctrl)
+			present, err := v.getBool(prefix + ".present")
+			if err != nil {
+				return err
+			}
+			if !present {
+				continue
+			}
+			index := ctrl
+			controller := DomainController{Type: bus, Index: &index}
+			if bus == "scsi" {
+				if virtualDev := strings.ToLower(v.get(prefix + ".virtualDev")); virtualDev != "" {
+					model, ok := vmxSCSIModels[virtualDev]
+					if !ok {
+						return fmt.Errorf("unsupported SCSI controller '%s' for VMX entry '%s'", virtualDev, prefix)
+					}
+					controller.Model = model
+				}
+			}
+			devices.Controllers = append(devices.Controllers, controller)
+
+			for unit := uint(0); unit < units; unit++ {
+				if bus == "scsi" && unit == 7 {
+					continue
+				}
+				disk, err := v.disk(fmt.Sprintf("%s:%d", prefix, unit), bus, ctrl, unit)
+				if err != nil {
+					return err
+				}
+				if disk != nil {
+					devices.Disks = append(devices.Disks, *disk)
+				}
+			}
+		}
+	}
+	for ideBus := uint(0); ideBus < 2; ideBus++ {
+		for unit := uint(0); unit < 2; unit++ {
+			disk, err := v.disk(fmt.Sprintf("ide%d:%d", ideBus, unit), "ide", ideBus, unit)
+			if err != nil {
+				return err
+			}
+			if disk != nil {
+				devices.Disks = append(devices.Disks, *disk)
+			}
+		}
+	}
+	for unit := uint(0); unit < 2; unit++ {
+		disk, err := v.disk(fmt.Sprintf("floppy%d", unit), "fdc", 0, unit)
+		if err != nil {
+			return err
+		}
+		if disk != nil {
+			devices.Disks = append(devices.Disks, *disk)
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		iface, err := v.ethernet(fmt.Sprintf("ethernet%d", i))
+		if err != nil {
+			return err
+		}
+		if iface != nil {
+			devices.Interfaces = append(devices.Interfaces, *iface)
+		}
+	}
+	dom.Devices = devices
+
+	*d = dom
+	return nil
+}
+
+type vmxWriter struct {
+	buf strings.Builder
+}
+
+func (w *vmxWriter) set(key, format string, args ...interface{}) {
+	fmt.Fprintf(&w.buf, "%s = \"%s\"\n", key, vmxEscape(fmt.Sprintf(format, args...)))
+}
+
+// Returns the bus of a disk and the index of its name
+func vmxDiskTarget(disk *DomainDisk) (string, uint, error) {
+	if disk.Target == nil || disk.Target.Dev == "" {
+		return "", 0, fmt.Errorf("disk has no target")
+	}
+	bus := disk.Target.Bus
+	if bus == "" {
+		for b, prefix := range map[string]string{"ide": "hd", "scsi": "sd", "fdc": "fd"} {
+			if strings.HasPrefix(disk.Target.Dev, prefix) {
+				bus = b
+			}
+		}
+	}
+	idx, ok := diskNameIndex(diskBusPrefixes[bus], disk.Target.Dev)
+	if !ok {
+		return "", 0, fmt.Errorf("disk target '%s' on bus '%s' is not supported in VMX", disk.Target.Dev, bus)
+	}
+	return bus, idx, nil
+}
+
+func (w *vmxWriter) disk(disk *DomainDisk) error {
+	bus, idx, err := vmxDiskTarget(disk)
+	if err != nil {
+		return err
+	}
+	controller, unit, err := vmxDiskLocation(bus, idx)
+	if err != nil {
+		return err
+	}
+	file, dev := "", ""
+	if disk.Source != nil && disk.Source.File != nil {
+		file = disk.Source.File.File
+	} else if disk.Source != nil && disk.Source.Block != nil {
+		dev = disk.Source.Block.Dev
+	} else if disk.Source != nil && (disk.Device != "cdrom" || disk.Source.Network != nil ||
+		disk.Source.Volume != nil || disk.Source.Dir != nil || disk.Source.NVME != nil) {
+		return fmt.Errorf("disk '%s' has a source which is not supported in VMX", disk.Target.Dev)
+	}
+
+	if bus == "fdc" {
+		if disk.Device != "floppy" {
+			return fmt.Errorf("disk '%s' on bus fdc must be a floppy", disk.Target.Dev)
+		}
+		prefix := fmt.Sprintf("floppy%d", unit)
+		w.set(prefix+".present", "true")
+		if dev != "" {
+			w.set(prefix+".fileType", "device")
+			w.set(prefix+".fileName", "%s", dev)
+		} else {
+			w.set(prefix+".fileType", "file")
+			w.set(prefix+".fileName", "%s", file)
+		}
+		return nil
+	}
+
+	var prefix string
+	switch bus {
+	case "scsi", "sata", "ide":
+		prefix = fmt.Sprintf("%s%d:%d", bus, controller, unit)
+	default:
+		return fmt.Errorf("disk bus '%s' is not supported in VMX", bus)
+	}
+	w.set(prefix+".present", "true")
+	switch disk.Device {
+	case "", "disk":
+		if !strings.HasSuffix(strings.ToLower(file), ".vmdk") {
+			return fmt.Errorf("disk '%s' must be a .vmdk file", disk.Target.Dev)
+		}
+		if bus == "scsi" {
+			w.set(prefix+".deviceType", "scsi-hardDisk")
+		} else {
+			w.set(prefix+".deviceType", "ata-hardDisk")
+		}
+		w.set(prefix+".fileName", "%s", file)
+	case "cdrom":
+		if file != "" {
+			w.set(prefix+".deviceType", "cdrom-image")
+			w.set(prefix+".fileName", "%s", file)
+		} else {
+			w.set(prefix+".deviceType", "atapi-cdrom")
+			if dev == "" {
+				dev = "auto detect"
+			}
+			w.set(prefix+".fileName", "%s", dev)
+		}
+	default:
+		return fmt.Errorf("disk device '%s' is not supported in VMX", disk.Device)
+	}
+	if disk.Transient != nil {
+		w.set(prefix+".mode", "independent-nonpersistent")
+	}
+	if disk.Driver != nil && disk.Driver.Cache == "writethrough" {
+		w.set(prefix+".writeThrough", "true")
+	}
+	return nil
+}
+
+func (w *vmxWriter) ethernet(idx int, iface *DomainInterface) error {
+	prefix := fmt.Sprintf("ethernet%d", idx)
+	if iface.Source == nil || iface.Source.Bridge == nil || iface.Source.Bridge.Bridge == "" {
+		return fmt.Errorf("interface %d must be connected to a bridge for VMX", idx)
+	}
+	w.set(prefix+".present", "true")
+	w.set(prefix+".networkName", "%s", iface.Source.Bridge.Bridge)
+	if iface.Target != nil && iface.Target.Dev != "" {
+		w.set(prefix+".connectionType", "custom")
+		w.set(prefix+".vnet", "%s", iface.Target.Dev)
+	} else {
+		w.set(prefix+".connectionType", "bridged")
+	}
+	if iface.Model != nil && iface.Model.Type != "" {
+		known := false
+		for _, m := range vmxNICModels {
+			known = known || m == iface.Model.Type
+		}
+		if !known {
+			return fmt.Errorf("interface model '%s' is not supported in VMX", iface.Model.Type)
+		}
+		w.set(prefix+".virtualDev", "%s", iface.Model.Type)
+	}
+
+	if iface.MAC == nil || iface.MAC.Address == "" {
+		w.set(prefix+".addressType", "generated")
+		return nil
+	}
+	mac, err := ParseMACAddress(iface.MAC.Address)
+	if err != nil {
+		return err
+	}
+	// VMware generates addresses in 00:0c:29 itself, and vCenter
+	// in 00:50:56:80:00:00 to 00:50:56:bf:ff:ff, while the range
+	// up to 00:50:56:3f:ff:ff is left for static addresses
+	switch {
+	case mac[0] == 0x00 && mac[1] == 0x0c && mac[2] == 0x29:
+		w.set(prefix+".addressType", "generated")
+		w.set(prefix+".generatedAddress", "%s", mac)
+		w.set(prefix+".generatedAddressOffset", "0")
+	case mac[0] == 0x00 && mac[1] == 0x50 && mac[2] == 0x56 && mac[3] <= 0x3f:
+		w.set(prefix+".addressType", "static")
+		w.set(prefix+".address", "%s", mac)
+	case mac[0] == 0x00 && mac[1] == 0x50 && mac[2] == 0x56 && mac[3] >= 0x80 && mac[3] <= 0xbf:
+		w.set(prefix+".addressType", "vpx")
+		w.set(prefix+".generatedAddress", "%s", mac)
+	default:
+		w.set(prefix+".addressType", "static")
+		w.set(prefix+".address", "%s", mac)
+		w.set(prefix+".checkMACAddress", "false")
+	}
+	return nil
+}
+
+// Returns the VMware guest OS recorded in the domain metadata
+// when it was read from a VMX file
+func vmxMetadataGuestOS(metadata *DomainMetadata) string {
+	if metadata == nil {
+		return ""
+	}
+	node, err := parseXMLNode("<metadata>" + metadata.XML + "</metadata>")
+	if err != nil {
+		return ""
+	}
+	for _, child := range node.Children {
+		if child.isElement() && child.Space == vmxMetadataNamespace && child.Local == "guestOS" {
+			return strings.TrimSpace(child.content())
+		}
+	}
+	return ""
+}
+
+// Rounds a memory size up to the multiple of 4 MiB VMware requires
+func vmxMemoryMiB(size Size) uint64 {
+	mib := (uint64(size) + uint64(MebiByte) - 1) / uint64(MebiByte)
+	return (mib + 3) / 4 * 4
+}
+
+// MarshalVMX formats the domain as the contents of a VMware .vmx
+// file. Only the parts of the domain configuration which VMware
+// supports are written, and an error is returned for devices
+// which cannot be represented. The guest OS is taken from the
+// domain metadata if it was read from a VMX file, otherwise it
+// is the generic "other" or "other-64" for the architecture.
+func (d *Domain) MarshalVMX() (string, error) {
+	w := &vmxWriter{}
+
+	hwVersion := 4
+	if d.OS != nil && d.OS.Firmware == "efi" {
+		hwVersion = 8
+	}
+	var devices DomainDeviceList
+	if d.Devices != nil {
+		devices = *d.Devices
+	}
+	for _, iface := range devices.Interfaces {
+		if iface.Model != nil && iface.Model.Type == "vmxnet3" && hwVersion < 7 {
+			hwVersion = 7
+		} else if iface.Model != nil && iface.Model.Type == "e1000e" && hwVersion < 8 {
+			hwVersion = 8
+		}
+	}
+
+	// The controllers are written before the disks attached to
+	// them, and every controller a disk uses must be present
+	type vmxController struct {
+		bus   string
+		index uint
+	}
+	controllers := map[vmxController]string{}
+	for _, ctrl := range devices.Controllers {
+		if (ctrl.Type == "scsi" || ctrl.Type == "sata") && ctrl.Index != nil {
+			controllers[vmxController{ctrl.Type, *ctrl.Index}] = ctrl.Model
+		}
+	}
+	for i := range devices.Disks {
+		bus, idx, err := vmxDiskTarget(&devices.Disks[i])
+		if err != nil {
+			return "", err
+		}
+		if bus != "scsi" && bus != "sata" {
+			continue
+		}
+		ctrl, _, err := vmxDiskLocation(bus, idx)
+		if err != nil {
+			return "", err
+		}
+		key := vmxController{bus, ctrl}
+		if _, ok := controllers[key]; !ok {
+			controllers[key] = ""
+		}
+	}
+	keys := []vmxController{}
+	for key := range controllers {
+		keys = append(keys, key)
+		if key.index > 3 {
+			return "", fmt.Errorf("%s controller index %d is not supported in VMX", key.bus, key.index)
+		}
+		if key.bus == "sata" && hwVersion < 10 {
+			hwVersion = 10
+		} else if controllers[key] == "vmpvscsi" && hwVersion < 7 {
+			hwVersion = 7
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].bus != keys[j].bus {
+			return keys[i].bus > keys[j].bus
+		}
+		return keys[i].index < keys[j].index
+	})
+
+	w.set(".encoding", "UTF-8")
+	w.set("config.version", "8")
+	w.set("virtualHW.version", "%d", hwVersion)
+
+	arch := ""
+	if d.OS != nil && d.OS.Type != nil {
+		arch = d.OS.Type.Arch
+	}
+	guestOS := vmxMetadataGuestOS(d.Metadata)
+	switch arch {
+	case "x86_64":
+		if guestOS == "" {
+			guestOS = "other-64"
+		}
+	case "", "i686":
+		if guestOS == "" {
+			guestOS = "other"
+		}
+	default:
+		return "", fmt.Errorf("architecture '%s' is not supported in VMX", arch)
+	}
+	w.set("guestOS", "%s", guestOS)
+
+	if d.UUID != "" {
+		uuid, err := vmxFormatUUID(d.UUID)
+		if err != nil {
+			return "", err
+		}
+		w.set("uuid.bios", "%s", uuid)
+	}
+	w.set("displayName", "%s", d.Name)
+	if d.Description != "" {
+		w.set("annotation", "%s", d.Description)
+	}
+
+	if d.Memory == nil {
+		return "", fmt.Errorf("domain has no memory size")
+	}
+	memory, err := d.Memory.AsSize()
+	if err != nil {
+		return "", err
+	}
+	w.set("memsize", "%d", vmxMemoryMiB(memory))
+	if d.CurrentMemory != nil {
+		current, err := d.CurrentMemory.AsSize()
+		if err != nil {
+			return "", err
+		}
+		if current < memory {
+			w.set("sched.mem.max", "%d", (uint64(current)+uint64(MebiByte)-1)/uint64(MebiByte))
+		}
+	}
+
+	vcpus := uint(1)
+	if d.VCPU != nil && d.VCPU.Value != 0 {
+		vcpus = d.VCPU.Value
+	}
+	w.set("numvcpus", "%d", vcpus)
+	if d.CPU != nil && d.CPU.Topology != nil {
+		topo := d.CPU.Topology
+		if topo.Threads > 1 || topo.Dies > 1 {
+			return "", fmt.Errorf("CPU topology with threads or dies is not supported in VMX")
+		}
+		if topo.Cores > 0 {
+			if uint(topo.Sockets*topo.Cores) != vcpus {
+				return "", fmt.Errorf("CPU topology does not match %d vcpus", vcpus)
+			}
+			w.set("cpuid.coresPerSocket", "%d", topo.Cores)
+		}
+	}
+	if d.VCPU != nil && d.VCPU.CPUSet != "" {
+		// VMware only takes a plain list of CPUs
+		cpus, err := ParseBitmap(d.VCPU.CPUSet)
+		if err != nil {
+			return "", fmt.Errorf("invalid vcpu cpuset '%s': %s", d.VCPU.CPUSet, err)
+		}
+		if uint(cpus.Count()) < vcpus {
+			return "", fmt.Errorf("vcpu cpuset '%s' has fewer CPUs than the %d vcpus", d.VCPU.CPUSet, vcpus)
+		}
+		members := []string{}
+		for _, cpu := range cpus.Members() {
+			members = append(members, strconv.FormatUint(uint64(cpu), 10))
+		}
+		w.set("sched.cpu.affinity", "%s", strings.Join(members, ","))
+	}
+	if d.OS != nil && d.OS.Firmware == "efi" {
+		w.set("firmware", "efi")
+	}
+
+	for _, key := range keys {
+		prefix := fmt.Sprintf("%s%d", key.bus, key.index)
+		w.set(prefix+".present", "true")
+		if model := controllers[key]; key.bus == "scsi" && model != "" && model != "auto" {
+			virtualDev := ""
+			for dev, m := range vmxSCSIModels {
+				if m == model {
+					virtualDev = dev
+				}
+			}
+			if virtualDev == "" {
+				return "", fmt.Errorf("SCSI controller model '%s' is not supported in VMX", model)
+			}
+			w.set(prefix+".virtualDev", "%s", virtualDev)
+		}
+	}
+	for i := range devices.Disks {
+		if err := w.disk(&devices.Disks[i]); err != nil {
+			return "", err
+		}
+	}
+	for i := range devices.Interfaces {
+		if err := w.ethernet(i, &devices.Interfaces[i]); err != nil {
+			return "", err
+		}
+	}
+	return w.buf.String(), nil
+}
@@ -0,0 +1,613 @@
+/*
+ * This file is part of the libvirt-go-xml project
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ *
+ * Copyright (C) 2020 Red Hat, Inc.
+ *
+ */
+
+package libvirtxml
+
+import (
+	"strings"
+	"testing"
+)
+
+const vmxTestFile = `.encoding = "UTF-8"
+config.version = "8"
+virtualHW.version = "7"
+guestOS = "rhel7-64"
+uuid.bios = "56 4d 9b ef ac d9 b4 e0-c8 f0 ae a8 b9 10 35 15"
+displayName = "Fedora |22server|22"
+annotation = "first line|0Asecond line"
+memsize = "2048"
+sched.mem.max = "1024"
+numvcpus = "4"
+cpuid.coresPerSocket = "2"
+firmware = "efi"
+# the boot disk
+scsi0.present = "TRUE"
+scsi0.virtualDev = "pvscsi"
+scsi0:0.present = "TRUE"
+scsi0:0.deviceType = "scsi-hardDisk"
+scsi0:0.fileName = "[datastore1] fedora/fedora.vmdk"
+scsi0:8.present = "true"
+scsi0:8.fileName = "fedora-data.vmdk"
+scsi0:8.mode = "independent-nonpersistent"
+scsi0:8.writeThrough = "true"
+ide1:0.present = "true"
+ide1:0.deviceType = "cdrom-image"
+ide1:0.fileName = "/isos/install.iso"
+floppy0.present = "false"
+ethernet0.present = "true"
+ethernet0.virtualDev = "vmxnet3"
+ethernet0.networkName = "VM Network"
+ethernet0.addressType = "generated"
+ethernet0.generatedAddress = "00:0C:29:3D:05:AB"
+ethernet1.present = "true"
+ethernet1.connectionType = "custom"
+ethernet1.vnet = "vmnet7"
+ethernet1.networkName = "Internal"
+ethernet1.virtualDev = "e1000e"
+ethernet1.addressType = "static"
+ethernet1.address = "00:50:56:11:22:33"
+`
+
+const vmxTestXML = `<domain type="vmware">
+  <name>Fedora &#34;server&#34;</name>
+  <uuid>564d9bef-acd9-b4e0-c8f0-aea8b9103515</uuid>
+  <description>first line&#xA;second line</description>
+  <metadata><vmx:guestOS xmlns:vmx="http://libvirt.org/libvirt-go-xml/vmx/1.0">rhel7-64</vmx:guestOS></metadata>
+  <memory unit="KiB">2097152</memory>
+  <currentMemory unit="KiB">1048576</currentMemory>
+  <vcpu placement="static">4</vcpu>
+  <os firmware="efi">
+    <type arch="x86_64">hvm</type>
+  </os>
+  <cpu>
+    <topology sockets="2" cores="2" threads="1"></topology>
+  </cpu>
+  <devices>
+    <disk type="file" device="disk">
+      <source file="[datastore1] fedora/fedora.vmdk"></source>
+      <target dev="sda" bus="scsi"></target>
+      <address type="drive" controller="0" bus="0" target="0" unit="0"></address>
+    </disk>
+    <disk type="file" device="disk">
+      <driver cache="writethrough"></driver>
+      <source file="fedora-data.vmdk"></source>
+      <target dev="sdh" bus="scsi"></target>
+      <transient></transient>
+      <address type="drive" controller="0" bus="0" target="0" unit="8"></address>
+    </disk>
+    <disk type="file" device="cdrom">
+      <source file="/isos/install.iso"></source>
+      <target dev="hdc" bus="ide"></target>
+      <address type="drive" controller="0" bus="1" target="0" unit="0"></address>
+    </disk>
+    <controller type="scsi" index="0" model="vmpvscsi"></controller>
+    <interface type="bridge">
+      <mac address="00:0c:29:3d:05:ab"></mac>
+      <source bridge="VM Network"></source>
+      <model type="vmxnet3"></model>
+    </interface>
+    <interface type="bridge">
+      <mac address="00:50:56:11:22:33"></mac>
+      <source bridge="Internal"></source>
+      <target dev="vmnet7"></target>
+      <model type="e1000e"></model>
+    </interface>
+  </devices>
+</domain>`
+
+const vmxTestOutput = `.encoding = "UTF-8"
+config.version = "8"
+virtualHW.version = "8"
+guestOS = "rhel7-64"
+uuid.bios = "56 4d 9b ef ac d9 b4 e0-c8 f0 ae a8 b9 10 35 15"
+displayName = "Fedora |22server|22"
+annotation = "first line|0Asecond line"
+memsize = "2048"
+sched.mem.max = "1024"
+numvcpus = "4"
+cpuid.coresPerSocket = "2"
+firmware = "efi"
+scsi0.present = "true"
+scsi0.virtualDev = "pvscsi"
+scsi0:0.present = "true"
+scsi0:0.deviceType = "scsi-hardDisk"
+scsi0:0.fileName = "[datastore1] fedora/fedora.vmdk"
+scsi0:8.present = "true"
+scsi0:8.deviceType = "scsi-hardDisk"
+scsi0:8.fileName = "fedora-data.vmdk"
+scsi0:8.mode = "independent-nonpersistent"
+scsi0:8.writeThrough = "true"
+ide1:0.present = "true"
+ide1:0.deviceType = "cdrom-image"
+ide1:0.fileName = "/isos/install.iso"
+ethernet0.present = "true"
+ethernet0.networkName = "VM Network"
+ethernet0.connectionType = "bridged"
+ethernet0.virtualDev = "vmxnet3"
+ethernet0.addressType = "generated"
+ethernet0.generatedAddress = "00:0c:29:3d:05:ab"
+ethernet0.generatedAddressOffset = "0"
+ethernet1.present = "true"
+ethernet1.networkName = "Internal"
+ethernet1.connectionType = "custom"
+ethernet1.vnet = "vmnet7"
+ethernet1.virtualDev = "e1000e"
+ethernet1.addressType = "static"
+ethernet1.address = "00:50:56:11:22:33"
+`
+
+func TestDomainUnmarshalVMX(t *testing.T) {
+	dom := &Domain{}
+	if err := dom.UnmarshalVMX(vmxTestFile); err != nil {
+		t.Fatal(err)
+	}
+	doc, err := dom.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc != vmxTestXML {
+		t.Errorf("expected\n%s\ngot\n%s", vmxTestXML, doc)
+	}
+}
+
+func TestDomainMarshalVMX(t *testing.T) {
+	dom := &Domain{}
+	if err := dom.Unmarshal(vmxTestXML); err != nil {
+		t.Fatal(err)
+	}
+	vmx, err := dom.MarshalVMX()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vmx != vmxTestOutput {
+		t.Errorf("expected\n%s\ngot\n%s", vmxTestOutput, vmx)
+	}
+
+	again := &Domain{}
+	if err := again.UnmarshalVMX(vmx); err != nil {
+		t.Fatal(err)
+	}
+	doc, err := again.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc != vmxTestXML {
+		t.Errorf("round trip expected\n%s\ngot\n%s", vmxTestXML, doc)
+	}
+}
+
+func TestDomainVMXDefaults(t *testing.T) {
+	dom := &Domain{}
+	err := dom.UnmarshalVMX("config.version = \"8\"\nvirtualHW.version = \"4\"\ndisplayName = \"min\"\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dom.Memory.Value != 32*1024 || dom.CurrentMemory.Value != 32*1024 ||
+		dom.VCPU.Value != 1 || dom.OS.Type.Arch != "i686" || dom.CPU != nil {
+		t.Errorf("unexpected defaults %+v", dom)
+	}
+
+	dom = &Domain{
+		Name:   "odd",
+		Memory: &DomainMemory{Value: 1000, Unit: "MiB"},
+	}
+	vmx, err := dom.MarshalVMX()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(vmx, "memsize = \"1000\"\n") || !strings.Contains(vmx, "numvcpus = \"1\"\n") {
+		t.Errorf("unexpected VMX\n%s", vmx)
+	}
+	dom.Memory = &DomainMemory{Value: 1025, Unit: "KiB"}
+	if vmx, _ = dom.MarshalVMX(); !strings.Contains(vmx, "memsize = \"4\"\n") {
+		t.Errorf("expected memory rounded up to 4 MiB\n%s", vmx)
+	}
+}
+
+func TestDomainVMXMACAddress(t *testing.T) {
+	tests := []struct {
+		MAC      string
+		Expected []string
+	}{
+		{
+			MAC: "00:0c:29:3d:05:ab",
+			Expected: []string{
+				`ethernet0.addressType = "generated"`,
+				`ethernet0.generatedAddress = "00:0c:29:3d:05:ab"`,
+				`ethernet0.generatedAddressOffset = "0"`,
+			},
+		},
+		{
+			MAC: "00:50:56:3f:ff:ff",
+			Expected: []string{
+				`ethernet0.addressType = "static"`,
+				`ethernet0.address = "00:50:56:3f:ff:ff"`,
+			},
+		},
+		{
+			MAC: "00:50:56:80:00:01",
+			Expected: []string{
+				`ethernet0.addressType = "vpx"`,
+				`ethernet0.generatedAddress = "00:50:56:80:00:01"`,
+			},
+		},
+		{
+			MAC: "00:50:56:bf:ff:ff",
+			Expected: []string{
+				`ethernet0.addressType = "vpx"`,
+				`ethernet0.generatedAddress = "00:50:56:bf:ff:ff"`,
+			},
+		},
+		{
+			MAC: "00:50:56:40:00:01",
+			Expected: []string{
+				`ethernet0.addressType = "static"`,
+				`ethernet0.address = "00:50:56:40:00:01"`,
+				`ethernet0.checkMACAddress = "false"`,
+			},
+		},
+		{
+			MAC: "00:50:56:ff:00:01",
+			Expected: []string{
+				`ethernet0.addressType = "static"`,
+				`ethernet0.address = "00:50:56:ff:00:01"`,
+				`ethernet0.checkMACAddress = "false"`,
+			},
+		},
+		{
+			MAC: "52:54:00:12:34:56",
+			Expected: []string{
+				`ethernet0.addressType = "static"`,
+				`ethernet0.address = "52:54:00:12:34:56"`,
+				`ethernet0.checkMACAddress = "false"`,
+			},
+		},
+	}
+	for _, test := range tests {
+		dom := &Domain{
+			Name:   "nic",
+			Memory: &DomainMemory{Value: 1024, Unit: "MiB"},
+			Devices: &DomainDeviceList{
+				Interfaces: []DomainInterface{
+					DomainInterface{
+						MAC: &DomainInterfaceMAC{Address: test.MAC},
+						Source: &DomainInterfaceSource{
+							Bridge: &DomainInterfaceSourceBridge{Bridge: "VM Network"},
+						},
+					},
+				},
+			},
+		}
+		vmx, err := dom.MarshalVMX()
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSpace(vmx), "\n")
+		got := lines[len(lines)-len(test.Expected):]
+		if strings.Join(got, "\n") != strings.Join(test.Expected, "\n") {
+			t.Errorf("MAC %s: expected\n%s\ngot\n%s", test.MAC,
+				strings.Join(test.Expected, "\n"), strings.Join(got, "\n"))
+		}
+	}
+}
+
+// Cases modelled on libvirt's vmx2xmldata and xml2vmxdata tests
+var vmxCorpusTests = []struct {
+	Name string
+	VMX  string
+	XML  string
+}{
+	{
+		Name: "case-insensitive",
+		VMX: `CONFIG.VERSION = "8"
+VIRTUALHW.VERSION = "4"
+DISPLAYNAME = "case"
+MEMSIZE = "1024"
+SCSI0.PRESENT = "TRUE"
+SCSI0.VIRTUALDEV = "LSILOGIC"
+SCSI0:0.PRESENT = "TRUE"
+SCSI0:0.DEVICETYPE = "SCSI-HARDDISK"
+SCSI0:0.FILENAME = "Fedora11.vmdk"
+ETHERNET0.PRESENT = "TRUE"
+ETHERNET0.NETWORKNAME = "VM Network"
+ETHERNET0.ADDRESSTYPE = "VPX"
+ETHERNET0.GENERATEDADDRESS = "00:50:56:91:48:C7"
+`,
+		XML: `<domain type="vmware">
+  <name>case</name>
+  <memory unit="KiB">1048576</memory>
+  <currentMemory unit="KiB">1048576</currentMemory>
+  <vcpu placement="static">1</vcpu>
+  <os>
+    <type arch="i686">hvm</type>
+  </os>
+  <devices>
+    <disk type="file" device="disk">
+      <source file="Fedora11.vmdk"></source>
+      <target dev="sda" bus="scsi"></target>
+      <address type="drive" controller="0" bus="0" target="0" unit="0"></address>
+    </disk>
+    <controller type="scsi" index="0" model="lsilogic"></controller>
+    <interface type="bridge">
+      <mac address="00:50:56:91:48:c7"></mac>
+      <source bridge="VM Network"></source>
+    </interface>
+  </devices>
+</domain>`,
+	},
+	{
+		Name: "cdrom-ide-file",
+		VMX: `config.version = "8"
+virtualHW.version = "4"
+ide0:0.present = "true"
+ide0:0.deviceType = "cdrom-image"
+ide0:0.fileName = "[datastore] directory/Debian1-cdrom.iso"
+`,
+		XML: `<domain type="vmware">
+  <memory unit="KiB">32768</memory>
+  <currentMemory unit="KiB">32768</currentMemory>
+  <vcpu placement="static">1</vcpu>
+  <os>
+    <type arch="i686">hvm</type>
+  </os>
+  <devices>
+    <disk type="file" device="cdrom">
+      <source file="[datastore] directory/Debian1-cdrom.iso"></source>
+      <target dev="hda" bus="ide"></target>
+      <address type="drive" controller="0" bus="0" target="0" unit="0"></address>
+    </disk>
+  </devices>
+</domain>`,
+	},
+	{
+		Name: "scsi-driver",
+		VMX: `config.version = "8"
+virtualHW.version = "4"
+scsi0.present = "true"
+scsi0.virtualDev = "buslogic"
+scsi1.present = "true"
+scsi1.virtualDev = "lsilogic"
+scsi2.present = "true"
+scsi2.virtualDev = "lsisas1068"
+scsi3.present = "true"
+scsi3.virtualDev = "pvscsi"
+scsi0:0.present = "true"
+scsi0:0.fileName = "Debian1.vmdk"
+scsi1:0.present = "true"
+scsi1:0.fileName = "Debian1-data.vmdk"
+scsi2:0.present = "true"
+scsi2:0.fileName = "Debian1-log.vmdk"
+scsi3:0.present = "true"
+scsi3:0.fileName = "Debian1-swap.vmdk"
+`,
+		XML: `<domain type="vmware">
+  <memory unit="KiB">32768</memory>
+  <currentMemory unit="KiB">32768</currentMemory>
+  <vcpu placement="static">1</vcpu>
+  <os>
+    <type arch="i686">hvm</type>
+  </os>
+  <devices>
+    <disk type="file" device="disk">
+      <source file="Debian1.vmdk"></source>
+      <target dev="sda" bus="scsi"></target>
+      <address type="drive" controller="0" bus="0" target="0" unit="0"></address>
+    </disk>
+    <disk type="file" device="disk">
+      <source file="Debian1-data.vmdk"></source>
+      <target dev="sdp" bus="scsi"></target>
+      <address type="drive" controller="1" bus="0" target="0" unit="0"></address>
+    </disk>
+    <disk type="file" device="disk">
+      <source file="Debian1-log.vmdk"></source>
+      <target dev="sdae" bus="scsi"></target>
+      <address type="drive" controller="2" bus="0" target="0" unit="0"></address>
+    </disk>
+    <disk type="file" device="disk">
+      <source file="Debian1-swap.vmdk"></source>
+      <target dev="sdat" bus="scsi"></target>
+      <address type="drive" controller="3" bus="0" target="0" unit="0"></address>
+    </disk>
+    <controller type="scsi" index="0" model="buslogic"></controller>
+    <controller type="scsi" index="1" model="lsilogic"></controller>
+    <controller type="scsi" index="2" model="lsisas1068"></controller>
+    <controller type="scsi" index="3" model="vmpvscsi"></controller>
+  </devices>
+</domain>`,
+	},
+	{
+		Name: "sched-cpu-affinity",
+		VMX: `config.version = "8"
+virtualHW.version = "4"
+numvcpus = "4"
+sched.cpu.affinity = "0, 1, 2, 3, 5"
+`,
+		XML: `<domain type="vmware">
+  <memory unit="KiB">32768</memory>
+  <currentMemory unit="KiB">32768</currentMemory>
+  <vcpu placement="static" cpuset="0-3,5">4</vcpu>
+  <os>
+    <type arch="i686">hvm</type>
+  </os>
+  <devices></devices>
+</domain>`,
+	},
+	{
+		Name: "sched-cpu-affinity-all",
+		VMX: `config.version = "8"
+virtualHW.version = "4"
+numvcpus = "2"
+sched.cpu.affinity = "all"
+`,
+		XML: `<domain type="vmware">
+  <memory unit="KiB">32768</memory>
+  <currentMemory unit="KiB">32768</currentMemory>
+  <vcpu placement="static">2</vcpu>
+  <os>
+    <type arch="i686">hvm</type>
+  </os>
+  <devices></devices>
+</domain>`,
+	},
+}
+
+func TestDomainVMXCorpus(t *testing.T) {
+	for _, test := range vmxCorpusTests {
+		dom := &Domain{}
+		if err := dom.UnmarshalVMX(test.VMX); err != nil {
+			t.Fatalf("%s: %s", test.Name, err)
+		}
+		doc, err := dom.Marshal()
+		if err != nil {
+			t.Fatalf("%s: %s", test.Name, err)
+		}
+		if doc != test.XML {
+			t.Errorf("%s: expected\n%s\ngot\n%s", test.Name, test.XML, doc)
+		}
+	}
+
+	xmlTests := []struct {
+		Name string
+		XML  string
+		VMX  string
+	}{
+		{
+			Name: "minimal",
+			XML: `<domain type='vmware'>
+  <name>minimal</name>
+  <uuid>564d9bef-acd9-b4e0-c8f0-aea8b9103515</uuid>
+  <memory>219136</memory>
+  <os>
+    <type>hvm</type>
+  </os>
+</domain>`,
+			VMX: `.encoding = "UTF-8"
+config.version = "8"
+virtualHW.version = "4"
+guestOS = "other"
+uuid.bios = "56 4d 9b ef ac d9 b4 e0-c8 f0 ae a8 b9 10 35 15"
+displayName = "minimal"
+memsize = "216"
+numvcpus = "1"
+`,
+		},
+		{
+			Name: "minimal-64bit",
+			XML: `<domain type='vmware'>
+  <name>minimal-64bit</name>
+  <uuid>564d9bef-acd9-b4e0-c8f0-aea8b9103515</uuid>
+  <memory>219136</memory>
+  <os>
+    <type arch='x86_64'>hvm</type>
+  </os>
+</domain>`,
+			VMX: `.encoding = "UTF-8"
+config.version = "8"
+virtualHW.version = "4"
+guestOS = "other-64"
+uuid.bios = "56 4d 9b ef ac d9 b4 e0-c8 f0 ae a8 b9 10 35 15"
+displayName = "minimal-64bit"
+memsize = "216"
+numvcpus = "1"
+`,
+		},
+		{
+			Name: "sched-cpu-affinity",
+			XML: `<domain type='vmware'>
+  <name>affinity</name>
+  <memory>219136</memory>
+  <vcpu cpuset='0-2,^1,4'>2</vcpu>
+  <os>
+    <type>hvm</type>
+  </os>
+</domain>`,
+			VMX: `.encoding = "UTF-8"
+config.version = "8"
+virtualHW.version = "4"
+guestOS = "other"
+displayName = "affinity"
+memsize = "216"
+numvcpus = "2"
+sched.cpu.affinity = "0,2,4"
+`,
+		},
+	}
+	for _, test := range xmlTests {
+		dom := &Domain{}
+		if err := dom.Unmarshal(test.XML); err != nil {
+			t.Fatalf("%s: %s", test.Name, err)
+		}
+		vmx, err := dom.MarshalVMX()
+		if err != nil {
+			t.Fatalf("%s: %s", test.Name, err)
+		}
+		if vmx != test.VMX {
+			t.Errorf("%s: expected\n%s\ngot\n%s", test.Name, test.VMX, vmx)
+		}
+	}
+}
+
+func TestDomainVMXErrors(t *testing.T) {
+	vmxs := []string{
+		"config.version = \"7\"\nvirtualHW.version = \"4\"\n",
+		"config.version = \"8\"\nvirtualHW.version = \"3\"\n",
+		"config.version = \"8\"\nvirtualHW.version = \"4\"\nbogus line\n",
+		"config.version = \"8\"\nvirtualHW.version = \"4\"\nnumvcpus = \"3\"\ncpuid.coresPerSocket = \"2\"\n",
+		"config.version = \"8\"\nvirtualHW.version = \"4\"\nscsi0.present = \"true\"\nscsi0.virtualDev = \"unknown\"\n",
+		"config.version = \"8\"\nvirtualHW.version = \"4\"\nide0:0.present = \"true\"\nide0:0.fileName = \"disk.img\"\n",
+		"config.version = \"8\"\nvirtualHW.version = \"4\"\nethernet0.present = \"true\"\nethernet0.connectionType = \"nat\"\n",
+		"config.version = \"8\"\nvirtualHW.version = \"4\"\nethernet0.present = \"true\"\n",
+		"config.version = \"8\"\nvirtualHW.version = \"4\"\nnumvcpus = \"2\"\nsched.cpu.affinity = \"0\"\n",
+		"config.version = \"8\"\nvirtualHW.version = \"4\"\nsched.cpu.affinity = \"0,x\"\n",
+	}
+	for _, vmx := range vmxs {
+		dom := &Domain{}
+		if err := dom.UnmarshalVMX(vmx); err == nil {
+			t.Errorf("expected error for\n%s", vmx)
+		}
+	}
+
+	xmls := []string{
+		`<domain><name>a</name></domain>`,
+		`<domain><name>a</name><memory>1024</memory><os><type arch="aarch64">hvm</type></os></domain>`,
+		`<domain><name>a</name><memory>1024</memory><devices><disk type="file" device="disk">` +
+			`<source file="a.qcow2"/><target dev="sda" bus="scsi"/></disk></devices></domain>`,
+		`<domain><name>a</name><memory>1024</memory><devices><disk type="file" device="disk">` +
+			`<source file="a.vmdk"/><target dev="vda" bus="virtio"/></disk></devices></domain>`,
+		`<domain><name>a</name><memory>1024</memory><devices><interface type="network">` +
+			`<source network="default"/></interface></devices></domain>`,
+		`<domain><name>a</name><memory>1024</memory><vcpu cpuset="0">2</vcpu></domain>`,
+		`<domain><name>a</name><memory>1024</memory><vcpu cpuset="0-">1</vcpu></domain>`,
+	}
+	for _, doc := range xmls {
+		dom := &Domain{}
+		if err := dom.Unmarshal(doc); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := dom.MarshalVMX(); err == nil {
+			t.Errorf("expected error for %s", doc)
+		}
+	}
+}
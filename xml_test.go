@@ -32,6 +32,7 @@ import (
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -42,6 +43,8 @@ var xmldirs = []string{
 	"testdata/libvirt/tests/bhyvexml2xmloutdata",
 	"testdata/libvirt/tests/capabilityschemadata",
 	"testdata/libvirt/tests/cputestdata",
+	"testdata/libvirt/tests/domainbackupxml2xmlin",
+	"testdata/libvirt/tests/domainbackupxml2xmlout",
 	"testdata/libvirt/tests/domaincapsdata",
 	"testdata/libvirt/tests/domainconfdata",
 	"testdata/libvirt/tests/domainschemadata",
@@ -64,16 +67,20 @@ var xmldirs = []string{
 	"testdata/libvirt/tests/nwfilterxml2xmlout",
 	"testdata/libvirt/tests/qemuagentdata",
 	"testdata/libvirt/tests/qemucapabilitiesdata",
+	"testdata/libvirt/tests/qemudomaincheckpointxml2xmlin",
+	"testdata/libvirt/tests/qemudomaincheckpointxml2xmlout",
 	"testdata/libvirt/tests/qemudomainsnapshotxml2xmlin",
 	"testdata/libvirt/tests/qemudomainsnapshotxml2xmlout",
 	"testdata/libvirt/tests/qemuhotplugtestcpus",
 	"testdata/libvirt/tests/qemuhotplugtestdevices",
 	"testdata/libvirt/tests/qemuhotplugtestdomains",
 	"testdata/libvirt/tests/qemumemlockdata",
+	"testdata/libvirt/tests/qemustatusxml2xmldata",
 	"testdata/libvirt/tests/qemuxml2argvdata",
 	"testdata/libvirt/tests/qemuxml2xmloutdata",
 	"testdata/libvirt/tests/secretxml2xmlin",
 	"testdata/libvirt/tests/securityselinuxlabeldata",
+	"testdata/libvirt/tests/storagepoolcapsschemadata",
 	"testdata/libvirt/tests/storagepoolschemadata",
 	"testdata/libvirt/tests/storagepoolxml2xmlin",
 	"testdata/libvirt/tests/storagepoolxml2xmlout",
@@ -311,6 +318,8 @@ func testRoundTrip(t *testing.T, xml string, filename string) {
 		doc = &NodeDevice{}
 	} else if strings.HasPrefix(xml, "<volume") {
 		doc = &StorageVolume{}
+	} else if strings.HasPrefix(xml, "<storagepoolCapabilities") {
+		doc = &StoragePoolCaps{}
 	} else if strings.HasPrefix(xml, "<pool") {
 		doc = &StoragePool{}
 	} else if strings.HasPrefix(xml, "<cpuTest") || strings.HasPrefix(xml, "<cpudata") {
@@ -336,6 +345,12 @@ func testRoundTrip(t *testing.T, xml string, filename string) {
 		}
 	} else if strings.HasPrefix(xml, "<domainsnapshot") {
 		doc = &DomainSnapshot{}
+	} else if strings.HasPrefix(xml, "<domstatus") {
+		doc = &DomainStatus{}
+	} else if strings.HasPrefix(xml, "<domainbackup") {
+		doc = &DomainBackup{}
+	} else if strings.HasPrefix(xml, "<domaincheckpoint") {
+		doc = &DomainCheckpoint{}
 	} else if strings.HasPrefix(xml, "<domainCapabilities") {
 		doc = &DomainCaps{}
 	} else if strings.HasPrefix(xml, "<disk") {
@@ -396,6 +411,28 @@ func testRoundTrip(t *testing.T, xml string, filename string) {
 		}
 		t.Fatal(err)
 	}
+
+	// With unmodelled content preserved, nothing in the
+	// original document should go missing
+	preserved := NewPreservingDocument(reflect.New(reflect.TypeOf(doc).Elem()).Interface().(Document))
+	err = preserved.Unmarshal(xml)
+	if err != nil {
+		t.Fatal(fmt.Errorf("Cannot parse file %s: %s\n", filename, err))
+	}
+
+	newxml, err = preserved.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = testCompareXML(filename, xml, newxml, nil, extraActualNodes)
+	if err != nil {
+		if os.Getenv("LIBVIRT_DEBUG") == "1" {
+			fmt.Printf("Expected %s\n", xml)
+			fmt.Printf("Actual %s\n", newxml)
+		}
+		t.Fatal(err)
+	}
 }
 
 func syncGit(t *testing.T) {